@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// --- admin (pprof) ---
+
+// startAdmin serves net/http/pprof on its own address, so profiles are
+// never reachable through the metrics listener, and registers the Go
+// runtime and process collectors that the custom registry otherwise leaves
+// out. The profiles are unauthenticated, so the address defaults to the
+// loopback interface.
+func startAdmin(addr string, reg prometheus.Registerer) *http.Server {
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("admin: %v", err)
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	log.Printf("pprof enabled on http://%s/debug/pprof/", srv.Addr)
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("admin: %v", err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+	"github.com/aireet/cc-exporter/exporter/pkg/push"
+)
+
+// --- JSON API ---
+
+// indexHTML is the built-in dashboard served at /.
+//
+//go:embed web/index.html
+var indexHTML []byte
+
+// summaryHandler refreshes the collector and returns the latest summary.
+func summaryHandler(c *collector.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.Update()
+		summary := c.Summary()
+		if summary == nil {
+			http.Error(w, "stats not available", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summary)
+	}
+}
+
+// sessionsHandler refreshes the collector and lists the live sessions.
+func sessionsHandler(c *collector.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.Update()
+		if !c.Ready() {
+			http.Error(w, "stats not available", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"sessions": c.Sessions()})
+	}
+}
+
+// historyHandler refreshes the collector and returns the daily totals
+// from ?from= to ?to= (YYYY-MM-DD, inclusive, both optional).
+func historyHandler(c *collector.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		for _, date := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				http.Error(w, fmt.Sprintf("want dates as YYYY-MM-DD, got %q", date), http.StatusBadRequest)
+				return
+			}
+		}
+		c.Update()
+		if !c.Ready() {
+			http.Error(w, "stats not available", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"daily": c.History(from, to)})
+	}
+}
+
+// apiAuth requires one of tokens as a bearer token, when any are set.
+// The tokens are read-only: they only allow GET requests. API_TOKENS and
+// METRICS_TOKENS are separate lists, so neither grants access to the other.
+func apiAuth(tokens []string, next http.HandlerFunc) http.HandlerFunc {
+	if len(tokens) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validToken(tokens, r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="claude-exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func validToken(tokens []string, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// leaderboardHandler ranks the users pushing to the server by cost over
+// ?window=, in days (e.g. 7d, the default) or a duration such as 48h.
+func leaderboardHandler(srv *push.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		days, err := windowDays(r.URL.Query().Get("window"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"window": fmt.Sprintf("%dd", days),
+			"from":   now.AddDate(0, 0, 1-days).Format("2006-01-02"),
+			"to":     now.Format("2006-01-02"),
+			"users":  srv.Leaderboard(days, now),
+		})
+	}
+}
+
+// windowDays parses a leaderboard window, rounding durations up to whole
+// days since the agents report daily series.
+func windowDays(window string) (int, error) {
+	if window == "" {
+		return 7, nil
+	}
+	days := 0
+	if n, ok := strings.CutSuffix(window, "d"); ok {
+		days, _ = strconv.Atoi(n)
+	} else if d, err := time.ParseDuration(window); err == nil && d > 0 {
+		days = int((d + 24*time.Hour - 1) / (24 * time.Hour))
+	}
+	if days < 1 || days > push.MaxLeaderboardDays {
+		return 0, fmt.Errorf("window: want 1d to %dd, got %q", push.MaxLeaderboardDays, window)
+	}
+	return days, nil
+}
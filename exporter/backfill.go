@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/remotewrite"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- history backfill ---
+//
+// The exporter's daily series only reach back as far as it has been
+// running (and at most 30 days). The backfill subcommand aggregates the
+// full session history into the same series and writes them as OpenMetrics
+// with timestamps, for `promtool tsdb create-blocks-from openmetrics`, or
+// sends them to a remote_write endpoint with those timestamps.
+
+// runBackfill implements the `backfill` subcommand.
+func runBackfill(args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromFlag := fs.String("from", "", "first day (YYYY-MM-DD) or time (RFC 3339) to backfill")
+	toFlag := fs.String("to", "", "last day (YYYY-MM-DD, inclusive) or time (RFC 3339, exclusive) to backfill")
+	output := fs.String("output", "-", "file to write the OpenMetrics to (- for stdout)")
+	remoteWrite := fs.String("remote-write", "", "remote_write URL to send the series to instead (bearer token from REMOTE_WRITE_TOKEN)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := parseExportTime(*fromFlag, false)
+	if err != nil {
+		return err
+	}
+	to, err := parseExportTime(*toFlag, true)
+	if err != nil {
+		return err
+	}
+	rows, err := readHistory(from, to)
+	if err != nil {
+		return err
+	}
+	families, err := backfillFamilies(rows)
+	if err != nil {
+		return err
+	}
+
+	if *remoteWrite != "" {
+		series := remotewrite.FromFamilies(families)
+		client := &remotewrite.Client{URL: *remoteWrite, Token: envOr("REMOTE_WRITE_TOKEN", "")}
+		if err := client.Write(context.Background(), series); err != nil {
+			return err
+		}
+		log.Printf("backfill: wrote %d series from %d requests to %s", len(series), len(rows), *remoteWrite)
+		return nil
+	}
+	return writeOutput(*output, func(w io.Writer) error {
+		for _, mf := range families {
+			if _, err := expfmt.MetricFamilyToOpenMetrics(w, mf); err != nil {
+				return err
+			}
+		}
+		_, err := expfmt.FinalizeOpenMetrics(w)
+		return err
+	})
+}
+
+// backfillFamilies aggregates rows by day. Each day's values are stamped at
+// the last second of that local day, when the exporter would have reported
+// them complete.
+func backfillFamilies(rows []source.MessageRow) ([]*dto.MetricFamily, error) {
+	tokens := newBackfillFamily("claude_daily_tokens_by_kind", "Tokens per day by model and kind (input, output, cache_read, cache_create), backfilled from session logs", "model", "kind")
+	projectCost := newBackfillFamily("claude_daily_project_cost_usd", "Estimated cost per day by project, backfilled from session logs", "project")
+	tools := newBackfillFamily("claude_daily_tool_use", "Tool calls per day by tool, backfilled from session logs", "tool")
+
+	for _, r := range rows {
+		date := r.Timestamp.Local().Format("2006-01-02")
+		tokens.add(date, r.Input, r.Model, "input")
+		tokens.add(date, r.Output, r.Model, "output")
+		tokens.add(date, r.CacheRead, r.Model, "cache_read")
+		tokens.add(date, r.CacheCreate, r.Model, "cache_create")
+		projectCost.add(date, r.Cost, r.Project)
+		for _, tool := range r.Tools {
+			tools.add(date, 1, tool)
+		}
+	}
+
+	var families []*dto.MetricFamily
+	for _, f := range []*backfillFamily{tokens, projectCost, tools} {
+		mf, err := f.family()
+		if err != nil {
+			return nil, err
+		}
+		families = append(families, mf)
+	}
+	return families, nil
+}
+
+// backfillFamily sums the values of one gauge by date and labels.
+type backfillFamily struct {
+	desc   *prometheus.Desc
+	name   string
+	help   string
+	labels int // labels besides date
+	values map[backfillKey]float64
+}
+
+// backfillKey is a date and the values of the family's other labels.
+type backfillKey struct {
+	date   string
+	labels [2]string
+}
+
+func newBackfillFamily(name, help string, labels ...string) *backfillFamily {
+	return &backfillFamily{
+		desc:   prometheus.NewDesc(name, help, append([]string{"date"}, labels...), nil),
+		name:   name,
+		help:   help,
+		labels: len(labels),
+		values: make(map[backfillKey]float64),
+	}
+}
+
+func (f *backfillFamily) add(date string, v float64, labels ...string) {
+	key := backfillKey{date: date}
+	copy(key.labels[:], labels)
+	f.values[key] += v
+}
+
+// family returns the samples sorted by labels, then date.
+func (f *backfillFamily) family() (*dto.MetricFamily, error) {
+	keys := make([]backfillKey, 0, len(f.values))
+	for k := range f.values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.labels != b.labels {
+			return a.labels[0] < b.labels[0] || a.labels[0] == b.labels[0] && a.labels[1] < b.labels[1]
+		}
+		return a.date < b.date
+	})
+
+	mf := &dto.MetricFamily{Name: &f.name, Help: &f.help, Type: dto.MetricType_GAUGE.Enum()}
+	for _, k := range keys {
+		day, err := time.ParseInLocation("2006-01-02", k.date, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		values := append([]string{k.date}, k.labels[:f.labels]...)
+		m, err := prometheus.NewConstMetric(f.desc, prometheus.GaugeValue, f.values[k], values...)
+		if err != nil {
+			return nil, err
+		}
+		pb := &dto.Metric{}
+		if err := prometheus.NewMetricWithTimestamp(day.AddDate(0, 0, 1).Add(-time.Second), m).Write(pb); err != nil {
+			return nil, err
+		}
+		mf.Metric = append(mf.Metric, pb)
+	}
+	return mf, nil
+}
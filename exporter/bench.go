@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// --- scan benchmark ---
+//
+// The bench subcommand generates a synthetic Claude config directory and
+// times the collector scanning it: a cold scan reading every file, scans
+// with nothing changed, and scans after a turn is appended to some
+// sessions. The results are written as JSON, and compared against an
+// earlier run with -baseline, so scan changes are judged by numbers.
+
+// benchMarker marks a directory as generated by bench, which is then
+// allowed to regenerate it.
+const benchMarker = ".cc-bench"
+
+// benchLinesPerTurn is the lines writeBenchTurn writes.
+const benchLinesPerTurn = 4
+
+var (
+	benchModels = []string{"claude-sonnet-4-5-20250929", "claude-opus-4-1-20250805", "claude-haiku-4-5-20251001"}
+	benchTools  = []string{"Read", "Bash", "Edit", "Grep", "Write"}
+)
+
+// benchTree is the size of the generated tree.
+type benchTree struct {
+	Projects int   `json:"projects"`
+	Sessions int   `json:"sessions_per_project"`
+	Lines    int   `json:"lines"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// benchPhase is the measurements of one kind of scan, per scan where the
+// name says so.
+type benchPhase struct {
+	Name          string  `json:"name"`
+	Scans         int     `json:"scans"`
+	Lines         int     `json:"lines"`
+	Bytes         int64   `json:"bytes"`
+	Seconds       float64 `json:"seconds"`
+	SecondsPerRun float64 `json:"seconds_per_scan"`
+	LinesPerSec   float64 `json:"lines_per_second"`
+	MBPerSec      float64 `json:"mb_per_second"`
+	AllocsPerRun  float64 `json:"allocs_per_scan"`
+	BytesPerRun   float64 `json:"alloc_bytes_per_scan"`
+}
+
+// benchResult is the output of one bench run.
+type benchResult struct {
+	Time      time.Time    `json:"time"`
+	GoVersion string       `json:"go_version"`
+	CPUs      int          `json:"cpus"`
+	Tree      benchTree    `json:"tree"`
+	HeapBytes uint64       `json:"heap_bytes"`
+	Phases    []benchPhase `json:"phases"`
+}
+
+// runBench implements the `bench` subcommand.
+func runBench(args []string) error {
+	fset := flag.NewFlagSet("bench", flag.ExitOnError)
+	dir := fset.String("dir", "", "directory to generate the tree in (default a temporary directory, removed afterwards)")
+	projects := fset.Int("projects", 20, "projects to generate")
+	sessions := fset.Int("sessions", 10, "sessions per project")
+	lines := fset.Int("lines", 1000, "lines per session")
+	scans := fset.Int("scans", 5, "scans to time for each phase after the cold scan")
+	active := fset.Int("active", 10, "sessions a turn is appended to before each append scan")
+	output := fset.String("output", "-", "file to write the JSON results to (- for stdout)")
+	baseline := fset.String("baseline", "", "results of an earlier run to compare against")
+	maxRegression := fset.Float64("max-regression", 0.2, "fail when a phase takes this much more time or allocations per scan than in -baseline")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if *projects < 1 || *sessions < 1 || *lines < benchLinesPerTurn || *scans < 1 {
+		return fmt.Errorf("want at least 1 project, session and scan, and %d lines per session", benchLinesPerTurn)
+	}
+
+	root := *dir
+	if root == "" {
+		tmp, err := os.MkdirTemp("", "cc-bench-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+		root = tmp
+	}
+	start := time.Now()
+	tree, err := generateBenchTree(root, *projects, *sessions, *lines)
+	if err != nil {
+		return err
+	}
+	log.Printf("bench: generated %d lines (%.1f MB) in %s in %v", tree.Lines, float64(tree.Bytes)/1e6, root, time.Since(start).Round(time.Millisecond))
+
+	result, err := benchScans(root, tree, *scans, *active)
+	if err != nil {
+		return err
+	}
+	for _, p := range result.Phases {
+		log.Printf("bench: %-9s %8.1f ms/scan %12.0f lines/s %8.1f MB/s %10.0f allocs/scan",
+			p.Name, p.SecondsPerRun*1000, p.LinesPerSec, p.MBPerSec, p.AllocsPerRun)
+	}
+	err = writeOutput(*output, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	})
+	if err != nil {
+		return err
+	}
+	if *baseline != "" {
+		return compareBench(*baseline, result, *maxRegression)
+	}
+	return nil
+}
+
+// generateBenchTree writes projects × sessions session files of lines
+// lines each under root/projects, replacing an earlier bench tree. The
+// content only depends on the sizes, and the timestamps end now.
+func generateBenchTree(root string, projects, sessions, lines int) (benchTree, error) {
+	tree := benchTree{Projects: projects, Sessions: sessions}
+	entries, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return tree, err
+	}
+	if len(entries) > 0 {
+		if _, err := os.Stat(filepath.Join(root, benchMarker)); err != nil {
+			return tree, fmt.Errorf("%s is not empty and was not generated by bench", root)
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(root, "projects")); err != nil {
+		return tree, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return tree, err
+	}
+	if err := os.WriteFile(filepath.Join(root, benchMarker), nil, 0o644); err != nil {
+		return tree, err
+	}
+
+	// an empty stats cache computed before the sessions, so every file is
+	// live and the cold scan reads them all
+	computed := time.Now().AddDate(0, 0, -2)
+	statsFile := filepath.Join(root, "stats-cache.json")
+	stats := fmt.Sprintf(`{"version": 2, "lastComputedDate": %q, "modelUsage": {}, "dailyActivity": [], "dailyModelTokens": []}`, computed.Format("2006-01-02"))
+	if err := os.WriteFile(statsFile, []byte(stats), 0o644); err != nil {
+		return tree, err
+	}
+	if err := os.Chtimes(statsFile, computed, computed); err != nil {
+		return tree, err
+	}
+
+	turns := lines / benchLinesPerTurn
+	end := time.Now().Add(-time.Minute)
+	for p := range projects {
+		projectDir := filepath.Join(root, "projects", benchProject(p))
+		if err := os.MkdirAll(projectDir, 0o755); err != nil {
+			return tree, err
+		}
+		for s := range sessions {
+			path := filepath.Join(projectDir, benchSession(p, s)+".jsonl")
+			f, err := os.Create(path)
+			if err != nil {
+				return tree, err
+			}
+			w := bufio.NewWriter(f)
+			for t := range turns {
+				writeBenchTurn(w, p, s, t, end.Add(time.Duration(t-turns)*time.Second))
+			}
+			err = w.Flush()
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return tree, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return tree, err
+			}
+			tree.Lines += turns * benchLinesPerTurn
+			tree.Bytes += info.Size()
+		}
+	}
+	return tree, nil
+}
+
+func benchProject(p int) string {
+	return fmt.Sprintf("-home-bench-project%03d", p)
+}
+
+func benchSession(p, s int) string {
+	return fmt.Sprintf("%08x-0000-4000-8000-%012x", p, s)
+}
+
+// writeBenchTurn writes turn t of a session: a prompt, a tool call, its
+// result, and the answer, with tool output of varying size.
+func writeBenchTurn(w io.Writer, p, s, t int, ts time.Time) {
+	session := benchSession(p, s)
+	cwd := fmt.Sprintf("/home/bench/project%03d", p)
+	model := benchModels[(p+t)%len(benchModels)]
+	tool := benchTools[(s+t)%len(benchTools)]
+	id := fmt.Sprintf("%d_%d_%d", p, s, t)
+	stamp := func(d time.Duration) string { return ts.Add(d).UTC().Format("2006-01-02T15:04:05.000Z") }
+	output := strings.Repeat("x", 200+(p*31+s*17+t*7)%2000)
+
+	fmt.Fprintf(w, `{"type":"user","uuid":"u-%[1]s","sessionId":"%[2]s","timestamp":"%[3]s","version":"2.0.30","cwd":"%[4]s","message":{"role":"user","content":"Step %[5]d of the task"}}`+"\n",
+		id, session, stamp(0), cwd, t)
+	fmt.Fprintf(w, `{"type":"assistant","uuid":"a-%[1]s","parentUuid":"u-%[1]s","sessionId":"%[2]s","timestamp":"%[3]s","version":"2.0.30","cwd":"%[4]s","requestId":"req_%[1]s_1","message":{"id":"msg_%[1]s_1","role":"assistant","model":"%[5]s","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_%[1]s","name":"%[6]s","input":{"command":"step %[7]d"}}],"usage":{"input_tokens":%[7]d,"output_tokens":120,"cache_read_input_tokens":18000,"cache_creation_input_tokens":900}}}`+"\n",
+		id, session, stamp(100*time.Millisecond), cwd, model, tool, 100+t%500)
+	fmt.Fprintf(w, `{"type":"user","uuid":"r-%[1]s","parentUuid":"a-%[1]s","sessionId":"%[2]s","timestamp":"%[3]s","version":"2.0.30","cwd":"%[4]s","message":{"role":"user","content":[{"tool_use_id":"toolu_%[1]s","type":"tool_result","content":"%[5]s"}]}}`+"\n",
+		id, session, stamp(300*time.Millisecond), cwd, output)
+	fmt.Fprintf(w, `{"type":"assistant","uuid":"b-%[1]s","parentUuid":"r-%[1]s","sessionId":"%[2]s","timestamp":"%[3]s","version":"2.0.30","cwd":"%[4]s","requestId":"req_%[1]s_2","message":{"id":"msg_%[1]s_2","role":"assistant","model":"%[5]s","stop_reason":"end_turn","content":[{"type":"text","text":"Done with step %[6]d."}],"usage":{"input_tokens":80,"output_tokens":60,"cache_read_input_tokens":19000,"cache_creation_input_tokens":0}}}`+"\n",
+		id, session, stamp(800*time.Millisecond), cwd, model, t)
+}
+
+// benchScans times the collector's scans of the tree at root.
+func benchScans(root string, tree benchTree, scans, active int) (*benchResult, error) {
+	prices, err := pricing.Load("")
+	if err != nil {
+		return nil, err
+	}
+	// the scans' own logging is not what is measured
+	logOut := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOut)
+
+	c := collector.NewCollector(collector.Options{
+		StatsFile:   filepath.Join(root, "stats-cache.json"),
+		ClaudeDir:   root,
+		Pricing:     prices,
+		TopSessions: 5,
+	})
+	result := &benchResult{
+		Time:      time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		CPUs:      runtime.NumCPU(),
+		Tree:      tree,
+	}
+	measure := func(name string, runs, lines int, bytes int64, before func(run int) error) error {
+		p := benchPhase{Name: name, Scans: runs, Lines: lines, Bytes: bytes}
+		runtime.GC()
+		var m0, m1 runtime.MemStats
+		var elapsed time.Duration
+		for run := range runs {
+			if before != nil {
+				if err := before(run); err != nil {
+					return err
+				}
+			}
+			runtime.ReadMemStats(&m0)
+			start := time.Now()
+			c.Update()
+			elapsed += time.Since(start)
+			runtime.ReadMemStats(&m1)
+			p.AllocsPerRun += float64(m1.Mallocs - m0.Mallocs)
+			p.BytesPerRun += float64(m1.TotalAlloc - m0.TotalAlloc)
+		}
+		p.Seconds = elapsed.Seconds()
+		p.SecondsPerRun = p.Seconds / float64(runs)
+		p.AllocsPerRun /= float64(runs)
+		p.BytesPerRun /= float64(runs)
+		if p.Seconds > 0 {
+			p.LinesPerSec = float64(lines) / p.Seconds
+			p.MBPerSec = float64(bytes) / 1e6 / p.Seconds
+		}
+		result.Phases = append(result.Phases, p)
+		return nil
+	}
+
+	if err := measure("cold", 1, tree.Lines, tree.Bytes, nil); err != nil {
+		return nil, err
+	}
+	if !c.Ready() {
+		return nil, fmt.Errorf("the cold scan produced no results")
+	}
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	result.HeapBytes = m.HeapAlloc
+
+	if err := measure("unchanged", scans, 0, 0, nil); err != nil {
+		return nil, err
+	}
+
+	// each append scan adds one turn to the next active sessions
+	active = min(active, tree.Projects*tree.Sessions)
+	var appended int64
+	turns := tree.Lines / (tree.Projects * tree.Sessions * benchLinesPerTurn)
+	appendTurns := func(run int) error {
+		for i := range active {
+			n := (run*active + i) % (tree.Projects * tree.Sessions)
+			p, s := n/tree.Sessions, n%tree.Sessions
+			path := filepath.Join(root, "projects", benchProject(p), benchSession(p, s)+".jsonl")
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				return err
+			}
+			cw := &countingWriter{w: f}
+			writeBenchTurn(cw, p, s, turns+run, time.Now())
+			appended += cw.n
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// the appended bytes are only known after the scans
+	if err := measure("append", scans, scans*active*benchLinesPerTurn, 0, appendTurns); err != nil {
+		return nil, err
+	}
+	p := &result.Phases[len(result.Phases)-1]
+	p.Bytes = appended
+	if p.Seconds > 0 {
+		p.MBPerSec = float64(appended) / 1e6 / p.Seconds
+	}
+	return result, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
+
+// compareBench fails when a phase of result takes more than maxRegression
+// more time or allocations per scan than in the baseline file.
+func compareBench(path string, result *benchResult, maxRegression float64) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var base benchResult
+	if err := json.Unmarshal(data, &base); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if base.Tree.Projects != result.Tree.Projects || base.Tree.Sessions != result.Tree.Sessions || base.Tree.Lines != result.Tree.Lines {
+		return fmt.Errorf("%s was measured on a tree of another size", path)
+	}
+	phases := make(map[string]benchPhase)
+	for _, p := range base.Phases {
+		phases[p.Name] = p
+	}
+	var regressed []string
+	for _, p := range result.Phases {
+		b, ok := phases[p.Name]
+		if !ok {
+			continue
+		}
+		for _, m := range []struct {
+			what      string
+			got, want float64
+		}{
+			{"seconds/scan", p.SecondsPerRun, b.SecondsPerRun},
+			{"allocs/scan", p.AllocsPerRun, b.AllocsPerRun},
+		} {
+			change := 0.0
+			if m.want > 0 {
+				change = m.got/m.want - 1
+			}
+			log.Printf("bench: %-9s %-12s %+6.1f%% against %s", p.Name, m.what, change*100, path)
+			if change > maxRegression {
+				regressed = append(regressed, fmt.Sprintf("%s %s %+.1f%%", p.Name, m.what, change*100))
+			}
+		}
+	}
+	if len(regressed) > 0 {
+		return fmt.Errorf("regressed past %.0f%%: %s", maxRegression*100, strings.Join(regressed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/remote"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- configuration check ---
+
+// The variables read as numbers, flags and durations. The exporter falls
+// back to the default on a value it can't parse, so a typo goes unnoticed
+// until the check reports it.
+var (
+	intVars = []string{
+		"EXPORTER_PORT", "API_PORT", "ADMIN_PORT", "HEALTH_MAX_AGE",
+		"LIVE_WINDOW_MINUTES", "MAX_LABEL_CARDINALITY", "SAMPLE_EVERY",
+		"SCAN_LINE_LIMIT_MB", "SCAN_MEMORY_BUDGET_MB", "TOP_SESSIONS", "WINDOW_TOKEN_LIMIT",
+		"DEGRADED_STAT_MS", "DEGRADED_SCAN_INTERVAL",
+		"SINK_INTERVAL", "PUSH_INTERVAL", "STATSD_INTERVAL", "PUSH_STALE_AFTER",
+		"STATE_SAVE_INTERVAL", "STATE_RETENTION_DAYS", "REMOTE_SYNC_INTERVAL",
+		"NOTIFY_INTERVAL", "NOTIFY_TURN_MINUTES", "NOTIFY_IDLE_MINUTES",
+	}
+	boolVars     = []string{"ENABLE_PPROF", "NATIVE_HISTOGRAMS", "SUMMARIES_ONLY", "LANGUAGE_LABELS", "GRPC_API"}
+	durationVars = []string{"RECENT_WINDOW", "SCAN_TIMEOUT"}
+)
+
+// configCheck collects what --check-config finds. Errors stop the exporter
+// from working; warnings are setups that likely export nothing.
+type configCheck struct {
+	errors   []string
+	warnings []string
+}
+
+func (c *configCheck) errorf(format string, args ...any) {
+	c.errors = append(c.errors, fmt.Sprintf(format, args...))
+}
+
+func (c *configCheck) warnf(format string, args ...any) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// runCheckConfig validates the configuration in the environment without
+// starting the exporter, prints what is wrong and returns the exit status:
+// 0 when there are no errors.
+func runCheckConfig() int {
+	c := &configCheck{}
+	mode := envOr("MODE", "standalone")
+	if !slices.Contains([]string{"standalone", "agent", "server", "remote"}, mode) {
+		c.errorf("MODE: unknown mode %q (want standalone, agent, server or remote)", mode)
+	}
+	c.checkValues()
+
+	switch mode {
+	case "standalone", "agent":
+		c.checkLocalData()
+		c.checkFiles()
+	case "remote":
+		if _, err := remote.ParseHosts(envList("REMOTE_HOSTS"), envOr("REMOTE_CACHE_DIR", "/data/remote")); err != nil {
+			c.errorf("REMOTE_HOSTS: %v", err)
+		} else if len(envList("REMOTE_HOSTS")) == 0 {
+			c.errorf("REMOTE_HOSTS: remote mode requires at least one host")
+		}
+		c.checkFiles()
+	case "server":
+		if len(envList("PUSH_TOKENS")) == 0 {
+			c.errorf("PUSH_TOKENS: server mode requires at least one token")
+		}
+	}
+	c.checkLabelRules()
+	c.checkSinks(mode)
+	c.checkServing()
+
+	for _, w := range c.warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+	for _, e := range c.errors {
+		fmt.Fprintf(os.Stderr, "error: %s\n", e)
+	}
+	if len(c.errors) > 0 {
+		fmt.Fprintf(os.Stderr, "configuration has %d error(s)\n", len(c.errors))
+		return 1
+	}
+	fmt.Println("configuration ok")
+	return 0
+}
+
+// checkValues reports numbers, flags and durations that don't parse, and
+// counts that can't be negative.
+func (c *configCheck) checkValues() {
+	for _, key := range intVars {
+		if v := os.Getenv(key); v != "" {
+			if n, err := strconv.Atoi(v); err != nil {
+				c.errorf("%s: %q is not a whole number", key, v)
+			} else if n < 0 {
+				c.errorf("%s: %d is negative", key, n)
+			}
+		}
+	}
+	for _, key := range boolVars {
+		if v := os.Getenv(key); v != "" {
+			if _, err := strconv.ParseBool(v); err != nil {
+				c.errorf("%s: %q is not true or false", key, v)
+			}
+		}
+	}
+	for _, key := range durationVars {
+		if v := os.Getenv(key); v != "" {
+			if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+				c.errorf("%s: %q is not a duration like 24h", key, v)
+			}
+		}
+	}
+	for _, v := range envList("SUMMARY_QUANTILES") {
+		if q, err := strconv.ParseFloat(v, 64); err != nil || q <= 0 || q >= 1 {
+			c.errorf("SUMMARY_QUANTILES: %q is not a number between 0 and 1", v)
+		}
+	}
+}
+
+// checkLocalData makes sure the exporter finds Claude data to read:
+// CLAUDE_DIR or each of CLAUDE_CONFIG_DIRS, and the optional agent dirs.
+func (c *configCheck) checkLocalData() {
+	var dirs []string
+	for _, pattern := range envList("CLAUDE_CONFIG_DIRS") {
+		matches, err := filepath.Glob(pattern)
+		switch {
+		case err != nil:
+			c.errorf("CLAUDE_CONFIG_DIRS: %q: %v", pattern, err)
+		case len(matches) == 0 && strings.ContainsAny(pattern, "*?["):
+			c.warnf("CLAUDE_CONFIG_DIRS: %q matches no directory", pattern)
+		case len(matches) == 0:
+			dirs = append(dirs, pattern)
+		}
+		dirs = append(dirs, matches...)
+	}
+	if len(dirs) > 0 {
+		for _, dir := range dirs {
+			c.checkClaudeDir("CLAUDE_CONFIG_DIRS", dir, filepath.Join(dir, "stats-cache.json"))
+		}
+	} else {
+		dir := envOr("CLAUDE_DIR", "/data/claude")
+		c.checkClaudeDir("CLAUDE_DIR", dir, envOr("CLAUDE_STATS_FILE", filepath.Join(dir, "stats-cache.json")))
+	}
+	for _, key := range []string{"CODEX_DIR", "GEMINI_DIR"} {
+		if dir := envOr(key, ""); dir != "" {
+			c.checkDir(key, dir)
+		}
+	}
+}
+
+// checkClaudeDir fails when dir is missing or holds neither the stats
+// cache nor session logs, which exports only zeros, and warns when it
+// lacks one of them.
+func (c *configCheck) checkClaudeDir(key, dir, statsFile string) {
+	if !c.checkDir(key, dir) {
+		return
+	}
+	_, statsErr := os.Stat(statsFile)
+	_, projectsErr := os.Stat(filepath.Join(dir, "projects"))
+	switch {
+	case statsErr != nil && projectsErr != nil:
+		c.errorf("%s: %s has neither %s nor a projects directory; is it the Claude config dir (usually ~/.claude)?", key, dir, filepath.Base(statsFile))
+	case statsErr != nil:
+		c.warnf("stats file %s: %v; only live session metrics will be exported", statsFile, statsErr)
+	case projectsErr != nil:
+		c.warnf("%s: %s has no projects directory; only stats-cache metrics will be exported", key, dir)
+	}
+}
+
+// checkDir reports whether dir is a readable directory.
+func (c *configCheck) checkDir(key, dir string) bool {
+	if _, err := os.ReadDir(dir); err != nil {
+		c.errorf("%s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// checkFiles loads the optional pricing, model rules and team mapping
+// files, and checks the state file and audit log can be written.
+func (c *configCheck) checkFiles() {
+	if f := envOr("PRICING_FILE", ""); f != "" {
+		if _, err := pricing.Load(f); err != nil {
+			c.errorf("PRICING_FILE: %s: %v", f, err)
+		}
+	}
+	if f := envOr("MODEL_RULES_FILE", ""); f != "" {
+		if _, err := model.LoadRules(f); err != nil {
+			c.errorf("MODEL_RULES_FILE: %s: %v", f, err)
+		}
+	}
+	if f := envOr("TEAM_MAPPING_FILE", ""); f != "" {
+		if _, err := source.LoadTeamMap(f); err != nil {
+			c.errorf("TEAM_MAPPING_FILE: %s: %v", f, err)
+		}
+	}
+	if f := envOr("LANGUAGE_MAPPING_FILE", ""); f != "" {
+		if _, err := source.LoadLanguageMap(f); err != nil {
+			c.errorf("LANGUAGE_MAPPING_FILE: %s: %v", f, err)
+		}
+	}
+	if f := envOr("STATE_FILE", ""); f != "" {
+		c.checkWritableDir("STATE_FILE", filepath.Dir(f))
+	}
+	if f := envOr("AUDIT_LOG", ""); f != "" {
+		c.checkWritableDir("AUDIT_LOG", filepath.Dir(f))
+	}
+}
+
+// checkWritableDir fails unless a file can be created in dir.
+func (c *configCheck) checkWritableDir(key, dir string) {
+	f, err := os.CreateTemp(dir, ".check-config-*")
+	if err != nil {
+		c.errorf("%s: can't write to %s: %v", key, dir, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// checkLabelRules validates the allow and deny patterns of the label
+// cardinality controls.
+func (c *configCheck) checkLabelRules() {
+	for _, prefix := range []string{"MODEL", "TOOL", "STOP_REASON"} {
+		for _, key := range []string{prefix + "_ALLOW", prefix + "_DENY"} {
+			for _, p := range envList(key) {
+				if _, err := path.Match(p, ""); err != nil {
+					c.errorf("%s: %q is not a valid pattern: %v", key, p, err)
+				}
+			}
+		}
+	}
+	if n := envInt("MAX_LABEL_CARDINALITY", 0); n == 1 {
+		c.warnf("MAX_LABEL_CARDINALITY: 1 keeps only the other value")
+	}
+}
+
+// checkSinks builds each sink in SINKS, as the exporter does at startup,
+// and validates the notification settings.
+func (c *configCheck) checkSinks(mode string) {
+	names := sinkNames(mode)
+	sd, sdErr := statsdClient()
+	if sdErr != nil {
+		c.errorf("statsd: %v", sdErr)
+	}
+	if sd != nil {
+		defer sd.Close()
+	}
+	registry := sinkRegistry(prometheus.NewRegistry(), sd)
+	for _, name := range names {
+		if name == "prometheus" {
+			continue
+		}
+		build, ok := registry[name]
+		if !ok {
+			c.errorf("SINKS: unknown sink %q (want prometheus, textfile, otlp, statsd, influx, pushgateway or push)", name)
+			continue
+		}
+		if name == "statsd" && sdErr != nil {
+			continue
+		}
+		if _, err := build(); err != nil {
+			c.errorf("sink %s: %v", name, err)
+		}
+		if name == "textfile" && strings.HasSuffix(envOr("TEXTFILE_PATH", ""), ".prom") {
+			c.checkWritableDir("TEXTFILE_PATH", filepath.Dir(envOr("TEXTFILE_PATH", "")))
+		}
+	}
+	if envOr("GOTIFY_URL", "") != "" && envOr("NTFY_URL", "") == "" && envOr("GOTIFY_TOKEN", "") == "" {
+		c.errorf("GOTIFY_URL requires GOTIFY_TOKEN")
+	}
+}
+
+// checkServing loads the TLS certificates, which come in pairs, checks
+// that the gRPC API has one and parses the admin address.
+func (c *configCheck) checkServing() {
+	if addr := envOr("ADMIN_ADDR", ""); addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			c.errorf("ADMIN_ADDR: %v", err)
+		}
+	}
+	if cert, key := apiCerts(envInt("EXPORTER_PORT", 9101)); envBool("GRPC_API", false) && (cert == "" || key == "") {
+		c.errorf("GRPC_API: gRPC needs HTTP/2, which the exporter only serves over TLS; set TLS_CERT_FILE and TLS_KEY_FILE (or API_TLS_*)")
+	}
+	pairs := []struct{ prefix, cert, key string }{
+		{"TLS", envOr("TLS_CERT_FILE", ""), envOr("TLS_KEY_FILE", "")},
+	}
+	if envOr("API_TLS_CERT_FILE", "") != "" || envOr("API_TLS_KEY_FILE", "") != "" {
+		// the API listener falls back to the main certificate
+		pairs = append(pairs, struct{ prefix, cert, key string }{
+			"API_TLS", envOr("API_TLS_CERT_FILE", envOr("TLS_CERT_FILE", "")), envOr("API_TLS_KEY_FILE", envOr("TLS_KEY_FILE", "")),
+		})
+	}
+	for _, p := range pairs {
+		switch {
+		case p.cert == "" && p.key == "":
+		case p.cert == "" || p.key == "":
+			c.errorf("%s_CERT_FILE and %s_KEY_FILE must be set together", p.prefix, p.prefix)
+		default:
+			if _, err := tls.LoadX509KeyPair(p.cert, p.key); err != nil {
+				c.errorf("%s_CERT_FILE: %v", p.prefix, err)
+			}
+		}
+	}
+}
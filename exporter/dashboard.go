@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+)
+
+// --- generated Grafana dashboard ---
+
+const dashboardUID = "claude-exporter-generated"
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type panelTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	Instant      bool   `json:"instant,omitempty"`
+	Format       string `json:"format,omitempty"`
+	RefID        string `json:"refId"`
+}
+
+type panel struct {
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	GridPos     gridPos           `json:"gridPos"`
+	Datasource  map[string]string `json:"datasource,omitempty"`
+	Targets     []panelTarget     `json:"targets,omitempty"`
+	Collapsed   *bool             `json:"collapsed,omitempty"`
+	Panels      []panel           `json:"panels"`
+}
+
+// dashboardLayout places panels on Grafana's 24-column grid.
+type dashboardLayout struct {
+	panels []panel
+	nextID int
+	x, y   int
+	rowH   int
+}
+
+func (l *dashboardLayout) row(title string) {
+	l.newline()
+	collapsed := false
+	l.nextID++
+	l.panels = append(l.panels, panel{
+		ID: l.nextID, Type: "row", Title: title, Collapsed: &collapsed,
+		GridPos: gridPos{H: 1, W: 24, X: 0, Y: l.y}, Panels: []panel{},
+	})
+	l.y++
+}
+
+func (l *dashboardLayout) add(p panel, w, h int) {
+	if l.x+w > 24 {
+		l.newline()
+	}
+	l.nextID++
+	p.ID = l.nextID
+	p.GridPos = gridPos{H: h, W: w, X: l.x, Y: l.y}
+	p.Datasource = map[string]string{"type": "prometheus", "uid": "${datasource}"}
+	p.Panels = []panel{}
+	l.panels = append(l.panels, p)
+	l.x += w
+	if h > l.rowH {
+		l.rowH = h
+	}
+}
+
+func (l *dashboardLayout) newline() {
+	if l.x > 0 {
+		l.y += l.rowH
+	}
+	l.x, l.rowH = 0, 0
+}
+
+func isPeriodLabel(label string) bool {
+	return label == "date" || label == "week" || label == "month" || label == "hour"
+}
+
+func panelTitle(name string) string {
+	words := strings.Split(strings.TrimPrefix(name, "claude_"), "_")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func legendFormat(labels []string) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = "{{" + l + "}}"
+	}
+	return strings.Join(parts, " ")
+}
+
+// generateDashboard builds a Grafana dashboard with one panel per metric:
+// stats for scalars, time series for labelled gauges, bar gauges for
+// date-bucketed series and heatmaps for histograms.
+func generateDashboard(metas []collector.MetricInfo) map[string]any {
+	var scalars, breakdowns, periods, histograms []collector.MetricInfo
+	for _, m := range metas {
+		switch {
+		case strings.HasSuffix(m.Name, "_info"):
+			// metadata only, nothing to chart
+		case m.Histogram:
+			histograms = append(histograms, m)
+		case len(m.Labels) == 0:
+			scalars = append(scalars, m)
+		case isPeriodLabel(m.Labels[0]):
+			periods = append(periods, m)
+		default:
+			breakdowns = append(breakdowns, m)
+		}
+	}
+
+	l := &dashboardLayout{}
+
+	l.row("Overview")
+	for _, m := range scalars {
+		l.add(panel{
+			Type: "stat", Title: panelTitle(m.Name), Description: m.Help,
+			Targets: []panelTarget{{Expr: m.Name, RefID: "A"}},
+		}, 4, 4)
+	}
+
+	l.row("Breakdown")
+	for _, m := range breakdowns {
+		l.add(panel{
+			Type: "timeseries", Title: panelTitle(m.Name), Description: m.Help,
+			Targets: []panelTarget{{
+				Expr:         fmt.Sprintf("sum by (%s) (%s)", strings.Join(m.Labels, ", "), m.Name),
+				LegendFormat: legendFormat(m.Labels),
+				RefID:        "A",
+			}},
+		}, 12, 8)
+	}
+
+	l.row("Trends")
+	for _, m := range periods {
+		l.add(panel{
+			Type: "bargauge", Title: panelTitle(m.Name), Description: m.Help,
+			Targets: []panelTarget{{
+				Expr:         m.Name,
+				LegendFormat: legendFormat(m.Labels),
+				Instant:      true,
+				RefID:        "A",
+			}},
+		}, 12, 8)
+	}
+
+	l.row("Distributions")
+	for _, m := range histograms {
+		l.add(panel{
+			Type: "heatmap", Title: panelTitle(m.Name), Description: m.Help,
+			Targets: []panelTarget{{
+				Expr:         fmt.Sprintf("sum by (le) (increase(%s_bucket[$__rate_interval]))", m.Name),
+				LegendFormat: "{{le}}",
+				Format:       "heatmap",
+				RefID:        "A",
+			}},
+		}, 12, 8)
+	}
+
+	return map[string]any{
+		"uid":           dashboardUID,
+		"title":         "Claude Code (generated)",
+		"tags":          []string{"claude", "generated"},
+		"timezone":      "browser",
+		"schemaVersion": 39,
+		"version":       1,
+		"editable":      true,
+		"refresh":       "30s",
+		"time":          map[string]string{"from": "now-24h", "to": "now"},
+		"templating": map[string]any{
+			"list": []map[string]any{{
+				"name":  "datasource",
+				"label": "Data source",
+				"type":  "datasource",
+				"query": "prometheus",
+			}},
+		},
+		"panels": l.panels,
+	}
+}
+
+func dashboardJSON(c *collector.Collector) ([]byte, error) {
+	return json.MarshalIndent(generateDashboard(c.MetricInfos()), "", "  ")
+}
+
+// runDashboard implements the `dashboard` subcommand.
+func runDashboard(args []string) error {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+	output := fs.String("output", "-", "file to write the dashboard JSON to (- for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := dashboardJSON(collector.NewCollector(collector.Options{}))
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0o644)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+	"github.com/parquet-go/parquet-go"
+)
+
+// --- per-message export ---
+
+// exportRow is the parquet schema of an exported message.
+type exportRow struct {
+	Timestamp           time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Session             string    `parquet:"session,dict"`
+	Project             string    `parquet:"project,dict"`
+	Model               string    `parquet:"model,dict"`
+	InputTokens         int64     `parquet:"input_tokens"`
+	OutputTokens        int64     `parquet:"output_tokens"`
+	CacheReadTokens     int64     `parquet:"cache_read_tokens"`
+	CacheCreationTokens int64     `parquet:"cache_creation_tokens"`
+	CostUSD             float64   `parquet:"cost_usd"`
+	Tools               []string  `parquet:"tools,list"`
+}
+
+var exportHeader = []string{
+	"timestamp", "session", "project", "model",
+	"input_tokens", "output_tokens", "cache_read_tokens", "cache_creation_tokens",
+	"cost_usd", "tools",
+}
+
+func writeCSV(w io.Writer, rows []source.MessageRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		cw.Write([]string{
+			r.Timestamp.UTC().Format(time.RFC3339Nano),
+			r.Session,
+			r.Project,
+			r.Model,
+			strconv.FormatFloat(r.Input, 'f', -1, 64),
+			strconv.FormatFloat(r.Output, 'f', -1, 64),
+			strconv.FormatFloat(r.CacheRead, 'f', -1, 64),
+			strconv.FormatFloat(r.CacheCreate, 'f', -1, 64),
+			strconv.FormatFloat(r.Cost, 'f', -1, 64),
+			strings.Join(r.Tools, ";"),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeParquet(w io.Writer, rows []source.MessageRow) error {
+	out := make([]exportRow, len(rows))
+	for i, r := range rows {
+		out[i] = exportRow{
+			Timestamp:           r.Timestamp.UTC(),
+			Session:             r.Session,
+			Project:             r.Project,
+			Model:               r.Model,
+			InputTokens:         int64(r.Input),
+			OutputTokens:        int64(r.Output),
+			CacheReadTokens:     int64(r.CacheRead),
+			CacheCreationTokens: int64(r.CacheCreate),
+			CostUSD:             r.Cost,
+			Tools:               r.Tools,
+		}
+	}
+	pw := parquet.NewGenericWriter[exportRow](w)
+	if _, err := pw.Write(out); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+// parseExportTime accepts a local date or an RFC 3339 timestamp. With
+// endOfDay set, a date means the end of that day so --to is inclusive.
+func parseExportTime(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: want YYYY-MM-DD or RFC 3339", s)
+	}
+	return t, nil
+}
+
+// runExport implements the `export` subcommand.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format: csv or parquet")
+	fromFlag := fs.String("from", "", "first day (YYYY-MM-DD) or time (RFC 3339) to export")
+	toFlag := fs.String("to", "", "last day (YYYY-MM-DD, inclusive) or time (RFC 3339, exclusive) to export")
+	output := fs.String("output", "-", "file to write to (- for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var write func(io.Writer, []source.MessageRow) error
+	switch *format {
+	case "csv":
+		write = writeCSV
+	case "parquet":
+		write = writeParquet
+	default:
+		return fmt.Errorf("unknown format %q (want csv or parquet)", *format)
+	}
+	from, err := parseExportTime(*fromFlag, false)
+	if err != nil {
+		return err
+	}
+	to, err := parseExportTime(*toFlag, true)
+	if err != nil {
+		return err
+	}
+
+	rows, err := readHistory(from, to)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*output, func(w io.Writer) error { return write(w, rows) })
+}
+
+// readHistory reads the session history with the same CLAUDE_DIR,
+// PRICING_FILE and project filters as the exporter.
+func readHistory(from, to time.Time) ([]source.MessageRow, error) {
+	prices, err := pricing.Load(envOr("PRICING_FILE", ""))
+	if err != nil {
+		return nil, err
+	}
+	sessions := source.NewClaudeSessions(source.ClaudeSessionsOptions{
+		ClaudeDir: envOr("CLAUDE_DIR", "/data/claude"),
+		Pricing:   prices,
+		Projects: source.ProjectFilter{
+			Include: envList("PROJECT_INCLUDE"),
+			Exclude: envList("PROJECT_EXCLUDE"),
+		},
+	})
+	return sessions.History(context.Background(), from, to)
+}
+
+// writeOutput runs write on stdout for "-", or on the named file.
+func writeOutput(name string, write func(io.Writer) error) error {
+	if name == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
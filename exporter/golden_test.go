@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- golden fixtures ---
+//
+// Every directory under testdata/golden is a case: a Claude config
+// directory in claude/, optionally Codex and Gemini directories in codex/
+// and gemini/, and the metrics they produce in metrics.golden. Each case
+// is scanned against a fixed clock, so a new metric can't silently break
+// an existing one.
+
+var update = flag.Bool("update", false, "rewrite metrics.golden instead of comparing")
+
+// goldenNow is the clock of every case, in UTC so dates and hours are
+// bucketed the same on every machine. Files count as written then, and
+// stats-cache.json a day earlier, since a checkout doesn't keep mtimes.
+var goldenNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestGolden(t *testing.T) {
+	entries, err := os.ReadDir(filepath.Join("testdata", "golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		caseDir := filepath.Join("testdata", "golden", e.Name())
+		t.Run(e.Name(), func(t *testing.T) {
+			got := goldenMetrics(t, caseDir)
+			path := filepath.Join(caseDir, "metrics.golden")
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := diffLines(string(want), string(got)); diff != "" {
+				t.Errorf("metrics differ from %s (rerun with -update if intended):\n%s", path, diff)
+			}
+		})
+	}
+}
+
+// goldenMetrics scans a copy of a case and returns its metrics in the text
+// format, leaving out the exporter's own metrics (paths, timings).
+func goldenMetrics(t *testing.T, caseDir string) []byte {
+	t.Helper()
+	families, err := goldenRegistry(t, caseDir).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, mf := range families {
+		if strings.HasPrefix(mf.GetName(), "claude_exporter_") {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// goldenRegistry registers a collector over a copy of a case.
+func goldenRegistry(t *testing.T, caseDir string) *prometheus.Registry {
+	t.Helper()
+	tmp := t.TempDir()
+	if err := os.CopyFS(tmp, os.DirFS(caseDir)); err != nil {
+		t.Fatal(err)
+	}
+	err := filepath.WalkDir(tmp, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		mtime := goldenNow
+		if d.Name() == "stats-cache.json" {
+			mtime = goldenNow.Add(-24 * time.Hour)
+		}
+		return os.Chtimes(path, mtime, mtime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := func() time.Time { return goldenNow }
+	claudeDir := filepath.Join(tmp, "claude")
+	statsFile := filepath.Join(claudeDir, "stats-cache.json")
+	sources := []source.Source{
+		source.NewStatsCache(statsFile),
+		source.NewClaudeSessions(source.ClaudeSessionsOptions{
+			ClaudeDir: claudeDir,
+			StatsFile: statsFile,
+			Pricing:   prices,
+			Now:       now,
+		}),
+	}
+	if dir := filepath.Join(tmp, "codex"); isDir(dir) {
+		sources = append(sources, source.NewCodex(dir, prices))
+	}
+	if dir := filepath.Join(tmp, "gemini"); isDir(dir) {
+		sources = append(sources, source.NewGemini(dir, prices))
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(collector.NewCollector(collector.Options{
+		StatsFile:   statsFile,
+		ClaudeDir:   claudeDir,
+		Pricing:     prices,
+		Sources:     sources,
+		TopSessions: 5,
+		Now:         now,
+	}))
+	return reg
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// diffLines lists the lines only in want (-) or only in got (+). Metric
+// families are sorted, so that is enough to spot what changed.
+func diffLines(want, got string) string {
+	count := make(map[string]int)
+	for _, l := range strings.Split(got, "\n") {
+		count[l]++
+	}
+	var out strings.Builder
+	for _, l := range strings.Split(want, "\n") {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		fmt.Fprintf(&out, "- %s\n", l)
+	}
+	for _, l := range strings.Split(got, "\n") {
+		if count[l] > 0 {
+			count[l]--
+			fmt.Fprintf(&out, "+ %s\n", l)
+		}
+	}
+	return out.String()
+}
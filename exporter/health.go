@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+)
+
+// --- health ---
+
+// healthTimeout bounds how long /healthz waits for a scan, within Docker's
+// default HEALTHCHECK timeout of 30s.
+const healthTimeout = 20 * time.Second
+
+// healthHandler fails when the stats file is unreadable or no scan has read
+// it within maxAge. A stale collector is scanned first, so an exporter that
+// nobody scrapes stays healthy; a scan still running after healthTimeout
+// means the exporter is wedged.
+func healthHandler(c *collector.Collector, maxAge time.Duration) http.HandlerFunc {
+	var mu sync.Mutex
+	var inflight chan struct{}
+	refresh := func() <-chan struct{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if inflight == nil {
+			done := make(chan struct{})
+			inflight = done
+			go func() {
+				c.Update()
+				mu.Lock()
+				inflight = nil
+				mu.Unlock()
+				close(done)
+			}()
+		}
+		return inflight
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := os.Open(c.StatsFile())
+		if err != nil {
+			http.Error(w, fmt.Sprintf("stats file: %v", err), http.StatusServiceUnavailable)
+			return
+		}
+		f.Close()
+		if time.Since(c.LastUpdate()) > maxAge {
+			select {
+			case <-refresh():
+			case <-time.After(healthTimeout):
+			case <-r.Context().Done():
+			}
+		}
+		if last := c.LastUpdate(); time.Since(last) > maxAge {
+			http.Error(w, fmt.Sprintf("no successful scan since %s", last.Format(time.RFC3339)), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// runCheck queries the running exporter's /healthz for a container
+// HEALTHCHECK and returns the exit status: 0 when healthy.
+func runCheck(port int) int {
+	client := &http.Client{Timeout: healthTimeout + 5*time.Second}
+	scheme := "http"
+	if envOr("TLS_CERT_FILE", "") != "" && envOr("TLS_KEY_FILE", "") != "" {
+		scheme = "https"
+		// the certificate is issued for the service name, not localhost
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+	resp, err := client.Get(fmt.Sprintf("%s://127.0.0.1:%d/healthz", scheme, port))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "check: %s: %s", resp.Status, body)
+		return 1
+	}
+	return 0
+}
@@ -1,23 +1,41 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+	"github.com/aireet/cc-exporter/exporter/pkg/grpcapi"
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/notify"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/push"
+	"github.com/aireet/cc-exporter/exporter/pkg/remote"
+	"github.com/aireet/cc-exporter/exporter/pkg/sink"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+	"github.com/aireet/cc-exporter/exporter/pkg/statsd"
 )
 
 // --- config ---
 
+// shutdownTimeout bounds how long SIGTERM waits for in-flight requests,
+// within Kubernetes' default 30s grace period.
+const shutdownTimeout = 25 * time.Second
+
 func envOr(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -25,6 +43,27 @@ func envOr(key, fallback string) string {
 	return fallback
 }
 
+// envList splits a comma-separated variable, dropping empty entries.
+func envList(key string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func envBool(key string, fallback bool) bool {
+	if v := os.Getenv(key); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
 func envInt(key string, fallback int) int {
 	if v := os.Getenv(key); v != "" {
 		n, err := strconv.Atoi(v)
@@ -35,797 +74,759 @@ func envInt(key string, fallback int) int {
 	return fallback
 }
 
-// --- stats-cache.json structs ---
-
-type StatsCache struct {
-	ModelUsage       map[string]ModelUsage `json:"modelUsage"`
-	TotalSessions    int                   `json:"totalSessions"`
-	TotalMessages    int                   `json:"totalMessages"`
-	DailyActivity    []DailyActivity       `json:"dailyActivity"`
-	DailyModelTokens []DailyModelTokens    `json:"dailyModelTokens"`
-	HourCounts       map[string]float64    `json:"hourCounts"`
-	LastComputedDate string                `json:"lastComputedDate"`
-	FirstSessionDate string                `json:"firstSessionDate"`
-}
-
-type ModelUsage struct {
-	InputTokens              float64 `json:"inputTokens"`
-	OutputTokens             float64 `json:"outputTokens"`
-	CacheReadInputTokens     float64 `json:"cacheReadInputTokens"`
-	CacheCreationInputTokens float64 `json:"cacheCreationInputTokens"`
-	CostUSD                  float64 `json:"costUSD"`
-}
-
-type DailyActivity struct {
-	Date          string `json:"date"`
-	MessageCount  int    `json:"messageCount"`
-	SessionCount  int    `json:"sessionCount"`
-	ToolCallCount int    `json:"toolCallCount"`
-}
-
-type DailyModelTokens struct {
-	Date          string             `json:"date"`
-	TokensByModel map[string]float64 `json:"tokensByModel"`
-}
-
-// --- JSONL record structs ---
-
-type JSONLRecord struct {
-	Type    string `json:"type"`
-	Subtype string `json:"subtype,omitempty"`
-
-	// For type=assistant or type=progress (nested)
-	Message *JSONLMessage `json:"message,omitempty"`
-	Data    *JSONLData    `json:"data,omitempty"`
-
-	// For subtype=turn_duration
-	DurationMs *float64 `json:"durationMs,omitempty"`
-
-	// For subtype=api_error
-	RetryAttempt *int     `json:"retryAttempt,omitempty"`
-	MaxRetries   *int     `json:"maxRetries,omitempty"`
-	RetryInMs    *float64 `json:"retryInMs,omitempty"`
-
-	// For subtype=compact_boundary
-	CompactMetadata *CompactMetadata `json:"compactMetadata,omitempty"`
-}
-
-type JSONLData struct {
-	Message *JSONLDataMessage `json:"message,omitempty"`
+// envDuration reads a Go duration such as "24h" or "90m".
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil && d > 0 {
+			return d
+		}
+		log.Printf("%s: ignoring %q, want a duration like 24h", key, v)
+	}
+	return fallback
 }
 
-type JSONLDataMessage struct {
-	Message *JSONLMessage `json:"message,omitempty"`
+// summaryQuantiles reads SUMMARY_QUANTILES, e.g. "0.5,0.9,0.99".
+func summaryQuantiles() []float64 {
+	var qs []float64
+	for _, v := range envList("SUMMARY_QUANTILES") {
+		q, err := strconv.ParseFloat(v, 64)
+		if err != nil || q <= 0 || q >= 1 {
+			log.Printf("SUMMARY_QUANTILES: ignoring %q, want a number between 0 and 1", v)
+			continue
+		}
+		qs = append(qs, q)
+	}
+	return qs
 }
 
-type JSONLMessage struct {
-	Model      string         `json:"model"`
-	Role       string         `json:"role"`
-	StopReason *string        `json:"stop_reason"`
-	Content    []ContentBlock `json:"content"`
-	Usage      JSONLUsage     `json:"usage"`
+// labelLimiter reads PREFIX_ALLOW and PREFIX_DENY.
+func labelLimiter(prefix string, max int) collector.LabelLimiter {
+	return collector.LabelLimiter{
+		Allow: envList(prefix + "_ALLOW"),
+		Deny:  envList(prefix + "_DENY"),
+		Max:   max,
+	}
 }
 
-type ContentBlock struct {
-	Type string `json:"type"`
-	Name string `json:"name,omitempty"` // tool name for tool_use blocks
+func loadLabelLimits() collector.LabelLimits {
+	max := envInt("MAX_LABEL_CARDINALITY", 0)
+	return collector.LabelLimits{
+		Model:      labelLimiter("MODEL", max),
+		Tool:       labelLimiter("TOOL", max),
+		StopReason: labelLimiter("STOP_REASON", max),
+	}
 }
 
-type JSONLUsage struct {
-	InputTokens              *float64       `json:"input_tokens"`
-	OutputTokens             *float64       `json:"output_tokens"`
-	CacheReadInputTokens     *float64       `json:"cache_read_input_tokens"`
-	CacheCreationInputTokens *float64       `json:"cache_creation_input_tokens"`
-	Cost                     *float64       `json:"cost"`
-	CostDetails              *CostDetails   `json:"cost_details"`
-	ServerToolUse            *ServerToolUse `json:"server_tool_use"`
-	ServiceTier              *string        `json:"service_tier"`
-	IsByok                   *bool          `json:"is_byok"`
+// collectorPaths locates the data of one collector.
+type collectorPaths struct {
+	claudeDir string
+	statsFile string
+	stateFile string
+	auditLog  string
+	// Codex and Gemini are only read when set
+	codexDir  string
+	geminiDir string
 }
 
-type CostDetails struct {
-	UpstreamInferenceCost            *float64 `json:"upstream_inference_cost"`
-	UpstreamInferencePromptCost      *float64 `json:"upstream_inference_prompt_cost"`
-	UpstreamInferenceCompletionsCost *float64 `json:"upstream_inference_completions_cost"`
+// newLocalCollector builds the collector for the data on this machine,
+// forwarding histogram samples to sd when set.
+func newLocalCollector(sd *statsd.Client, watcher *notify.Watcher) *collector.Collector {
+	claudeDir := envOr("CLAUDE_DIR", "/data/claude")
+	paths := collectorPaths{
+		claudeDir: claudeDir,
+		statsFile: envOr("CLAUDE_STATS_FILE", filepath.Join(claudeDir, "stats-cache.json")),
+		stateFile: envOr("STATE_FILE", ""),
+		auditLog:  envOr("AUDIT_LOG", ""),
+		codexDir:  envOr("CODEX_DIR", ""),
+		geminiDir: envOr("GEMINI_DIR", ""),
+	}
+	log.Printf("Stats file: %s", paths.statsFile)
+	log.Printf("Claude dir: %s", paths.claudeDir)
+	return newCollector(paths, sd, watcher)
 }
 
-type ServerToolUse struct {
-	WebSearchRequests int `json:"web_search_requests"`
-	WebFetchRequests  int `json:"web_fetch_requests"`
-}
+func newCollector(paths collectorPaths, sd *statsd.Client, watcher *notify.Watcher) *collector.Collector {
+	claudeDir, statsFile := paths.claudeDir, paths.statsFile
+	pricingFile := envOr("PRICING_FILE", "")
+	prices, err := pricing.Load(pricingFile)
+	if err != nil {
+		log.Fatalf("failed to load pricing file %s: %v", pricingFile, err)
+	}
+	rulesFile := envOr("MODEL_RULES_FILE", "")
+	modelRules, err := model.LoadRules(rulesFile)
+	if err != nil {
+		log.Fatalf("failed to load model rules file %s: %v", rulesFile, err)
+	}
+	teamFile := envOr("TEAM_MAPPING_FILE", "")
+	teams, err := source.LoadTeamMap(teamFile)
+	if err != nil {
+		log.Fatalf("failed to load team mapping file %s: %v", teamFile, err)
+	}
+	var languages *source.LanguageMap
+	if languageFile := envOr("LANGUAGE_MAPPING_FILE", ""); languageFile != "" || envBool("LANGUAGE_LABELS", false) {
+		languages, err = source.LoadLanguageMap(languageFile)
+		if err != nil {
+			log.Fatalf("failed to load language mapping file %s: %v", languageFile, err)
+		}
+	}
 
-type CompactMetadata struct {
-	Trigger   string `json:"trigger"`
-	PreTokens int    `json:"preTokens"`
-}
+	sampleEvery := envInt("SAMPLE_EVERY", 0)
+	if sampleEvery > 1 {
+		log.Printf("Sampling one in %d API requests", sampleEvery)
+	}
+	sources := []source.Source{
+		source.NewStatsCache(statsFile),
+		source.NewClaudeSessions(source.ClaudeSessionsOptions{
+			ClaudeDir: claudeDir,
+			StatsFile: statsFile,
+			Pricing:   prices,
+			Projects: source.ProjectFilter{
+				Include: envList("PROJECT_INCLUDE"),
+				Exclude: envList("PROJECT_EXCLUDE"),
+			},
+			Teams:            teams,
+			Languages:        languages,
+			LiveWindow:       time.Duration(envInt("LIVE_WINDOW_MINUTES", 0)) * time.Minute,
+			LineLimit:        envInt("SCAN_LINE_LIMIT_MB", 0) << 20,
+			MemoryBudget:     envInt("SCAN_MEMORY_BUDGET_MB", 0) << 20,
+			BackgroundModels: envList("BACKGROUND_MODELS"),
+			RecentWindow:     envDuration("RECENT_WINDOW", source.RecentLookback),
+			SampleEvery:      sampleEvery,
+		}),
+	}
+	if paths.codexDir != "" {
+		log.Printf("Codex dir: %s", paths.codexDir)
+		sources = append(sources, source.NewCodex(paths.codexDir, prices))
+	}
+	if paths.geminiDir != "" {
+		log.Printf("Gemini dir: %s", paths.geminiDir)
+		sources = append(sources, source.NewGemini(paths.geminiDir, prices))
+	}
 
-// --- live session aggregation ---
+	var onObserve func(string, prometheus.Labels, float64)
+	if sd != nil {
+		onObserve = sd.Observe
+	}
+	var onUpdate func(*source.LiveResult)
+	if watcher != nil {
+		onUpdate = watcher.Update
+	}
 
-type LiveModelUsage struct {
-	Input       float64
-	Output      float64
-	CacheRead   float64
-	CacheCreate float64
+	return collector.NewCollector(collector.Options{
+		StatsFile:      statsFile,
+		ClaudeDir:      claudeDir,
+		StateFile:      paths.stateFile,
+		StateRetention: time.Duration(envInt("STATE_RETENTION_DAYS", 30)) * 24 * time.Hour,
+		AuditLog:       paths.auditLog,
+		Pricing:        prices,
+		ModelRules:     modelRules,
+		Limits:         loadLabelLimits(),
+		Languages:      languages,
+		Sources:        sources,
+		AgentPrefixes: map[string]string{
+			"codex":  envOr("CODEX_METRIC_PREFIX", "codex"),
+			"gemini": envOr("GEMINI_METRIC_PREFIX", "gemini"),
+		},
+		NativeHistograms: envBool("NATIVE_HISTOGRAMS", false),
+		WindowTokenLimit: float64(envInt("WINDOW_TOKEN_LIMIT", 0)),
+		TopSessions:      envInt("TOP_SESSIONS", 5),
+		SampleEvery:      sampleEvery,
+		OnObserve:        onObserve,
+		OnUpdate:         onUpdate,
+		ScanTimeout:      envDuration("SCAN_TIMEOUT", 0),
+		SummaryQuantiles: summaryQuantiles(),
+		SummariesOnly:    envBool("SUMMARIES_ONLY", false),
+
+		DegradedStatLatency: time.Duration(envInt("DEGRADED_STAT_MS", 50)) * time.Millisecond,
+		DegradedInterval:    time.Duration(envInt("DEGRADED_SCAN_INTERVAL", 120)) * time.Second,
+	})
 }
 
-type LiveResult struct {
-	ModelUsage   map[string]*LiveModelUsage
-	SessionCount int
-	MessageCount int
-
-	// New per-request metrics from JSONL
-	TurnDurations    []float64
-	ToolUseCounts    map[string]int
-	StopReasons      map[string]int
-	APIErrors        int
-	APIRetries       int
-	CompactEvents    int
-	CompactPreTokens []float64
-	WebSearches      int
-	WebFetches       int
+// handleLocal adds the dashboards and APIs backed by a local collector.
+// They are meant for people, so they can be served apart from /metrics.
+// It returns the gRPC service, whose streams end with the listener, or nil
+// when the listener has no TLS for it.
+func handleLocal(mux *http.ServeMux, c *collector.Collector, tls bool) *grpcapi.Server {
+	dashboard, err := dashboardJSON(c)
+	if err != nil {
+		log.Fatalf("failed to generate dashboard: %v", err)
+	}
+	mux.HandleFunc("/grafana/dashboard.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(dashboard)
+	})
+	tokens := envList("API_TOKENS")
+	mux.HandleFunc("/api/v1/summary", apiAuth(tokens, summaryHandler(c)))
+	mux.HandleFunc("/api/v1/sessions", apiAuth(tokens, sessionsHandler(c)))
+	mux.HandleFunc("/api/v1/history", apiAuth(tokens, historyHandler(c)))
+	var query *grpcapi.Server
+	if grpc := envBool("GRPC_API", tls); grpc && !tls {
+		log.Printf("GRPC_API: gRPC needs HTTP/2, which the API listener only serves over TLS, not serving it")
+	} else if grpc {
+		query = &grpcapi.Server{
+			Collector: c,
+			Authorize: func(r *http.Request) bool { return len(tokens) == 0 || validToken(tokens, r) },
+		}
+		mux.Handle(grpcapi.Path, query)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(indexHTML)
+	})
+	return query
 }
 
-// --- helper ---
-
-func shortModel(name string) string {
-	name = strings.ReplaceAll(name, "anthropic/", "")
-	// Normalize version separators: "claude-opus-4.6" → "claude-opus-4-6"
-	// This avoids duplicate model entries with dots vs dashes
-	name = strings.ReplaceAll(name, ".", "-")
-	return name
+// readyHandler fails until every collector has loaded its stats cache.
+func readyHandler(collectors ...*collector.Collector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range collectors {
+			if !c.Ready() {
+				c.Update()
+			}
+			if !c.Ready() {
+				http.Error(w, fmt.Sprintf("stats not loaded: %s", c.StatsFile()), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("ok\n"))
+	}
 }
 
-func ptrVal(p *float64) float64 {
-	if p == nil {
-		return 0
+// apiCerts returns the certificate pair of the listener serving the
+// dashboard and APIs.
+func apiCerts(port int) (certFile, keyFile string) {
+	if apiPort := envInt("API_PORT", 0); apiPort != 0 && apiPort != port {
+		return envOr("API_TLS_CERT_FILE", envOr("TLS_CERT_FILE", "")), envOr("API_TLS_KEY_FILE", envOr("TLS_KEY_FILE", ""))
 	}
-	return *p
+	return envOr("TLS_CERT_FILE", ""), envOr("TLS_KEY_FILE", "")
 }
 
-// --- collector ---
-
-type claudeCollector struct {
-	statsFile string
-	claudeDir string
-
-	// cumulative (cache + live)
-	modelInputTokens       *prometheus.GaugeVec
-	modelOutputTokens      *prometheus.GaugeVec
-	modelCacheReadTokens   *prometheus.GaugeVec
-	modelCacheCreateTokens *prometheus.GaugeVec
-
-	// live only
-	liveInputTokens  *prometheus.GaugeVec
-	liveOutputTokens *prometheus.GaugeVec
-	liveSessions     prometheus.Gauge
-	liveMessages     prometheus.Gauge
-
-	// totals
-	totalSessions prometheus.Gauge
-	totalMessages prometheus.Gauge
-
-	// today
-	todayMessages  prometheus.Gauge
-	todaySessions  prometheus.Gauge
-	todayToolCalls prometheus.Gauge
-	todayTokens    *prometheus.GaugeVec
-
-	// daily (last 30 days)
-	dailyMessages  *prometheus.GaugeVec
-	dailySessions  *prometheus.GaugeVec
-	dailyToolCalls *prometheus.GaugeVec
-	dailyTokens    *prometheus.GaugeVec
-
-	// hour distribution
-	hourActivity *prometheus.GaugeVec
-
-	// info
-	exporterInfo *prometheus.GaugeVec
-
-	// --- NEW: turn duration ---
-	turnDuration prometheus.Histogram
-
-	// --- NEW: tool usage breakdown ---
-	toolUseTotal *prometheus.GaugeVec
-
-	// --- NEW: stop reason ---
-	stopReasonTotal *prometheus.GaugeVec
-
-	// --- NEW: API errors ---
-	apiErrorsTotal  prometheus.Gauge
-	apiRetriesTotal prometheus.Gauge
-
-	// --- NEW: context compaction ---
-	compactEventsTotal    prometheus.Gauge
-	compactPreTokensTotal prometheus.Histogram
-
-	// --- NEW: web search / fetch ---
-	webSearchTotal prometheus.Gauge
-	webFetchTotal  prometheus.Gauge
+// serve starts srv in the background, with TLS when both files are set.
+func serve(srv *http.Server, certFile, keyFile string) {
+	go func() {
+		var err error
+		if certFile != "" && keyFile != "" {
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 }
 
-func newCollector(statsFile, claudeDir string) *claudeCollector {
-	return &claudeCollector{
-		statsFile: statsFile,
-		claudeDir: claudeDir,
-
-		modelInputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_model_input_tokens_total",
-			Help: "Total input tokens by model",
-		}, []string{"model"}),
-		modelOutputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_model_output_tokens_total",
-			Help: "Total output tokens by model",
-		}, []string{"model"}),
-		modelCacheReadTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_model_cache_read_tokens_total",
-			Help: "Total cache-read input tokens by model",
-		}, []string{"model"}),
-		modelCacheCreateTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_model_cache_creation_tokens_total",
-			Help: "Total cache-creation input tokens by model",
-		}, []string{"model"}),
-		liveInputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_live_input_tokens",
-			Help: "Input tokens from active sessions (not yet in cache)",
-		}, []string{"model"}),
-		liveOutputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_live_output_tokens",
-			Help: "Output tokens from active sessions (not yet in cache)",
-		}, []string{"model"}),
-		liveSessions: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_sessions",
-			Help: "Number of active sessions (not yet in cache)",
-		}),
-		liveMessages: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_messages",
-			Help: "Messages in active sessions (not yet in cache)",
-		}),
-
-		totalSessions: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_sessions_total",
-			Help: "Total number of sessions",
-		}),
-		totalMessages: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_messages_total",
-			Help: "Total number of messages",
-		}),
-
-		todayMessages: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_today_messages",
-			Help: "Messages sent today",
-		}),
-		todaySessions: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_today_sessions",
-			Help: "Sessions started today",
-		}),
-		todayToolCalls: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_today_tool_calls",
-			Help: "Tool calls today",
-		}),
-		todayTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_today_tokens",
-			Help: "Tokens used today by model",
-		}, []string{"model"}),
-
-		dailyMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_daily_messages",
-			Help: "Daily message count",
-		}, []string{"date"}),
-		dailySessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_daily_sessions",
-			Help: "Daily session count",
-		}, []string{"date"}),
-		dailyToolCalls: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_daily_tool_calls",
-			Help: "Daily tool call count",
-		}, []string{"date"}),
-		dailyTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_daily_tokens",
-			Help: "Daily tokens by model",
-		}, []string{"date", "model"}),
-
-		hourActivity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_hour_sessions",
-			Help: "Session count by hour of day",
-		}, []string{"hour"}),
-
-		exporterInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_exporter_info",
-			Help: "Claude Code exporter metadata",
-		}, []string{"stats_file", "claude_dir", "last_computed_date", "first_session_date", "live_sessions"}),
-
-		// --- NEW metrics ---
-
-		turnDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
-			Name:    "claude_turn_duration_seconds",
-			Help:    "Distribution of assistant turn durations in seconds",
-			Buckets: []float64{5, 10, 20, 30, 60, 120, 300, 600, 1800, 3600},
-		}),
-
-		toolUseTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_live_tool_use_total",
-			Help: "Tool usage count from active sessions by tool name",
-		}, []string{"tool"}),
-
-		stopReasonTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Name: "claude_live_stop_reason_total",
-			Help: "Stop reason count from active sessions",
-		}, []string{"reason"}),
-
-		apiErrorsTotal: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_api_errors_total",
-			Help: "API error count from active sessions",
-		}),
-		apiRetriesTotal: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_api_retries_total",
-			Help: "API retry count from active sessions",
-		}),
-
-		compactEventsTotal: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_compact_events_total",
-			Help: "Context compaction events from active sessions",
-		}),
-		compactPreTokensTotal: prometheus.NewHistogram(prometheus.HistogramOpts{
-			Name:    "claude_compact_pre_tokens",
-			Help:    "Distribution of token counts before context compaction",
-			Buckets: []float64{50000, 100000, 150000, 200000, 300000, 500000},
-		}),
-
-		webSearchTotal: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_web_search_total",
-			Help: "Web search requests from active sessions",
-		}),
-		webFetchTotal: prometheus.NewGauge(prometheus.GaugeOpts{
-			Name: "claude_live_web_fetch_total",
-			Help: "Web fetch requests from active sessions",
-		}),
+// kubernetesLabels returns the pod identity exposed through the downward
+// API, attached as constant labels to every metric when present.
+func kubernetesLabels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	for label, key := range map[string]string{
+		"pod":       "POD_NAME",
+		"namespace": "NAMESPACE",
+	} {
+		if v := os.Getenv(key); v != "" {
+			labels[label] = v
+		}
 	}
+	return labels
 }
 
-func (c *claudeCollector) Describe(ch chan<- *prometheus.Desc) {
-	c.modelInputTokens.Describe(ch)
-	c.modelOutputTokens.Describe(ch)
-	c.modelCacheReadTokens.Describe(ch)
-	c.modelCacheCreateTokens.Describe(ch)
-	c.liveInputTokens.Describe(ch)
-	c.liveOutputTokens.Describe(ch)
-	c.liveSessions.Describe(ch)
-	c.liveMessages.Describe(ch)
-	c.totalSessions.Describe(ch)
-	c.totalMessages.Describe(ch)
-	c.todayMessages.Describe(ch)
-	c.todaySessions.Describe(ch)
-	c.todayToolCalls.Describe(ch)
-	c.todayTokens.Describe(ch)
-	c.dailyMessages.Describe(ch)
-	c.dailySessions.Describe(ch)
-	c.dailyToolCalls.Describe(ch)
-	c.dailyTokens.Describe(ch)
-	c.hourActivity.Describe(ch)
-	c.exporterInfo.Describe(ch)
-
-	c.turnDuration.Describe(ch)
-	c.toolUseTotal.Describe(ch)
-	c.stopReasonTotal.Describe(ch)
-	c.apiErrorsTotal.Describe(ch)
-	c.apiRetriesTotal.Describe(ch)
-	c.compactEventsTotal.Describe(ch)
-	c.compactPreTokensTotal.Describe(ch)
-	c.webSearchTotal.Describe(ch)
-	c.webFetchTotal.Describe(ch)
+// newPushClient configures agent mode, pushing the registry's metrics.
+func newPushClient(reg prometheus.Gatherer) *push.Client {
+	host, _ := os.Hostname()
+	return &push.Client{
+		URL:      strings.TrimSuffix(envOr("PUSH_URL", ""), "/") + push.Path,
+		Token:    envOr("PUSH_TOKEN", ""),
+		Host:     envOr("PUSH_HOST", host),
+		User:     envOr("PUSH_USER", os.Getenv("USER")),
+		Gatherer: reg,
+	}
 }
 
-func (c *claudeCollector) Collect(ch chan<- prometheus.Metric) {
-	c.update()
-
-	c.modelInputTokens.Collect(ch)
-	c.modelOutputTokens.Collect(ch)
-	c.modelCacheReadTokens.Collect(ch)
-	c.modelCacheCreateTokens.Collect(ch)
-	c.liveInputTokens.Collect(ch)
-	c.liveOutputTokens.Collect(ch)
-	c.liveSessions.Collect(ch)
-	c.liveMessages.Collect(ch)
-	c.totalSessions.Collect(ch)
-	c.totalMessages.Collect(ch)
-	c.todayMessages.Collect(ch)
-	c.todaySessions.Collect(ch)
-	c.todayToolCalls.Collect(ch)
-	c.todayTokens.Collect(ch)
-	c.dailyMessages.Collect(ch)
-	c.dailySessions.Collect(ch)
-	c.dailyToolCalls.Collect(ch)
-	c.dailyTokens.Collect(ch)
-	c.hourActivity.Collect(ch)
-	c.exporterInfo.Collect(ch)
-
-	c.turnDuration.Collect(ch)
-	c.toolUseTotal.Collect(ch)
-	c.stopReasonTotal.Collect(ch)
-	c.apiErrorsTotal.Collect(ch)
-	c.apiRetriesTotal.Collect(ch)
-	c.compactEventsTotal.Collect(ch)
-	c.compactPreTokensTotal.Collect(ch)
-	c.webSearchTotal.Collect(ch)
-	c.webFetchTotal.Collect(ch)
+// newStatsdClient connects to STATSD_ADDR, or returns nil when unset.
+func newStatsdClient() *statsd.Client {
+	client, err := statsdClient()
+	if err != nil {
+		log.Fatalf("statsd: %v", err)
+	}
+	return client
 }
 
-func (c *claudeCollector) loadStats() (*StatsCache, error) {
-	data, err := os.ReadFile(c.statsFile)
+func statsdClient() (*statsd.Client, error) {
+	addr := envOr("STATSD_ADDR", "")
+	if addr == "" {
+		return nil, nil
+	}
+	client, err := statsd.Dial(addr)
 	if err != nil {
 		return nil, err
 	}
-	var stats StatsCache
-	if err := json.Unmarshal(data, &stats); err != nil {
-		return nil, err
+	switch flavor := envOr("STATSD_FLAVOR", "dogstatsd"); flavor {
+	case "dogstatsd":
+		client.DogStatsD = true
+	case "statsd":
+	default:
+		client.Close()
+		return nil, fmt.Errorf("unknown STATSD_FLAVOR %q (want dogstatsd or statsd)", flavor)
 	}
-	return &stats, nil
+	client.Prefix = envOr("STATSD_PREFIX", "")
+	client.Tags = envList("STATSD_TAGS")
+	return client, nil
 }
 
-func (c *claudeCollector) cacheMtime() time.Time {
-	info, err := os.Stat(c.statsFile)
-	if err != nil {
-		return time.Time{}
+// sinkNames reads SINKS. Unset, the exporter keeps its earlier behaviour:
+// serve /metrics, and send to StatsD when STATSD_ADDR is set. Agent mode
+// always pushes to its server.
+func sinkNames(mode string) []string {
+	names := envList("SINKS")
+	if len(names) == 0 {
+		names = []string{"prometheus"}
+		if envOr("STATSD_ADDR", "") != "" {
+			names = append(names, "statsd")
+		}
+	}
+	if mode == "agent" && !slices.Contains(names, "push") {
+		names = append(names, "push")
 	}
-	return info.ModTime()
+	return names
 }
 
-// extractMessage resolves the message from either direct field or nested data.message.message
-func (rec *JSONLRecord) extractMessage() *JSONLMessage {
-	if rec.Message != nil {
-		return rec.Message
-	}
-	if rec.Data != nil && rec.Data.Message != nil && rec.Data.Message.Message != nil {
-		return rec.Data.Message.Message
+// sinkRegistry maps the names SINKS accepts, besides prometheus, to the
+// constructors of those sinks. Each reads its own variables.
+func sinkRegistry(reg prometheus.Gatherer, sd *statsd.Client) map[string]func() (sink.Sink, error) {
+	host, _ := os.Hostname()
+	return map[string]func() (sink.Sink, error){
+		"statsd": func() (sink.Sink, error) {
+			if sd == nil {
+				return nil, fmt.Errorf("requires STATSD_ADDR")
+			}
+			log.Printf("Sending to StatsD at %s", envOr("STATSD_ADDR", ""))
+			return sd, nil
+		},
+		"push": func() (sink.Sink, error) {
+			client := newPushClient(reg)
+			if envOr("PUSH_URL", "") == "" || client.Token == "" {
+				return nil, fmt.Errorf("requires PUSH_URL and PUSH_TOKEN")
+			}
+			log.Printf("Pushing to %s as %s/%s", client.URL, client.Host, client.User)
+			return client, nil
+		},
+		"textfile": func() (sink.Sink, error) {
+			path := envOr("TEXTFILE_PATH", "")
+			if !strings.HasSuffix(path, ".prom") {
+				return nil, fmt.Errorf("requires TEXTFILE_PATH ending in .prom")
+			}
+			log.Printf("Writing metrics to %s", path)
+			return &sink.Textfile{Path: path}, nil
+		},
+		"otlp": func() (sink.Sink, error) {
+			url := envOr("OTLP_ENDPOINT", envOr("OTEL_EXPORTER_OTLP_ENDPOINT", ""))
+			if url == "" {
+				return nil, fmt.Errorf("requires OTLP_ENDPOINT")
+			}
+			headers := map[string]string{}
+			for _, h := range envList("OTLP_HEADERS") {
+				k, v, ok := strings.Cut(h, "=")
+				if !ok {
+					return nil, fmt.Errorf("OTLP_HEADERS: want key=value, got %q", h)
+				}
+				headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+			}
+			log.Printf("Sending OTLP to %s", url)
+			return &sink.OTLP{
+				URL:     url,
+				Headers: headers,
+				Resource: map[string]string{
+					"service.name": envOr("OTLP_SERVICE_NAME", "claude-exporter"),
+					"host.name":    host,
+				},
+			}, nil
+		},
+		"influx": func() (sink.Sink, error) {
+			url, bucket := envOr("INFLUX_URL", ""), envOr("INFLUX_BUCKET", "")
+			if url == "" || bucket == "" {
+				return nil, fmt.Errorf("requires INFLUX_URL and INFLUX_BUCKET")
+			}
+			log.Printf("Writing to InfluxDB at %s, bucket %s", url, bucket)
+			return &sink.Influx{URL: url, Token: envOr("INFLUX_TOKEN", ""), Org: envOr("INFLUX_ORG", ""), Bucket: bucket}, nil
+		},
+		"pushgateway": func() (sink.Sink, error) {
+			url := envOr("PUSHGATEWAY_URL", "")
+			if url == "" {
+				return nil, fmt.Errorf("requires PUSHGATEWAY_URL")
+			}
+			p := &sink.Pushgateway{URL: url, Job: envOr("PUSHGATEWAY_JOB", "claude_exporter"), Instance: envOr("PUSHGATEWAY_INSTANCE", host)}
+			log.Printf("Pushing to Pushgateway at %s as job %s", url, p.Job)
+			return p, nil
+		},
 	}
-	return nil
 }
 
-func (c *claudeCollector) scanLiveSessions() *LiveResult {
-	result := &LiveResult{
-		ModelUsage:    make(map[string]*LiveModelUsage),
-		ToolUseCounts: make(map[string]int),
-		StopReasons:   make(map[string]int),
+// newSinks builds the sinks named in names, other than prometheus.
+func newSinks(names []string, reg prometheus.Gatherer, sd *statsd.Client) map[string]sink.Sink {
+	registry := sinkRegistry(reg, sd)
+	sinks := make(map[string]sink.Sink)
+	for _, name := range names {
+		if name == "prometheus" {
+			continue
+		}
+		build, ok := registry[name]
+		if !ok {
+			log.Fatalf("SINKS: unknown sink %q (want prometheus, textfile, otlp, statsd, influx, pushgateway or push)", name)
+		}
+		s, err := build()
+		if err != nil {
+			log.Fatalf("sink %s: %v", name, err)
+		}
+		sinks[name] = s
 	}
+	return sinks
+}
 
-	projectsDir := filepath.Join(c.claudeDir, "projects")
-	if _, err := os.Stat(projectsDir); err != nil {
-		return result
+// newNotifyWatcher configures notifications to NTFY_URL or GOTIFY_URL, or
+// returns nil when neither is set.
+func newNotifyWatcher() *notify.Watcher {
+	var sink notify.Sink
+	switch {
+	case envOr("NTFY_URL", "") != "":
+		sink = &notify.Ntfy{URL: envOr("NTFY_URL", ""), Token: envOr("NTFY_TOKEN", "")}
+		log.Printf("Notifying ntfy topic %s", envOr("NTFY_URL", ""))
+	case envOr("GOTIFY_URL", "") != "":
+		token := envOr("GOTIFY_TOKEN", "")
+		if token == "" {
+			log.Fatalf("GOTIFY_URL requires GOTIFY_TOKEN")
+		}
+		sink = &notify.Gotify{URL: envOr("GOTIFY_URL", ""), Token: token}
+		log.Printf("Notifying Gotify at %s", envOr("GOTIFY_URL", ""))
+	default:
+		return nil
 	}
+	return &notify.Watcher{
+		Sink:           sink,
+		TurnLongerThan: time.Duration(envInt("NOTIFY_TURN_MINUTES", 10)) * time.Minute,
+		IdleLongerThan: time.Duration(envInt("NOTIFY_IDLE_MINUTES", 10)) * time.Minute,
+	}
+}
 
-	cacheMtime := c.cacheMtime()
-
-	pattern := filepath.Join(projectsDir, "*", "*.jsonl")
-	files, err := filepath.Glob(pattern)
+// newRemote configures remote mode: a collector per host in REMOTE_HOSTS,
+// over a mirror of its Claude data synced through SSH, registered with a
+// host label.
+func newRemote(reg prometheus.Registerer, sd *statsd.Client) (*remote.Syncer, []*collector.Collector) {
+	hosts, err := remote.ParseHosts(envList("REMOTE_HOSTS"), envOr("REMOTE_CACHE_DIR", "/data/remote"))
 	if err != nil {
-		log.Printf("glob error: %v", err)
-		return result
+		log.Fatalf("REMOTE_HOSTS: %v", err)
+	}
+	if len(hosts) == 0 {
+		log.Fatalf("remote mode requires REMOTE_HOSTS")
+	}
+	syncer := remote.NewSyncer(hosts, strings.Fields(envOr("REMOTE_SSH_COMMAND", "ssh -o BatchMode=yes")))
+	reg.MustRegister(syncer)
+
+	var collectors []*collector.Collector
+	for _, h := range hosts {
+		if h.Bucket != nil {
+			log.Printf("Bucket %s mirrored in %s", h.Target, h.Dir)
+		} else {
+			log.Printf("Remote host %s (%s:%s) mirrored in %s", h.Name, h.Target, h.ClaudeDir, h.Dir)
+		}
+		paths := collectorPaths{
+			claudeDir: h.Dir,
+			statsFile: filepath.Join(h.Dir, "stats-cache.json"),
+		}
+		if stateFile := envOr("STATE_FILE", ""); stateFile != "" {
+			paths.stateFile = stateFile + "." + h.Name
+		}
+		if auditLog := envOr("AUDIT_LOG", ""); auditLog != "" {
+			paths.auditLog = auditLog + "." + h.Name
+		}
+		c := newCollector(paths, sd, nil)
+		labels := kubernetesLabels()
+		labels["host"] = h.Name
+		prometheus.WrapRegistererWith(labels, reg).MustRegister(c)
+		collectors = append(collectors, c)
 	}
+	return syncer, collectors
+}
 
-	for _, fpath := range files {
-		info, err := os.Stat(fpath)
+// configDirs expands CLAUDE_CONFIG_DIRS: directories or globs such as
+// /data/claude-*, matched once at startup.
+func configDirs() []string {
+	var dirs []string
+	for _, pattern := range envList("CLAUDE_CONFIG_DIRS") {
+		matches, err := filepath.Glob(pattern)
 		if err != nil {
-			continue
+			log.Fatalf("CLAUDE_CONFIG_DIRS: %v", err)
 		}
-		if !info.ModTime().After(cacheMtime) {
-			continue
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			// a missing directory is reported by its collector
+			matches = []string{pattern}
 		}
-
-		sessionHasMessages := false
-		func() {
-			f, err := os.Open(fpath)
-			if err != nil {
-				return
+		for _, m := range matches {
+			if !slices.Contains(dirs, m) {
+				dirs = append(dirs, m)
 			}
-			defer f.Close()
-
-			scanner := bufio.NewScanner(f)
-			scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
-			for scanner.Scan() {
-				line := scanner.Bytes()
-				if len(line) == 0 {
-					continue
-				}
-
-				var rec JSONLRecord
-				if err := json.Unmarshal(line, &rec); err != nil {
-					continue
-				}
-
-				// Handle system subtypes
-				if rec.Type == "system" {
-					switch rec.Subtype {
-					case "turn_duration":
-						if rec.DurationMs != nil {
-							result.TurnDurations = append(result.TurnDurations, *rec.DurationMs)
-						}
-					case "api_error":
-						result.APIErrors++
-						if rec.RetryAttempt != nil && *rec.RetryAttempt > 0 {
-							result.APIRetries++
-						}
-					case "compact_boundary":
-						if rec.CompactMetadata != nil {
-							result.CompactEvents++
-							if rec.CompactMetadata.PreTokens > 0 {
-								result.CompactPreTokens = append(result.CompactPreTokens, float64(rec.CompactMetadata.PreTokens))
-							}
-						}
-					}
-					continue
-				}
-
-				// Handle message records (type=assistant or type=progress)
-				msg := rec.extractMessage()
-				if msg == nil {
-					continue
-				}
-
-				inp := ptrVal(msg.Usage.InputTokens)
-				out := ptrVal(msg.Usage.OutputTokens)
-
-				model := shortModel(msg.Model)
-				if model == "" {
-					model = "unknown"
-				}
-
-				// Token usage
-				if inp > 0 || out > 0 {
-					mu, ok := result.ModelUsage[model]
-					if !ok {
-						mu = &LiveModelUsage{}
-						result.ModelUsage[model] = mu
-					}
-					mu.Input += inp
-					mu.Output += out
-					mu.CacheRead += ptrVal(msg.Usage.CacheReadInputTokens)
-					mu.CacheCreate += ptrVal(msg.Usage.CacheCreationInputTokens)
-					result.MessageCount++
-					sessionHasMessages = true
-				}
-
-				// Tool usage from content blocks
-				for _, block := range msg.Content {
-					if block.Type == "tool_use" && block.Name != "" {
-						result.ToolUseCounts[block.Name]++
-					}
-				}
-
-				// Stop reason
-				if msg.StopReason != nil && *msg.StopReason != "" {
-					result.StopReasons[*msg.StopReason]++
-				}
-
-				// Server tool use (web search/fetch)
-				if msg.Usage.ServerToolUse != nil {
-					result.WebSearches += msg.Usage.ServerToolUse.WebSearchRequests
-					result.WebFetches += msg.Usage.ServerToolUse.WebFetchRequests
-				}
-			}
-		}()
-
-		if sessionHasMessages {
-			result.SessionCount++
 		}
 	}
-
-	return result
+	return dirs
 }
 
-func (c *claudeCollector) update() {
-	// Reset vector metrics to avoid stale labels
-	c.modelInputTokens.Reset()
-	c.modelOutputTokens.Reset()
-	c.modelCacheReadTokens.Reset()
-	c.modelCacheCreateTokens.Reset()
-	c.liveInputTokens.Reset()
-	c.liveOutputTokens.Reset()
-	c.todayTokens.Reset()
-	c.dailyMessages.Reset()
-	c.dailySessions.Reset()
-	c.dailyToolCalls.Reset()
-	c.dailyTokens.Reset()
-	c.hourActivity.Reset()
-	c.exporterInfo.Reset()
-	c.toolUseTotal.Reset()
-	c.stopReasonTotal.Reset()
-
-	stats, err := c.loadStats()
-	if err != nil {
-		log.Printf("failed to load stats: %v", err)
-		return
+// newConfigDirs builds a collector per Claude config directory, for setups
+// that give projects their own CLAUDE_CONFIG_DIR and so their own stats
+// cache, registered with a config_dir label.
+func newConfigDirs(reg prometheus.Registerer, dirs []string, sd *statsd.Client) []*collector.Collector {
+	var collectors []*collector.Collector
+	for _, dir := range dirs {
+		log.Printf("Claude config dir: %s", dir)
+		paths := collectorPaths{
+			claudeDir: dir,
+			statsFile: filepath.Join(dir, "stats-cache.json"),
+		}
+		suffix := "." + strings.ReplaceAll(strings.Trim(dir, "/"), "/", "_")
+		if stateFile := envOr("STATE_FILE", ""); stateFile != "" {
+			paths.stateFile = stateFile + suffix
+		}
+		if auditLog := envOr("AUDIT_LOG", ""); auditLog != "" {
+			paths.auditLog = auditLog + suffix
+		}
+		c := newCollector(paths, sd, nil)
+		labels := kubernetesLabels()
+		labels["config_dir"] = dir
+		prometheus.WrapRegistererWith(labels, reg).MustRegister(c)
+		collectors = append(collectors, c)
 	}
+	return collectors
+}
 
-	today := time.Now().UTC().Format("2006-01-02")
-
-	// Scan live sessions
-	live := c.scanLiveSessions()
-	log.Printf("live sessions: %d, live messages: %d, api_errors: %d, compactions: %d",
-		live.SessionCount, live.MessageCount, live.APIErrors, live.CompactEvents)
-
-	// Collect all models
-	allModels := make(map[string]struct{})
-	for m := range stats.ModelUsage {
-		allModels[shortModel(m)] = struct{}{}
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dashboard" {
+		if err := runDashboard(os.Args[2:]); err != nil {
+			log.Fatalf("dashboard: %v", err)
+		}
+		return
 	}
-	for m := range live.ModelUsage {
-		allModels[m] = struct{}{}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			log.Fatalf("export: %v", err)
+		}
+		return
 	}
-
-	// Model usage: cache + live
-	for model := range allModels {
-		var base ModelUsage
-		for raw, u := range stats.ModelUsage {
-			if shortModel(raw) == model {
-				base = u
-				break
-			}
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfill(os.Args[2:]); err != nil {
+			log.Fatalf("backfill: %v", err)
 		}
-
-		lm := live.ModelUsage[model]
-		var liveIn, liveOut, liveCR, liveCC float64
-		if lm != nil {
-			liveIn = lm.Input
-			liveOut = lm.Output
-			liveCR = lm.CacheRead
-			liveCC = lm.CacheCreate
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		if err := runBench(os.Args[2:]); err != nil {
+			log.Fatalf("bench: %v", err)
 		}
-
-		c.modelInputTokens.WithLabelValues(model).Set(base.InputTokens + liveIn)
-		c.modelOutputTokens.WithLabelValues(model).Set(base.OutputTokens + liveOut)
-		c.modelCacheReadTokens.WithLabelValues(model).Set(base.CacheReadInputTokens + liveCR)
-		c.modelCacheCreateTokens.WithLabelValues(model).Set(base.CacheCreationInputTokens + liveCC)
-
-		if liveIn > 0 || liveOut > 0 {
-			c.liveInputTokens.WithLabelValues(model).Set(liveIn)
-			c.liveOutputTokens.WithLabelValues(model).Set(liveOut)
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rules" {
+		if err := runRules(os.Args[2:]); err != nil {
+			log.Fatalf("rules: %v", err)
 		}
+		return
 	}
 
-	c.liveSessions.Set(float64(live.SessionCount))
-	c.liveMessages.Set(float64(live.MessageCount))
-
-	// Totals
-	c.totalSessions.Set(float64(stats.TotalSessions + live.SessionCount))
-	c.totalMessages.Set(float64(stats.TotalMessages + live.MessageCount))
+	enablePprof := flag.Bool("enable-pprof", envBool("ENABLE_PPROF", false), "serve pprof and runtime metrics (env ENABLE_PPROF)")
+	adminPort := flag.Int("admin-port", envInt("ADMIN_PORT", 6060), "port for pprof (env ADMIN_PORT)")
+	adminAddr := flag.String("admin-addr", envOr("ADMIN_ADDR", ""), "address for pprof, e.g. :6060 for every interface (env ADMIN_ADDR, default 127.0.0.1 on the admin port)")
+	check := flag.Bool("check", false, "query the running exporter's /healthz and exit non-zero when unhealthy")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and exit non-zero when it has errors")
+	flag.Parse()
 
-	// Daily activity (last 30)
-	start := 0
-	if len(stats.DailyActivity) > 30 {
-		start = len(stats.DailyActivity) - 30
+	port := envInt("EXPORTER_PORT", 9101)
+	if *check {
+		os.Exit(runCheck(port))
 	}
-	for _, entry := range stats.DailyActivity[start:] {
-		c.dailyMessages.WithLabelValues(entry.Date).Set(float64(entry.MessageCount))
-		c.dailySessions.WithLabelValues(entry.Date).Set(float64(entry.SessionCount))
-		c.dailyToolCalls.WithLabelValues(entry.Date).Set(float64(entry.ToolCallCount))
+	if *checkConfig {
+		os.Exit(runCheckConfig())
 	}
+	mode := envOr("MODE", "standalone")
+	log.Printf("Starting Claude Code exporter (%s) on :%d", mode, port)
 
-	// Today
-	var todayEntry *DailyActivity
-	for i := range stats.DailyActivity {
-		if stats.DailyActivity[i].Date == today {
-			todayEntry = &stats.DailyActivity[i]
+	reg := prometheus.NewRegistry()
+	mux := http.NewServeMux()
+	// the dashboard and JSON API get their own listener when API_PORT is set
+	apiMux := mux
+	var apiSrv *http.Server
+	if apiPort := envInt("API_PORT", 0); apiPort != 0 && apiPort != port {
+		apiMux = http.NewServeMux()
+		apiSrv = &http.Server{Addr: fmt.Sprintf(":%d", apiPort), Handler: apiMux}
+	}
+	sinks := sinkNames(mode)
+	log.Printf("Sinks: %s", strings.Join(sinks, ", "))
+	var sd *statsd.Client
+	if slices.Contains(sinks, "statsd") {
+		// histogram samples go out as they are observed, not per flush
+		sd = newStatsdClient()
+	}
+	pushSinks := newSinks(sinks, reg, sd)
+	watcher := newNotifyWatcher()
+	var c *collector.Collector
+	var query *grpcapi.Server
+	var syncer *remote.Syncer
+	// one per remote host or config dir
+	var scoped []*collector.Collector
+	switch mode {
+	case "server":
+		tokens := envList("PUSH_TOKENS")
+		if len(tokens) == 0 {
+			log.Fatalf("server mode requires PUSH_TOKENS")
+		}
+		srv := push.NewServer(tokens, time.Duration(envInt("PUSH_STALE_AFTER", 600))*time.Second)
+		reg.MustRegister(srv)
+		mux.Handle(push.Path, srv)
+		mux.HandleFunc("/api/v1/leaderboard", apiAuth(envList("API_TOKENS"), leaderboardHandler(srv)))
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok\n"))
+		})
+	case "standalone", "agent":
+		if dirs := configDirs(); len(dirs) > 0 {
+			scoped = newConfigDirs(reg, dirs, sd)
+			if watcher != nil {
+				log.Printf("CLAUDE_CONFIG_DIRS: notifications need a single config dir, not sending any")
+			}
+			mux.HandleFunc("/readyz", readyHandler(scoped...))
 			break
 		}
+		c = newLocalCollector(sd, watcher)
+		if labels := kubernetesLabels(); len(labels) > 0 {
+			log.Printf("Kubernetes labels: %v", labels)
+			prometheus.WrapRegistererWith(labels, reg).MustRegister(c)
+		} else {
+			reg.MustRegister(c)
+		}
+		cert, key := apiCerts(port)
+		query = handleLocal(apiMux, c, cert != "" && key != "")
+		mux.HandleFunc("/readyz", readyHandler(c))
+	case "remote":
+		syncer, scoped = newRemote(reg, sd)
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok\n"))
+		})
+	default:
+		log.Fatalf("unknown MODE %q (want standalone, agent, server or remote)", mode)
 	}
-	if todayEntry != nil {
-		c.todayMessages.Set(float64(todayEntry.MessageCount + live.MessageCount))
-		c.todaySessions.Set(float64(todayEntry.SessionCount + live.SessionCount))
-		c.todayToolCalls.Set(float64(todayEntry.ToolCallCount))
-	} else {
-		c.todayMessages.Set(float64(live.MessageCount))
-		c.todaySessions.Set(float64(live.SessionCount))
-		c.todayToolCalls.Set(0)
+	if apiSrv != nil && c == nil {
+		log.Printf("API_PORT: the dashboard and API need a single local collector, not listening")
+		apiSrv = nil
 	}
 
-	// Daily model tokens (last 30)
-	start = 0
-	if len(stats.DailyModelTokens) > 30 {
-		start = len(stats.DailyModelTokens) - 30
+	if slices.Contains(sinks, "prometheus") {
+		mux.Handle("/metrics", apiAuth(envList("METRICS_TOKENS"), metricsHandler(reg).ServeHTTP))
 	}
-	for _, entry := range stats.DailyModelTokens[start:] {
-		for rawModel, tokens := range entry.TokensByModel {
-			model := shortModel(rawModel)
-			c.dailyTokens.WithLabelValues(entry.Date, model).Set(tokens)
-		}
+	if c != nil {
+		mux.HandleFunc("/healthz", healthHandler(c, time.Duration(envInt("HEALTH_MAX_AGE", 300))*time.Second))
+	} else {
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok\n"))
+		})
 	}
 
-	// Today tokens
-	var todayTokenEntry *DailyModelTokens
-	for i := range stats.DailyModelTokens {
-		if stats.DailyModelTokens[i].Date == today {
-			todayTokenEntry = &stats.DailyModelTokens[i]
-			break
-		}
-	}
-	if todayTokenEntry != nil {
-		for rawModel, tokens := range todayTokenEntry.TokensByModel {
-			model := shortModel(rawModel)
-			liveTok := float64(0)
-			if lm, ok := live.ModelUsage[model]; ok {
-				liveTok = lm.Input
-			}
-			c.dailyTokens.WithLabelValues(today, model).Set(tokens + liveTok)
-			c.todayTokens.WithLabelValues(model).Set(tokens + liveTok)
-		}
-	} else {
-		for model, mu := range live.ModelUsage {
-			c.dailyTokens.WithLabelValues(today, model).Set(mu.Input)
-			c.todayTokens.WithLabelValues(model).Set(mu.Input)
+	var admin *http.Server
+	if *enablePprof {
+		addr := *adminAddr
+		if addr == "" {
+			addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(*adminPort))
 		}
+		admin = startAdmin(addr, reg)
 	}
 
-	// Hour distribution
-	for hour, count := range stats.HourCounts {
-		h := hour
-		if len(h) == 1 {
-			h = "0" + h
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
+	if query != nil {
+		srv.RegisterOnShutdown(query.Close)
+		if apiSrv != nil {
+			apiSrv.RegisterOnShutdown(query.Close)
 		}
-		c.hourActivity.WithLabelValues(h).Set(count)
 	}
-
-	// Info
-	c.exporterInfo.WithLabelValues(
-		c.statsFile,
-		c.claudeDir,
-		stats.LastComputedDate,
-		stats.FirstSessionDate,
-		strconv.Itoa(live.SessionCount),
-	).Set(1)
-
-	// --- NEW: turn duration histogram ---
-	for _, durationMs := range live.TurnDurations {
-		c.turnDuration.Observe(durationMs / 1000.0) // convert ms to seconds
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	serve(srv, envOr("TLS_CERT_FILE", ""), envOr("TLS_KEY_FILE", ""))
+	if apiSrv != nil {
+		log.Printf("Serving the dashboard and API on %s", apiSrv.Addr)
+		cert, key := apiCerts(port)
+		serve(apiSrv, cert, key)
 	}
 
-	// --- NEW: tool usage breakdown ---
-	for tool, count := range live.ToolUseCounts {
-		c.toolUseTotal.WithLabelValues(tool).Set(float64(count))
+	if len(pushSinks) > 0 {
+		// one gather, and so one scan, per interval feeds every sink
+		interval := envInt("SINK_INTERVAL", envInt("PUSH_INTERVAL", envInt("STATSD_INTERVAL", 60)))
+		runner := &sink.Runner{Gatherer: unitGatherer{reg}, Sinks: pushSinks}
+		go runner.Run(ctx, time.Duration(interval)*time.Second)
 	}
 
-	// --- NEW: stop reason ---
-	for reason, count := range live.StopReasons {
-		c.stopReasonTotal.WithLabelValues(reason).Set(float64(count))
+	if watcher != nil && c != nil {
+		// notifications shouldn't wait for a scrape
+		go func() {
+			ticker := time.NewTicker(time.Duration(envInt("NOTIFY_INTERVAL", 60)) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					c.Update()
+				}
+			}
+		}()
 	}
 
-	// --- NEW: API errors ---
-	c.apiErrorsTotal.Set(float64(live.APIErrors))
-	c.apiRetriesTotal.Set(float64(live.APIRetries))
-
-	// --- NEW: context compaction ---
-	c.compactEventsTotal.Set(float64(live.CompactEvents))
-	for _, preTokens := range live.CompactPreTokens {
-		c.compactPreTokensTotal.Observe(preTokens)
+	if syncer != nil {
+		go syncer.Run(ctx, time.Duration(envInt("REMOTE_SYNC_INTERVAL", 60))*time.Second)
 	}
 
-	// --- NEW: web search / fetch ---
-	c.webSearchTotal.Set(float64(live.WebSearches))
-	c.webFetchTotal.Set(float64(live.WebFetches))
-
-	log.Printf("metrics updated (lastComputedDate=%s, live_sessions=%d)",
-		stats.LastComputedDate, live.SessionCount)
-}
-
-func main() {
-	statsFile := envOr("CLAUDE_STATS_FILE", "/data/claude/stats-cache.json")
-	claudeDir := envOr("CLAUDE_DIR", "/data/claude")
-	port := envInt("EXPORTER_PORT", 9101)
-
-	log.Printf("Starting Claude Code exporter on :%d", port)
-	log.Printf("Stats file: %s", statsFile)
-	log.Printf("Claude dir: %s", claudeDir)
-
-	collector := newCollector(statsFile, claudeDir)
-
-	reg := prometheus.NewRegistry()
-	reg.MustRegister(collector)
-
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(`<html><body><h1>Claude Code Exporter</h1><p><a href="/metrics">Metrics</a></p></body></html>`))
-	})
+	collectors := scoped
+	if c != nil {
+		collectors = append(collectors, c)
+	}
+	saveState := func() {
+		for _, c := range collectors {
+			if err := c.SaveState(); err != nil {
+				log.Printf("state: save failed: %v", err)
+			}
+		}
+	}
+	if stateFile := envOr("STATE_FILE", ""); len(collectors) > 0 && stateFile != "" {
+		log.Printf("State file: %s", stateFile)
+		go func() {
+			ticker := time.NewTicker(time.Duration(envInt("STATE_SAVE_INTERVAL", 300)) * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					saveState()
+				}
+			}
+		}()
+	}
 
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), mux))
+	<-ctx.Done()
+	log.Printf("Shutting down")
+	// Shutdown waits for in-flight scrapes, and so their scans, to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if apiSrv != nil {
+		if err := apiSrv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}
+	if admin != nil {
+		admin.Close()
+	}
+	saveState()
+	if sd != nil {
+		sd.Close()
+	}
 }
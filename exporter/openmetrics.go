@@ -0,0 +1,88 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// --- metrics endpoint ---
+
+// metricUnits are the units a metric name may end in. OpenMetrics only
+// allows a unit that is the name's suffix (before _total on counters), so
+// e.g. claude_window_seconds_remaining goes without one.
+var metricUnits = []string{"seconds", "bytes", "ratio", "usd"}
+
+// unitGatherer sets the unit of every family whose name ends in one.
+type unitGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g unitGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, mf := range families {
+		name := mf.GetName()
+		if mf.GetType() == dto.MetricType_COUNTER {
+			name = strings.TrimSuffix(name, "_total")
+		}
+		for _, unit := range metricUnits {
+			if strings.HasSuffix(name, "_"+unit) {
+				mf.Unit = &unit
+				break
+			}
+		}
+	}
+	return families, err
+}
+
+// metricsHandler serves /metrics. Scrapers that negotiate OpenMetrics get
+// # UNIT lines and _created samples, which promhttp can't write, so that
+// format is encoded here; the text and protobuf formats go to promhttp.
+func metricsHandler(g prometheus.Gatherer) http.Handler {
+	g = unitGatherer{g}
+	// agents may report different label sets; serve what is consistent
+	classic := promhttp.HandlerFor(g, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		if format.FormatType() != expfmt.TypeOpenMetrics {
+			classic.ServeHTTP(w, r)
+			return
+		}
+
+		families, err := g.Gather()
+		if err != nil {
+			log.Printf("metrics: %v", err)
+			if len(families) == 0 {
+				http.Error(w, "error gathering metrics", http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", string(format))
+		var out io.Writer = w
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			out = gz
+		}
+		// the histograms carry exemplars, which only OpenMetrics transmits
+		enc := expfmt.NewEncoder(out, format, expfmt.WithCreatedLines(), expfmt.WithUnit())
+		for _, mf := range families {
+			if err := enc.Encode(mf); err != nil {
+				log.Printf("metrics: encode %s: %v", mf.GetName(), err)
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			closer.Close()
+		}
+	})
+}
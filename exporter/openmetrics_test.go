@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnitGatherer(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  dto.MetricType
+		want string
+	}{
+		{"claude_live_cost_usd", dto.MetricType_GAUGE, "usd"},
+		{"claude_cache_hit_ratio", dto.MetricType_GAUGE, "ratio"},
+		{"claude_exporter_remote_fetched_bytes_total", dto.MetricType_COUNTER, "bytes"},
+		{"claude_turn_duration_seconds", dto.MetricType_HISTOGRAM, "seconds"},
+		// the unit has to be the suffix
+		{"claude_window_seconds_remaining", dto.MetricType_GAUGE, ""},
+		// a deprecated alias is a gauge, so _total is part of its name
+		{"claude_model_input_tokens_total", dto.MetricType_GAUGE, ""},
+		{"claude_cost_usd_total", dto.MetricType_GAUGE, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := unitGatherer{prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				return []*dto.MetricFamily{{Name: proto.String(tt.name), Type: tt.typ.Enum()}}, nil
+			})}
+			families, err := g.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := families[0].GetUnit(); got != tt.want {
+				t.Errorf("unit %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeprecatedAliases(t *testing.T) {
+	families, err := unitGatherer{goldenRegistry(t, filepath.Join("testdata", "golden", "basic"))}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*dto.MetricFamily)
+	for _, mf := range families {
+		byName[mf.GetName()] = mf
+	}
+
+	// a sample of the renamed gauges, of Claude and of other agents
+	for _, name := range []string{
+		"claude_model_input_tokens", "claude_sessions", "claude_live_tool_use",
+		"claude_live_api_errors", "codex_model_input_tokens", "gemini_sessions",
+	} {
+		if byName[name] == nil || byName[name+"_total"] == nil {
+			t.Errorf("%s or its alias %s_total is missing", name, name)
+		}
+	}
+
+	aliases := 0
+	for _, alias := range families {
+		name, ok := strings.CutPrefix(alias.GetHelp(), "Deprecated: renamed to ")
+		if !ok {
+			continue
+		}
+		name, _, _ = strings.Cut(name, ",")
+		aliases++
+		t.Run(alias.GetName(), func(t *testing.T) {
+			mf := byName[name]
+			switch {
+			case mf == nil:
+				t.Fatalf("renamed to %s, which is missing", name)
+			case alias.GetName() != name+"_total":
+				t.Errorf("alias of %s, want %s_total", name, name)
+			case alias.GetType() != dto.MetricType_GAUGE || alias.GetUnit() != "":
+				t.Errorf("type %v unit %q, want a gauge without a unit", alias.GetType(), alias.GetUnit())
+			}
+			if len(alias.GetMetric()) != len(mf.GetMetric()) {
+				t.Fatalf("%d series, want the %d of %s", len(alias.GetMetric()), len(mf.GetMetric()), name)
+			}
+			for i, m := range alias.GetMetric() {
+				if !proto.Equal(m, mf.GetMetric()[i]) {
+					t.Errorf("series %v, want %v", m, mf.GetMetric()[i])
+				}
+			}
+		})
+	}
+	if aliases == 0 {
+		t.Fatal("no deprecated aliases served")
+	}
+}
+
+func TestMetricsHandlerOpenMetrics(t *testing.T) {
+	h := metricsHandler(goldenRegistry(t, filepath.Join("testdata", "golden", "basic")))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/openmetrics-text") {
+		t.Fatalf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	body, _ := io.ReadAll(w.Body)
+	for _, want := range []string{
+		"# UNIT claude_live_cost_usd usd\n",
+		"# TYPE claude_sessions_total gauge\n",
+		"claude_sessions_total ",
+		"\n# EOF\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("OpenMetrics output lacks %q", want)
+		}
+	}
+	if strings.Contains(string(body), "# UNIT claude_sessions_total") {
+		t.Error("the claude_sessions_total alias has a unit")
+	}
+}
@@ -0,0 +1,128 @@
+package collector
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- other coding agents (Codex, Gemini) ---
+
+// agentMetrics exports one agent's usage under its own metric prefix, with
+// a constant provider label on every series.
+type agentMetrics struct {
+	provider string
+
+	modelInputTokens     *prometheus.GaugeVec
+	modelOutputTokens    *prometheus.GaugeVec
+	modelCacheReadTokens *prometheus.GaugeVec
+	modelReasoningTokens *prometheus.GaugeVec
+	modelCost            *prometheus.GaugeVec
+	totalSessions        prometheus.Gauge
+	totalMessages        prometheus.Gauge
+	todayTokens          *prometheus.GaugeVec
+	dailyTokens          *prometheus.GaugeVec
+	toolUseTotal         *prometheus.GaugeVec
+}
+
+func newAgentMetrics(provider, prefix string, aliases deprecatedAliases) *agentMetrics {
+	labels := prometheus.Labels{"provider": provider}
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Name: prefix + "_" + name, Help: help, ConstLabels: labels})
+	}
+	vec := func(name, help string, labelNames ...string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: prefix + "_" + name, Help: help, ConstLabels: labels}, labelNames)
+	}
+
+	m := &agentMetrics{
+		provider: provider,
+
+		modelInputTokens:     vec("model_input_tokens", "Total uncached input tokens by model", "model"),
+		modelOutputTokens:    vec("model_output_tokens", "Total output tokens by model", "model"),
+		modelCacheReadTokens: vec("model_cache_read_tokens", "Total cached input tokens by model", "model"),
+		modelReasoningTokens: vec("model_reasoning_tokens", "Total reasoning output tokens by model", "model"),
+		modelCost:            vec("model_cost_usd", "Estimated cost in USD by model", "model"),
+		totalSessions:        gauge("sessions", "Total number of sessions"),
+		totalMessages:        gauge("messages", "Total number of assistant messages"),
+		todayTokens:          vec("today_tokens", "Tokens used today by model", "model"),
+		dailyTokens:          vec("daily_tokens", "Daily tokens by model", "date", "model"),
+		toolUseTotal:         vec("tool_use", "Tool usage count by tool name", "tool"),
+	}
+	aliases.add(m.modelInputTokens, prefix+"_model_input_tokens", labels, "model")
+	aliases.add(m.modelOutputTokens, prefix+"_model_output_tokens", labels, "model")
+	aliases.add(m.modelCacheReadTokens, prefix+"_model_cache_read_tokens", labels, "model")
+	aliases.add(m.modelReasoningTokens, prefix+"_model_reasoning_tokens", labels, "model")
+	aliases.add(m.totalSessions, prefix+"_sessions", labels)
+	aliases.add(m.totalMessages, prefix+"_messages", labels)
+	aliases.add(m.toolUseTotal, prefix+"_tool_use", labels, "tool")
+	return m
+}
+
+func (m *agentMetrics) metrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.modelInputTokens,
+		m.modelOutputTokens,
+		m.modelCacheReadTokens,
+		m.modelReasoningTokens,
+		m.modelCost,
+		m.totalSessions,
+		m.totalMessages,
+		m.todayTokens,
+		m.dailyTokens,
+		m.toolUseTotal,
+	}
+}
+
+func (m *agentMetrics) update(u *source.AgentUsage, now time.Time) {
+	m.modelInputTokens.Reset()
+	m.modelOutputTokens.Reset()
+	m.modelCacheReadTokens.Reset()
+	m.modelReasoningTokens.Reset()
+	m.modelCost.Reset()
+	m.todayTokens.Reset()
+	m.dailyTokens.Reset()
+	m.toolUseTotal.Reset()
+
+	if u == nil {
+		return
+	}
+
+	for model, t := range u.Totals() {
+		m.modelInputTokens.WithLabelValues(model).Set(t.Input)
+		m.modelOutputTokens.WithLabelValues(model).Set(t.Output)
+		m.modelCacheReadTokens.WithLabelValues(model).Set(t.CacheRead)
+		m.modelReasoningTokens.WithLabelValues(model).Set(u.Reasoning[model])
+		m.modelCost.WithLabelValues(model).Set(t.Cost)
+	}
+	m.totalSessions.Set(float64(u.Sessions))
+	m.totalMessages.Set(float64(u.Messages))
+
+	// Daily tokens (last 30 days)
+	dates := make([]string, 0, len(u.Daily))
+	for date := range u.Daily {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	if len(dates) > 30 {
+		dates = dates[len(dates)-30:]
+	}
+	for _, date := range dates {
+		for model, mu := range u.Daily[date] {
+			m.dailyTokens.WithLabelValues(date, model).Set(mu.Input + mu.Output)
+		}
+	}
+	today := now.UTC().Format("2006-01-02")
+	for model, mu := range u.Daily[today] {
+		m.todayTokens.WithLabelValues(model).Set(mu.Input + mu.Output)
+	}
+
+	for tool, n := range u.Tools {
+		m.toolUseTotal.WithLabelValues(tool).Set(float64(n))
+	}
+
+	log.Printf("%s metrics updated (sessions=%d, messages=%d)", m.provider, u.Sessions, u.Messages)
+}
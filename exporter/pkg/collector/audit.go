@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- audit log ---
+
+// auditEntry is one line of the audit log: the usage of one date, model and
+// project that a scan found and no earlier scan had.
+type auditEntry struct {
+	Time                time.Time `json:"time"`
+	Date                string    `json:"date"`
+	Model               string    `json:"model"`
+	Project             string    `json:"project"`
+	Requests            int       `json:"requests"`
+	InputTokens         float64   `json:"input_tokens"`
+	OutputTokens        float64   `json:"output_tokens"`
+	CacheReadTokens     float64   `json:"cache_read_tokens"`
+	CacheCreationTokens float64   `json:"cache_creation_tokens"`
+	CostUSD             float64   `json:"cost_usd"`
+}
+
+// writeAudit appends the requests not logged before to Options.AuditLog.
+// Requests are only marked logged once the lines are written, so a failed
+// write is retried by the next scan.
+func (c *Collector) writeAudit(usage []source.DatedUsage, now time.Time) {
+	if c.auditLog == "" {
+		return
+	}
+	s := &c.state
+	s.mu.Lock()
+	s.init()
+	type key struct{ date, model, project string }
+	entries := make(map[key]*auditEntry)
+	var logged []source.DatedUsage
+	for _, u := range usage {
+		if c.seen(u.ID+":audit", dateTime(u.Date, u.Hour, now.Location()), now) {
+			continue
+		}
+		logged = append(logged, u)
+		k := key{u.Date, u.Model, u.Project}
+		e, ok := entries[k]
+		if !ok {
+			e = &auditEntry{Time: now.UTC(), Date: u.Date, Model: u.Model, Project: u.Project}
+			entries[k] = e
+		}
+		e.Requests += c.sampleEvery
+		e.InputTokens += u.Usage.Input
+		e.OutputTokens += u.Usage.Output
+		e.CacheReadTokens += u.Usage.CacheRead
+		e.CacheCreationTokens += u.Usage.CacheCreate
+		e.CostUSD += u.Usage.Cost
+	}
+	s.mu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	sorted := make([]*auditEntry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Project < b.Project
+	})
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range sorted {
+		enc.Encode(e)
+	}
+	// one write per scan, so lines of concurrent writers don't interleave
+	f, err := os.OpenFile(c.auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		log.Printf("audit: %v", err)
+		return
+	}
+	_, err = f.Write(buf.Bytes())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("audit: failed to append to %s: %v", c.auditLog, err)
+		return
+	}
+
+	s.mu.Lock()
+	for _, u := range logged {
+		c.markSeen(u.ID+":audit", dateTime(u.Date, u.Hour, now.Location()), now)
+	}
+	s.mu.Unlock()
+}
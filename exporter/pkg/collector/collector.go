@@ -0,0 +1,1784 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- cost helpers ---
+
+// usageCost returns the cost recorded in the stats cache, falling back to
+// the pricing table when Claude did not record one.
+func usageCost(t *pricing.Table, model string, u source.ModelUsage) float64 {
+	if u.CostUSD > 0 {
+		return u.CostUSD
+	}
+	return t.Cost(model, u.InputTokens, u.OutputTokens, u.CacheReadInputTokens, u.CacheCreationInputTokens) +
+		t.WebSearchCost(model, u.WebSearchRequests)
+}
+
+// blendedRate returns the average USD cost per input+output token for a
+// model, used to price daily token totals that carry no per-kind breakdown.
+func blendedRate(t *pricing.Table, model string, u source.ModelUsage) float64 {
+	if tokens := u.InputTokens + u.OutputTokens; tokens > 0 {
+		return usageCost(t, model, u) / tokens
+	}
+	p, _ := t.Lookup(model)
+	return p.Input / 1e6
+}
+
+// --- collector ---
+
+// Options configures a Collector.
+type Options struct {
+	// StateFile persists histogram observations across restarts (empty
+	// keeps them in memory only).
+	StateFile string
+	// StateRetention is how long observed records and saved histogram
+	// samples are kept (0 means 30 days).
+	StateRetention time.Duration
+	// AuditLog is a JSONL file each update appends its new usage to, by
+	// date, model and project (empty disables it).
+	AuditLog string
+	// StatsFile and ClaudeDir locate Claude Code's stats-cache.json and
+	// config directory. They are reported in claude_exporter_info and, when
+	// Sources is nil, used to build the default Claude sources.
+	StatsFile string
+	ClaudeDir string
+	// Pricing estimates costs Claude did not record (nil disables it).
+	Pricing *pricing.Table
+	// ModelRules rewrites Claude model names, e.g. to merge dated
+	// snapshots (nil keeps them as reported).
+	ModelRules *model.Rules
+	// Limits folds long-tail label values into "other".
+	Limits LabelLimits
+	// Sources to scan on every collect. Defaults to the stats cache and
+	// Claude session logs.
+	Sources []source.Source
+	// Projects filters the projects of the default Claude session source.
+	Projects source.ProjectFilter
+	// Teams attributes the cost of the default Claude session source to
+	// teams (nil disables claude_cost_usd).
+	Teams *source.TeamMap
+	// Languages resolves the language of the projects of the default
+	// Claude session source, and adds a language label to the per-project
+	// cost metrics (nil leaves it out).
+	Languages *source.LanguageMap
+	// LiveWindow also treats session files modified this recently as live
+	// in the default Claude session source.
+	LiveWindow time.Duration
+	// LineLimit bounds the bytes of one JSONL line the default Claude
+	// session source holds while parsing (0 uses the default).
+	LineLimit int
+	// AgentPrefixes overrides the metric prefix per agent provider
+	// (defaults to the provider name).
+	AgentPrefixes map[string]string
+	// NativeHistograms also emits the histograms as native (sparse)
+	// histograms, which Prometheus scrapes over protobuf.
+	NativeHistograms bool
+	// WindowTokenLimit is the plan's token limit per 5-hour window, used to
+	// project the time to limit (0 disables the projection).
+	WindowTokenLimit float64
+	// TopSessions is how many of the most expensive live sessions and
+	// projects to rank in claude_top_session_cost_usd and
+	// claude_top_project_cost_usd (0 disables them).
+	TopSessions int
+	// SampleEvery is the Claude session source's sampling rate
+	// (source.ClaudeSessionsOptions.SampleEvery). Above 1, the token and
+	// cost metrics estimated from the sample carry a sample_every label.
+	SampleEvery int
+	// OnObserve is called with every new histogram sample, e.g. to forward
+	// it as a StatsD distribution.
+	OnObserve func(name string, labels prometheus.Labels, value float64)
+	// OnUpdate is called with the live session data after every update,
+	// e.g. to send notifications. Model names are already normalized.
+	OnUpdate func(live *source.LiveResult)
+	// SummaryQuantiles also exports the turn duration and compaction
+	// pre-token histograms as summaries with these quantiles, for backends
+	// that prefer them (nil disables the summaries).
+	SummaryQuantiles []float64
+	// SummariesOnly drops those two histograms when summaries are enabled.
+	SummariesOnly bool
+	// DegradedStatLatency is the stat latency of the Claude data over which
+	// the collector sheds load, scanning at most every DegradedInterval
+	// (0 disables it).
+	DegradedStatLatency time.Duration
+	DegradedInterval    time.Duration
+	// ScanTimeout bounds each scan; sources still running then report
+	// what they have read so far (0 waits for them).
+	ScanTimeout time.Duration
+	// Now is the clock the metrics are computed against (nil uses
+	// time.Now). A fixed clock and no StateFile make Apply reproducible.
+	Now func() time.Time
+}
+
+// providerSource is implemented by sources of non-Claude agents.
+type providerSource interface {
+	Provider() string
+}
+
+// Collector exports Claude Code (and other agents') usage as Prometheus
+// metrics. Every collect rescans its sources.
+type Collector struct {
+	now        func() time.Time
+	statsFile  string
+	claudeDir  string
+	pricing    *pricing.Table
+	modelRules *model.Rules
+	limits     LabelLimits
+	sources    []source.Source
+
+	// scan timeout, see scan.go
+	scanTimeout time.Duration
+	scanMu      sync.Mutex
+	stuckScans  []int // abandoned scans still running, by source
+	// what each source last returned, kept over incomplete scans
+	last []source.Snapshot
+
+	// degraded mode, see degraded.go
+	degradedLatency  time.Duration
+	degradedInterval time.Duration
+	probe            statProbe
+
+	// metrics for non-Claude agents, keyed by provider
+	agents map[string]*agentMetrics
+	// old names of renamed gauges, served until the next release
+	aliases deprecatedAliases
+
+	windowTokenLimit float64
+	topSessions      int
+	languages        bool
+	sampleEvery      int
+	onObserve        func(name string, labels prometheus.Labels, value float64)
+	onUpdate         func(live *source.LiveResult)
+
+	// summaries of histograms, keyed by histogram name
+	summaries     map[string]*prometheus.SummaryVec
+	summariesOnly bool
+
+	// histogram observations, see state.go
+	state          histogramState
+	stateFile      string
+	stateRetention time.Duration
+	auditLog       string
+
+	// updateMu serializes updates, which reset and refill the vectors;
+	// scrapes read frozen, the values taken once the last update finished
+	updateMu sync.Mutex
+	frozen   atomic.Pointer[[]prometheus.Metric]
+
+	// latest summary, live sessions and daily history served by the APIs
+	summary  atomic.Pointer[Summary]
+	sessions atomic.Pointer[[]SessionSummary]
+	history  atomic.Pointer[[]DailySummary]
+	// unix nanoseconds of the last update that read the stats cache
+	lastUpdate atomic.Int64
+
+	// cumulative (cache + live)
+	modelInputTokens       *prometheus.GaugeVec
+	modelOutputTokens      *prometheus.GaugeVec
+	modelCacheReadTokens   *prometheus.GaugeVec
+	modelCacheCreateTokens *prometheus.GaugeVec
+
+	// prompt cache efficiency
+	cacheHitRatio *prometheus.GaugeVec
+	cacheSavings  *prometheus.GaugeVec
+
+	// live only
+	liveInputTokens  *prometheus.GaugeVec
+	liveOutputTokens *prometheus.GaugeVec
+	liveCost         *prometheus.GaugeVec
+	liveSessions     prometheus.Gauge
+	liveFiles        *prometheus.GaugeVec
+	liveMessages     prometheus.Gauge
+
+	// extended thinking (live only)
+	thinkingTokens *prometheus.GaugeVec
+	thinkingRatio  *prometheus.GaugeVec
+
+	// totals
+	totalSessions prometheus.Gauge
+	totalMessages prometheus.Gauge
+
+	// today
+	todayMessages  prometheus.Gauge
+	todaySessions  prometheus.Gauge
+	todayToolCalls prometheus.Gauge
+	todayTokens    *prometheus.GaugeVec
+
+	// daily (last 30 days)
+	dailyMessages  *prometheus.GaugeVec
+	dailySessions  *prometheus.GaugeVec
+	dailyToolCalls *prometheus.GaugeVec
+	dailyTokens    *prometheus.GaugeVec
+	dailyToolUse   *prometheus.GaugeVec
+	dailyTokenKind *prometheus.GaugeVec
+	dailyProject   *prometheus.GaugeVec
+	costPerMessage *prometheus.GaugeVec
+	costPerSession *prometheus.GaugeVec
+
+	// weekly / monthly (ISO weeks, calendar months)
+	weeklyTokens  *prometheus.GaugeVec
+	weeklyCost    *prometheus.GaugeVec
+	monthlyTokens *prometheus.GaugeVec
+	monthlyCost   *prometheus.GaugeVec
+
+	// hour distribution
+	hourActivity *prometheus.GaugeVec
+	hourTokens   *prometheus.GaugeVec
+	hourCost     *prometheus.GaugeVec
+
+	// cost by team from the team mapping file
+	teamCost *prometheus.GaugeVec
+
+	// current 5-hour usage window
+	windowTokens      prometheus.Gauge
+	windowCost        prometheus.Gauge
+	windowRemaining   prometheus.Gauge
+	windowBurnRate    prometheus.Gauge
+	windowLimit       prometheus.Gauge
+	windowTimeToLimit prometheus.Gauge
+
+	// recent activity, from request timestamps
+	requestsLast5m prometheus.Gauge
+	tokensLast5m   prometheus.Gauge
+	costLastHour   prometheus.Gauge
+
+	// cost anomaly and forecast
+	costAnomalyScore prometheus.Gauge
+	forecastEOD      prometheus.Gauge
+	forecastEOM      prometheus.Gauge
+
+	// info
+	exporterInfo   *prometheus.GaugeVec
+	versionInfo    *prometheus.GaugeVec
+	scanIncomplete prometheus.Gauge
+	degradedMode   prometheus.Gauge
+	statLatency    prometheus.Gauge
+
+	// stats cache freshness
+	lastComputedTime prometheus.Gauge
+	firstSessionTime prometheus.Gauge
+	statsCacheAge    prometheus.Gauge
+	statsSchema      prometheus.Gauge
+
+	// --- NEW: turn duration ---
+	turnDuration prometheus.Histogram
+	turnCost     prometheus.Histogram
+	outputSpeed  *prometheus.HistogramVec
+	firstToken   *prometheus.HistogramVec
+	approvalWait prometheus.Histogram
+	contextSize  *prometheus.HistogramVec
+	turnActive   prometheus.Histogram
+
+	// session lifetime
+	sessionDuration prometheus.Histogram
+	sessionTurns    prometheus.Histogram
+	roleMessages    *prometheus.GaugeVec
+	sessionIdle     *prometheus.GaugeVec
+	topSessionCost  *prometheus.GaugeVec
+	topProjectCost  *prometheus.GaugeVec
+
+	// --- NEW: tool usage breakdown ---
+	toolUseTotal *prometheus.GaugeVec
+	toolErrors   *prometheus.GaugeVec
+	permissions  *prometheus.GaugeVec
+
+	// --- NEW: stop reason ---
+	stopReasonTotal *prometheus.GaugeVec
+	maxTokensRate   *prometheus.GaugeVec
+
+	// recent window, including sessions the stats cache already covers
+	recentWindow      prometheus.Gauge
+	recentSessions    prometheus.Gauge
+	recentInput       *prometheus.GaugeVec
+	recentOutput      *prometheus.GaugeVec
+	recentCost        *prometheus.GaugeVec
+	recentToolUse     *prometheus.GaugeVec
+	recentStopReasons *prometheus.GaugeVec
+
+	// --- NEW: API errors ---
+	apiErrorsTotal      prometheus.Gauge
+	apiRetriesTotal     prometheus.Gauge
+	apiRetriesExhausted prometheus.Gauge
+	usageLimitEvents    prometheus.Gauge
+	usageLimitReset     prometheus.Gauge
+	apiRetryBackoff     prometheus.Histogram
+
+	// --- NEW: context compaction ---
+	compactEventsTotal    *prometheus.GaugeVec
+	compactPreTokensTotal *prometheus.HistogramVec
+	compactionsPerSession *prometheus.GaugeVec
+
+	// --- NEW: web search / fetch ---
+	webSearchTotal prometheus.Gauge
+	webSearchCost  *prometheus.GaugeVec
+	webFetchTotal  prometheus.Gauge
+
+	// resumed-session dedupe
+	duplicateRecords prometheus.Gauge
+	overlapMessages  prometheus.Gauge
+	oversizedLines   prometheus.Gauge
+	recordBytes      prometheus.Gauge
+	overBudgetFiles  prometheus.Gauge
+	malformedLines   *prometheus.GaugeVec
+
+	// size of the histogram state, see state.go
+	stateEntries   *prometheus.GaugeVec
+	statePruned    *prometheus.CounterVec
+	stateFileBytes prometheus.Gauge
+
+	// mid-session model changes (e.g. Opus falling back to Sonnet)
+	modelSwitches *prometheus.GaugeVec
+
+	// turns stopped by the user
+	turnInterruptions prometheus.Gauge
+}
+
+// maxMalformedFiles bounds the file label of
+// claude_exporter_malformed_lines_total.
+const maxMalformedFiles = 20
+
+// histogramOpts adds native histogram settings when enabled. Classic
+// buckets are kept for scrapers without native histogram support.
+func histogramOpts(opts prometheus.HistogramOpts, native bool) prometheus.HistogramOpts {
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 160
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+	return opts
+}
+
+// NewCollector returns a collector ready to be registered with a
+// prometheus.Registerer.
+func NewCollector(cfg Options) *Collector {
+	if cfg.Sources == nil && cfg.StatsFile != "" {
+		cfg.Sources = []source.Source{
+			source.NewStatsCache(cfg.StatsFile),
+			source.NewClaudeSessions(source.ClaudeSessionsOptions{
+				ClaudeDir:   cfg.ClaudeDir,
+				StatsFile:   cfg.StatsFile,
+				Pricing:     cfg.Pricing,
+				Projects:    cfg.Projects,
+				Teams:       cfg.Teams,
+				Languages:   cfg.Languages,
+				LiveWindow:  cfg.LiveWindow,
+				LineLimit:   cfg.LineLimit,
+				SampleEvery: cfg.SampleEvery,
+				Now:         cfg.Now,
+			}),
+		}
+	}
+
+	aliases := make(deprecatedAliases)
+	agents := make(map[string]*agentMetrics)
+	for _, src := range cfg.Sources {
+		if ps, ok := src.(providerSource); ok {
+			provider := ps.Provider()
+			prefix := cfg.AgentPrefixes[provider]
+			if prefix == "" {
+				prefix = provider
+			}
+			agents[provider] = newAgentMetrics(provider, prefix, aliases)
+		}
+	}
+
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.StateRetention <= 0 {
+		cfg.StateRetention = defaultStateRetention
+	}
+	var sampled prometheus.Labels
+	if cfg.SampleEvery > 1 {
+		sampled = prometheus.Labels{"sample_every": strconv.Itoa(cfg.SampleEvery)}
+	}
+
+	c := &Collector{
+		now:        cfg.Now,
+		statsFile:  cfg.StatsFile,
+		claudeDir:  cfg.ClaudeDir,
+		pricing:    cfg.Pricing,
+		modelRules: cfg.ModelRules,
+		limits:     cfg.Limits,
+		sources:    cfg.Sources,
+		agents:     agents,
+		aliases:    aliases,
+
+		scanTimeout: cfg.ScanTimeout,
+		stuckScans:  make([]int, len(cfg.Sources)),
+		last:        make([]source.Snapshot, len(cfg.Sources)),
+
+		degradedLatency:  cfg.DegradedStatLatency,
+		degradedInterval: cfg.DegradedInterval,
+
+		windowTokenLimit: cfg.WindowTokenLimit,
+		topSessions:      cfg.TopSessions,
+		languages:        cfg.Languages != nil,
+		sampleEvery:      max(cfg.SampleEvery, 1),
+		onObserve:        cfg.OnObserve,
+		onUpdate:         cfg.OnUpdate,
+		stateFile:        cfg.StateFile,
+		stateRetention:   cfg.StateRetention,
+		auditLog:         cfg.AuditLog,
+
+		modelInputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_model_input_tokens",
+			Help: "Total input tokens by model",
+		}, []string{"model", "provider"}),
+		modelOutputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_model_output_tokens",
+			Help: "Total output tokens by model",
+		}, []string{"model", "provider"}),
+		modelCacheReadTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_model_cache_read_tokens",
+			Help: "Total cache-read input tokens by model",
+		}, []string{"model", "provider"}),
+		modelCacheCreateTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_model_cache_creation_tokens",
+			Help: "Total cache-creation input tokens by model",
+		}, []string{"model", "provider"}),
+		cacheHitRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_cache_hit_ratio",
+			Help: "Share of input tokens served from the prompt cache by model",
+		}, []string{"model"}),
+		cacheSavings: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_cache_savings_usd",
+			Help: "Estimated USD saved by prompt caching versus uncached input, net of the cache write premium, by model",
+		}, []string{"model"}),
+		liveInputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_live_input_tokens",
+			Help:        "Input tokens from active sessions (not yet in cache), by plan or normal mode and interactive or background purpose",
+			ConstLabels: sampled,
+		}, []string{"model", "provider", "mode", "purpose"}),
+		liveOutputTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_live_output_tokens",
+			Help:        "Output tokens from active sessions (not yet in cache), by plan or normal mode and interactive or background purpose",
+			ConstLabels: sampled,
+		}, []string{"model", "provider", "mode", "purpose"}),
+		liveCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_live_cost_usd",
+			Help:        "Estimated cost of active sessions (not yet in cache), by plan or normal mode and interactive or background purpose",
+			ConstLabels: sampled,
+		}, []string{"model", "mode", "purpose"}),
+		liveSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_sessions",
+			Help: "Number of active sessions (not yet in cache)",
+		}),
+		liveFiles: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_live_files",
+			Help: "Session files treated as live, by basis (stats_cache, window, no_stats_cache)",
+		}, []string{"basis"}),
+		liveMessages: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_live_messages",
+			Help:        "Messages in active sessions (not yet in cache)",
+			ConstLabels: sampled,
+		}),
+
+		thinkingTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_thinking_tokens",
+			Help:        "Extended thinking output tokens from active sessions by model",
+			ConstLabels: sampled,
+		}, []string{"model"}),
+		thinkingRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_thinking_output_ratio",
+			Help: "Thinking tokens per visible output token from active sessions by model",
+		}, []string{"model"}),
+
+		totalSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_sessions",
+			Help: "Total number of sessions",
+		}),
+		totalMessages: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_messages",
+			Help: "Total number of messages",
+		}),
+
+		todayMessages: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_today_messages",
+			Help: "Messages sent today",
+		}),
+		todaySessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_today_sessions",
+			Help: "Sessions started today",
+		}),
+		todayToolCalls: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_today_tool_calls",
+			Help: "Tool calls today",
+		}),
+		todayTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_today_tokens",
+			Help: "Tokens used today by model",
+		}, []string{"model"}),
+
+		dailyMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_daily_messages",
+			Help: "Daily message count",
+		}, []string{"date"}),
+		dailySessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_daily_sessions",
+			Help: "Daily session count",
+		}, []string{"date"}),
+		dailyToolCalls: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_daily_tool_calls",
+			Help: "Daily tool call count",
+		}, []string{"date"}),
+		dailyTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_daily_tokens",
+			Help: "Daily tokens by model",
+		}, []string{"date", "model"}),
+		dailyToolUse: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_daily_tool_use",
+			Help:        "Tool calls per day by tool over the last 30 days, counted by the exporter from session logs",
+			ConstLabels: sampled,
+		}, []string{"date", "tool"}),
+		dailyTokenKind: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_daily_tokens_by_kind",
+			Help:        "Tokens per day by model and kind (input, output, cache_read, cache_create) over the last 30 days, counted by the exporter from session logs",
+			ConstLabels: sampled,
+		}, []string{"date", "model", "kind"}),
+		dailyProject: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_daily_project_cost_usd",
+			Help:        "Estimated cost per day by project over the last 30 days, counted by the exporter from session logs",
+			ConstLabels: sampled,
+		}, projectLabels(cfg.Languages, "date", "project")),
+		costPerMessage: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_cost_per_message_usd",
+			Help:        "Average estimated cost of an API request by model over the last 7 days, counted by the exporter from session logs",
+			ConstLabels: sampled,
+		}, []string{"model"}),
+		costPerSession: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_cost_per_session_usd",
+			Help:        "Average estimated cost of a model's requests per session that used it over the last 7 days, counted by the exporter from session logs",
+			ConstLabels: sampled,
+		}, []string{"model"}),
+
+		weeklyTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_weekly_tokens",
+			Help: "Tokens per ISO week by model",
+		}, []string{"week", "model"}),
+		weeklyCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_weekly_cost_usd",
+			Help: "Estimated cost in USD per ISO week by model",
+		}, []string{"week", "model"}),
+		monthlyTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_monthly_tokens",
+			Help: "Tokens per calendar month by model",
+		}, []string{"month", "model"}),
+		monthlyCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_monthly_cost_usd",
+			Help: "Estimated cost in USD per calendar month by model",
+		}, []string{"month", "model"}),
+
+		hourActivity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_hour_sessions",
+			Help: "Session count by hour of day",
+		}, []string{"hour"}),
+		hourTokens: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_hour_tokens",
+			Help:        "Tokens from active sessions by local hour of day and model",
+			ConstLabels: sampled,
+		}, []string{"hour", "model"}),
+		hourCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_hour_cost_usd",
+			Help:        "Estimated cost in USD from active sessions by local hour of day",
+			ConstLabels: sampled,
+		}, []string{"hour"}),
+
+		teamCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_cost_usd",
+			Help:        "Estimated cost in USD of all session history by team",
+			ConstLabels: sampled,
+		}, []string{"team"}),
+
+		windowTokens: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_window_tokens",
+			Help:        "Input and output tokens used in the current 5-hour window",
+			ConstLabels: sampled,
+		}),
+		windowCost: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_window_cost_usd",
+			Help:        "Estimated cost in USD of the current 5-hour window",
+			ConstLabels: sampled,
+		}),
+		windowRemaining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_window_seconds_remaining",
+			Help: "Seconds until the current 5-hour window resets",
+		}),
+		windowBurnRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_window_burn_rate_tokens_per_minute",
+			Help:        "Average tokens per minute since the current 5-hour window started",
+			ConstLabels: sampled,
+		}),
+		windowLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_window_token_limit",
+			Help: "Configured plan token limit per 5-hour window",
+		}),
+		windowTimeToLimit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_window_seconds_to_limit",
+			Help:        "Projected seconds until the window token limit is reached at the current burn rate, capped at the window reset",
+			ConstLabels: sampled,
+		}),
+		requestsLast5m: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_requests_last_5m",
+			Help:        "API requests made in the last 5 minutes",
+			ConstLabels: sampled,
+		}),
+		tokensLast5m: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_tokens_last_5m",
+			Help:        "Input and output tokens used in the last 5 minutes",
+			ConstLabels: sampled,
+		}),
+		costLastHour: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_cost_last_hour_usd",
+			Help:        "Estimated cost in USD of the requests made in the last hour",
+			ConstLabels: sampled,
+		}),
+		costAnomalyScore: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_cost_anomaly_score",
+			Help: "Z-score of the current hour's cost against the same hour of the previous 7 days, 0 until 3 days are recorded",
+		}),
+		forecastEOD: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_cost_forecast_eod_usd",
+			Help:        "Projected cost of today: spent so far plus the rest of the day at the usual hourly pattern and the average of this weekday over the last 4 weeks",
+			ConstLabels: sampled,
+		}),
+		forecastEOM: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_cost_forecast_eom_usd",
+			Help:        "Projected cost of this calendar month: spent so far, today's forecast and each remaining day at the average of its weekday over the last 4 weeks",
+			ConstLabels: sampled,
+		}),
+
+		exporterInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_exporter_info",
+			Help: "Claude Code exporter metadata",
+		}, []string{"stats_file", "claude_dir", "last_computed_date", "first_session_date", "live_sessions"}),
+		scanIncomplete: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_exporter_scan_incomplete",
+			Help: "1 if the last scan hit SCAN_TIMEOUT and reported partial results",
+		}),
+		degradedMode: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_exporter_degraded_mode",
+			Help: "1 while slow stats of the Claude data, e.g. on a network filesystem, have the exporter scan at most every DEGRADED_SCAN_INTERVAL and serve cached results in between",
+		}),
+		statLatency: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_exporter_stat_latency_seconds",
+			Help: "Median over the last 5 scans of the slowest stat of the stats cache, the Claude dir and its projects dir",
+		}),
+		lastComputedTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_stats_last_computed_timestamp_seconds",
+			Help: "Start of the last day the stats cache covers (lastComputedDate), as a Unix timestamp",
+		}),
+		firstSessionTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_first_session_timestamp_seconds",
+			Help: "When the first Claude Code session started (firstSessionDate), as a Unix timestamp",
+		}),
+		statsCacheAge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_stats_cache_age_seconds",
+			Help: "Seconds since Claude last rewrote the stats cache file",
+		}),
+		statsSchema: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_stats_schema_version",
+			Help: "Version field of the stats cache file (0 when it has none)",
+		}),
+
+		versionInfo: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_code_version_info",
+			Help: "Active sessions by the Claude Code version they run",
+		}, []string{"version"}),
+
+		// --- NEW metrics ---
+
+		turnDuration: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_turn_duration_seconds",
+			Help:    "Distribution of assistant turn durations in seconds",
+			Buckets: []float64{5, 10, 20, 30, 60, 120, 300, 600, 1800, 3600},
+		}, cfg.NativeHistograms)),
+		turnCost: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_turn_cost_usd",
+			Help:    "Distribution of the estimated cost in USD of each assistant turn, subagents included",
+			Buckets: []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10},
+		}, cfg.NativeHistograms)),
+		outputSpeed: prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_output_tokens_per_second",
+			Help:    "Distribution of main-thread output tokens per second of turn duration, by model",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 50, 75, 100, 150, 200},
+		}, cfg.NativeHistograms), []string{"model"}),
+		firstToken: prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_time_to_first_token_seconds",
+			Help:    "Distribution of seconds from a prompt or tool result to the first content block of the response, by model",
+			Buckets: []float64{0.5, 1, 2, 3, 5, 10, 20, 30, 60, 120},
+		}, cfg.NativeHistograms), []string{"model"}),
+		approvalWait: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_approval_wait_seconds",
+			Help:    "Distribution of seconds from a tool call that may ask for permission to its result, the tool's run time included",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300, 600, 1800},
+		}, cfg.NativeHistograms)),
+		contextSize: prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_request_context_tokens",
+			Help:    "Distribution of the context (input, cache read and cache creation tokens) of each API request, by model",
+			Buckets: []float64{1000, 2000, 5000, 10000, 20000, 50000, 100000, 150000, 200000, 500000, 1000000},
+		}, cfg.NativeHistograms), []string{"model"}),
+		turnActive: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_turn_active_seconds",
+			Help:    "Distribution of assistant turn durations in seconds without the main thread's approval waits",
+			Buckets: []float64{5, 10, 20, 30, 60, 120, 300, 600, 1800, 3600},
+		}, cfg.NativeHistograms)),
+		sessionDuration: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_session_duration_seconds",
+			Help:    "Distribution of session durations (first to last record) of sessions idle for an hour",
+			Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400},
+		}, cfg.NativeHistograms)),
+		sessionTurns: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_turns_per_session",
+			Help:    "Distribution of user prompts per session, of sessions idle for an hour",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 9),
+		}, cfg.NativeHistograms)),
+		roleMessages: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_live_messages_by_role",
+			Help:        "Messages in active sessions by role: user prompts and tool results, and assistant responses",
+			ConstLabels: sampled,
+		}, []string{"role"}),
+		sessionIdle: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_session_idle_seconds",
+			Help: "Seconds since the last record of each active session",
+		}, []string{"session", "project"}),
+		topSessionCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_top_session_cost_usd",
+			Help:        "Estimated cost of the most expensive live sessions, rank 1 the highest (TOP_SESSIONS)",
+			ConstLabels: sampled,
+		}, []string{"rank", "session_id", "project"}),
+		topProjectCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_top_project_cost_usd",
+			Help:        "Estimated cost of live sessions of the most expensive projects, rank 1 the highest (TOP_SESSIONS)",
+			ConstLabels: sampled,
+		}, projectLabels(cfg.Languages, "rank", "project")),
+
+		toolUseTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_live_tool_use",
+			Help:        "Tool usage count from active sessions by tool name",
+			ConstLabels: sampled,
+		}, []string{"tool"}),
+		toolErrors: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_tool_errors",
+			Help:        "Tool results flagged is_error in active sessions by tool name, without permission denials",
+			ConstLabels: sampled,
+		}, []string{"tool"}),
+		permissions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_permission_requests",
+			Help:        "Tool calls in active sessions by tool and permission decision (allowed, auto or denied)",
+			ConstLabels: sampled,
+		}, []string{"tool", "decision"}),
+
+		recentWindow: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_recent_window_seconds",
+			Help: "Span of the claude_recent_* metrics (RECENT_WINDOW)",
+		}),
+		recentSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_recent_sessions",
+			Help: "Sessions with API requests in the recent window, including those the stats cache covers",
+		}),
+		recentInput: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_recent_input_tokens",
+			Help:        "Input tokens in the recent window by model, including requests the stats cache covers",
+			ConstLabels: sampled,
+		}, []string{"model"}),
+		recentOutput: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_recent_output_tokens",
+			Help:        "Output tokens in the recent window by model, including requests the stats cache covers",
+			ConstLabels: sampled,
+		}, []string{"model"}),
+		recentCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_recent_cost_usd",
+			Help:        "Estimated cost in the recent window by model, including requests the stats cache covers",
+			ConstLabels: sampled,
+		}, []string{"model"}),
+		recentToolUse: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_recent_tool_use",
+			Help:        "Tool calls in the recent window by tool, including sessions the stats cache covers",
+			ConstLabels: sampled,
+		}, []string{"tool"}),
+		recentStopReasons: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_recent_stop_reason",
+			Help:        "API requests in the recent window by model and stop reason, including those the stats cache covers",
+			ConstLabels: sampled,
+		}, []string{"model", "reason"}),
+
+		stopReasonTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "claude_live_stop_reason",
+			Help:        "Stop reason count from active sessions by model",
+			ConstLabels: sampled,
+		}, []string{"model", "reason"}),
+		maxTokensRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_live_max_tokens_ratio",
+			Help: "Share of responses from active sessions truncated at max_tokens, by model",
+		}, []string{"model"}),
+
+		apiErrorsTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_api_errors",
+			Help: "API error count from active sessions",
+		}),
+		apiRetriesTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_api_retries",
+			Help: "API retry count from active sessions",
+		}),
+		apiRetriesExhausted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_api_retry_exhausted",
+			Help: "API errors from active sessions on the last allowed retry attempt",
+		}),
+		usageLimitEvents: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_usage_limit_events",
+			Help: "Subscription usage limit notices in active sessions",
+		}),
+		usageLimitReset: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_usage_limit_reset_timestamp_seconds",
+			Help: "When the limit of the latest usage limit notice resets, as a Unix timestamp (0 if unknown)",
+		}),
+		apiRetryBackoff: prometheus.NewHistogram(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_api_retry_backoff_seconds",
+			Help:    "Distribution of backoff delays announced before API retries",
+			Buckets: prometheus.ExponentialBuckets(0.5, 2, 8),
+		}, cfg.NativeHistograms)),
+
+		compactEventsTotal: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_live_compact_events",
+			Help: "Context compaction events from active sessions, by trigger (auto or manual)",
+		}, []string{"trigger"}),
+		compactPreTokensTotal: prometheus.NewHistogramVec(histogramOpts(prometheus.HistogramOpts{
+			Name:    "claude_compact_pre_tokens",
+			Help:    "Distribution of token counts before context compaction",
+			Buckets: []float64{50000, 100000, 150000, 200000, 300000, 500000},
+		}, cfg.NativeHistograms), []string{"trigger"}),
+		compactionsPerSession: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_live_compactions_per_session",
+			Help: "Average context compactions per active session, over the sessions that used the model",
+		}, []string{"model"}),
+
+		webSearchTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_live_web_search",
+			Help:        "Web search requests from active sessions",
+			ConstLabels: sampled,
+		}),
+		webSearchCost: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_web_search_cost_usd",
+			Help: "Estimated cost in USD of web search requests by model, included in the model costs",
+		}, []string{"model"}),
+		webFetchTotal: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "claude_live_web_fetch",
+			Help:        "Web fetch requests from active sessions",
+			ConstLabels: sampled,
+		}),
+
+		duplicateRecords: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_duplicate_records",
+			Help: "Records skipped in active sessions because a resumed session already contained them",
+		}),
+		overlapMessages: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_overlap_messages",
+			Help: "Messages in active sessions left out of live totals because the stats cache already counts them",
+		}),
+		oversizedLines: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_oversized_lines",
+			Help: "Lines of scanned session files over the line limit (SCAN_LINE_LIMIT_MB), parsed with long strings cut or dropped",
+		}),
+		recordBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_record_bytes",
+			Help: "Bytes of session file lines whose parsed records are kept between scans",
+		}),
+		overBudgetFiles: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_live_over_budget_files",
+			Help: "Session files read whole on every scan because keeping their records would go over SCAN_MEMORY_BUDGET_MB",
+		}),
+		malformedLines: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_exporter_malformed_lines",
+			Help: "Lines of scanned session files skipped because they are not valid records, by file (the top 20, the rest as other)",
+		}, []string{"file"}),
+		stateEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_exporter_state_entries",
+			Help: "Entries of the histogram state: observed records (observed) and histogram samples kept for the state file (samples)",
+		}, []string{"kind"}),
+		statePruned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "claude_exporter_state_pruned_total",
+			Help: "State entries dropped since the start for being older than STATE_RETENTION_DAYS, by kind",
+		}, []string{"kind"}),
+		stateFileBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_exporter_state_file_bytes",
+			Help: "Size of the state file as last loaded or saved (0 without STATE_FILE)",
+		}),
+
+		modelSwitches: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_model_switches",
+			Help: "Model changes between consecutive messages within active sessions",
+		}, []string{"from", "to"}),
+
+		turnInterruptions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "claude_turn_interruptions",
+			Help: "Turns the user interrupted (Esc) in active sessions",
+		}),
+	}
+	if len(cfg.SummaryQuantiles) > 0 {
+		objectives := make(map[float64]float64, len(cfg.SummaryQuantiles))
+		for _, q := range cfg.SummaryQuantiles {
+			objectives[q] = math.Min(q, 1-q) / 10 // 0.5: ±0.05, 0.99: ±0.001
+		}
+		c.summaries = map[string]*prometheus.SummaryVec{
+			"claude_turn_duration_seconds": prometheus.NewSummaryVec(prometheus.SummaryOpts{
+				Name:       "claude_turn_duration_summary_seconds",
+				Help:       "Quantiles of assistant turn durations in seconds over the last 10 minutes",
+				Objectives: objectives,
+			}, nil),
+			"claude_compact_pre_tokens": prometheus.NewSummaryVec(prometheus.SummaryOpts{
+				Name:       "claude_compact_pre_tokens_summary",
+				Help:       "Quantiles of token counts before context compaction over the last 10 minutes",
+				Objectives: objectives,
+			}, []string{"trigger"}),
+		}
+		c.summariesOnly = cfg.SummariesOnly
+	}
+	aliases.add(c.modelInputTokens, "claude_model_input_tokens", nil, "model", "provider")
+	aliases.add(c.modelOutputTokens, "claude_model_output_tokens", nil, "model", "provider")
+	aliases.add(c.modelCacheReadTokens, "claude_model_cache_read_tokens", nil, "model", "provider")
+	aliases.add(c.modelCacheCreateTokens, "claude_model_cache_creation_tokens", nil, "model", "provider")
+	aliases.add(c.thinkingTokens, "claude_thinking_tokens", sampled, "model")
+	aliases.add(c.totalSessions, "claude_sessions", nil)
+	aliases.add(c.totalMessages, "claude_messages", nil)
+	aliases.add(c.toolUseTotal, "claude_live_tool_use", sampled, "tool")
+	aliases.add(c.toolErrors, "claude_tool_errors", sampled, "tool")
+	aliases.add(c.permissions, "claude_permission_requests", sampled, "tool", "decision")
+	aliases.add(c.stopReasonTotal, "claude_live_stop_reason", sampled, "model", "reason")
+	aliases.add(c.apiErrorsTotal, "claude_live_api_errors", nil)
+	aliases.add(c.apiRetriesTotal, "claude_live_api_retries", nil)
+	aliases.add(c.apiRetriesExhausted, "claude_api_retry_exhausted", nil)
+	aliases.add(c.usageLimitEvents, "claude_usage_limit_events", nil)
+	aliases.add(c.compactEventsTotal, "claude_live_compact_events", nil, "trigger")
+	aliases.add(c.webSearchTotal, "claude_live_web_search", sampled)
+	aliases.add(c.webFetchTotal, "claude_live_web_fetch", sampled)
+	aliases.add(c.malformedLines, "claude_exporter_malformed_lines", nil, "file")
+	aliases.add(c.modelSwitches, "claude_model_switches", nil, "from", "to")
+	aliases.add(c.turnInterruptions, "claude_turn_interruptions", nil)
+	c.loadState()
+	return c
+}
+
+// metrics lists every metric owned by the collector, in exposition order.
+func (c *Collector) metrics() []prometheus.Collector {
+	metrics := []prometheus.Collector{
+		c.modelInputTokens,
+		c.modelOutputTokens,
+		c.modelCacheReadTokens,
+		c.modelCacheCreateTokens,
+		c.cacheHitRatio,
+		c.cacheSavings,
+		c.liveInputTokens,
+		c.liveOutputTokens,
+		c.liveCost,
+		c.liveSessions,
+		c.liveFiles,
+		c.liveMessages,
+		c.thinkingTokens,
+		c.thinkingRatio,
+		c.totalSessions,
+		c.totalMessages,
+		c.todayMessages,
+		c.todaySessions,
+		c.todayToolCalls,
+		c.todayTokens,
+		c.dailyMessages,
+		c.dailySessions,
+		c.dailyToolCalls,
+		c.dailyTokens,
+		c.dailyToolUse,
+		c.dailyTokenKind,
+		c.dailyProject,
+		c.costPerMessage,
+		c.costPerSession,
+		c.weeklyTokens,
+		c.weeklyCost,
+		c.monthlyTokens,
+		c.monthlyCost,
+		c.hourActivity,
+		c.hourTokens,
+		c.hourCost,
+		c.teamCost,
+		c.windowTokens,
+		c.windowCost,
+		c.windowRemaining,
+		c.windowBurnRate,
+		c.requestsLast5m,
+		c.tokensLast5m,
+		c.costLastHour,
+		c.costAnomalyScore,
+		c.forecastEOD,
+		c.forecastEOM,
+		c.exporterInfo,
+		c.scanIncomplete,
+		c.degradedMode,
+		c.statLatency,
+		c.lastComputedTime,
+		c.firstSessionTime,
+		c.statsCacheAge,
+		c.statsSchema,
+		c.versionInfo,
+
+		c.turnDuration,
+		c.turnCost,
+		c.outputSpeed,
+		c.firstToken,
+		c.approvalWait,
+		c.turnActive,
+		c.contextSize,
+		c.sessionDuration,
+		c.sessionTurns,
+		c.roleMessages,
+		c.sessionIdle,
+		c.topSessionCost,
+		c.topProjectCost,
+		c.toolUseTotal,
+		c.toolErrors,
+		c.permissions,
+		c.stopReasonTotal,
+		c.maxTokensRate,
+		c.recentWindow,
+		c.recentSessions,
+		c.recentInput,
+		c.recentOutput,
+		c.recentCost,
+		c.recentToolUse,
+		c.recentStopReasons,
+		c.apiErrorsTotal,
+		c.apiRetriesTotal,
+		c.apiRetriesExhausted,
+		c.usageLimitEvents,
+		c.usageLimitReset,
+		c.apiRetryBackoff,
+		c.compactEventsTotal,
+		c.compactPreTokensTotal,
+		c.compactionsPerSession,
+		c.webSearchTotal,
+		c.webSearchCost,
+		c.webFetchTotal,
+		c.duplicateRecords,
+		c.overlapMessages,
+		c.oversizedLines,
+		c.recordBytes,
+		c.overBudgetFiles,
+		c.malformedLines,
+		c.stateEntries,
+		c.statePruned,
+		c.stateFileBytes,
+		c.modelSwitches,
+		c.turnInterruptions,
+	}
+	if c.summariesOnly {
+		metrics = slices.DeleteFunc(metrics, func(m prometheus.Collector) bool {
+			return m == c.turnDuration || m == c.compactPreTokensTotal
+		})
+	}
+	for _, name := range []string{"claude_turn_duration_seconds", "claude_compact_pre_tokens"} {
+		if s := c.summaries[name]; s != nil {
+			metrics = append(metrics, s)
+		}
+	}
+	if c.windowTokenLimit > 0 {
+		metrics = append(metrics, c.windowLimit, c.windowTimeToLimit)
+	}
+	for _, a := range c.agents {
+		metrics = append(metrics, a.metrics()...)
+	}
+	return metrics
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics() {
+		m.Describe(ch)
+	}
+	for _, d := range c.aliases {
+		ch <- d
+	}
+}
+
+// Collect updates the metrics and sends their values as of the end of an
+// update, so concurrent scrapes never see one half reset.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.Update()
+
+	if frozen := c.frozen.Load(); frozen != nil {
+		for _, m := range *frozen {
+			ch <- m
+		}
+	}
+}
+
+// frozenMetric is a metric whose value was read when it was frozen.
+type frozenMetric struct {
+	desc *prometheus.Desc
+	pb   *dto.Metric
+}
+
+func (m frozenMetric) Desc() *prometheus.Desc { return m.desc }
+
+func (m frozenMetric) Write(out *dto.Metric) error {
+	// the registry sorts the labels in place, so they aren't shared
+	out.Label = slices.Clone(m.pb.Label)
+	out.Gauge = m.pb.Gauge
+	out.Counter = m.pb.Counter
+	out.Summary = m.pb.Summary
+	out.Untyped = m.pb.Untyped
+	out.Histogram = m.pb.Histogram
+	out.TimestampMs = m.pb.TimestampMs
+	return nil
+}
+
+// freeze reads the current value of every metric and publishes them for
+// Collect. It runs at the end of each update, under updateMu.
+func (c *Collector) freeze() {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		for _, m := range c.metrics() {
+			m.Collect(ch)
+		}
+		close(ch)
+	}()
+	var persisted map[*prometheus.Desc]string
+	if c.stateFile != "" {
+		persisted = c.histogramDescs()
+	}
+	var frozen []prometheus.Metric
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			log.Printf("freeze %s: %v", m.Desc(), err)
+			continue
+		}
+		if name, ok := persisted[m.Desc()]; ok {
+			key := name
+			if labels := pb.GetLabel(); len(labels) > 0 {
+				key = vecStateKey(name, labels[0].GetValue())
+			}
+			c.restoreCreated(key, pb.Histogram)
+		}
+		frozen = append(frozen, frozenMetric{desc: m.Desc(), pb: pb})
+		if alias := c.aliases[m.Desc()]; alias != nil {
+			frozen = append(frozen, frozenMetric{desc: alias, pb: pb})
+		}
+	}
+	c.frozen.Store(&frozen)
+}
+
+// scan runs every registered source and merges their snapshots. A failing
+// source is logged and skipped so the others still report.
+func (c *Collector) scan(ctx context.Context) *source.Snapshot {
+	snap := &source.Snapshot{}
+	for i, src := range c.sources {
+		s, err := c.scanSource(ctx, i, src)
+		if err != nil {
+			log.Printf("%s: scan failed: %v", src.Name(), err)
+			continue
+		}
+		if s.Incomplete {
+			log.Printf("%s: scan exceeded SCAN_TIMEOUT, reporting partial results", src.Name())
+			// a part not read in time hasn't changed as far as we know;
+			// without it every metric it feeds would be reset. A stuck
+			// source returns no parts at all.
+			last := c.last[i]
+			if s.Stats == nil {
+				s.Stats = last.Stats
+			}
+			if s.Live == nil {
+				s.Live = last.Live
+			}
+			if s.Agents == nil {
+				s.Agents = last.Agents
+			}
+		}
+		if s.Stats != nil {
+			c.last[i].Stats = s.Stats
+		}
+		if s.Live != nil {
+			c.last[i].Live = s.Live
+		}
+		if s.Agents != nil {
+			c.last[i].Agents = s.Agents
+		}
+		snap.Merge(s)
+	}
+	return snap
+}
+
+// Update rescans all sources and refreshes every metric and the summary,
+// unless degraded mode sheds the scan. Collect calls it on every scrape;
+// concurrent calls run one at a time.
+func (c *Collector) Update() {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+	if c.shedScan() {
+		return
+	}
+	ctx := context.Background()
+	if c.scanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.scanTimeout)
+		defer cancel()
+	}
+	c.apply(c.scan(ctx), c.now())
+}
+
+// Apply refreshes every metric and the summary from a snapshot as of now,
+// as Update does after scanning with the time from Options.Now. Besides
+// its arguments it only depends on the persisted histogram state, so
+// goldens can pin its output.
+func (c *Collector) Apply(snap *source.Snapshot, now time.Time) {
+	c.updateMu.Lock()
+	defer c.updateMu.Unlock()
+	c.apply(snap, now)
+}
+
+func (c *Collector) apply(snap *source.Snapshot, now time.Time) {
+	defer c.freeze()
+	if snap.Incomplete {
+		c.scanIncomplete.Set(1)
+	} else {
+		c.scanIncomplete.Set(0)
+	}
+
+	for provider, a := range c.agents {
+		a.update(snap.Agents[provider], now)
+	}
+	c.updateClaude(snap, now)
+	c.updateState(now)
+}
+
+func (c *Collector) updateClaude(snap *source.Snapshot, now time.Time) {
+	// Reset vector metrics to avoid stale labels
+	c.modelInputTokens.Reset()
+	c.modelOutputTokens.Reset()
+	c.modelCacheReadTokens.Reset()
+	c.modelCacheCreateTokens.Reset()
+	c.cacheHitRatio.Reset()
+	c.cacheSavings.Reset()
+	c.liveInputTokens.Reset()
+	c.webSearchCost.Reset()
+	c.liveOutputTokens.Reset()
+	c.liveCost.Reset()
+	c.thinkingTokens.Reset()
+	c.thinkingRatio.Reset()
+	c.todayTokens.Reset()
+	c.dailyMessages.Reset()
+	c.dailySessions.Reset()
+	c.dailyToolCalls.Reset()
+	c.dailyTokens.Reset()
+	c.dailyToolUse.Reset()
+	c.dailyTokenKind.Reset()
+	c.dailyProject.Reset()
+	c.costPerMessage.Reset()
+	c.costPerSession.Reset()
+	c.weeklyTokens.Reset()
+	c.weeklyCost.Reset()
+	c.monthlyTokens.Reset()
+	c.monthlyCost.Reset()
+	c.hourActivity.Reset()
+	c.hourTokens.Reset()
+	c.hourCost.Reset()
+	c.teamCost.Reset()
+	c.exporterInfo.Reset()
+	c.versionInfo.Reset()
+	c.toolUseTotal.Reset()
+	c.toolErrors.Reset()
+	c.permissions.Reset()
+	c.stopReasonTotal.Reset()
+	c.maxTokensRate.Reset()
+	c.recentInput.Reset()
+	c.recentOutput.Reset()
+	c.recentCost.Reset()
+	c.recentToolUse.Reset()
+	c.recentStopReasons.Reset()
+	c.sessionIdle.Reset()
+	c.topSessionCost.Reset()
+	c.topProjectCost.Reset()
+	c.modelSwitches.Reset()
+	c.malformedLines.Reset()
+
+	stats := snap.Stats
+	if stats == nil {
+		return
+	}
+	live := snap.Live
+	if live == nil {
+		live = &source.LiveResult{
+			ModelUsage:    make(map[string]*source.LiveModelUsage),
+			ToolUseCounts: make(map[string]int),
+			StopReasons:   make(map[string]map[string]int),
+		}
+	}
+
+	today := now.UTC().Format("2006-01-02")
+
+	log.Printf("live sessions: %d, live messages: %d, api_errors: %d, compactions: %d",
+		live.SessionCount, live.MessageCount, live.APIErrors, live.CompactEvents)
+
+	c.limits.apply(stats, live, c.pricing, c.modelRules)
+
+	// Collect all models
+	allModels := make(map[string]struct{})
+	for m := range stats.ModelUsage {
+		allModels[m] = struct{}{}
+	}
+	for m := range live.ModelUsage {
+		allModels[m] = struct{}{}
+	}
+
+	// Model usage: cache + live
+	models := make(map[string]*ModelSummary, len(allModels))
+	for model := range allModels {
+		base := stats.ModelUsage[model]
+
+		lm := live.ModelUsage[model]
+		var liveIn, liveOut, liveCR, liveCC, liveCost, liveSearchCost float64
+		if lm != nil {
+			liveIn = lm.Input
+			liveOut = lm.Output
+			liveCR = lm.CacheRead
+			liveCC = lm.CacheCreate
+			liveCost = lm.Cost
+			liveSearchCost = lm.WebSearchCost
+		}
+		models[model] = &ModelSummary{
+			Input:       base.InputTokens + liveIn,
+			Output:      base.OutputTokens + liveOut,
+			CacheRead:   base.CacheReadInputTokens + liveCR,
+			CacheCreate: base.CacheCreationInputTokens + liveCC,
+			CostUSD:     usageCost(c.pricing, model, base) + liveCost,
+		}
+
+		provider := live.Providers[model]
+		c.modelInputTokens.WithLabelValues(model, provider).Set(base.InputTokens + liveIn)
+		c.modelOutputTokens.WithLabelValues(model, provider).Set(base.OutputTokens + liveOut)
+		c.modelCacheReadTokens.WithLabelValues(model, provider).Set(base.CacheReadInputTokens + liveCR)
+		c.modelCacheCreateTokens.WithLabelValues(model, provider).Set(base.CacheCreationInputTokens + liveCC)
+
+		if cacheRead, input := base.CacheReadInputTokens+liveCR, base.InputTokens+liveIn; cacheRead+input > 0 {
+			c.cacheHitRatio.WithLabelValues(model).Set(cacheRead / (cacheRead + input))
+			if p, ok := c.pricing.Lookup(model); ok {
+				// reads are discounted, but writes carry a premium over plain input
+				cacheCreate := base.CacheCreationInputTokens + liveCC
+				c.cacheSavings.WithLabelValues(model).Set((cacheRead*(p.Input-p.CacheRead) - cacheCreate*(p.CacheWrite-p.Input)) / 1e6)
+			}
+		}
+
+		if searchCost := base.WebSearchCostUSD + liveSearchCost; searchCost > 0 {
+			c.webSearchCost.WithLabelValues(model).Set(searchCost)
+		}
+
+		if lm != nil && lm.Thinking > 0 {
+			c.thinkingTokens.WithLabelValues(model).Set(lm.Thinking)
+			// thinking is billed as output; the rest is what the user sees
+			if visible := liveOut - lm.Thinking; visible > 0 {
+				c.thinkingRatio.WithLabelValues(model).Set(lm.Thinking / visible)
+			}
+		}
+	}
+
+	for class, byModel := range live.ClassUsage {
+		for model, u := range byModel {
+			provider := live.Providers[model]
+			c.liveInputTokens.WithLabelValues(model, provider, class.Mode, class.Purpose).Set(u.Input)
+			c.liveOutputTokens.WithLabelValues(model, provider, class.Mode, class.Purpose).Set(u.Output)
+			c.liveCost.WithLabelValues(model, class.Mode, class.Purpose).Set(u.Cost)
+		}
+	}
+
+	c.liveSessions.Set(float64(live.SessionCount))
+	for _, basis := range []string{source.LiveNewerThanCache, source.LiveInWindow, source.LiveNoStatsCache} {
+		c.liveFiles.WithLabelValues(basis).Set(float64(live.LiveFiles[basis]))
+	}
+	c.liveMessages.Set(float64(live.MessageCount))
+
+	// Totals
+	// sessions spanning the cache boundary are in both
+	c.totalSessions.Set(float64(stats.TotalSessions + live.SessionCount - live.OverlapSessions))
+	c.totalMessages.Set(float64(stats.TotalMessages + live.MessageCount))
+
+	// Daily activity (last 30)
+	start := 0
+	if len(stats.DailyActivity) > 30 {
+		start = len(stats.DailyActivity) - 30
+	}
+	for _, entry := range stats.DailyActivity[start:] {
+		c.dailyMessages.WithLabelValues(entry.Date).Set(float64(entry.MessageCount))
+		c.dailySessions.WithLabelValues(entry.Date).Set(float64(entry.SessionCount))
+		c.dailyToolCalls.WithLabelValues(entry.Date).Set(float64(entry.ToolCallCount))
+	}
+
+	// Today
+	var todayEntry *source.DailyActivity
+	for i := range stats.DailyActivity {
+		if stats.DailyActivity[i].Date == today {
+			todayEntry = &stats.DailyActivity[i]
+			break
+		}
+	}
+	if todayEntry != nil {
+		c.todayMessages.Set(float64(todayEntry.MessageCount + live.MessageCount))
+		c.todaySessions.Set(float64(todayEntry.SessionCount + live.SessionCount))
+		c.todayToolCalls.Set(float64(todayEntry.ToolCallCount))
+	} else {
+		c.todayMessages.Set(float64(live.MessageCount))
+		c.todaySessions.Set(float64(live.SessionCount))
+		c.todayToolCalls.Set(0)
+	}
+
+	// Daily model tokens (last 30)
+	start = 0
+	if len(stats.DailyModelTokens) > 30 {
+		start = len(stats.DailyModelTokens) - 30
+	}
+	for _, entry := range stats.DailyModelTokens[start:] {
+		for model, tokens := range entry.TokensByModel {
+			c.dailyTokens.WithLabelValues(entry.Date, model).Set(tokens)
+		}
+	}
+
+	// Today tokens
+	var todayTokenEntry *source.DailyModelTokens
+	for i := range stats.DailyModelTokens {
+		if stats.DailyModelTokens[i].Date == today {
+			todayTokenEntry = &stats.DailyModelTokens[i]
+			break
+		}
+	}
+	if todayTokenEntry != nil {
+		for model, tokens := range todayTokenEntry.TokensByModel {
+			liveTok := float64(0)
+			if lm, ok := live.ModelUsage[model]; ok {
+				liveTok = lm.Input
+			}
+			c.dailyTokens.WithLabelValues(today, model).Set(tokens + liveTok)
+			c.todayTokens.WithLabelValues(model).Set(tokens + liveTok)
+		}
+	} else {
+		for model, mu := range live.ModelUsage {
+			c.dailyTokens.WithLabelValues(today, model).Set(mu.Input)
+			c.todayTokens.WithLabelValues(model).Set(mu.Input)
+		}
+	}
+
+	// Weekly / monthly
+	days, weeks, months := c.aggregatePeriods(stats, live, today)
+	for _, week := range weeks.latest(periodWeeks) {
+		for model, u := range weeks[week] {
+			c.weeklyTokens.WithLabelValues(week, model).Set(u.Tokens)
+			c.weeklyCost.WithLabelValues(week, model).Set(u.Cost)
+		}
+	}
+	for _, month := range months.latest(periodMonths) {
+		for model, u := range months[month] {
+			c.monthlyTokens.WithLabelValues(month, model).Set(u.Tokens)
+			c.monthlyCost.WithLabelValues(month, model).Set(u.Cost)
+		}
+	}
+
+	c.summary.Store(buildSummary(stats, live, models, days, now))
+	history := dailySummaries(days, days.latest(len(days)))
+	c.history.Store(&history)
+
+	// Hour distribution
+	for hour, count := range stats.HourCounts {
+		h := hour
+		if len(h) == 1 {
+			h = "0" + h
+		}
+		c.hourActivity.WithLabelValues(h).Set(count)
+	}
+	for hour, byModel := range live.HourUsage {
+		cost := 0.0
+		for model, mu := range byModel {
+			c.hourTokens.WithLabelValues(hour, model).Set(mu.Input + mu.Output)
+			cost += mu.Cost
+		}
+		c.hourCost.WithLabelValues(hour).Set(cost)
+	}
+	for team, cost := range live.TeamCost {
+		c.teamCost.WithLabelValues(team).Set(cost)
+	}
+
+	// 5-hour window
+	c.updateWindow(live, now)
+	c.updateRecent(live, now)
+	hours := c.addHourlyCost(live.DailyUsage, now)
+	c.updateAnomaly(hours, now)
+	c.updateForecast(days, hours, now)
+	c.windowLimit.Set(c.windowTokenLimit)
+
+	// Info
+	c.exporterInfo.WithLabelValues(
+		c.statsFile,
+		c.claudeDir,
+		stats.LastComputedDate,
+		stats.FirstSessionDate,
+		strconv.Itoa(live.SessionCount),
+	).Set(1)
+	for version, n := range live.Versions {
+		c.versionInfo.WithLabelValues(version).Set(float64(n))
+	}
+	if t := stats.LastComputedIn(now.Location()); !t.IsZero() {
+		c.lastComputedTime.Set(float64(t.Unix()))
+	}
+	if t := stats.FirstSessionIn(now.Location()); !t.IsZero() {
+		c.firstSessionTime.Set(float64(t.Unix()))
+	}
+	if !stats.ModTime.IsZero() {
+		c.statsCacheAge.Set(now.Sub(stats.ModTime).Seconds())
+	}
+	c.statsSchema.Set(float64(stats.SchemaVersion))
+
+	// --- NEW: turn duration histogram ---
+	c.observe(c.turnDuration, "claude_turn_duration_seconds", nil, live.TurnDurations, 1/1000.0, now) // ms to seconds
+	c.observe(c.turnCost, "claude_turn_cost_usd", nil, live.TurnCosts, 1, now)
+	for model, obs := range live.OutputSpeeds {
+		c.observe(c.outputSpeed.WithLabelValues(model), "claude_output_tokens_per_second", prometheus.Labels{"model": model}, obs, 1, now)
+	}
+	for model, obs := range live.FirstTokenLatency {
+		c.observe(c.firstToken.WithLabelValues(model), "claude_time_to_first_token_seconds", prometheus.Labels{"model": model}, obs, 1, now)
+	}
+	c.observe(c.approvalWait, "claude_approval_wait_seconds", nil, live.ApprovalWaits, 1, now)
+	for model, obs := range live.RequestContexts {
+		c.observe(c.contextSize.WithLabelValues(model), "claude_request_context_tokens", prometheus.Labels{"model": model}, obs, 1, now)
+	}
+	c.observe(c.turnActive, "claude_turn_active_seconds", nil, live.TurnActiveDurations, 1/1000.0, now)
+
+	// session lifetime
+	c.observe(c.sessionDuration, "claude_session_duration_seconds", nil, live.SessionDurations, 1, now)
+	c.observe(c.sessionTurns, "claude_turns_per_session", nil, live.SessionTurns, 1, now)
+	for _, role := range []string{"user", "assistant"} {
+		c.roleMessages.WithLabelValues(role).Set(float64(live.RoleMessages[role]))
+	}
+	for _, sess := range live.Sessions {
+		if !sess.LastActivity.IsZero() {
+			c.sessionIdle.WithLabelValues(sess.ID, sess.Project).Set(now.Sub(sess.LastActivity).Seconds())
+		}
+	}
+
+	// --- NEW: tool usage breakdown ---
+	for tool, count := range live.ToolUseCounts {
+		c.toolUseTotal.WithLabelValues(tool).Set(float64(count))
+	}
+	for tool, count := range live.ToolErrors {
+		c.toolErrors.WithLabelValues(tool).Set(float64(count))
+	}
+	for p, count := range live.Permissions {
+		c.permissions.WithLabelValues(p.Tool, p.Decision).Set(float64(count))
+	}
+	daily := c.addToolUses(live.ToolUses, now)
+	totals := make(map[string]int)
+	for _, byTool := range daily {
+		for tool, n := range byTool {
+			totals[tool] += n
+		}
+	}
+	tools := c.limits.Tool.collapseMapping(totals)
+	for date, byTool := range daily {
+		for tool, n := range foldCounts(tools, byTool) {
+			c.dailyToolUse.WithLabelValues(date, tool).Set(float64(n))
+		}
+	}
+
+	// --- NEW: daily tokens by kind, cost by project ---
+	for date, byModel := range c.addDailyUsage(live.DailyUsage, now) {
+		for model, byKind := range byModel {
+			for kind, n := range byKind {
+				c.dailyTokenKind.WithLabelValues(date, model, kind).Set(n)
+			}
+		}
+	}
+	projectCost := c.addProjectCost(live.DailyUsage, now)
+	languages := c.addProjectLanguages(live.ProjectLanguages)
+	for date, byProject := range projectCost {
+		for project, cost := range byProject {
+			c.dailyProject.WithLabelValues(c.withLanguage(languages, date, project)...).Set(cost)
+		}
+	}
+	c.writeAudit(live.DailyUsage, now)
+
+	for model, a := range c.addModelActivity(live.DailyUsage, now) {
+		if a.Messages > 0 {
+			c.costPerMessage.WithLabelValues(model).Set(a.Cost / float64(a.Messages))
+		}
+		if len(a.Sessions) > 0 {
+			c.costPerSession.WithLabelValues(model).Set(a.Cost / float64(len(a.Sessions)))
+		}
+	}
+
+	// --- NEW: stop reason ---
+	for model, byReason := range live.StopReasons {
+		total := 0
+		for reason, count := range byReason {
+			c.stopReasonTotal.WithLabelValues(model, reason).Set(float64(count))
+			total += count
+		}
+		if total > 0 {
+			c.maxTokensRate.WithLabelValues(model).Set(float64(byReason["max_tokens"]) / float64(total))
+		}
+	}
+
+	// recent window
+	c.recentWindow.Set(live.RecentWindow.Seconds())
+	c.recentSessions.Set(float64(live.RecentSessions))
+	for model, mu := range live.RecentUsage {
+		c.recentInput.WithLabelValues(model).Set(mu.Input)
+		c.recentOutput.WithLabelValues(model).Set(mu.Output)
+		c.recentCost.WithLabelValues(model).Set(mu.Cost)
+	}
+	for tool, n := range live.RecentTools {
+		c.recentToolUse.WithLabelValues(tool).Set(float64(n))
+	}
+	for model, byReason := range live.RecentStopReasons {
+		for reason, n := range byReason {
+			c.recentStopReasons.WithLabelValues(model, reason).Set(float64(n))
+		}
+	}
+
+	// --- NEW: API errors ---
+	c.apiErrorsTotal.Set(float64(live.APIErrors))
+	c.apiRetriesTotal.Set(float64(live.APIRetries))
+	c.apiRetriesExhausted.Set(float64(live.RetriesExhausted))
+	c.observe(c.apiRetryBackoff, "claude_api_retry_backoff_seconds", nil, live.RetryDelays, 1/1000.0, now)
+	c.usageLimitEvents.Set(float64(live.UsageLimitEvents))
+	if live.UsageLimitReset.IsZero() {
+		c.usageLimitReset.Set(0)
+	} else {
+		c.usageLimitReset.Set(float64(live.UsageLimitReset.Unix()))
+	}
+
+	// --- NEW: context compaction ---
+	c.compactEventsTotal.Reset()
+	for trigger, n := range live.CompactTriggers {
+		c.compactEventsTotal.WithLabelValues(trigger).Set(float64(n))
+	}
+	for trigger, obs := range live.CompactPreTokens {
+		c.observe(c.compactPreTokensTotal.WithLabelValues(trigger), "claude_compact_pre_tokens", prometheus.Labels{"trigger": trigger}, obs, 1, now)
+	}
+	// a session counts for every model it used
+	modelSessions := make(map[string]int)
+	modelCompactions := make(map[string]int)
+	for _, sess := range live.Sessions {
+		for _, name := range sess.Models {
+			modelSessions[name]++
+			modelCompactions[name] += sess.Compactions
+		}
+	}
+	c.compactionsPerSession.Reset()
+	for name, n := range modelSessions {
+		c.compactionsPerSession.WithLabelValues(name).Set(float64(modelCompactions[name]) / float64(n))
+	}
+
+	// --- NEW: web search / fetch ---
+	c.webSearchTotal.Set(float64(live.WebSearches))
+	c.webFetchTotal.Set(float64(live.WebFetches))
+
+	c.duplicateRecords.Set(float64(live.DuplicateRecords))
+	c.overlapMessages.Set(float64(live.OverlapMessages))
+	c.oversizedLines.Set(float64(live.OversizedLines))
+	c.recordBytes.Set(float64(live.RecordBytes))
+	c.overBudgetFiles.Set(float64(live.OverBudgetFiles))
+	for file, n := range (LabelLimiter{Max: maxMalformedFiles}).collapseCounts(live.MalformedLines) {
+		c.malformedLines.WithLabelValues(file).Set(float64(n))
+	}
+
+	for sw, n := range live.ModelSwitches {
+		c.modelSwitches.WithLabelValues(sw.From, sw.To).Set(float64(n))
+	}
+	c.turnInterruptions.Set(float64(live.Interruptions))
+
+	sessions := buildSessions(live.Sessions)
+	c.sessions.Store(&sessions)
+	c.setTopCosts(sessions, languages)
+	if c.onUpdate != nil {
+		c.onUpdate(live)
+	}
+	c.lastUpdate.Store(now.UnixNano())
+
+	log.Printf("metrics updated (lastComputedDate=%s, live_sessions=%d)",
+		stats.LastComputedDate, live.SessionCount)
+}
+
+// setTopCosts ranks the topSessions most expensive sessions, and projects
+// by the cost of their sessions. Ties go to the smaller ID or name, so
+// ranks don't flap between scrapes.
+func (c *Collector) setTopCosts(sessions []SessionSummary, languages map[string]string) {
+	if c.topSessions <= 0 {
+		return
+	}
+	type ranked struct {
+		id, project string
+		cost        float64
+	}
+	byCost := func(a, b ranked) int {
+		if a.cost != b.cost {
+			if a.cost > b.cost {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.id, b.id)
+	}
+
+	var top []ranked
+	projects := make(map[string]float64)
+	for _, s := range sessions {
+		if s.CostUSD <= 0 {
+			continue
+		}
+		top = append(top, ranked{id: s.ID, project: s.Project, cost: s.CostUSD})
+		projects[s.Project] += s.CostUSD
+	}
+	slices.SortFunc(top, byCost)
+	for i, r := range top[:min(len(top), c.topSessions)] {
+		c.topSessionCost.WithLabelValues(strconv.Itoa(i+1), r.id, r.project).Set(r.cost)
+	}
+
+	top = top[:0]
+	for project, cost := range projects {
+		top = append(top, ranked{id: project, cost: cost})
+	}
+	slices.SortFunc(top, byCost)
+	for i, r := range top[:min(len(top), c.topSessions)] {
+		c.topProjectCost.WithLabelValues(c.withLanguage(languages, strconv.Itoa(i+1), r.id)...).Set(r.cost)
+	}
+}
+
+// projectLabels are the labels of a per-project cost metric, ending with
+// the project, plus language when a language map is set.
+func projectLabels(languages *source.LanguageMap, labels ...string) []string {
+	if languages != nil {
+		labels = append(labels, "language")
+	}
+	return labels
+}
+
+// withLanguage appends the language of the project, the last of values,
+// when the language label is on.
+func (c *Collector) withLanguage(languages map[string]string, values ...string) []string {
+	if !c.languages {
+		return values
+	}
+	lang := languages[values[len(values)-1]]
+	if lang == "" {
+		lang = "unknown"
+	}
+	return append(values, lang)
+}
@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+func TestApplyUsesOnlyItsClock(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	snap, prices := basicSnapshot(t, now)
+
+	var want string
+	for _, clock := range []func() time.Time{
+		func() time.Time { return now },
+		// far from now, so anything still reading it shows
+		func() time.Time { return now.AddDate(0, 1, 3).Add(7 * time.Hour) },
+	} {
+		c := NewCollector(Options{Pricing: prices, Sources: []source.Source{}, Now: clock})
+		c.Apply(snap, now)
+		got := frozenText(t, c)
+		if want == "" {
+			want = got
+			continue
+		}
+		if diff := diffLines(want, got); diff != "" {
+			t.Errorf("Apply depends on Options.Now:\n%s", diff)
+		}
+	}
+}
+
+// frozenOnly serves what the last update froze, without updating.
+type frozenOnly struct{ c *Collector }
+
+func (f frozenOnly) Describe(ch chan<- *prometheus.Desc) { f.c.Describe(ch) }
+
+func (f frozenOnly) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range *f.c.frozen.Load() {
+		ch <- m
+	}
+}
+
+// frozenText renders the frozen Claude metrics in the text format, leaving
+// out the exporter's own.
+func frozenText(t *testing.T, c *Collector) string {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(frozenOnly{c})
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, mf := range families {
+		if strings.HasPrefix(mf.GetName(), "claude_exporter_") {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+// diffLines lists the lines only in want (-) or only in got (+).
+func diffLines(want, got string) string {
+	count := make(map[string]int)
+	for _, l := range strings.Split(got, "\n") {
+		count[l]++
+	}
+	var out strings.Builder
+	for _, l := range strings.Split(want, "\n") {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		out.WriteString("- " + l + "\n")
+	}
+	for _, l := range strings.Split(got, "\n") {
+		if count[l] > 0 {
+			count[l]--
+			out.WriteString("+ " + l + "\n")
+		}
+	}
+	return out.String()
+}
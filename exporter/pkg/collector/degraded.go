@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// --- degraded mode ---
+
+// statSamples is how many scans' stat latencies decide degraded mode, so a
+// single slow stat doesn't switch it.
+const statSamples = 5
+
+// statProbeTimeout bounds a stat probe. A probe stuck past it, e.g. on a
+// hung network filesystem, counts as that slow and is left running; no
+// new probe starts until it returns.
+const statProbeTimeout = time.Second
+
+// statProbe tracks the stat latency of the Claude data. It is only used
+// under updateMu.
+type statProbe struct {
+	samples  []time.Duration
+	pending  chan time.Duration // a probe past statProbeTimeout
+	degraded bool
+	lastScan time.Time
+}
+
+// shedScan reports whether this update should skip its scan and keep the
+// last results: in degraded mode, scans run at most every
+// Options.DegradedInterval. Updates that do scan sample the stat latency
+// first, entering degraded mode while its median is over
+// Options.DegradedStatLatency and leaving once it is under half of that.
+func (c *Collector) shedScan() bool {
+	if c.degradedLatency <= 0 {
+		return false
+	}
+	p := &c.probe
+	now := c.now()
+	if p.degraded && c.Ready() && now.Sub(p.lastScan) < c.degradedInterval {
+		return true
+	}
+	p.lastScan = now
+
+	p.samples = append(p.samples, c.sampleStat())
+	if len(p.samples) > statSamples {
+		p.samples = p.samples[1:]
+	}
+	sorted := slices.Sorted(slices.Values(p.samples))
+	median := sorted[len(sorted)/2]
+	c.statLatency.Set(median.Seconds())
+	switch {
+	case !p.degraded && median > c.degradedLatency:
+		p.degraded = true
+		log.Printf("%s: stat latency %v is over %v, scanning every %v until it recovers (degraded mode)", c.claudeDir, median, c.degradedLatency, c.degradedInterval)
+	case p.degraded && median < c.degradedLatency/2:
+		p.degraded = false
+		log.Printf("%s: stat latency %v recovered, scanning on every update again", c.claudeDir, median)
+	}
+	if p.degraded {
+		c.degradedMode.Set(1)
+	} else {
+		c.degradedMode.Set(0)
+	}
+	return false
+}
+
+// sampleStat times a stat of the stats cache, the Claude dir and its
+// projects dir, and returns the slowest.
+func (c *Collector) sampleStat() time.Duration {
+	p := &c.probe
+	if p.pending != nil {
+		select {
+		case <-p.pending:
+			p.pending = nil
+		default:
+			return statProbeTimeout
+		}
+	}
+	paths := []string{c.statsFile}
+	if c.claudeDir != "" {
+		paths = append(paths, c.claudeDir, filepath.Join(c.claudeDir, "projects"))
+	}
+	ch := make(chan time.Duration, 1)
+	go func() {
+		var slowest time.Duration
+		for _, path := range paths {
+			start := time.Now()
+			os.Stat(path)
+			slowest = max(slowest, time.Since(start))
+		}
+		ch <- slowest
+	}()
+	timer := time.NewTimer(statProbeTimeout)
+	defer timer.Stop()
+	select {
+	case d := <-ch:
+		return d
+	case <-timer.C:
+		p.pending = ch
+		return statProbeTimeout
+	}
+}
@@ -0,0 +1,18 @@
+package collector
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// --- deprecated names ---
+
+// deprecatedAliases maps the desc of a gauge that lost its _total suffix,
+// since its value drops and isn't a counter, to a desc under the old name.
+// The old names are served with the same values for one more release.
+type deprecatedAliases map[*prometheus.Desc]*prometheus.Desc
+
+// add serves m, a gauge or gauge vector named name, also as name_total.
+func (a deprecatedAliases) add(m prometheus.Collector, name string, constLabels prometheus.Labels, labels ...string) {
+	ch := make(chan *prometheus.Desc, 1)
+	m.Describe(ch)
+	a[<-ch] = prometheus.NewDesc(name+"_total",
+		"Deprecated: renamed to "+name+", removed in the next release", labels, constLabels)
+}
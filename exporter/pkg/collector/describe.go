@@ -0,0 +1,58 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// --- metric descriptions ---
+
+// MetricInfo is the name, help text and variable labels of one metric.
+type MetricInfo struct {
+	Name      string
+	Help      string
+	Labels    []string
+	Histogram bool
+}
+
+var (
+	descNameRe   = regexp.MustCompile(`fqName: "([^"]+)"`)
+	descHelpRe   = regexp.MustCompile(`help: "((?:[^"\\]|\\.)*)"`)
+	descLabelsRe = regexp.MustCompile(`variableLabels: \{([^}]*)\}`)
+)
+
+// MetricInfos describes every metric the collector exposes, in exposition
+// order, without scanning any source.
+func (c *Collector) MetricInfos() []MetricInfo {
+	var metas []MetricInfo
+	for _, m := range c.metrics() {
+		ch := make(chan *prometheus.Desc, 1)
+		m.Describe(ch)
+		close(ch)
+		d := (<-ch).String()
+
+		meta := MetricInfo{}
+		if match := descNameRe.FindStringSubmatch(d); match != nil {
+			meta.Name = match[1]
+		}
+		if match := descHelpRe.FindStringSubmatch(d); match != nil {
+			meta.Help = match[1]
+		}
+		if match := descLabelsRe.FindStringSubmatch(d); match != nil && match[1] != "" {
+			for _, l := range strings.Split(match[1], ",") {
+				l = strings.TrimSuffix(strings.TrimPrefix(l, "c("), ")")
+				meta.Labels = append(meta.Labels, l)
+			}
+		}
+		switch m.(type) {
+		case prometheus.Histogram, *prometheus.HistogramVec:
+			meta.Histogram = true
+		case *prometheus.SummaryVec:
+			meta.Labels = append(meta.Labels, "quantile")
+		}
+		metas = append(metas, meta)
+	}
+	return metas
+}
@@ -0,0 +1,120 @@
+package collector
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// --- cost forecast ---
+
+// forecastWeeks is how many previous weeks of a weekday make up the
+// expected cost of that weekday.
+const forecastWeeks = 4
+
+// updateForecast projects the cost of today and of this month: what was
+// spent so far, plus the rest of today at the hourly pattern of the
+// previous days, plus each remaining day of the month at the average of
+// the same weekday over the previous forecastWeeks weeks.
+func (c *Collector) updateForecast(days periodTotals, hours map[string]float64, now time.Time) {
+	today := now.Format("2006-01-02")
+	// summed in a fixed order, so the same data always gives the same value
+	cost := func(date string) float64 {
+		total := 0.0
+		for _, model := range slices.Sorted(maps.Keys(days[date])) {
+			total += days[date][model].Cost
+		}
+		return total
+	}
+	// days before the first recorded one are unknown, not free
+	first := ""
+	for date := range days {
+		if first == "" || date < first {
+			first = date
+		}
+	}
+
+	var sum, n [7]float64
+	for d := 1; d <= 7*forecastWeeks; d++ {
+		t := now.AddDate(0, 0, -d)
+		if date := t.Format("2006-01-02"); first != "" && date >= first {
+			sum[t.Weekday()] += cost(date)
+			n[t.Weekday()]++
+		}
+	}
+	var allSum, allN float64
+	for wd := range sum {
+		allSum += sum[wd]
+		allN += n[wd]
+	}
+
+	spent := cost(today)
+	done := c.dayShareDone(hours, now)
+	// a weekday without history falls back to the average day, and with no
+	// history at all today's pace is all there is
+	pace := 0.0
+	if done > 0 {
+		pace = spent / done
+	}
+	expected := func(wd time.Weekday) float64 {
+		switch {
+		case n[wd] > 0:
+			return sum[wd] / n[wd]
+		case allN > 0:
+			return allSum / allN
+		}
+		return pace
+	}
+
+	eod := spent + (1-done)*expected(now.Weekday())
+	c.forecastEOD.Set(eod)
+
+	month := today[:7]
+	eom := eod
+	for _, date := range slices.Sorted(maps.Keys(days)) {
+		if date[:7] == month && date < today {
+			eom += cost(date)
+		}
+	}
+	for t := now.AddDate(0, 0, 1); t.Format("2006-01") == month; t = t.AddDate(0, 0, 1) {
+		eom += expected(t.Weekday())
+	}
+	c.forecastEOM.Set(eom)
+}
+
+// dayShareDone estimates the share of a day's cost spent by now's time of
+// day, from the hourly costs of the previous days. Until enough of them
+// are recorded, or when they cost nothing, the day is taken as uniform.
+func (c *Collector) dayShareDone(hours map[string]float64, now time.Time) float64 {
+	first := ""
+	for hour := range hours {
+		if first == "" || hour < first {
+			first = hour
+		}
+	}
+	elapsed := float64(now.Minute())/60 + float64(now.Second())/3600
+	var before, total float64
+	days := 0
+	for d := 1; d <= anomalyBaselineDays; d++ {
+		day := now.AddDate(0, 0, -d)
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, now.Location())
+		if first == "" || hourKey(start) < first {
+			continue
+		}
+		days++
+		for h := 0; h < 24; h++ {
+			v := hours[hourKey(start.Add(time.Duration(h)*time.Hour))]
+			total += v
+			switch {
+			case h < now.Hour():
+				before += v
+			case h == now.Hour():
+				before += v * elapsed
+			}
+		}
+	}
+	if days < anomalyMinDays || total == 0 {
+		return (float64(now.Hour()) + elapsed) / 24
+	}
+	return before / total
+}
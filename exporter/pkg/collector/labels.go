@@ -0,0 +1,318 @@
+package collector
+
+import (
+	"log"
+	"path"
+	"slices"
+	"sort"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- label cardinality controls ---
+
+const otherLabel = "other"
+
+// LabelLimiter restricts the values of one label. Values that are denied,
+// not allowed, or outside the top Max by weight are folded into "other".
+// Allow and Deny hold path.Match patterns; Max 0 means unlimited.
+type LabelLimiter struct {
+	Allow []string
+	Deny  []string
+	Max   int
+}
+
+func matchAny(patterns []string, v string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, v); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (l LabelLimiter) permitted(v string) bool {
+	if matchAny(l.Deny, v) {
+		return false
+	}
+	return len(l.Allow) == 0 || matchAny(l.Allow, v)
+}
+
+// mapping returns the label value to use for each raw value, keeping the
+// heaviest values when more than max are present.
+func (l LabelLimiter) mapping(weights map[string]float64) map[string]string {
+	m := make(map[string]string, len(weights))
+	var kept []string
+	for v := range weights {
+		if l.permitted(v) {
+			kept = append(kept, v)
+		} else {
+			m[v] = otherLabel
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if weights[kept[i]] != weights[kept[j]] {
+			return weights[kept[i]] > weights[kept[j]]
+		}
+		return kept[i] < kept[j]
+	})
+	limit := len(kept)
+	if l.Max > 0 && len(kept) > l.Max {
+		// reserve one slot for "other"
+		limit = l.Max - 1
+	}
+	for i, v := range kept {
+		if i < limit {
+			m[v] = v
+		} else {
+			m[v] = otherLabel
+		}
+	}
+	return m
+}
+
+// foldUsage merges usage of models folded into the same label value.
+func foldUsage(models map[string]string, usage map[string]*source.LiveModelUsage) map[string]*source.LiveModelUsage {
+	out := make(map[string]*source.LiveModelUsage, len(usage))
+	for model, mu := range usage {
+		to := fold(models, model)
+		merged, ok := out[to]
+		if !ok {
+			merged = &source.LiveModelUsage{}
+			out[to] = merged
+		}
+		merged.Add(mu)
+	}
+	return out
+}
+
+// fold maps a value through mapping, folding values it has not ranked.
+func fold(mapping map[string]string, v string) string {
+	if to, ok := mapping[v]; ok {
+		return to
+	}
+	return otherLabel
+}
+
+// collapseMapping ranks values by count.
+func (l LabelLimiter) collapseMapping(counts map[string]int) map[string]string {
+	weights := make(map[string]float64, len(counts))
+	for k, v := range counts {
+		weights[k] = float64(v)
+	}
+	return l.mapping(weights)
+}
+
+func (l LabelLimiter) collapseCounts(counts map[string]int) map[string]int {
+	return foldCounts(l.collapseMapping(counts), counts)
+}
+
+// foldCounts sums counts by their value in mapping.
+func foldCounts(mapping map[string]string, counts map[string]int) map[string]int {
+	out := make(map[string]int, len(counts))
+	for k, v := range counts {
+		out[fold(mapping, k)] += v
+	}
+	return out
+}
+
+// LabelLimits holds the limiter for each bounded label.
+type LabelLimits struct {
+	Model      LabelLimiter
+	Tool       LabelLimiter
+	StopReason LabelLimiter
+}
+
+// apply renames models by rules and folds long-tail model, tool and stop
+// reason values in place, before any metric is set, so every metric family
+// agrees on the label values. Costs are priced per original model before
+// folding.
+func (l LabelLimits) apply(stats *source.StatsCache, live *source.LiveResult, pricing *pricing.Table, rules *model.Rules) {
+	renamed := make(map[string]string) // original model -> name after rules
+	rename := func(name string) string {
+		to, ok := renamed[name]
+		if !ok {
+			to = rules.Apply(name)
+			renamed[name] = to
+		}
+		return to
+	}
+
+	weights := make(map[string]float64)
+	for model, u := range stats.ModelUsage {
+		weights[rename(model)] += u.InputTokens + u.OutputTokens
+	}
+	for _, entry := range stats.DailyModelTokens {
+		for model, n := range entry.TokensByModel {
+			if _, ok := weights[rename(model)]; !ok {
+				// models only seen in daily history rank by those tokens
+				weights[rename(model)] = n
+			}
+		}
+	}
+	for model, mu := range live.ModelUsage {
+		weights[rename(model)] += mu.Input + mu.Output
+	}
+	for model, mu := range live.RecentUsage {
+		if _, ok := weights[rename(model)]; !ok {
+			// models only seen in the recent window rank by those tokens
+			weights[rename(model)] = mu.Input + mu.Output
+		}
+	}
+	limited := l.Model.mapping(weights)
+	models := make(map[string]string, len(renamed))
+	for from, to := range renamed {
+		models[from] = limited[to]
+	}
+
+	collapsed := 0
+	for _, to := range limited {
+		if to == otherLabel {
+			collapsed++
+		}
+	}
+	if collapsed > 0 {
+		log.Printf("label limits: folded %d model(s) into %q", collapsed, otherLabel)
+	}
+
+	usage := make(map[string]source.ModelUsage, len(stats.ModelUsage))
+	for name, u := range stats.ModelUsage {
+		to := models[name]
+		merged := usage[to]
+		merged.CostUSD += usageCost(pricing, name, u)
+		merged.InputTokens += u.InputTokens
+		merged.OutputTokens += u.OutputTokens
+		merged.CacheReadInputTokens += u.CacheReadInputTokens
+		merged.CacheCreationInputTokens += u.CacheCreationInputTokens
+		merged.WebSearchRequests += u.WebSearchRequests
+		merged.WebSearchCostUSD += pricing.WebSearchCost(name, u.WebSearchRequests)
+		usage[to] = merged
+	}
+	stats.ModelUsage = usage
+
+	for i, entry := range stats.DailyModelTokens {
+		tokens := make(map[string]float64, len(entry.TokensByModel))
+		for name, n := range entry.TokensByModel {
+			tokens[models[name]] += n
+		}
+		stats.DailyModelTokens[i].TokensByModel = tokens
+	}
+
+	providers := make(map[string]string, len(models))
+	for from, to := range models {
+		providers[to] = model.MergeProvider(providers[to], stats.Providers[from])
+		providers[to] = model.MergeProvider(providers[to], live.Providers[from])
+	}
+	for to, p := range providers {
+		if p == "" {
+			providers[to] = model.ProviderAnthropic
+		}
+	}
+	stats.Providers, live.Providers = providers, providers
+
+	live.ModelUsage = foldUsage(models, live.ModelUsage)
+	for hour, byModel := range live.HourUsage {
+		live.HourUsage[hour] = foldUsage(models, byModel)
+	}
+	for class, byModel := range live.ClassUsage {
+		live.ClassUsage[class] = foldUsage(models, byModel)
+	}
+	live.RecentUsage = foldUsage(models, live.RecentUsage)
+
+	switches := make(map[source.ModelSwitch]int, len(live.ModelSwitches))
+	for sw, n := range live.ModelSwitches {
+		switches[source.ModelSwitch{From: fold(models, sw.From), To: fold(models, sw.To)}] += n
+	}
+	live.ModelSwitches = switches
+
+	speeds := make(map[string][]source.Observation, len(live.OutputSpeeds))
+	for model, obs := range live.OutputSpeeds {
+		to := fold(models, model)
+		speeds[to] = append(speeds[to], obs...)
+	}
+	live.OutputSpeeds = speeds
+
+	latency := make(map[string][]source.Observation, len(live.FirstTokenLatency))
+	for model, obs := range live.FirstTokenLatency {
+		to := fold(models, model)
+		latency[to] = append(latency[to], obs...)
+	}
+	live.FirstTokenLatency = latency
+
+	contexts := make(map[string][]source.Observation, len(live.RequestContexts))
+	for model, obs := range live.RequestContexts {
+		to := fold(models, model)
+		contexts[to] = append(contexts[to], obs...)
+	}
+	live.RequestContexts = contexts
+	for i := range live.DailyUsage {
+		live.DailyUsage[i].Model = fold(models, live.DailyUsage[i].Model)
+	}
+
+	// the recent window ranks along, so its tools fold the same way
+	toolCounts := make(map[string]int, len(live.ToolUseCounts))
+	for tool, n := range live.ToolUseCounts {
+		toolCounts[tool] += n
+	}
+	for tool, n := range live.RecentTools {
+		toolCounts[tool] += n
+	}
+	tools := l.Tool.collapseMapping(toolCounts)
+	live.ToolUseCounts = foldCounts(tools, live.ToolUseCounts)
+	live.RecentTools = foldCounts(tools, live.RecentTools)
+	live.ToolErrors = foldCounts(tools, live.ToolErrors)
+	permissions := make(map[source.Permission]int, len(live.Permissions))
+	for p, n := range live.Permissions {
+		permissions[source.Permission{Tool: fold(tools, p.Tool), Decision: p.Decision}] += n
+	}
+	live.Permissions = permissions
+
+	// rank stop reasons across models, then fold both labels
+	reasonCounts := make(map[string]int)
+	for _, stops := range []map[string]map[string]int{live.StopReasons, live.RecentStopReasons} {
+		for _, byReason := range stops {
+			for reason, n := range byReason {
+				reasonCounts[reason] += n
+			}
+		}
+	}
+	reasons := l.StopReason.collapseMapping(reasonCounts)
+	foldStops := func(in map[string]map[string]int) map[string]map[string]int {
+		stops := make(map[string]map[string]int, len(in))
+		for model, byReason := range in {
+			to := fold(models, model)
+			if stops[to] == nil {
+				stops[to] = make(map[string]int)
+			}
+			for reason, n := range byReason {
+				stops[to][fold(reasons, reason)] += n
+			}
+		}
+		return stops
+	}
+	live.StopReasons = foldStops(live.StopReasons)
+	live.RecentStopReasons = foldStops(live.RecentStopReasons)
+
+	for _, sess := range live.Sessions {
+		var names []string
+		for _, name := range sess.Models {
+			if name = rules.Apply(name); !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		sess.Models = names
+		if len(sess.Usage) > 0 {
+			usage := make(map[string]*source.LiveModelUsage, len(sess.Usage))
+			for name, mu := range sess.Usage {
+				name = rules.Apply(name)
+				if usage[name] == nil {
+					usage[name] = &source.LiveModelUsage{}
+				}
+				usage[name].Add(mu)
+			}
+			sess.Usage = usage
+		}
+	}
+}
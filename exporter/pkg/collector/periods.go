@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- weekly / monthly aggregation ---
+
+const (
+	periodWeeks  = 12
+	periodMonths = 12
+)
+
+type periodUsage struct {
+	Tokens float64
+	Cost   float64
+}
+
+// periodTotals maps period label -> model -> usage.
+type periodTotals map[string]map[string]*periodUsage
+
+func (p periodTotals) add(period, model string, tokens, cost float64) {
+	byModel, ok := p[period]
+	if !ok {
+		byModel = make(map[string]*periodUsage)
+		p[period] = byModel
+	}
+	u, ok := byModel[model]
+	if !ok {
+		u = &periodUsage{}
+		byModel[model] = u
+	}
+	u.Tokens += tokens
+	u.Cost += cost
+}
+
+// latest returns the n most recent period labels in ascending order.
+// Labels sort lexically in chronological order.
+func (p periodTotals) latest(n int) []string {
+	labels := make([]string, 0, len(p))
+	for k := range p {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+	if len(labels) > n {
+		labels = labels[len(labels)-n:]
+	}
+	return labels
+}
+
+// isoWeek returns the ISO-8601 week label (e.g. "2025-W07") for a YYYY-MM-DD date.
+func isoWeek(date string) (string, bool) {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", false
+	}
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week), true
+}
+
+// aggregatePeriods sums cached daily tokens plus today's live usage into
+// day, ISO-week and calendar-month buckets.
+func (c *Collector) aggregatePeriods(stats *source.StatsCache, live *source.LiveResult, today string) (days, weeks, months periodTotals) {
+	days = make(periodTotals)
+	weeks = make(periodTotals)
+	months = make(periodTotals)
+
+	add := func(date, model string, tokens, cost float64) {
+		week, ok := isoWeek(date)
+		if !ok {
+			return
+		}
+		days.add(date, model, tokens, cost)
+		weeks.add(week, model, tokens, cost)
+		months.add(date[:7], model, tokens, cost)
+	}
+
+	for _, entry := range stats.DailyModelTokens {
+		for model, tokens := range entry.TokensByModel {
+			add(entry.Date, model, tokens, tokens*blendedRate(c.pricing, model, stats.ModelUsage[model]))
+		}
+	}
+	for model, mu := range live.ModelUsage {
+		add(today, model, mu.Input+mu.Output, mu.Cost)
+	}
+	return days, weeks, months
+}
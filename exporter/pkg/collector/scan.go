@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- scan timeout ---
+
+// scanGrace is how long past the scan deadline a source gets to return
+// what it has read before the collector stops waiting for it.
+const scanGrace = time.Second
+
+type scanResult struct {
+	snap *source.Snapshot
+	err  error
+}
+
+// scanSource runs the i-th source's Scan with ctx, which carries the
+// Options.ScanTimeout deadline. A scan stuck past it, e.g. on a hung
+// network filesystem, is left running and reported as incomplete, and the
+// source's last results stand in for it until that scan returns.
+func (c *Collector) scanSource(ctx context.Context, i int, src source.Source) (*source.Snapshot, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return src.Scan(ctx)
+	}
+
+	c.scanMu.Lock()
+	stuck := c.stuckScans[i] > 0
+	c.scanMu.Unlock()
+	if stuck {
+		return &source.Snapshot{Incomplete: true}, nil
+	}
+
+	ch := make(chan scanResult, 1)
+	abandoned := false
+	go func() {
+		snap, err := src.Scan(ctx)
+		ch <- scanResult{snap, err}
+		c.scanMu.Lock()
+		if abandoned {
+			c.stuckScans[i]--
+		}
+		c.scanMu.Unlock()
+	}()
+
+	timer := time.NewTimer(time.Until(deadline) + scanGrace)
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r.snap, r.err
+	case <-timer.C:
+	}
+
+	c.scanMu.Lock()
+	defer c.scanMu.Unlock()
+	select {
+	case r := <-ch: // returned just now
+		return r.snap, r.err
+	default:
+	}
+	abandoned = true
+	c.stuckScans[i]++
+	return &source.Snapshot{Incomplete: true}, nil
+}
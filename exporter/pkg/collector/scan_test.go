@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// replaySource returns its snapshots in turn, the last one from then on.
+type replaySource struct {
+	snaps []*source.Snapshot
+}
+
+func (r *replaySource) Name() string { return "replay" }
+
+func (r *replaySource) Scan(context.Context) (*source.Snapshot, error) {
+	snap := r.snaps[0]
+	if len(r.snaps) > 1 {
+		r.snaps = r.snaps[1:]
+	}
+	return snap, nil
+}
+
+func TestIncompleteScanKeepsStats(t *testing.T) {
+	dir := copyClaudeDir(t, "basic")
+	stats, err := source.NewStatsCache(filepath.Join(dir, "stats-cache.json")).Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := NewCollector(Options{
+		StatsFile: filepath.Join(dir, "stats-cache.json"),
+		ClaudeDir: dir,
+		Pricing:   prices,
+		Sources: []source.Source{&replaySource{snaps: []*source.Snapshot{
+			stats,
+			// the stats cache wasn't read before SCAN_TIMEOUT
+			{Incomplete: true},
+		}}},
+		Now: func() time.Time { return now },
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	// every gather scans once
+	want := gaugeValues(t, reg, "claude_model_input_tokens")
+	if len(want) == 0 {
+		t.Fatal("the fixture's stats cache has no model usage")
+	}
+	got := gaugeValues(t, reg, "claude_model_input_tokens")
+	for model, w := range want {
+		if got[model] != w {
+			t.Errorf("claude_model_input_tokens%s = %v after an incomplete scan, want %v", model, got[model], w)
+		}
+	}
+}
+
+// stuckSource returns its snapshot once, then blocks in Scan until
+// release is closed.
+type stuckSource struct {
+	snap    *source.Snapshot
+	release chan struct{}
+	scans   int
+}
+
+func (s *stuckSource) Name() string { return "stuck" }
+
+func (s *stuckSource) Scan(context.Context) (*source.Snapshot, error) {
+	s.scans++
+	if s.scans > 1 {
+		<-s.release
+	}
+	return s.snap, nil
+}
+
+func TestStuckSourceKeepsLive(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	snap, prices := basicSnapshot(t, now)
+	stuck := &stuckSource{snap: snap, release: make(chan struct{})}
+	defer close(stuck.release)
+	c := NewCollector(Options{
+		Pricing:     prices,
+		Sources:     []source.Source{stuck},
+		ScanTimeout: 10 * time.Millisecond,
+		Now:         func() time.Time { return now },
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	want := gaugeValues(t, reg, "claude_live_input_tokens")
+	if len(want) == 0 {
+		t.Fatal("the fixture has no live usage")
+	}
+	// the first gather abandons the stuck scan, later ones skip the source
+	for i := 0; i < 2; i++ {
+		got := gaugeValues(t, reg, "claude_live_input_tokens")
+		if len(got) != len(want) {
+			t.Errorf("gather %d: %d claude_live_input_tokens series while the source is stuck, want %d", i, len(got), len(want))
+		}
+		for labels, w := range want {
+			if got[labels] != w {
+				t.Errorf("gather %d: claude_live_input_tokens%s = %v while the source is stuck, want %v", i, labels, got[labels], w)
+			}
+		}
+		if v := gaugeValues(t, reg, "claude_exporter_scan_incomplete")["{}"]; v != 1 {
+			t.Errorf("gather %d: claude_exporter_scan_incomplete = %v, want 1", i, v)
+		}
+	}
+}
+
+// basicSnapshot scans the basic golden case as of now, with its session
+// files written just then and its stats cache a day earlier.
+func basicSnapshot(t *testing.T, now time.Time) (*source.Snapshot, *pricing.Table) {
+	t.Helper()
+	dir := copyClaudeDir(t, "basic")
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		mtime := now
+		if d.Name() == "stats-cache.json" {
+			mtime = now.Add(-24 * time.Hour)
+		}
+		return os.Chtimes(path, mtime, mtime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statsFile := filepath.Join(dir, "stats-cache.json")
+	snap, err := source.NewStatsCache(statsFile).Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := source.NewClaudeSessions(source.ClaudeSessionsOptions{
+		ClaudeDir: dir,
+		StatsFile: statsFile,
+		Pricing:   prices,
+		Now:       func() time.Time { return now },
+	}).Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap.Merge(live)
+	return snap, prices
+}
+
+func gaugeValues(t *testing.T, reg *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]float64)
+	for _, mf := range families {
+		if mf.GetName() == name {
+			for _, m := range mf.GetMetric() {
+				out[labelString(m)] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,740 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- histogram state ---
+
+// defaultStateRetention is how long an observed record, and a histogram
+// sample kept for the state file, is remembered unless
+// Options.StateRetention is set. Records only reappear when a session file
+// is rescanned, e.g. after a resume.
+const defaultStateRetention = 30 * 24 * time.Hour
+
+// stateCompactInterval is how often updates prune the state between saves.
+const stateCompactInterval = time.Hour
+
+// histogramState tracks which records have been observed, so each scrape
+// only adds new samples, and the samples themselves, so histograms can be
+// rebuilt after a restart without a counter reset.
+type histogramState struct {
+	mu       sync.Mutex
+	observed map[string]time.Time
+	values   map[string][]float64
+	// unix seconds each value was observed, parallel to values
+	valueTimes map[string][]int64
+	// when each histogram series was created, kept across restarts, and
+	// when the histogram reported it created in this process, see
+	// restoreCreated
+	created     map[string]time.Time
+	liveCreated map[string]time.Time
+	// last compaction
+	compacted time.Time
+	// tool uses by date, then tool, see addToolUses
+	dailyTools map[string]map[string]int
+	// tokens by date, model, then kind, see addDailyUsage
+	dailyTokens map[string]map[string]map[string]float64
+	// cost by local hour ("2006-01-02 15"), see addHourlyCost
+	hourlyCost map[string]float64
+	// cost by date, then project, see addProjectCost
+	dailyProjectCost map[string]map[string]float64
+	// language by project, see addProjectLanguages
+	projectLanguages map[string]string
+	// requests, cost and sessions by date, then model, see addModelActivity
+	dailyModelActivity map[string]map[string]*modelActivity
+}
+
+func (s *histogramState) init() {
+	if s.observed == nil {
+		s.observed = make(map[string]time.Time)
+	}
+	if s.values == nil {
+		s.values = make(map[string][]float64)
+	}
+	if s.valueTimes == nil {
+		s.valueTimes = make(map[string][]int64)
+	}
+	if s.created == nil {
+		s.created = make(map[string]time.Time)
+	}
+	if s.liveCreated == nil {
+		s.liveCreated = make(map[string]time.Time)
+	}
+	if s.dailyTools == nil {
+		s.dailyTools = make(map[string]map[string]int)
+	}
+	if s.dailyTokens == nil {
+		s.dailyTokens = make(map[string]map[string]map[string]float64)
+	}
+	if s.hourlyCost == nil {
+		s.hourlyCost = make(map[string]float64)
+	}
+	if s.dailyProjectCost == nil {
+		s.dailyProjectCost = make(map[string]map[string]float64)
+	}
+	if s.projectLanguages == nil {
+		s.projectLanguages = make(map[string]string)
+	}
+	if s.dailyModelActivity == nil {
+		s.dailyModelActivity = make(map[string]map[string]*modelActivity)
+	}
+}
+
+// savedState is the on-disk form of histogramState.
+type savedState struct {
+	Version  int                  `json:"version"`
+	SavedAt  time.Time            `json:"saved_at"`
+	Observed map[string]time.Time `json:"observed"`
+	Values   map[string][]float64 `json:"values"`
+	// unix seconds, parallel to Values; missing in older files
+	ValueTimes map[string][]int64 `json:"value_times,omitempty"`
+	// creation time of each histogram series; missing in older files
+	Created    map[string]time.Time      `json:"created,omitempty"`
+	DailyTools map[string]map[string]int `json:"daily_tools,omitempty"`
+	// date -> model -> kind -> tokens
+	DailyTokens map[string]map[string]map[string]float64 `json:"daily_tokens,omitempty"`
+	HourlyCost  map[string]float64                       `json:"hourly_cost,omitempty"`
+	// date -> project -> USD
+	DailyProjectCost map[string]map[string]float64 `json:"daily_project_cost,omitempty"`
+	ProjectLanguages map[string]string             `json:"project_languages,omitempty"`
+	// date -> model -> activity
+	DailyModelActivity map[string]map[string]*modelActivity `json:"daily_model_activity,omitempty"`
+}
+
+const stateVersion = 1
+
+// observe adds the samples not seen before to h, the series of histogram
+// name with the given labels (at most one, see vecStateKey), scaled by
+// scale.
+func (c *Collector) observe(h prometheus.Observer, name string, labels prometheus.Labels, obs []source.Observation, scale float64, now time.Time) {
+	key := name
+	for _, v := range labels {
+		key = vecStateKey(name, v)
+	}
+
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for _, o := range obs {
+		if !c.firstSight(o.ID, o.Time, now) {
+			continue
+		}
+		v := o.Value * scale
+		if e, ok := h.(prometheus.ExemplarObserver); ok && o.Session != "" {
+			e.ObserveWithExemplar(v, exemplarLabels(o))
+		} else {
+			h.Observe(v)
+		}
+		if sv := c.summaries[name]; sv != nil {
+			sv.With(labels).Observe(v)
+		}
+		if c.stateFile != "" {
+			s.values[key] = append(s.values[key], v)
+			s.valueTimes[key] = append(s.valueTimes[key], now.Unix())
+		}
+		if c.onObserve != nil {
+			c.onObserve(name, labels, v)
+		}
+	}
+}
+
+// maxExemplarRunes is the OpenMetrics limit on exemplar label names and
+// values combined.
+const maxExemplarRunes = 128
+
+// exemplarLabels links a sample to its session file. Long project names are shortened from the left,
+// keeping the distinctive end of the path.
+func exemplarLabels(o source.Observation) prometheus.Labels {
+	budget := maxExemplarRunes - len("session_id") - len("project")
+	session := []rune(o.Session)
+	if len(session) > budget {
+		session = session[:budget]
+	}
+	budget -= len(session)
+	project := []rune(o.Project)
+	if len(project) > budget {
+		project = project[len(project)-budget:]
+	}
+	return prometheus.Labels{"session_id": string(session), "project": string(project)}
+}
+
+// histograms maps the persisted histogram names to their metrics.
+func (c *Collector) histograms() map[string]prometheus.Histogram {
+	return map[string]prometheus.Histogram{
+		"claude_turn_duration_seconds":     c.turnDuration,
+		"claude_turn_cost_usd":             c.turnCost,
+		"claude_approval_wait_seconds":     c.approvalWait,
+		"claude_turn_active_seconds":       c.turnActive,
+		"claude_session_duration_seconds":  c.sessionDuration,
+		"claude_turns_per_session":         c.sessionTurns,
+		"claude_api_retry_backoff_seconds": c.apiRetryBackoff,
+	}
+}
+
+// histogramVecs maps the persisted histogram vectors to their metrics.
+// Their samples are stored per label value, see vecStateKey.
+func (c *Collector) histogramVecs() map[string]*prometheus.HistogramVec {
+	return map[string]*prometheus.HistogramVec{
+		"claude_output_tokens_per_second":    c.outputSpeed,
+		"claude_time_to_first_token_seconds": c.firstToken,
+		"claude_request_context_tokens":      c.contextSize,
+		"claude_compact_pre_tokens":          c.compactPreTokensTotal,
+	}
+}
+
+// vecStateKey is the state file key of one series of a single-label
+// histogram vector.
+func vecStateKey(name, label string) string {
+	return name + "/" + label
+}
+
+// histogramDescs maps the descriptors of the persisted histograms and
+// histogram vectors to their names.
+func (c *Collector) histogramDescs() map[*prometheus.Desc]string {
+	out := make(map[*prometheus.Desc]string)
+	for name, h := range c.histograms() {
+		out[h.Desc()] = name
+	}
+	for name, vec := range c.histogramVecs() {
+		ch := make(chan *prometheus.Desc, 1)
+		vec.Describe(ch)
+		out[<-ch] = name
+	}
+	return out
+}
+
+// restoreCreated sets the created timestamp of the histogram series key to
+// when it was first created, before any restart, so its restored samples
+// don't read as a counter reset. A series the histogram resets itself, as
+// native histograms do when they run out of buckets, is created anew.
+func (c *Collector) restoreCreated(key string, h *dto.Histogram) {
+	if h.GetCreatedTimestamp() == nil {
+		return
+	}
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	live := h.GetCreatedTimestamp().AsTime()
+	prev, seen := s.liveCreated[key]
+	if _, restored := s.created[key]; !restored || (seen && !prev.Equal(live)) {
+		s.created[key] = live
+	}
+	s.liveCreated[key] = live
+	h.CreatedTimestamp = timestamppb.New(s.created[key])
+}
+
+// --- daily tool use ---
+
+// dailyToolDays is how many days of tool use, tokens by kind and project
+// cost the exporter keeps. The stats cache has no such history, and live
+// sessions drop out of the scan once the cache covers them, so the
+// exporter aggregates its own.
+const dailyToolDays = 30
+
+// addToolUses counts the tool uses not seen before under their date and
+// returns the counts of the last dailyToolDays days.
+func (c *Collector) addToolUses(uses []source.ToolUse, now time.Time) map[string]map[string]int {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for _, u := range uses {
+		if !c.firstSight(u.ID, dateTime(u.Date, 0, now.Location()), now) {
+			continue
+		}
+		byTool, ok := s.dailyTools[u.Date]
+		if !ok {
+			byTool = make(map[string]int)
+			s.dailyTools[u.Date] = byTool
+		}
+		byTool[u.Tool] += max(u.Count, 1)
+	}
+
+	cutoff := now.AddDate(0, 0, 1-dailyToolDays).Format("2006-01-02")
+	out := make(map[string]map[string]int, len(s.dailyTools))
+	for date, byTool := range s.dailyTools {
+		if date < cutoff {
+			delete(s.dailyTools, date)
+			continue
+		}
+		out[date] = make(map[string]int, len(byTool))
+		for tool, n := range byTool {
+			out[date][tool] = n
+		}
+	}
+	return out
+}
+
+// addDailyUsage adds the tokens of the requests not seen before under
+// their date, model and kind (input, output, cache_read, cache_create) and
+// returns the totals of the last dailyToolDays days.
+func (c *Collector) addDailyUsage(usage []source.DatedUsage, now time.Time) map[string]map[string]map[string]float64 {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for _, u := range usage {
+		if !c.firstSight(u.ID+":tokens", dateTime(u.Date, u.Hour, now.Location()), now) {
+			continue
+		}
+		byModel, ok := s.dailyTokens[u.Date]
+		if !ok {
+			byModel = make(map[string]map[string]float64)
+			s.dailyTokens[u.Date] = byModel
+		}
+		byKind, ok := byModel[u.Model]
+		if !ok {
+			byKind = make(map[string]float64)
+			byModel[u.Model] = byKind
+		}
+		byKind["input"] += u.Usage.Input
+		byKind["output"] += u.Usage.Output
+		byKind["cache_read"] += u.Usage.CacheRead
+		byKind["cache_create"] += u.Usage.CacheCreate
+	}
+
+	cutoff := now.AddDate(0, 0, 1-dailyToolDays).Format("2006-01-02")
+	out := make(map[string]map[string]map[string]float64, len(s.dailyTokens))
+	for date, byModel := range s.dailyTokens {
+		if date < cutoff {
+			delete(s.dailyTokens, date)
+			continue
+		}
+		out[date] = make(map[string]map[string]float64, len(byModel))
+		for model, byKind := range byModel {
+			out[date][model] = make(map[string]float64, len(byKind))
+			for kind, n := range byKind {
+				out[date][model][kind] = n
+			}
+		}
+	}
+	return out
+}
+
+// addProjectCost adds the cost of the requests not seen before under their
+// date and project and returns the costs of the last dailyToolDays days.
+func (c *Collector) addProjectCost(usage []source.DatedUsage, now time.Time) map[string]map[string]float64 {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for _, u := range usage {
+		if !c.firstSight(u.ID+":projectcost", dateTime(u.Date, u.Hour, now.Location()), now) {
+			continue
+		}
+		byProject, ok := s.dailyProjectCost[u.Date]
+		if !ok {
+			byProject = make(map[string]float64)
+			s.dailyProjectCost[u.Date] = byProject
+		}
+		byProject[u.Project] += u.Usage.Cost
+	}
+
+	cutoff := now.AddDate(0, 0, 1-dailyToolDays).Format("2006-01-02")
+	out := make(map[string]map[string]float64, len(s.dailyProjectCost))
+	for date, byProject := range s.dailyProjectCost {
+		if date < cutoff {
+			delete(s.dailyProjectCost, date)
+			continue
+		}
+		out[date] = make(map[string]float64, len(byProject))
+		for project, cost := range byProject {
+			out[date][project] = cost
+		}
+	}
+	return out
+}
+
+// addProjectLanguages records the languages of the projects read and
+// returns those of the projects read or with cost in the last
+// dailyToolDays days, so projects whose files are no longer read keep
+// their label. Call it after addProjectCost, which drops older days.
+func (c *Collector) addProjectLanguages(langs map[string]string) map[string]string {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for project, lang := range langs {
+		if lang != "unknown" || s.projectLanguages[project] == "" {
+			s.projectLanguages[project] = lang
+		}
+	}
+	out := make(map[string]string, len(s.projectLanguages))
+	for project, lang := range s.projectLanguages {
+		_, keep := langs[project]
+		for _, byProject := range s.dailyProjectCost {
+			if _, ok := byProject[project]; ok {
+				keep = true
+				break
+			}
+		}
+		if !keep {
+			delete(s.projectLanguages, project)
+			continue
+		}
+		out[project] = lang
+	}
+	return out
+}
+
+// hourlyCostDays is how many days of hourly cost the exporter keeps, the
+// anomaly baseline plus the current day.
+const hourlyCostDays = anomalyBaselineDays + 1
+
+// efficiencyDays is the rolling window of claude_cost_per_message_usd and
+// claude_cost_per_session_usd.
+const efficiencyDays = 7
+
+// modelActivity is one model's requests, their cost and the sessions that
+// made them, on one day.
+type modelActivity struct {
+	Messages int      `json:"messages"`
+	Cost     float64  `json:"cost"`
+	Sessions []string `json:"sessions"`
+}
+
+// addModelActivity adds the requests not seen before under their date and
+// model and returns each model's activity over the last efficiencyDays
+// days, with a session that spans days counted once.
+func (c *Collector) addModelActivity(usage []source.DatedUsage, now time.Time) map[string]*modelActivity {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for _, u := range usage {
+		if !c.firstSight(u.ID+":activity", dateTime(u.Date, u.Hour, now.Location()), now) {
+			continue
+		}
+		byModel, ok := s.dailyModelActivity[u.Date]
+		if !ok {
+			byModel = make(map[string]*modelActivity)
+			s.dailyModelActivity[u.Date] = byModel
+		}
+		a, ok := byModel[u.Model]
+		if !ok {
+			a = &modelActivity{}
+			byModel[u.Model] = a
+		}
+		a.Messages += c.sampleEvery
+		a.Cost += u.Usage.Cost
+		if !slices.Contains(a.Sessions, u.Session) {
+			a.Sessions = append(a.Sessions, u.Session)
+		}
+	}
+
+	cutoff := now.AddDate(0, 0, 1-efficiencyDays).Format("2006-01-02")
+	out := make(map[string]*modelActivity)
+	sessions := make(map[string]map[string]bool) // model -> session IDs
+	for date, byModel := range s.dailyModelActivity {
+		if date < cutoff {
+			delete(s.dailyModelActivity, date)
+			continue
+		}
+		for model, a := range byModel {
+			total, ok := out[model]
+			if !ok {
+				total = &modelActivity{}
+				out[model] = total
+				sessions[model] = make(map[string]bool)
+			}
+			total.Messages += a.Messages
+			total.Cost += a.Cost
+			for _, id := range a.Sessions {
+				if !sessions[model][id] {
+					sessions[model][id] = true
+					total.Sessions = append(total.Sessions, id)
+				}
+			}
+		}
+	}
+	return out
+}
+
+// hourKey is the key of t's hour in histogramState.hourlyCost, t in the
+// zone of the collector's clock.
+func hourKey(t time.Time) string { return t.Format("2006-01-02 15") }
+
+// addHourlyCost adds the cost of the requests not seen before under their
+// local hour and returns the costs of the last hourlyCostDays days.
+func (c *Collector) addHourlyCost(usage []source.DatedUsage, now time.Time) map[string]float64 {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	for _, u := range usage {
+		if !c.firstSight(u.ID+":hourcost", dateTime(u.Date, u.Hour, now.Location()), now) {
+			continue
+		}
+		s.hourlyCost[fmt.Sprintf("%s %02d", u.Date, u.Hour)] += u.Usage.Cost
+	}
+
+	cutoff := hourKey(now.AddDate(0, 0, -hourlyCostDays))
+	out := make(map[string]float64, len(s.hourlyCost))
+	for hour, cost := range s.hourlyCost {
+		if hour < cutoff {
+			delete(s.hourlyCost, hour)
+			continue
+		}
+		out[hour] = cost
+	}
+	return out
+}
+
+// loadState restores histograms from the state file, if any.
+func (c *Collector) loadState() {
+	if c.stateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(c.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("state: failed to read %s: %v", c.stateFile, err)
+		return
+	}
+	var f savedState
+	if err := json.Unmarshal(data, &f); err != nil {
+		log.Printf("state: failed to parse %s: %v", c.stateFile, err)
+		return
+	}
+	if f.Version != stateVersion {
+		log.Printf("state: ignoring %s with version %d", c.stateFile, f.Version)
+		return
+	}
+
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.observed = f.Observed
+	s.values = make(map[string][]float64)
+	s.valueTimes = make(map[string][]int64)
+	s.dailyTools = f.DailyTools
+	s.dailyTokens = f.DailyTokens
+	s.hourlyCost = f.HourlyCost
+	s.dailyProjectCost = f.DailyProjectCost
+	s.projectLanguages = f.ProjectLanguages
+	s.dailyModelActivity = f.DailyModelActivity
+	s.init()
+	// samples saved without times count from the save
+	times := func(key string) []int64 {
+		if t := f.ValueTimes[key]; len(t) == len(f.Values[key]) {
+			return t
+		}
+		t := make([]int64, len(f.Values[key]))
+		for i := range t {
+			t[i] = f.SavedAt.Unix()
+		}
+		return t
+	}
+	// series saved without a creation time date from their oldest sample
+	restore := func(key string) {
+		s.values[key] = f.Values[key]
+		s.valueTimes[key] = times(key)
+		created, ok := f.Created[key]
+		if !ok {
+			created = f.SavedAt
+			if t := s.valueTimes[key]; len(t) > 0 {
+				created = time.Unix(t[0], 0)
+			}
+		}
+		s.created[key] = created
+	}
+	for name, h := range c.histograms() {
+		for _, v := range f.Values[name] {
+			h.Observe(v)
+		}
+		if len(f.Values[name]) > 0 {
+			restore(name)
+		}
+	}
+	vecs := c.histogramVecs()
+	for key, values := range f.Values {
+		name, label, ok := strings.Cut(key, "/")
+		if !ok {
+			// saved before the histogram gained its label
+			label = "unknown"
+		}
+		if vec := vecs[name]; vec != nil {
+			h := vec.WithLabelValues(label)
+			for _, v := range values {
+				h.Observe(v)
+			}
+			restore(key)
+		}
+	}
+	c.stateFileBytes.Set(float64(len(data)))
+	log.Printf("state: restored %d observed records from %s", len(s.observed), c.stateFile)
+}
+
+// SaveState writes the histogram state to Options.StateFile. It is a no-op
+// when no state file is configured.
+func (c *Collector) SaveState() error {
+	if c.stateFile == "" {
+		return nil
+	}
+
+	s := &c.state
+	s.mu.Lock()
+	s.init()
+	c.compact(c.now())
+	data, err := json.Marshal(savedState{
+		Version:          stateVersion,
+		SavedAt:          c.now().UTC(),
+		Observed:         s.observed,
+		Values:           s.values,
+		ValueTimes:       s.valueTimes,
+		Created:          s.created,
+		DailyTools:       s.dailyTools,
+		DailyTokens:      s.dailyTokens,
+		HourlyCost:       s.hourlyCost,
+		DailyProjectCost: s.dailyProjectCost,
+		ProjectLanguages: s.projectLanguages,
+
+		DailyModelActivity: s.dailyModelActivity,
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write then rename, so a crash never leaves a truncated file
+	tmp, err := os.CreateTemp(filepath.Dir(c.stateFile), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.stateFile); err != nil {
+		return err
+	}
+	c.stateFileBytes.Set(float64(len(data)))
+	return nil
+}
+
+// dateTime is the start of a date and hour in loc, the time of records
+// that only carry those; zero if date doesn't parse.
+func dateTime(date string, hour int, loc *time.Location) time.Time {
+	d, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return time.Time{}
+	}
+	return d.Add(time.Duration(hour) * time.Hour)
+}
+
+// seen reports whether a record of time t (zero when unknown) must not be
+// counted: it was observed before, or is older than the state retention.
+// compact drops observed records by that same time, so a record whose ID
+// was dropped is never counted again, however long its file stays live.
+// The caller holds the state lock.
+func (c *Collector) seen(id string, t, now time.Time) bool {
+	if !t.IsZero() && t.Before(now.Add(-c.stateRetention)) {
+		return true
+	}
+	_, ok := c.state.observed[id]
+	return ok
+}
+
+// markSeen records a record of time t as observed. Records without a time
+// count from now. The caller holds the state lock.
+func (c *Collector) markSeen(id string, t, now time.Time) {
+	if t.IsZero() {
+		t = now
+	}
+	c.state.observed[id] = t
+}
+
+// firstSight marks a record observed and reports whether it wasn't seen
+// before. The caller holds the state lock.
+func (c *Collector) firstSight(id string, t, now time.Time) bool {
+	if c.seen(id, t, now) {
+		return false
+	}
+	c.markSeen(id, t, now)
+	return true
+}
+
+// compact forgets the observed records and the saved histogram samples
+// older than the state retention, records by their own time. The
+// histograms keep those samples until the next restart. The caller holds
+// the state lock.
+func (c *Collector) compact(now time.Time) {
+	s := &c.state
+	cutoff := now.Add(-c.stateRetention)
+	records, samples := 0, 0
+	for id, t := range s.observed {
+		if t.Before(cutoff) {
+			delete(s.observed, id)
+			records++
+		}
+	}
+	for key, times := range s.valueTimes {
+		// samples are appended as they are observed, oldest first
+		i, _ := slices.BinarySearch(times, cutoff.Unix())
+		switch {
+		case i == 0:
+			continue
+		case i == len(times):
+			delete(s.values, key)
+			delete(s.valueTimes, key)
+		default:
+			s.values[key] = slices.Clone(s.values[key][i:])
+			s.valueTimes[key] = slices.Clone(times[i:])
+		}
+		samples += i
+	}
+	c.statePruned.WithLabelValues("observed").Add(float64(records))
+	c.statePruned.WithLabelValues("samples").Add(float64(samples))
+	s.compacted = now
+}
+
+// updateState compacts the state once per stateCompactInterval, so it
+// stays bounded without a state file too, and reports its size.
+func (c *Collector) updateState(now time.Time) {
+	s := &c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+	if now.Sub(s.compacted) >= stateCompactInterval {
+		c.compact(now)
+	}
+	samples := 0
+	for _, values := range s.values {
+		samples += len(values)
+	}
+	c.stateEntries.WithLabelValues("observed").Set(float64(len(s.observed)))
+	c.stateEntries.WithLabelValues("samples").Set(float64(samples))
+}
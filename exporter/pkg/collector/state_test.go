@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// copyClaudeDir copies the Claude config directory of a golden case to a
+// temporary directory, with the stats cache older than the session files
+// so they are all live.
+func copyClaudeDir(t *testing.T, goldenCase string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(filepath.Join("..", "..", "testdata", "golden", goldenCase, "claude"))); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "stats-cache.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// stateFamilies are the daily series kept in the histogram state.
+var stateFamilies = map[string]bool{
+	"claude_daily_tool_use":         true,
+	"claude_daily_tokens_by_kind":   true,
+	"claude_daily_project_cost_usd": true,
+}
+
+// totals are the values compaction must not change: the histogram sample
+// counts and sums, and the daily series kept in the state.
+func totals(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName() + labelString(m)
+			switch {
+			case m.GetHistogram() != nil:
+				out[key+"_count"] = float64(m.GetHistogram().GetSampleCount())
+				out[key+"_sum"] = m.GetHistogram().GetSampleSum()
+			case stateFamilies[mf.GetName()]:
+				out[key] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return out
+}
+
+func labelString(m *dto.Metric) string {
+	var b strings.Builder
+	for _, l := range m.GetLabel() {
+		b.WriteString("," + l.GetName() + "=" + l.GetValue())
+	}
+	return "{" + b.String() + "}"
+}
+
+func TestCompactKeepsLiveFileTotals(t *testing.T) {
+	dir := copyClaudeDir(t, "basic")
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// after the sessions of the fixture ended
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+	c := NewCollector(Options{
+		StatsFile:      filepath.Join(dir, "stats-cache.json"),
+		ClaudeDir:      dir,
+		Pricing:        prices,
+		StateRetention: 24 * time.Hour,
+		Now:            func() time.Time { return now },
+	})
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+
+	c.Update()
+	want := totals(t, reg)
+	if len(want) == 0 {
+		t.Fatal("the fixture produced no histograms or daily series")
+	}
+	// the files stay live and are sent again on every scan, while their
+	// records fall out of the retention and get compacted
+	for _, step := range []time.Duration{2 * time.Hour, 48 * time.Hour, 2 * time.Hour, 2 * time.Hour} {
+		now = now.Add(step)
+		c.Update()
+		got := totals(t, reg)
+		for key, w := range want {
+			if got[key] != w {
+				t.Errorf("at %v: %s = %v, want %v", now, key, got[key], w)
+			}
+		}
+	}
+	if n := len(c.state.observed); n != 0 {
+		t.Errorf("%d observed records left after they all fell out of the retention", n)
+	}
+}
+
+func TestRestoreKeepsCreated(t *testing.T) {
+	dir := copyClaudeDir(t, "basic")
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 3, 10, 14, 0, 0, 0, time.UTC)
+	opts := Options{
+		StatsFile: filepath.Join(dir, "stats-cache.json"),
+		ClaudeDir: dir,
+		Pricing:   prices,
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Now:       func() time.Time { return now },
+	}
+	before := prometheus.NewRegistry()
+	c := NewCollector(opts)
+	before.MustRegister(c)
+	want := createdTimes(t, before)
+	if len(want) == 0 {
+		t.Fatal("the fixture produced no histograms")
+	}
+	if err := c.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the restarted histograms are created later
+	time.Sleep(10 * time.Millisecond)
+	now = now.Add(time.Hour)
+	after := prometheus.NewRegistry()
+	after.MustRegister(NewCollector(opts))
+	got := createdTimes(t, after)
+	for key, w := range want {
+		if !got[key].Equal(w) {
+			t.Errorf("%s created %v after a restart, want %v", key, got[key], w)
+		}
+	}
+}
+
+// createdTimes returns the created timestamp of every histogram series.
+func createdTimes(t *testing.T, reg *prometheus.Registry) map[string]time.Time {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]time.Time)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				out[mf.GetName()+labelString(m)] = h.GetCreatedTimestamp().AsTime()
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,191 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- summary ---
+
+type ModelSummary struct {
+	Input       float64 `json:"input_tokens"`
+	Output      float64 `json:"output_tokens"`
+	CacheRead   float64 `json:"cache_read_tokens"`
+	CacheCreate float64 `json:"cache_creation_tokens"`
+	CostUSD     float64 `json:"cost_usd"`
+}
+
+type TodaySummary struct {
+	Date      string  `json:"date"`
+	Messages  int     `json:"messages"`
+	Sessions  int     `json:"sessions"`
+	ToolCalls int     `json:"tool_calls"`
+	Tokens    float64 `json:"tokens"`
+	CostUSD   float64 `json:"cost_usd"`
+}
+
+type LiveSummary struct {
+	Sessions int                      `json:"sessions"`
+	Messages int                      `json:"messages"`
+	Models   map[string]*ModelSummary `json:"models"`
+}
+
+type DailySummary struct {
+	Date    string             `json:"date"`
+	Tokens  map[string]float64 `json:"tokens"`
+	CostUSD float64            `json:"cost_usd"`
+}
+
+type Summary struct {
+	GeneratedAt time.Time                `json:"generated_at"`
+	Today       TodaySummary             `json:"today"`
+	Live        LiveSummary              `json:"live"`
+	Models      map[string]*ModelSummary `json:"models"`
+	Daily       []DailySummary           `json:"daily"`
+	Tools       map[string]int           `json:"tools"`
+}
+
+func buildSummary(stats *source.StatsCache, live *source.LiveResult, models map[string]*ModelSummary, days periodTotals, now time.Time) *Summary {
+	today := now.UTC().Format("2006-01-02")
+	s := &Summary{
+		GeneratedAt: now.UTC(),
+		Today:       TodaySummary{Date: today},
+		Live: LiveSummary{
+			Sessions: live.SessionCount,
+			Messages: live.MessageCount,
+			Models:   make(map[string]*ModelSummary),
+		},
+		Models: models,
+		Tools:  live.ToolUseCounts,
+	}
+
+	for model, mu := range live.ModelUsage {
+		s.Live.Models[model] = &ModelSummary{
+			Input: mu.Input, Output: mu.Output, CacheRead: mu.CacheRead, CacheCreate: mu.CacheCreate, CostUSD: mu.Cost,
+		}
+	}
+
+	s.Daily = dailySummaries(days, days.latest(30))
+	for _, u := range days[today] {
+		s.Today.Tokens += u.Tokens
+		s.Today.CostUSD += u.Cost
+	}
+
+	s.Today.Messages = live.MessageCount
+	s.Today.Sessions = live.SessionCount
+	for _, entry := range stats.DailyActivity {
+		if entry.Date == today {
+			s.Today.Messages += entry.MessageCount
+			s.Today.Sessions += entry.SessionCount
+			s.Today.ToolCalls = entry.ToolCallCount
+			break
+		}
+	}
+	return s
+}
+
+// dailySummaries returns the totals of the given dates.
+func dailySummaries(days periodTotals, dates []string) []DailySummary {
+	var out []DailySummary
+	for _, date := range dates {
+		entry := DailySummary{Date: date, Tokens: make(map[string]float64)}
+		for model, u := range days[date] {
+			entry.Tokens[model] = u.Tokens
+			entry.CostUSD += u.Cost
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+// SessionSummary is one live session in the sessions API.
+type SessionSummary struct {
+	ID            string    `json:"id"`
+	Project       string    `json:"project"`
+	Messages      int       `json:"messages"`
+	Turns         int       `json:"turns"`
+	Compactions   int       `json:"compactions"`
+	Models        []string  `json:"models"`
+	ModelSwitched bool      `json:"model_switched"`
+	ModelSwitches int       `json:"model_switches"`
+	LastActivity  time.Time `json:"last_activity"`
+	// tokens and cost by model, subagents included
+	ModelUsage map[string]*ModelSummary `json:"model_usage"`
+	CostUSD    float64                  `json:"cost_usd"`
+}
+
+func buildSessions(sessions []*source.Session) []SessionSummary {
+	out := make([]SessionSummary, 0, len(sessions))
+	for _, s := range sessions {
+		out = append(out, SessionSummary{
+			ID:            s.ID,
+			Project:       s.Project,
+			Messages:      s.Messages,
+			Turns:         s.Turns,
+			Compactions:   s.Compactions,
+			Models:        s.Models,
+			ModelSwitched: s.ModelSwitches > 0,
+			ModelSwitches: s.ModelSwitches,
+			LastActivity:  s.LastActivity,
+			ModelUsage:    make(map[string]*ModelSummary, len(s.Usage)),
+		})
+		sum := &out[len(out)-1]
+		for model, mu := range s.Usage {
+			sum.ModelUsage[model] = &ModelSummary{
+				Input: mu.Input, Output: mu.Output, CacheRead: mu.CacheRead, CacheCreate: mu.CacheCreate, CostUSD: mu.Cost,
+			}
+			sum.CostUSD += mu.Cost
+		}
+	}
+	return out
+}
+
+// Sessions returns the live sessions seen by the latest update.
+func (c *Collector) Sessions() []SessionSummary {
+	if s := c.sessions.Load(); s != nil {
+		return *s
+	}
+	return nil
+}
+
+// History returns the daily totals of the latest update from from to to
+// (YYYY-MM-DD, inclusive, empty for no bound), oldest first.
+func (c *Collector) History(from, to string) []DailySummary {
+	h := c.history.Load()
+	if h == nil {
+		return nil
+	}
+	var out []DailySummary
+	for _, d := range *h {
+		if (from == "" || d.Date >= from) && (to == "" || d.Date <= to) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Summary returns the summary computed by the latest update, or nil before
+// the stats cache has been read.
+func (c *Collector) Summary() *Summary {
+	return c.summary.Load()
+}
+
+// Ready reports whether the stats cache has been loaded at least once.
+func (c *Collector) Ready() bool {
+	return c.summary.Load() != nil
+}
+
+// StatsFile returns the path of the stats cache the collector reads.
+func (c *Collector) StatsFile() string {
+	return c.statsFile
+}
+
+// LastUpdate returns when an update last read the stats cache, or the zero
+// time if none has.
+func (c *Collector) LastUpdate() time.Time {
+	if n := c.lastUpdate.Load(); n != 0 {
+		return time.Unix(0, n)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- 5-hour usage window ---
+
+// windowLength is the length of a Claude subscription usage window.
+const windowLength = 5 * time.Hour
+
+type usageWindow struct {
+	Start  time.Time
+	Tokens float64
+	Cost   float64
+}
+
+// currentWindow replays recent requests into 5-hour windows the way Claude
+// counts them: a window opens at the hour of the first request after the
+// previous one expired. It returns false when no window is active at now.
+func currentWindow(events []source.UsageEvent, now time.Time) (usageWindow, bool) {
+	sorted := make([]source.UsageEvent, len(events))
+	copy(sorted, events)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+
+	var w usageWindow
+	for _, e := range sorted {
+		if w.Start.IsZero() || !e.Time.Before(w.Start.Add(windowLength)) {
+			w = usageWindow{Start: e.Time.Truncate(time.Hour)}
+		}
+		w.Tokens += e.Input + e.Output
+		w.Cost += e.Cost
+	}
+	if w.Start.IsZero() || !now.Before(w.Start.Add(windowLength)) {
+		return usageWindow{}, false
+	}
+	return w, true
+}
+
+func (c *Collector) updateWindow(live *source.LiveResult, now time.Time) {
+	w, ok := currentWindow(live.Recent, now)
+	if !ok {
+		c.windowTokens.Set(0)
+		c.windowCost.Set(0)
+		c.windowRemaining.Set(0)
+		c.windowBurnRate.Set(0)
+		// the next request opens a fresh window
+		c.windowTimeToLimit.Set(windowLength.Seconds())
+		return
+	}
+
+	elapsed := now.Sub(w.Start)
+	remaining := windowLength - elapsed
+	rate := w.Tokens / elapsed.Minutes()
+
+	c.windowTokens.Set(w.Tokens)
+	c.windowCost.Set(w.Cost)
+	c.windowRemaining.Set(remaining.Seconds())
+	c.windowBurnRate.Set(rate)
+
+	// at the current burn rate, capped at the window end when usage resets
+	toLimit := remaining
+	if rate > 0 {
+		toLimit = min(max(time.Duration((c.windowTokenLimit-w.Tokens)/rate*float64(time.Minute)), 0), remaining)
+	}
+	c.windowTimeToLimit.Set(toLimit.Seconds())
+}
+
+// --- recent activity ---
+
+// updateRecent sets the request, token and cost totals of the last minutes
+// from request timestamps, which a rate() over the cumulative gauges can't
+// give reliably as they reset when the stats cache catches up.
+func (c *Collector) updateRecent(live *source.LiveResult, now time.Time) {
+	var requests, tokens, cost float64
+	for _, e := range live.Recent {
+		age := now.Sub(e.Time)
+		if age <= 5*time.Minute {
+			// a sampled request stands for sampleEvery of them
+			requests += float64(c.sampleEvery)
+			tokens += e.Input + e.Output
+		}
+		if age <= time.Hour {
+			cost += e.Cost
+		}
+	}
+	c.requestsLast5m.Set(requests)
+	c.tokensLast5m.Set(tokens)
+	c.costLastHour.Set(cost)
+}
+
+// --- cost anomaly ---
+
+const (
+	// anomalyBaselineDays is how many previous days of the same hour make
+	// up the baseline, and anomalyMinDays how many of them must be known.
+	anomalyBaselineDays = 7
+	anomalyMinDays      = 3
+	// anomalyMinStdDev keeps a flat baseline, e.g. an hour that always
+	// costs nothing, from turning cents into huge scores.
+	anomalyMinStdDev = 0.10
+)
+
+// updateAnomaly scores the cost of the current hour against the same hour
+// of the previous days, as a z-score, from the hourly costs returned by
+// addHourlyCost. The score is left out until the exporter has recorded
+// enough days.
+func (c *Collector) updateAnomaly(hours map[string]float64, now time.Time) {
+	first := ""
+	for hour := range hours {
+		if first == "" || hour < first {
+			first = hour
+		}
+	}
+
+	var baseline []float64
+	for d := 1; d <= anomalyBaselineDays; d++ {
+		// days before the first recorded hour are unknown, not free
+		if key := hourKey(now.AddDate(0, 0, -d)); first != "" && key >= first {
+			baseline = append(baseline, hours[key])
+		}
+	}
+	if len(baseline) < anomalyMinDays {
+		c.costAnomalyScore.Set(0)
+		return
+	}
+	var mean, variance float64
+	for _, v := range baseline {
+		mean += v
+	}
+	mean /= float64(len(baseline))
+	for _, v := range baseline {
+		variance += (v - mean) * (v - mean)
+	}
+	std := math.Max(math.Sqrt(variance/float64(len(baseline))), anomalyMinStdDev)
+	c.costAnomalyScore.Set((hours[hourKey(now)] - mean) / std)
+}
@@ -0,0 +1,229 @@
+// Package grpcapi serves the JSON API as a gRPC service, ccmonitor.v1.Query
+// in query.proto, for tooling that wants typed data and streams instead of
+// polling.
+//
+// gRPC is protobuf messages, each behind a 5-byte length prefix, over
+// HTTP/2 with the status in trailers. That is little enough to speak with
+// net/http and protowire instead of pulling in grpc-go. net/http only
+// speaks HTTP/2 over TLS, so the service is only served on a listener with
+// a certificate; plaintext (h2c) connections are refused.
+package grpcapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+)
+
+// Path is the prefix of the service's methods.
+const Path = "/ccmonitor.v1.Query/"
+
+// maxRequest bounds request messages, which are a few bytes.
+const maxRequest = 1 << 16
+
+// defaultWatchInterval is how often WatchSessions sends the sessions when
+// the request leaves the interval out.
+const defaultWatchInterval = 10 * time.Second
+
+// gRPC status codes.
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// Server serves the Query service for one collector.
+type Server struct {
+	Collector *collector.Collector
+	// Authorize checks the call's metadata, which arrives as request
+	// headers (nil allows every call).
+	Authorize func(*http.Request) bool
+
+	initOnce  sync.Once
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// done is closed by Close.
+func (s *Server) done() chan struct{} {
+	s.initOnce.Do(func() { s.closed = make(chan struct{}) })
+	return s.closed
+}
+
+// Close ends the open WatchSessions streams, which would otherwise hold up
+// http.Server.Shutdown; register it with RegisterOnShutdown.
+func (s *Server) Close() {
+	done := s.done()
+	s.closeOnce.Do(func() { close(done) })
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC needs HTTP/2, which this listener only serves over TLS", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+proto") {
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	if s.Authorize != nil && !s.Authorize(r) {
+		status(w, codeUnauthenticated, "missing or invalid bearer token")
+		return
+	}
+	req, code, err := readMessage(r.Body)
+	if err != nil {
+		status(w, code, err.Error())
+		return
+	}
+	strs, ints, err := requestFields(req)
+	if err != nil {
+		status(w, codeInvalidArgument, fmt.Sprintf("request: %v", err))
+		return
+	}
+
+	c := s.Collector
+	switch strings.TrimPrefix(r.URL.Path, Path) {
+	case "GetSummary":
+		c.Update()
+		sum := c.Summary()
+		if sum == nil {
+			status(w, codeUnavailable, "stats not available")
+			return
+		}
+		writeMessage(w, summary(sum))
+	case "ListSessions":
+		c.Update()
+		if !c.Ready() {
+			status(w, codeUnavailable, "stats not available")
+			return
+		}
+		writeMessage(w, sessionList(c.Sessions()))
+	case "QueryHistory":
+		from, to := strs[1], strs[2]
+		for _, date := range []string{from, to} {
+			if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+				status(w, codeInvalidArgument, fmt.Sprintf("want dates as YYYY-MM-DD, got %q", date))
+				return
+			}
+		}
+		c.Update()
+		if !c.Ready() {
+			status(w, codeUnavailable, "stats not available")
+			return
+		}
+		for _, d := range c.History(from, to) {
+			if err := writeMessage(w, dailySummary(d)); err != nil {
+				return
+			}
+		}
+	case "WatchSessions":
+		interval := time.Duration(ints[1]) * time.Second
+		if interval <= 0 {
+			interval = defaultWatchInterval
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			// the sessions of the latest update: a stream doesn't scan, or
+			// clients would set how often the exporter rescans
+			if c.Ready() {
+				if err := writeMessage(w, sessionList(c.Sessions())); err != nil {
+					return
+				}
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-s.done():
+				// the client sees the stream end cleanly
+				status(w, codeOK, "")
+				return
+			case <-ticker.C:
+			}
+		}
+	default:
+		status(w, codeUnimplemented, fmt.Sprintf("unknown method %s", r.URL.Path))
+		return
+	}
+	status(w, codeOK, "")
+}
+
+// readMessage reads the single request message of a call. It returns the
+// status code to fail the call with along with any error.
+func readMessage(body io.Reader) ([]byte, int, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		if err == io.EOF {
+			// a request of only zero values may be sent as an empty frame
+			// or, by some clients, not at all
+			return nil, codeOK, nil
+		}
+		return nil, codeInternal, fmt.Errorf("reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, codeUnimplemented, fmt.Errorf("compressed requests are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxRequest {
+		return nil, codeResourceExhausted, fmt.Errorf("request of %d bytes is over %d", n, maxRequest)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, codeInternal, fmt.Errorf("reading request: %v", err)
+	}
+	return msg, codeOK, nil
+}
+
+// writeMessage sends one response message and flushes it, so streams
+// reach the client as they are written.
+func writeMessage(w http.ResponseWriter, m message) error {
+	frame := make([]byte, 5, 5+len(m))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(m)))
+	if _, err := w.Write(append(frame, m...)); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// status ends the call with a gRPC status, sent as trailers.
+func status(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", percentEncode(msg))
+	}
+}
+
+// percentEncode escapes a status message as gRPC requires: bytes outside
+// printable ASCII, and %, as %XX.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
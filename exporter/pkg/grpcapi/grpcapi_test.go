@@ -0,0 +1,346 @@
+package grpcapi
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+)
+
+// The messages are encoded by hand, so the tests decode them with
+// descriptors built from query.proto itself: a field number or type that
+// drifts from the proto file shows up as a mismatch or an unknown field.
+
+var (
+	messageStart = regexp.MustCompile(`^message (\w+) \{`)
+	fieldLine    = regexp.MustCompile(`^(repeated )?(?:map<(\w+), *([\w.]+)>|([\w.]+)) (\w+) = (\d+);`)
+)
+
+var scalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"double": descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint32": descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+}
+
+// queryProto builds the descriptor of query.proto from the file, which
+// keeps to one field per line.
+func queryProto(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	src, err := os.ReadFile("query.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := func(name string, number int, typ string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(int32(number)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if st, ok := scalarTypes[typ]; ok {
+			f.Type = st.Enum()
+		} else {
+			f.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			if !strings.Contains(typ, ".") {
+				typ = "ccmonitor.v1." + typ
+			}
+			f.TypeName = proto.String("." + typ)
+		}
+		return f
+	}
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("query.proto"),
+		Package:    proto.String("ccmonitor.v1"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+	}
+	var msg *descriptorpb.DescriptorProto
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if m := messageStart.FindStringSubmatch(line); m != nil {
+			msg = &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+			fd.MessageType = append(fd.MessageType, msg)
+			continue
+		}
+		m := fieldLine.FindStringSubmatch(line)
+		if m == nil || msg == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[6])
+		if m[2] == "" {
+			f := field(m[5], number, m[4])
+			if m[1] != "" {
+				f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			msg.Field = append(msg.Field, f)
+			continue
+		}
+		// a map is a repeated message of key and value
+		var entry string
+		for _, word := range strings.Split(m[5], "_") {
+			entry += strings.ToUpper(word[:1]) + word[1:]
+		}
+		entry += "Entry"
+		msg.NestedType = append(msg.NestedType, &descriptorpb.DescriptorProto{
+			Name:    proto.String(entry),
+			Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, m[2]), field("value", 2, m[3])},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		})
+		f := field(m[5], number, "ccmonitor.v1."+msg.GetName()+"."+entry)
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		msg.Field = append(msg.Field, f)
+	}
+	file, err := protodesc.NewFile(fd, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatalf("query.proto: %v", err)
+	}
+	return file
+}
+
+// decode parses b as the named message of query.proto, failing on fields
+// the proto file doesn't declare.
+func decode(t *testing.T, file protoreflect.FileDescriptor, name string, b []byte) *dynamicpb.Message {
+	t.Helper()
+	m := dynamicpb.NewMessage(file.Messages().ByName(protoreflect.Name(name)))
+	if err := proto.Unmarshal(b, m); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	noUnknown(t, m)
+	return m
+}
+
+func noUnknown(t *testing.T, m protoreflect.Message) {
+	t.Helper()
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s has fields query.proto doesn't declare", m.Descriptor().FullName())
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				v.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					noUnknown(t, v.Message())
+					return true
+				})
+			}
+		case fd.IsList() && fd.Message() != nil:
+			for i := range v.List().Len() {
+				noUnknown(t, v.List().Get(i).Message())
+			}
+		case fd.Message() != nil:
+			noUnknown(t, v.Message())
+		}
+		return true
+	})
+}
+
+func TestMessagesMatchProto(t *testing.T) {
+	file := queryProto(t)
+	at := time.Date(2026, 3, 10, 12, 30, 0, 500, time.UTC)
+	usage := map[string]*collector.ModelSummary{
+		"claude-opus-4-1":  {Input: 100, Output: 50.5, CacheRead: 1000, CacheCreate: 200, CostUSD: 0.25},
+		"claude-haiku-4-5": {Input: 10},
+	}
+	daily := collector.DailySummary{Date: "2026-03-09", Tokens: map[string]float64{"claude-opus-4-1": 1350}, CostUSD: 0.25}
+
+	tests := []struct {
+		name string
+		msg  message
+		want string
+	}{
+		{
+			name: "Summary",
+			msg: summary(&collector.Summary{
+				GeneratedAt: at,
+				Today:       collector.TodaySummary{Date: "2026-03-10", Messages: 12, Sessions: 2, ToolCalls: 7, Tokens: 1500, CostUSD: 1.5},
+				Live:        collector.LiveSummary{Sessions: 1, Messages: 3, Models: usage},
+				Models:      usage,
+				Daily:       []collector.DailySummary{daily, {Date: "2026-03-10"}},
+				Tools:       map[string]int{"Bash": 4, "Read": 3},
+			}),
+			want: `{
+				"generatedAt": "2026-03-10T12:30:00.000000500Z",
+				"today": {"date": "2026-03-10", "messages": "12", "sessions": "2", "toolCalls": "7", "tokens": 1500, "costUsd": 1.5},
+				"live": {"sessions": "1", "messages": "3", "models": {
+					"claude-opus-4-1": {"inputTokens": 100, "outputTokens": 50.5, "cacheReadTokens": 1000, "cacheCreationTokens": 200, "costUsd": 0.25},
+					"claude-haiku-4-5": {"inputTokens": 10}
+				}},
+				"models": {
+					"claude-opus-4-1": {"inputTokens": 100, "outputTokens": 50.5, "cacheReadTokens": 1000, "cacheCreationTokens": 200, "costUsd": 0.25},
+					"claude-haiku-4-5": {"inputTokens": 10}
+				},
+				"daily": [{"date": "2026-03-09", "tokens": {"claude-opus-4-1": 1350}, "costUsd": 0.25}, {"date": "2026-03-10"}],
+				"tools": {"Bash": "4", "Read": "3"}
+			}`,
+		},
+		{
+			name: "Summary",
+			msg:  summary(&collector.Summary{}),
+			want: `{"today": {}, "live": {}}`,
+		},
+		{
+			name: "SessionList",
+			msg: sessionList([]collector.SessionSummary{
+				{
+					ID: "s1", Project: "app", Messages: 9, Turns: 2, Compactions: 1,
+					Models: []string{"claude-opus-4-1", "claude-haiku-4-5"}, ModelSwitched: true, ModelSwitches: 1,
+					LastActivity: at, ModelUsage: usage, CostUSD: 0.25,
+				},
+				{ID: "s2"},
+			}),
+			want: `{"sessions": [
+				{
+					"id": "s1", "project": "app", "messages": "9", "turns": "2", "compactions": "1",
+					"models": ["claude-opus-4-1", "claude-haiku-4-5"], "modelSwitched": true, "modelSwitches": "1",
+					"lastActivity": "2026-03-10T12:30:00.000000500Z",
+					"modelUsage": {
+						"claude-opus-4-1": {"inputTokens": 100, "outputTokens": 50.5, "cacheReadTokens": 1000, "cacheCreationTokens": 200, "costUsd": 0.25},
+						"claude-haiku-4-5": {"inputTokens": 10}
+					},
+					"costUsd": 0.25
+				},
+				{"id": "s2"}
+			]}`,
+		},
+		{
+			name: "DailySummary",
+			msg:  dailySummary(daily),
+			want: `{"date": "2026-03-09", "tokens": {"claude-opus-4-1": 1350}, "costUsd": 0.25}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decode(t, file, tt.name, tt.msg)
+			want := dynamicpb.NewMessage(got.Descriptor())
+			if err := protojson.Unmarshal([]byte(tt.want), want); err != nil {
+				t.Fatal(err)
+			}
+			if !proto.Equal(got, want) {
+				t.Errorf("decoded %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRequestFields(t *testing.T) {
+	file := queryProto(t)
+	tests := []struct {
+		name string
+		json string
+		strs map[protowire.Number]string
+		ints map[protowire.Number]uint64
+	}{
+		{name: "HistoryRequest", json: `{"from": "2026-03-01", "to": "2026-03-10"}`, strs: map[protowire.Number]string{1: "2026-03-01", 2: "2026-03-10"}},
+		{name: "HistoryRequest", json: `{"to": "2026-03-10"}`, strs: map[protowire.Number]string{2: "2026-03-10"}},
+		{name: "WatchRequest", json: `{"intervalSeconds": 30}`, ints: map[protowire.Number]uint64{1: 30}},
+		{name: "WatchRequest", json: `{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := dynamicpb.NewMessage(file.Messages().ByName(protoreflect.Name(tt.name)))
+			if err := protojson.Unmarshal([]byte(tt.json), req); err != nil {
+				t.Fatal(err)
+			}
+			b, err := proto.Marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			strs, ints, err := requestFields(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(strs) != len(tt.strs) || len(ints) != len(tt.ints) {
+				t.Fatalf("decoded %v and %v, want %v and %v", strs, ints, tt.strs, tt.ints)
+			}
+			for n, s := range tt.strs {
+				if strs[n] != s {
+					t.Errorf("field %d = %q, want %q", n, strs[n], s)
+				}
+			}
+			for n, v := range tt.ints {
+				if ints[n] != v {
+					t.Errorf("field %d = %d, want %d", n, ints[n], v)
+				}
+			}
+		})
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	frame := func(compressed byte, n uint32, body string) []byte {
+		return append([]byte{compressed, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}, body...)
+	}
+	tests := []struct {
+		name string
+		body []byte
+		want string
+		code int
+	}{
+		{name: "message", body: frame(0, 3, "abc"), want: "abc"},
+		{name: "empty frame", body: frame(0, 0, "")},
+		{name: "no frame"},
+		{name: "compressed", body: frame(1, 3, "abc"), code: codeUnimplemented},
+		{name: "too large", body: frame(0, maxRequest+1, ""), code: codeResourceExhausted},
+		{name: "short", body: frame(0, 5, "abc"), code: codeInternal},
+		{name: "short prefix", body: []byte{0, 0}, code: codeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, code, err := readMessage(bytes.NewReader(tt.body))
+			if code != tt.code || (err != nil) != (tt.code != codeOK) {
+				t.Fatalf("readMessage = %d, %v; want code %d", code, err, tt.code)
+			}
+			if string(msg) != tt.want {
+				t.Errorf("message %q, want %q", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeHTTPRejects(t *testing.T) {
+	call := func(proto int, method, contentType string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, Path+"GetSummary", nil)
+		r.ProtoMajor = proto
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		(&Server{Authorize: func(*http.Request) bool { return false }}).ServeHTTP(w, r)
+		return w
+	}
+	if w := call(1, http.MethodPost, "application/grpc"); w.Code != http.StatusHTTPVersionNotSupported {
+		t.Errorf("HTTP/1.1 call: status %d, want %d", w.Code, http.StatusHTTPVersionNotSupported)
+	}
+	if w := call(2, http.MethodGet, "application/grpc"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET call: status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	if w := call(2, http.MethodPost, "application/json"); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("JSON call: status %d, want %d", w.Code, http.StatusUnsupportedMediaType)
+	}
+	w := call(2, http.MethodPost, "application/grpc+proto")
+	if got := w.Result().Trailer.Get("Grpc-Status"); got != strconv.Itoa(codeUnauthenticated) {
+		t.Errorf("unauthorized call: grpc-status %q, want %d", got, codeUnauthenticated)
+	}
+}
+
+func TestPercentEncode(t *testing.T) {
+	if got, want := percentEncode("bad date: 100% \"x\"\n√"), `bad date: 100%25 "x"%0A%E2%88%9A`; got != want {
+		t.Errorf("percentEncode = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,187 @@
+package grpcapi
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/collector"
+)
+
+// --- messages ---
+
+// message builds a protobuf message of query.proto. Fields at their zero
+// value are left out, as proto3 does.
+type message []byte
+
+func (m message) str(n protowire.Number, s string) message {
+	if s == "" {
+		return m
+	}
+	m = protowire.AppendTag(m, n, protowire.BytesType)
+	return protowire.AppendString(m, s)
+}
+
+func (m message) double(n protowire.Number, v float64) message {
+	if v == 0 {
+		return m
+	}
+	m = protowire.AppendTag(m, n, protowire.Fixed64Type)
+	return protowire.AppendFixed64(m, math.Float64bits(v))
+}
+
+func (m message) int(n protowire.Number, v int64) message {
+	if v == 0 {
+		return m
+	}
+	m = protowire.AppendTag(m, n, protowire.VarintType)
+	return protowire.AppendVarint(m, uint64(v))
+}
+
+func (m message) boolean(n protowire.Number, v bool) message {
+	if !v {
+		return m
+	}
+	m = protowire.AppendTag(m, n, protowire.VarintType)
+	return protowire.AppendVarint(m, 1)
+}
+
+// msg embeds sub, even when empty, so the field is present.
+func (m message) msg(n protowire.Number, sub message) message {
+	m = protowire.AppendTag(m, n, protowire.BytesType)
+	return protowire.AppendBytes(m, sub)
+}
+
+// timestamp adds t as a google.protobuf.Timestamp, unless it is zero.
+func (m message) timestamp(n protowire.Number, t time.Time) message {
+	if t.IsZero() {
+		return m
+	}
+	return m.msg(n, message(nil).int(1, t.Unix()).int(2, int64(t.Nanosecond())))
+}
+
+// sortedKeys returns the keys of a map field in order, so equal data
+// encodes the same.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func modelUsage(u *collector.ModelSummary) message {
+	return message(nil).
+		double(1, u.Input).
+		double(2, u.Output).
+		double(3, u.CacheRead).
+		double(4, u.CacheCreate).
+		double(5, u.CostUSD)
+}
+
+// usageMap adds a map<string, ModelUsage> field.
+func (m message) usageMap(n protowire.Number, usage map[string]*collector.ModelSummary) message {
+	for _, model := range sortedKeys(usage) {
+		m = m.msg(n, message(nil).str(1, model).msg(2, modelUsage(usage[model])))
+	}
+	return m
+}
+
+func dailySummary(d collector.DailySummary) message {
+	m := message(nil).str(1, d.Date)
+	for _, model := range sortedKeys(d.Tokens) {
+		m = m.msg(2, message(nil).str(1, model).double(2, d.Tokens[model]))
+	}
+	return m.double(3, d.CostUSD)
+}
+
+func summary(s *collector.Summary) message {
+	today := message(nil).
+		str(1, s.Today.Date).
+		int(2, int64(s.Today.Messages)).
+		int(3, int64(s.Today.Sessions)).
+		int(4, int64(s.Today.ToolCalls)).
+		double(5, s.Today.Tokens).
+		double(6, s.Today.CostUSD)
+	live := message(nil).
+		int(1, int64(s.Live.Sessions)).
+		int(2, int64(s.Live.Messages)).
+		usageMap(3, s.Live.Models)
+
+	m := message(nil).
+		timestamp(1, s.GeneratedAt).
+		msg(2, today).
+		msg(3, live).
+		usageMap(4, s.Models)
+	for _, d := range s.Daily {
+		m = m.msg(5, dailySummary(d))
+	}
+	for _, tool := range sortedKeys(s.Tools) {
+		m = m.msg(6, message(nil).str(1, tool).int(2, int64(s.Tools[tool])))
+	}
+	return m
+}
+
+func sessionList(sessions []collector.SessionSummary) message {
+	var m message
+	for _, s := range sessions {
+		sess := message(nil).
+			str(1, s.ID).
+			str(2, s.Project).
+			int(3, int64(s.Messages)).
+			int(4, int64(s.Turns)).
+			int(5, int64(s.Compactions))
+		for _, model := range s.Models {
+			sess = protowire.AppendTag(sess, 6, protowire.BytesType)
+			sess = protowire.AppendString(sess, model)
+		}
+		sess = sess.
+			boolean(7, s.ModelSwitched).
+			int(8, int64(s.ModelSwitches)).
+			timestamp(9, s.LastActivity).
+			usageMap(10, s.ModelUsage).
+			double(11, s.CostUSD)
+		m = m.msg(1, sess)
+	}
+	return m
+}
+
+// requestFields decodes the string and integer fields of a request.
+// Fields of other types are skipped.
+func requestFields(b []byte) (strs map[protowire.Number]string, ints map[protowire.Number]uint64, err error) {
+	strs = make(map[protowire.Number]string)
+	ints = make(map[protowire.Number]uint64)
+	for len(b) > 0 {
+		n, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return nil, nil, protowire.ParseError(l)
+		}
+		b = b[l:]
+		switch typ {
+		case protowire.BytesType:
+			v, l := protowire.ConsumeString(b)
+			if l < 0 {
+				return nil, nil, protowire.ParseError(l)
+			}
+			strs[n] = v
+			b = b[l:]
+		case protowire.VarintType:
+			v, l := protowire.ConsumeVarint(b)
+			if l < 0 {
+				return nil, nil, protowire.ParseError(l)
+			}
+			ints[n] = v
+			b = b[l:]
+		default:
+			l := protowire.ConsumeFieldValue(n, typ, b)
+			if l < 0 {
+				return nil, nil, protowire.ParseError(l)
+			}
+			b = b[l:]
+		}
+	}
+	return strs, ints, nil
+}
@@ -0,0 +1,128 @@
+// Package model normalizes model names reported by coding agents.
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Providers a Claude model ID can come from, told apart by its format.
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderBedrock   = "bedrock"
+	ProviderVertex    = "vertex"
+)
+
+var (
+	// AWS Bedrock: "anthropic.claude-sonnet-4-5-20250929-v1:0", optionally
+	// with a cross-region prefix ("us.") or as an inference profile ARN.
+	bedrockID = regexp.MustCompile(`^(?:arn:aws[\w-]*:bedrock:[^/]*/)?(?:[a-z]{2,6}\.)?anthropic\.(.+?)(?:-v\d+(?::\d+)?)?$`)
+	// Google Vertex AI: "claude-sonnet-4-5@20250929", "claude-3-5-sonnet-v2@20241022".
+	vertexID = regexp.MustCompile(`^(claude-.+?)(?:-v\d+)?@(\d{8}|latest)$`)
+)
+
+// Provider reports which API served a Claude model ID: ProviderBedrock,
+// ProviderVertex, or ProviderAnthropic for any other name.
+func Provider(name string) string {
+	switch {
+	case bedrockID.MatchString(name):
+		return ProviderBedrock
+	case vertexID.MatchString(name):
+		return ProviderVertex
+	}
+	return ProviderAnthropic
+}
+
+// MergeProvider returns the provider to report for a model seen through
+// both current and seen: a cloud provider wins over the Anthropic API,
+// which unrecognized names also default to.
+func MergeProvider(current, seen string) string {
+	if current == "" || current == ProviderAnthropic {
+		return seen
+	}
+	return current
+}
+
+// Short strips provider prefixes and normalizes version separators, so
+// "anthropic/claude-opus-4.6" and "claude-opus-4-6" are the same model.
+// Bedrock and Vertex AI IDs map to the Anthropic API ID, e.g.
+// "us.anthropic.claude-sonnet-4-5-20250929-v1:0" and
+// "claude-sonnet-4-5@20250929" to "claude-sonnet-4-5-20250929".
+func Short(name string) string {
+	if m := bedrockID.FindStringSubmatch(name); m != nil {
+		name = m[1]
+	} else if m := vertexID.FindStringSubmatch(name); m != nil {
+		name = m[1]
+		if m[2] != "latest" {
+			name += "-" + m[2]
+		}
+	}
+	name = strings.ReplaceAll(name, "anthropic/", "")
+	// Normalize version separators: "claude-opus-4.6" → "claude-opus-4-6"
+	// This avoids duplicate model entries with dots vs dashes
+	name = strings.ReplaceAll(name, ".", "-")
+	return name
+}
+
+// Rule rewrites the part of a model name matching Match, a regular
+// expression, to Replace, which may refer to submatches as $1.
+type Rule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+}
+
+// Rules rewrites names already normalized by Short, e.g. to merge dated
+// snapshots ("claude-sonnet-4-5-20250929") or provider-prefixed names into
+// one label value. Every rule is applied in order.
+type Rules struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+// NewRules compiles rules.
+func NewRules(rules []Rule) (*Rules, error) {
+	r := &Rules{}
+	for _, rule := range rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Match, err)
+		}
+		r.rules = append(r.rules, compiledRule{re: re, replace: rule.Replace})
+	}
+	return r, nil
+}
+
+// LoadRules reads a JSON array of rules. An empty path returns nil, which
+// leaves names unchanged.
+func LoadRules(path string) (*Rules, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	return NewRules(rules)
+}
+
+// Apply returns name rewritten by the rules.
+func (r *Rules) Apply(name string) string {
+	if r == nil {
+		return name
+	}
+	for _, rule := range r.rules {
+		name = rule.re.ReplaceAllString(name, rule.replace)
+	}
+	return name
+}
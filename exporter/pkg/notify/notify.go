@@ -0,0 +1,91 @@
+// Package notify sends push notifications about Claude Code sessions to a
+// phone, through an ntfy topic or a Gotify server: when a turn ran longer
+// than a threshold, and when a session has been waiting for the user's
+// next prompt for too long.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Message is one notification.
+type Message struct {
+	Title string
+	Body  string
+}
+
+// Sink delivers notifications.
+type Sink interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// sendTimeout bounds one delivery.
+const sendTimeout = 10 * time.Second
+
+// --- ntfy ---
+
+// Ntfy publishes to an ntfy topic, e.g. "https://ntfy.sh/my-topic".
+type Ntfy struct {
+	URL   string
+	Token string // access token for protected topics, optional
+	HTTP  *http.Client
+}
+
+func (n *Ntfy) Send(ctx context.Context, m Message) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, strings.NewReader(m.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", m.Title)
+	req.Header.Set("Tags", "robot")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return do(n.HTTP, req)
+}
+
+// --- Gotify ---
+
+// Gotify posts to a Gotify server with an application token.
+type Gotify struct {
+	URL   string // server base URL
+	Token string
+	HTTP  *http.Client
+}
+
+func (g *Gotify) Send(ctx context.Context, m Message) error {
+	body, err := json.Marshal(map[string]any{"title": m.Title, "message": m.Body, "priority": 5})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(g.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return do(g.HTTP, req)
+}
+
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
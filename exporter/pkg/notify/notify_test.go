@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+func TestSinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		sink  func(url string) Sink
+		check func(t *testing.T, r *http.Request, body []byte)
+	}{
+		{
+			"ntfy",
+			func(url string) Sink { return &Ntfy{URL: url + "/claude", Token: "secret"} },
+			func(t *testing.T, r *http.Request, body []byte) {
+				if r.URL.Path != "/claude" || r.Header.Get("Title") != "Turn finished" || r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("%s with headers %v", r.URL.Path, r.Header)
+				}
+				if string(body) != "It took 12m." {
+					t.Errorf("body %q", body)
+				}
+			},
+		},
+		{
+			"gotify",
+			func(url string) Sink { return &Gotify{URL: url + "/", Token: "app"} },
+			func(t *testing.T, r *http.Request, body []byte) {
+				if r.URL.Path != "/message" || r.Header.Get("X-Gotify-Key") != "app" {
+					t.Errorf("%s with headers %v", r.URL.Path, r.Header)
+				}
+				var msg struct{ Title, Message string }
+				if err := json.Unmarshal(body, &msg); err != nil || msg.Title != "Turn finished" || msg.Message != "It took 12m." {
+					t.Errorf("body %s: %v", body, err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := http.StatusOK
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				tt.check(t, r, body)
+				http.Error(w, "topic muted", status)
+			}))
+			defer srv.Close()
+			s := tt.sink(srv.URL)
+			m := Message{Title: "Turn finished", Body: "It took 12m."}
+			if err := s.Send(context.Background(), m); err != nil {
+				t.Fatal(err)
+			}
+			status = http.StatusForbidden
+			if err := s.Send(context.Background(), m); err == nil || !strings.Contains(err.Error(), "topic muted") {
+				t.Errorf("error %v, want the server's", err)
+			}
+		})
+	}
+}
+
+type chanSink chan Message
+
+func (c chanSink) Send(ctx context.Context, m Message) error {
+	c <- m
+	return nil
+}
+
+// received returns the messages sent within a short wait.
+func (c chanSink) received() []Message {
+	var out []Message
+	for {
+		select {
+		case m := <-c:
+			out = append(out, m)
+		case <-time.After(50 * time.Millisecond):
+			return out
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	now := time.Now()
+	turn := func(id string, took time.Duration) source.Observation {
+		return source.Observation{ID: id, Value: float64(took / time.Millisecond), Session: "0123456789abcdef", Project: "app"}
+	}
+	waiting := func(since time.Time) *source.Session {
+		return &source.Session{ID: "fedcba9876543210", Project: "app", AwaitingInput: true, LastActivity: since}
+	}
+	steps := []struct {
+		name string
+		live source.LiveResult
+		want []string // titles sent
+	}{
+		{
+			"first update only primes",
+			source.LiveResult{TurnDurations: []source.Observation{turn("t1", time.Hour)}, Sessions: []*source.Session{waiting(now.Add(-time.Hour))}},
+			nil,
+		},
+		{
+			"same turn and wait again",
+			source.LiveResult{TurnDurations: []source.Observation{turn("t1", time.Hour)}, Sessions: []*source.Session{waiting(now.Add(-time.Hour))}},
+			nil,
+		},
+		{
+			"long and short turn",
+			source.LiveResult{TurnDurations: []source.Observation{turn("t1", time.Hour), turn("t2", 20*time.Minute), turn("t3", time.Minute)}},
+			[]string{"Claude Code turn finished"},
+		},
+		{"waiting, but not long yet", source.LiveResult{Sessions: []*source.Session{waiting(now.Add(-time.Minute))}}, nil},
+		{"waiting since a later turn", source.LiveResult{Sessions: []*source.Session{waiting(now.Add(-30 * time.Minute))}}, []string{"Claude Code is waiting for you"}},
+	}
+	sink := make(chanSink, 10)
+	w := &Watcher{Sink: sink, TurnLongerThan: 10 * time.Minute, IdleLongerThan: 15 * time.Minute}
+	for _, step := range steps {
+		w.Update(&step.live)
+		var got []string
+		for _, m := range sink.received() {
+			got = append(got, m.Title)
+			if !strings.Contains(m.Body, "app") {
+				t.Errorf("%s: body %q lacks the project", step.name, m.Body)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(step.want, ",") {
+			t.Errorf("%s: sent %q, want %q", step.name, got, step.want)
+		}
+	}
+}
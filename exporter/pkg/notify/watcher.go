@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/source"
+)
+
+// --- watcher ---
+
+// Watcher finds notification-worthy events in the live session data. It
+// matches collector.Options.OnUpdate. The first update only records what
+// is already there, so a restart doesn't replay old turns.
+type Watcher struct {
+	Sink Sink
+	// TurnLongerThan notifies of turns that took longer (0 disables).
+	TurnLongerThan time.Duration
+	// IdleLongerThan notifies of sessions waiting for the user's prompt
+	// longer (0 disables).
+	IdleLongerThan time.Duration
+
+	mu     sync.Mutex
+	primed bool
+	turns  map[string]struct{}  // turn IDs seen
+	idle   map[string]time.Time // session ID -> waiting since, notified
+}
+
+func (w *Watcher) Update(live *source.LiveResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var msgs []Message
+	turns := make(map[string]struct{}, len(live.TurnDurations))
+	for _, o := range live.TurnDurations {
+		turns[o.ID] = struct{}{}
+		took := time.Duration(o.Value * float64(time.Millisecond))
+		if _, seen := w.turns[o.ID]; seen || !w.primed || w.TurnLongerThan <= 0 || took < w.TurnLongerThan {
+			continue
+		}
+		msgs = append(msgs, Message{
+			Title: "Claude Code turn finished",
+			Body:  fmt.Sprintf("A turn in %s (session %s) took %s.", o.Project, shortID(o.Session), took.Round(time.Second)),
+		})
+	}
+	w.turns = turns
+
+	now := time.Now()
+	idle := make(map[string]time.Time)
+	for _, sess := range live.Sessions {
+		if w.IdleLongerThan <= 0 || !sess.AwaitingInput || now.Sub(sess.LastActivity) < w.IdleLongerThan {
+			continue
+		}
+		idle[sess.ID] = sess.LastActivity
+		if since, ok := w.idle[sess.ID]; (ok && since.Equal(sess.LastActivity)) || !w.primed {
+			continue
+		}
+		msgs = append(msgs, Message{
+			Title: "Claude Code is waiting for you",
+			Body: fmt.Sprintf("Session %s in %s has been waiting for input for %s.",
+				shortID(sess.ID), sess.Project, now.Sub(sess.LastActivity).Round(time.Minute)),
+		})
+	}
+	w.idle = idle
+	w.primed = true
+
+	for _, m := range msgs {
+		go w.send(m)
+	}
+}
+
+func (w *Watcher) send(m Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	if err := w.Sink.Send(ctx, m); err != nil {
+		log.Printf("notify: %v", err)
+	}
+}
+
+// shortID shortens a session UUID to its first group.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
@@ -0,0 +1,118 @@
+// Package pricing estimates USD cost from token counts.
+package pricing
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+)
+
+// ModelPricing holds list prices in USD per million tokens, and per
+// thousand requests for web search.
+type ModelPricing struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheRead  float64 `json:"cache_read"`
+	CacheWrite float64 `json:"cache_write"`
+	WebSearch  float64 `json:"web_search"`
+}
+
+type rule struct {
+	match   string
+	pricing ModelPricing
+}
+
+// defaultPricing is matched in order against the normalized model name,
+// so more specific patterns must come first.
+var defaultPricing = []rule{
+	{"opus-4-5", ModelPricing{Input: 5, Output: 25, CacheRead: 0.5, CacheWrite: 6.25, WebSearch: 10}},
+	{"opus-4-6", ModelPricing{Input: 5, Output: 25, CacheRead: 0.5, CacheWrite: 6.25, WebSearch: 10}},
+	{"opus", ModelPricing{Input: 15, Output: 75, CacheRead: 1.5, CacheWrite: 18.75, WebSearch: 10}},
+	{"sonnet", ModelPricing{Input: 3, Output: 15, CacheRead: 0.3, CacheWrite: 3.75, WebSearch: 10}},
+	{"haiku-4", ModelPricing{Input: 1, Output: 5, CacheRead: 0.1, CacheWrite: 1.25, WebSearch: 10}},
+	{"3-5-haiku", ModelPricing{Input: 0.8, Output: 4, CacheRead: 0.08, CacheWrite: 1, WebSearch: 10}},
+	{"haiku", ModelPricing{Input: 0.25, Output: 1.25, CacheRead: 0.03, CacheWrite: 0.3, WebSearch: 10}},
+
+	// OpenAI models used by Codex CLI
+	{"gpt-5-nano", ModelPricing{Input: 0.05, Output: 0.4, CacheRead: 0.005}},
+	{"gpt-5-mini", ModelPricing{Input: 0.25, Output: 2, CacheRead: 0.025}},
+	{"gpt-5", ModelPricing{Input: 1.25, Output: 10, CacheRead: 0.125}},
+	{"o4-mini", ModelPricing{Input: 1.1, Output: 4.4, CacheRead: 0.275}},
+	{"o3", ModelPricing{Input: 2, Output: 8, CacheRead: 0.5}},
+	{"gpt-4-1", ModelPricing{Input: 2, Output: 8, CacheRead: 0.5}},
+
+	// Google models used by Gemini CLI
+	{"gemini-3-pro", ModelPricing{Input: 2, Output: 12, CacheRead: 0.2}},
+	{"gemini-2-5-pro", ModelPricing{Input: 1.25, Output: 10, CacheRead: 0.125}},
+	{"gemini-2-5-flash-lite", ModelPricing{Input: 0.1, Output: 0.4, CacheRead: 0.01}},
+	{"gemini-2-5-flash", ModelPricing{Input: 0.3, Output: 2.5, CacheRead: 0.03}},
+}
+
+// Table matches model names against pricing rules.
+type Table struct {
+	rules []rule
+}
+
+// Load returns the default table, with entries from the optional JSON
+// file (model substring -> prices) taking precedence.
+func Load(path string) (*Table, error) {
+	t := &Table{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		overrides := make(map[string]ModelPricing)
+		if err := json.Unmarshal(data, &overrides); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(overrides))
+		for k := range overrides {
+			keys = append(keys, k)
+		}
+		// Longest pattern wins when several overrides match
+		sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+		for _, k := range keys {
+			t.rules = append(t.rules, rule{match: model.Short(k), pricing: overrides[k]})
+		}
+	}
+	t.rules = append(t.rules, defaultPricing...)
+	return t, nil
+}
+
+// Lookup returns the prices for a normalized model name. Dots count as
+// dashes, so names renamed by model rules (e.g. "opus-4.5") still match.
+func (t *Table) Lookup(name string) (ModelPricing, bool) {
+	if t == nil {
+		return ModelPricing{}, false
+	}
+	name = strings.ReplaceAll(name, ".", "-")
+	for _, r := range t.rules {
+		if strings.Contains(name, r.match) {
+			return r.pricing, true
+		}
+	}
+	return ModelPricing{}, false
+}
+
+// Cost estimates the USD cost of the given token counts for a model.
+func (t *Table) Cost(name string, input, output, cacheRead, cacheCreate float64) float64 {
+	p, ok := t.Lookup(name)
+	if !ok {
+		return 0
+	}
+	return (input*p.Input + output*p.Output + cacheRead*p.CacheRead + cacheCreate*p.CacheWrite) / 1e6
+}
+
+// WebSearchCost estimates the USD cost of web search requests for a model,
+// billed per search on top of the tokens.
+func (t *Table) WebSearchCost(name string, searches float64) float64 {
+	p, ok := t.Lookup(name)
+	if !ok {
+		return 0
+	}
+	return searches * p.WebSearch / 1000
+}
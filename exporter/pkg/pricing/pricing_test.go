@@ -0,0 +1,107 @@
+package pricing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		model string
+		input float64 // 0 when unknown
+	}{
+		{"claude-opus-4-5-20251101", 5},
+		{"claude-opus-4.6", 5},
+		{"claude-opus-4-1-20250805", 15},
+		{"claude-sonnet-4-5-20250929", 3},
+		{"claude-haiku-4-5-20251001", 1},
+		{"claude-3-5-haiku-20241022", 0.8},
+		{"claude-3-haiku-20240307", 0.25},
+		{"gpt-5-mini", 0.25},
+		{"gpt-5-codex", 1.25},
+		{"gemini-2-5-flash-lite", 0.1},
+		{"gemini-2-5-flash", 0.3},
+		{"llama-3", 0},
+	}
+	table, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			p, ok := table.Lookup(tt.model)
+			if ok != (tt.input != 0) || p.Input != tt.input {
+				t.Errorf("input price %v (found %v), want %v", p.Input, ok, tt.input)
+			}
+		})
+	}
+	if _, ok := (*Table)(nil).Lookup("claude-opus-4-5"); ok {
+		t.Error("nil table found a price")
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	overrides := `{
+		"opus": {"input": 1, "output": 2},
+		"claude-opus-4.5": {"input": 3, "output": 4},
+		"my-model": {"input": 7}
+	}`
+	if err := os.WriteFile(path, []byte(overrides), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	table, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		model string
+		input float64
+	}{
+		// the longest matching override wins, over the defaults too
+		{"claude-opus-4-5-20251101", 3},
+		{"claude-opus-4-1", 1},
+		{"my-model-v2", 7},
+		{"claude-sonnet-4-5", 3},
+	}
+	for _, tt := range tests {
+		if p, _ := table.Lookup(tt.model); p.Input != tt.input {
+			t.Errorf("%s: input price %v, want %v", tt.model, p.Input, tt.input)
+		}
+	}
+
+	for name, content := range map[string]string{"not json": "opus: 1", "missing": ""} {
+		bad := filepath.Join(t.TempDir(), "pricing.json")
+		if content != "" {
+			os.WriteFile(bad, []byte(content), 0o644)
+		}
+		if _, err := Load(bad); err == nil {
+			t.Errorf("%s: loaded, want an error", name)
+		}
+	}
+}
+
+func TestCost(t *testing.T) {
+	table, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		// 1M each of input, output, cache read and cache write at sonnet prices
+		{"tokens", table.Cost("claude-sonnet-4-5", 1e6, 1e6, 1e6, 1e6), 3 + 15 + 0.3 + 3.75},
+		{"unknown model", table.Cost("llama-3", 1e6, 1e6, 0, 0), 0},
+		{"web search", table.WebSearchCost("claude-opus-4-6", 250), 2.5},
+		{"no web search price", table.WebSearchCost("gpt-5", 250), 0},
+	}
+	for _, tt := range tests {
+		if math.Abs(tt.got-tt.want) > 1e-9 {
+			t.Errorf("%s: cost %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
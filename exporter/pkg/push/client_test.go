@@ -0,0 +1,85 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestClientEnvelope(t *testing.T) {
+	var got []Envelope
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer secret" {
+			t.Errorf("Authorization %q, want the bearer token", auth)
+		}
+		var env Envelope
+		if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
+			t.Errorf("decode envelope: %v", err)
+		}
+		got = append(got, env)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	cost := prometheus.NewGauge(prometheus.GaugeOpts{Name: "claude_cost_usd", Help: "Total cost"})
+	cost.Set(2.5)
+	reg.MustRegister(cost)
+	c := &Client{URL: srv.URL, Token: "secret", Host: "laptop", User: "dev", Gatherer: reg}
+	for range 2 {
+		if err := c.Push(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("%d pushes received, want 2", len(got))
+	}
+	for i, env := range got {
+		if env.SchemaVersion != SchemaVersion || env.Host != "laptop" || env.User != "dev" {
+			t.Errorf("push %d: schema %d host %q user %q", i, env.SchemaVersion, env.Host, env.User)
+		}
+		if !strings.Contains(env.Metrics, "claude_cost_usd 2.5") {
+			t.Errorf("push %d: metrics %q lack claude_cost_usd", i, env.Metrics)
+		}
+		if env.SentAt.IsZero() {
+			t.Errorf("push %d: no sent_at", i)
+		}
+	}
+	// sequences start from the process start, so a restarted agent goes on
+	if got[0].Sequence < uint64(time.Now().Add(-time.Minute).UnixNano()) {
+		t.Errorf("first sequence %d is not from the start time", got[0].Sequence)
+	}
+	if got[1].Sequence <= got[0].Sequence {
+		t.Errorf("sequence went from %d to %d, want it increasing", got[0].Sequence, got[1].Sequence)
+	}
+}
+
+func TestClientToServer(t *testing.T) {
+	s := NewServer([]string{"secret"}, time.Minute)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	cost := prometheus.NewGauge(prometheus.GaugeOpts{Name: "claude_cost_usd", Help: "Total cost"})
+	cost.Set(2.5)
+	reg.MustRegister(cost)
+	c := &Client{URL: srv.URL + Path, Token: "secret", Host: "laptop", User: "dev", Gatherer: reg}
+	if err := c.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := gather(t, s)[`claude_cost_usd{host="laptop",user="dev"}`]; v != 2.5 {
+		t.Errorf("claude_cost_usd = %v on the server, want 2.5", v)
+	}
+
+	c.Token = "wrong"
+	if err := c.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("push with a wrong token: %v, want the server's 401", err)
+	}
+}
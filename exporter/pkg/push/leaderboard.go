@@ -0,0 +1,130 @@
+package push
+
+import (
+	"sort"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// --- leaderboard ---
+
+// MaxLeaderboardDays is the longest leaderboard window: agents keep 30
+// days of daily series.
+const MaxLeaderboardDays = 30
+
+// leaderboardTools is how many tools each leaderboard entry lists.
+const leaderboardTools = 5
+
+// ToolCalls is one tool of a leaderboard entry.
+type ToolCalls struct {
+	Tool  string `json:"tool"`
+	Calls int    `json:"calls"`
+}
+
+// LeaderboardEntry is the usage of one user, over all of their hosts.
+type LeaderboardEntry struct {
+	User         string             `json:"user"`
+	Hosts        []string           `json:"hosts"`
+	Tokens       float64            `json:"tokens"`
+	TokensByKind map[string]float64 `json:"tokens_by_kind"`
+	CostUSD      float64            `json:"cost_usd"`
+	// Sessions come from the stats cache, which Claude may update a day late
+	Sessions int         `json:"sessions"`
+	TopTools []ToolCalls `json:"top_tools"`
+}
+
+// Leaderboard sums the daily series of each user's agents over the days
+// from today back days-1 days, most expensive user first. Agents that
+// stopped pushing count until they are forgotten.
+func (s *Server) Leaderboard(days int, now time.Time) []LeaderboardEntry {
+	from := now.AddDate(0, 0, 1-days).Format("2006-01-02")
+	inWindow := func(m *dto.Metric) bool {
+		for _, lp := range m.GetLabel() {
+			if lp.GetName() == "date" {
+				return lp.GetValue() >= from
+			}
+		}
+		return false
+	}
+	label := func(m *dto.Metric, name string) string {
+		for _, lp := range m.GetLabel() {
+			if lp.GetName() == name {
+				return lp.GetValue()
+			}
+		}
+		return ""
+	}
+
+	users := make(map[string]*LeaderboardEntry)
+	tools := make(map[string]map[string]int)
+	s.mu.Lock()
+	for key, a := range s.agents {
+		if now.Sub(a.received) > forgetAfter {
+			continue
+		}
+		e := users[key.user]
+		if e == nil {
+			e = &LeaderboardEntry{User: key.user, TokensByKind: make(map[string]float64)}
+			users[key.user] = e
+			tools[key.user] = make(map[string]int)
+		}
+		e.Hosts = append(e.Hosts, key.host)
+		for _, m := range a.families["claude_daily_tokens_by_kind"].GetMetric() {
+			if inWindow(m) {
+				e.Tokens += m.GetGauge().GetValue()
+				e.TokensByKind[label(m, "kind")] += m.GetGauge().GetValue()
+			}
+		}
+		for _, m := range a.families["claude_daily_project_cost_usd"].GetMetric() {
+			if inWindow(m) {
+				e.CostUSD += m.GetGauge().GetValue()
+			}
+		}
+		for _, m := range a.families["claude_daily_sessions"].GetMetric() {
+			if inWindow(m) {
+				e.Sessions += int(m.GetGauge().GetValue())
+			}
+		}
+		for _, m := range a.families["claude_daily_tool_use"].GetMetric() {
+			if inWindow(m) {
+				tools[key.user][label(m, "tool")] += int(m.GetGauge().GetValue())
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	entries := make([]LeaderboardEntry, 0, len(users))
+	for user, e := range users {
+		sort.Strings(e.Hosts)
+		e.TopTools = topTools(tools[user], leaderboardTools)
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].CostUSD != entries[j].CostUSD {
+			return entries[i].CostUSD > entries[j].CostUSD
+		}
+		return entries[i].User < entries[j].User
+	})
+	return entries
+}
+
+// topTools returns the n most called tools, most called first.
+func topTools(calls map[string]int, n int) []ToolCalls {
+	out := make([]ToolCalls, 0, len(calls))
+	for tool, c := range calls {
+		if c > 0 {
+			out = append(out, ToolCalls{Tool: tool, Calls: c})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Calls != out[j].Calls {
+			return out[i].Calls > out[j].Calls
+		}
+		return out[i].Tool < out[j].Tool
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
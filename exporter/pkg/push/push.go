@@ -0,0 +1,33 @@
+// Package push carries metrics from per-developer agents to a central
+// server, so Prometheus only has to scrape the server.
+//
+// An agent POSTs an Envelope as JSON to the server's push endpoint with an
+// "Authorization: Bearer <token>" header. The envelope holds the agent's
+// identity, a sequence number and its metrics in the Prometheus text
+// format. The server re-exposes each agent's metrics with host and user
+// labels, and drops them once the agent stops pushing.
+package push
+
+import "time"
+
+// SchemaVersion is the envelope version this build speaks. Servers reject
+// envelopes of any other version.
+const SchemaVersion = 1
+
+// Path is the server endpoint agents push to.
+const Path = "/api/v1/push"
+
+// Envelope is one push from an agent.
+type Envelope struct {
+	SchemaVersion int `json:"schema_version"`
+	// Host and User identify the agent; together they key its series.
+	Host string `json:"host"`
+	User string `json:"user"`
+	// Sequence increases with every push of an agent process, starting
+	// from the process start time, so the server can drop replays and
+	// out-of-order pushes.
+	Sequence uint64    `json:"sequence"`
+	SentAt   time.Time `json:"sent_at"`
+	// Metrics is the agent's /metrics output in the text exposition format.
+	Metrics string `json:"metrics"`
+}
@@ -0,0 +1,204 @@
+package push
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// --- server ---
+
+// maxEnvelopeBytes bounds the size of one push.
+const maxEnvelopeBytes = 16 << 20
+
+// forgetAfter is how long a stale agent keeps reporting up=0 before it is
+// forgotten entirely.
+const forgetAfter = 24 * time.Hour
+
+type agentKey struct {
+	host string
+	user string
+}
+
+type agentState struct {
+	sequence uint64
+	received time.Time
+	families map[string]*dto.MetricFamily
+}
+
+// Server receives pushes from agents and exposes their metrics with host
+// and user labels. It is both an http.Handler for the push endpoint and a
+// prometheus.Collector.
+type Server struct {
+	tokens     []string
+	staleAfter time.Duration
+	now        func() time.Time
+
+	mu     sync.Mutex
+	agents map[agentKey]*agentState
+
+	up       *prometheus.Desc
+	lastPush *prometheus.Desc
+}
+
+// NewServer accepts pushes bearing any of tokens. An agent whose last push
+// is older than staleAfter has its metrics dropped.
+func NewServer(tokens []string, staleAfter time.Duration) *Server {
+	return &Server{
+		tokens:     tokens,
+		staleAfter: staleAfter,
+		now:        time.Now,
+		agents:     make(map[agentKey]*agentState),
+		up: prometheus.NewDesc("claude_exporter_agent_up",
+			"Whether the agent pushed within the staleness period", []string{"host", "user"}, nil),
+		lastPush: prometheus.NewDesc("claude_exporter_agent_last_push_timestamp_seconds",
+			"Unix time of the agent's last accepted push", []string{"host", "user"}, nil),
+	}
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return false
+	}
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var env Envelope
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEnvelopeBytes)).Decode(&env); err != nil {
+		http.Error(w, fmt.Sprintf("invalid envelope: %v", err), http.StatusBadRequest)
+		return
+	}
+	if env.SchemaVersion != SchemaVersion {
+		http.Error(w, fmt.Sprintf("unsupported schema version %d (want %d)", env.SchemaVersion, SchemaVersion), http.StatusBadRequest)
+		return
+	}
+	if env.Host == "" {
+		http.Error(w, "host is required", http.StatusBadRequest)
+		return
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(env.Metrics))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid metrics: %v", err), http.StatusBadRequest)
+		return
+	}
+	for name := range families {
+		if strings.HasPrefix(name, "claude_exporter_agent_") {
+			// reserved for the server's own series
+			delete(families, name)
+		}
+	}
+
+	key := agentKey{host: env.Host, user: env.User}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.agents[key]; ok && env.Sequence <= prev.sequence && now.Sub(prev.received) < s.staleAfter {
+		http.Error(w, "stale sequence", http.StatusConflict)
+		return
+	}
+	if _, ok := s.agents[key]; !ok {
+		log.Printf("push: new agent host=%s user=%s", env.Host, env.User)
+	}
+	s.agents[key] = &agentState{sequence: env.Sequence, received: now, families: families}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Describe sends nothing: agent metrics are only known once pushed.
+func (s *Server) Describe(ch chan<- *prometheus.Desc) {}
+
+func (s *Server) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for key, a := range s.agents {
+		age := now.Sub(a.received)
+		if age > forgetAfter {
+			delete(s.agents, key)
+			continue
+		}
+		up := 0.0
+		if age <= s.staleAfter {
+			up = 1
+			s.collectAgent(ch, key, a)
+		}
+		ch <- prometheus.MustNewConstMetric(s.up, prometheus.GaugeValue, up, key.host, key.user)
+		ch <- prometheus.MustNewConstMetric(s.lastPush, prometheus.GaugeValue,
+			float64(a.received.UnixNano())/1e9, key.host, key.user)
+	}
+}
+
+func (s *Server) collectAgent(ch chan<- prometheus.Metric, key agentKey, a *agentState) {
+	for name, mf := range a.families {
+		for _, m := range mf.GetMetric() {
+			names := []string{"host", "user"}
+			values := []string{key.host, key.user}
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == "host" || lp.GetName() == "user" {
+					continue
+				}
+				names = append(names, lp.GetName())
+				values = append(values, lp.GetValue())
+			}
+			desc := prometheus.NewDesc(name, mf.GetHelp(), names, nil)
+			metric, err := constMetric(desc, mf.GetType(), m, values)
+			if err != nil {
+				log.Printf("push: %s from %s: %v", name, key.host, err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+}
+
+func constMetric(desc *prometheus.Desc, typ dto.MetricType, m *dto.Metric, values []string) (prometheus.Metric, error) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		buckets := make(map[float64]uint64, len(h.GetBucket()))
+		for _, b := range h.GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, values...)
+	case dto.MetricType_SUMMARY:
+		sm := m.GetSummary()
+		quantiles := make(map[float64]float64, len(sm.GetQuantile()))
+		for _, q := range sm.GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, sm.GetSampleCount(), sm.GetSampleSum(), quantiles, values...)
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+	}
+}
@@ -0,0 +1,171 @@
+package push
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const testMetrics = `# HELP claude_cost_usd Total cost
+# TYPE claude_cost_usd gauge
+claude_cost_usd{model="opus"} 1.5
+# TYPE claude_exporter_agent_up gauge
+claude_exporter_agent_up 7
+`
+
+// envelope encodes a valid push of testMetrics with the given sequence.
+func envelope(t *testing.T, seq uint64) string {
+	t.Helper()
+	body, err := json.Marshal(Envelope{
+		SchemaVersion: SchemaVersion,
+		Host:          "laptop",
+		User:          "dev",
+		Sequence:      seq,
+		Metrics:       testMetrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func post(s *Server, token, body string) int {
+	req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestServeHTTPRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"no token", "", envelope(t, 1), http.StatusUnauthorized},
+		{"wrong token", "nope", envelope(t, 1), http.StatusUnauthorized},
+		{"not json", "secret", "metrics", http.StatusBadRequest},
+		{"other schema", "secret", `{"schema_version":2,"host":"laptop","sequence":1}`, http.StatusBadRequest},
+		{"no host", "secret", `{"schema_version":1,"sequence":1}`, http.StatusBadRequest},
+		{"bad metrics", "secret", `{"schema_version":1,"host":"laptop","sequence":1,"metrics":"claude_cost_usd{ 1"}`, http.StatusBadRequest},
+		{"valid", "secret", envelope(t, 1), http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer([]string{"other", "secret"}, time.Minute)
+			if got := post(s, tt.token, tt.body); got != tt.want {
+				t.Errorf("status %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	s := NewServer([]string{"secret"}, time.Minute)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, Path, nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET: status %d, Allow %q, want 405 and POST", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestServeHTTPSequence(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		after time.Duration // since the first push
+		seq   uint64
+		want  int
+	}{
+		{"repeated", time.Second, 10, http.StatusConflict},
+		{"older", time.Second, 9, http.StatusConflict},
+		{"newer", time.Second, 11, http.StatusNoContent},
+		// an agent whose clock went back is taken again once it is stale
+		{"older once stale", 2 * time.Minute, 9, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			s := NewServer([]string{"secret"}, time.Minute)
+			s.now = func() time.Time { return now }
+			if got := post(s, "secret", envelope(t, 10)); got != http.StatusNoContent {
+				t.Fatalf("first push: status %d", got)
+			}
+			now = start.Add(tt.after)
+			if got := post(s, "secret", envelope(t, tt.seq)); got != tt.want {
+				t.Errorf("sequence %d: status %d, want %d", tt.seq, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectStaleAndForget(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		after  time.Duration
+		known  bool // whether the agent is reported at all
+		up     float64
+		series bool // whether its pushed series are exposed
+	}{
+		{"fresh", 30 * time.Second, true, 1, true},
+		{"stale", 2 * time.Minute, true, 0, false},
+		{"forgotten", forgetAfter + time.Minute, false, 0, false},
+	}
+	const up = `claude_exporter_agent_up{host="laptop",user="dev"}`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start
+			s := NewServer([]string{"secret"}, time.Minute)
+			s.now = func() time.Time { return now }
+			if got := post(s, "secret", envelope(t, 1)); got != http.StatusNoContent {
+				t.Fatalf("push: status %d", got)
+			}
+			now = start.Add(tt.after)
+			// the pedantic registry also fails on the pushed
+			// claude_exporter_agent_up colliding with the server's
+			got := gather(t, s)
+			if v, ok := got[up]; ok != tt.known || v != tt.up {
+				t.Errorf("%s = %v (reported %v), want %v (reported %v)", up, v, ok, tt.up, tt.known)
+			}
+			v, ok := got[`claude_cost_usd{host="laptop",model="opus",user="dev"}`]
+			if ok != tt.series || (ok && v != 1.5) {
+				t.Errorf("claude_cost_usd = %v (exposed %v), want 1.5 (exposed %v)", v, ok, tt.series)
+			}
+		})
+	}
+}
+
+// gather returns the server's gauges as name{labels} to value.
+func gather(t *testing.T, s *Server) map[string]float64 {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(s)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(map[string]float64)
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			out[mf.GetName()+labels(m)] = m.GetGauge().GetValue()
+		}
+	}
+	return out
+}
+
+func labels(m *dto.Metric) string {
+	var parts []string
+	for _, lp := range m.GetLabel() {
+		parts = append(parts, lp.GetName()+`="`+lp.GetValue()+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,413 @@
+package remote
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- object storage ---
+
+// Bucket is a prefix in object storage that holds a Claude data directory,
+// e.g. session logs a CI job archives: stats-cache.json and projects/ right
+// under the prefix. s3:// URLs address S3 or an S3-compatible store,
+// gs:// URLs Google Cloud Storage through its XML API. Objects are listed
+// and fetched over plain HTTPS, with credentials from the environment.
+type Bucket struct {
+	Scheme string // s3 or gs
+	Name   string
+	Prefix string // empty, or ending in a slash
+	HTTP   *http.Client
+
+	mu          sync.Mutex
+	token       string // GCS access token from the metadata server
+	tokenExpiry time.Time
+}
+
+// parseBucket parses s3://bucket/prefix or gs://bucket/prefix.
+func parseBucket(spec string) (*Bucket, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid bucket URL %q", spec)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &Bucket{Scheme: u.Scheme, Name: u.Host, Prefix: prefix}, nil
+}
+
+// isBucketURL reports whether spec names object storage rather than an
+// SSH host.
+func isBucketURL(spec string) bool {
+	return strings.HasPrefix(spec, "s3://") || strings.HasPrefix(spec, "gs://")
+}
+
+// hostName is the host label of the bucket: its name and prefix.
+func (b *Bucket) hostName() string {
+	name := b.Name
+	if p := strings.Trim(b.Prefix, "/"); p != "" {
+		name += "_" + strings.ReplaceAll(p, "/", "_")
+	}
+	return name
+}
+
+// objectURL returns the URL of key, or of the bucket when key is empty.
+// AWS is addressed virtual-hosted style; custom endpoints and GCS path
+// style, which S3-compatible stores such as MinIO expect.
+func (b *Bucket) objectURL(key string, query url.Values) *url.URL {
+	var u *url.URL
+	path := "/" + key
+	bucketPath := "/" + b.Name
+	if key != "" {
+		bucketPath += path
+	}
+	switch endpoint := envFirst("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"); {
+	case b.Scheme == "gs":
+		u = &url.URL{Scheme: "https", Host: "storage.googleapis.com"}
+		path = bucketPath
+	case endpoint != "":
+		u, _ = url.Parse(strings.TrimSuffix(endpoint, "/"))
+		path = u.Path + bucketPath
+	default:
+		u = &url.URL{Scheme: "https", Host: b.Name + ".s3." + b.region() + ".amazonaws.com"}
+	}
+	u.Path = path
+	u.RawPath = awsEscape(path, true)
+	u.RawQuery = canonicalQuery(query)
+	return u
+}
+
+func (b *Bucket) region() string {
+	if b.Scheme == "gs" {
+		return "auto"
+	}
+	if r := envFirst("AWS_REGION", "AWS_DEFAULT_REGION"); r != "" {
+		return r
+	}
+	return "us-east-1"
+}
+
+// get sends a GET request for key, signed with the credentials the
+// environment holds.
+func (b *Bucket) get(ctx context.Context, key string, query url.Values, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if err := b.authorize(ctx, req); err != nil {
+		return nil, err
+	}
+	client := b.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s: %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// authorize signs req. S3 uses AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, or no signature for a public bucket. GCS uses
+// GOOGLE_OAUTH_ACCESS_TOKEN, then HMAC keys in GCS_ACCESS_KEY_ID and
+// GCS_SECRET_ACCESS_KEY, then the service account of the GCE or GKE
+// metadata server.
+func (b *Bucket) authorize(ctx context.Context, req *http.Request) error {
+	if b.Scheme == "s3" {
+		if key := os.Getenv("AWS_ACCESS_KEY_ID"); key != "" {
+			signV4(req, key, os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"), b.region(), time.Now())
+		}
+		return nil
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+	if key := os.Getenv("GCS_ACCESS_KEY_ID"); key != "" {
+		signV4(req, key, os.Getenv("GCS_SECRET_ACCESS_KEY"), "", b.region(), time.Now())
+		return nil
+	}
+	token, err := b.metadataToken(ctx)
+	if err != nil {
+		return fmt.Errorf("gcs credentials: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// metadataURL serves access tokens of the instance's service account.
+const metadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// metadataToken returns a cached access token, fetching a new one a minute
+// before it expires.
+func (b *Bucket) metadataToken(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token != "" && time.Until(b.tokenExpiry) > time.Minute {
+		return b.token, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("no GOOGLE_OAUTH_ACCESS_TOKEN or GCS_ACCESS_KEY_ID, and no metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	b.token = token.AccessToken
+	b.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return b.token, nil
+}
+
+// --- listing and fetching ---
+
+type listBucketResult struct {
+	IsTruncated bool
+	NextMarker  string
+	Contents    []struct {
+		Key          string
+		LastModified time.Time
+		Size         int64
+	}
+}
+
+// list returns the stats cache and session logs under the prefix, with
+// paths relative to it. It uses the original ListObjects call, which GCS
+// supports as well as S3.
+func (b *Bucket) list(ctx context.Context) ([]remoteFile, error) {
+	var files []remoteFile
+	marker := ""
+	for {
+		query := url.Values{"prefix": {b.Prefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := b.get(ctx, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(io.LimitReader(resp.Body, 64<<20)).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list: %w", err)
+		}
+		for _, obj := range page.Contents {
+			rel := filepath.FromSlash(strings.TrimPrefix(obj.Key, b.Prefix))
+			if !filepath.IsLocal(rel) || !mirrored(rel) {
+				continue
+			}
+			files = append(files, remoteFile{path: rel, size: obj.Size, mtime: obj.LastModified})
+		}
+		if !page.IsTruncated || len(page.Contents) == 0 {
+			return files, nil
+		}
+		marker = page.NextMarker
+		if marker == "" {
+			marker = page.Contents[len(page.Contents)-1].Key
+		}
+	}
+}
+
+// mirrored reports whether a path under the prefix is one the SSH listing
+// would pick: the stats cache or a session log under projects.
+func mirrored(rel string) bool {
+	if rel == "stats-cache.json" {
+		return true
+	}
+	if !strings.HasPrefix(rel, "projects"+string(filepath.Separator)) {
+		return false
+	}
+	for _, ext := range []string{".jsonl", ".jsonl.gz", ".jsonl.zst"} {
+		if strings.HasSuffix(rel, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncBucket brings the mirror of a bucket host up to date, fetching the
+// appended range of grown session logs, unless they were rewritten, and
+// whole objects otherwise.
+func (s *Syncer) syncBucket(ctx context.Context, h Host) (int64, error) {
+	files, err := h.Bucket.list(ctx)
+	if err != nil {
+		return 0, err
+	}
+	prune(h, files)
+	var fetched int64
+	fetches := plan(h, files)
+	for len(fetches) > 0 {
+		f := fetches[0]
+		fetches = fetches[1:]
+		header := http.Header{}
+		if f.offset > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-%d", f.start(), f.size-1))
+		}
+		resp, err := h.Bucket.get(ctx, h.Bucket.Prefix+filepath.ToSlash(f.path), nil, header)
+		if err != nil {
+			return fetched, err
+		}
+		if f.offset > 0 && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return fetched, errors.New("range requests not supported")
+		}
+		// the object may have grown since it was listed; the rest comes
+		// with the next sync
+		body := io.MultiReader(io.LimitReader(resp.Body, f.size-f.start()), strings.NewReader(endMarker))
+		err = receive(h, f, body)
+		resp.Body.Close()
+		if err != nil && !errors.Is(err, errRewritten) {
+			return fetched, err
+		}
+		fetched += f.size - f.start()
+		if err != nil {
+			log.Printf("remote %s: %v, fetching it whole", h.Name, err)
+			f.offset = 0
+			fetches = append(fetches, f)
+		}
+	}
+	return fetched, nil
+}
+
+// --- Signature Version 4 ---
+
+// unsignedPayload skips hashing the body, which GET requests don't have.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// signV4 adds an AWS Signature Version 4 to req. The URL must already be
+// in canonical form, see objectURL.
+func signV4(req *http.Request, accessKey, secretKey, sessionToken, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		canonicalHeaders.String(), signedHeaders, unsignedPayload,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// slashes when keepSlash is set, as SigV4 requires.
+func awsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by key, as SigV4 requires.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// envFirst returns the first of the variables that is set.
+func envFirst(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 serves ListObjects and ranged GETs of the objects of one bucket,
+// at most two keys per listing page.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	ranged  []string // Range headers of the GETs, in order
+}
+
+type fakeObject struct {
+	content string
+	mtime   time.Time
+}
+
+func (s *fakeS3) put(key, content string, mtime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = fakeObject{content, mtime}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := strings.CutPrefix(r.URL.Path, "/archive/")
+	if !ok {
+		var page listBucketResult
+		var keys []string
+		for k := range s.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("marker") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		if len(keys) > 2 {
+			keys, page.IsTruncated = keys[:2], true
+		}
+		for _, k := range keys {
+			page.Contents = append(page.Contents, struct {
+				Key          string
+				LastModified time.Time
+				Size         int64
+			}{k, s.objects[k].mtime, int64(len(s.objects[k].content))})
+		}
+		xml.NewEncoder(w).Encode(page)
+		return
+	}
+	obj, ok := s.objects[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	s.ranged = append(s.ranged, r.Header.Get("Range"))
+	http.ServeContent(w, r, key, obj.mtime, strings.NewReader(obj.content))
+}
+
+func TestSyncBucket(t *testing.T) {
+	s3 := &fakeS3{objects: make(map[string]fakeObject)}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	t.Setenv("AWS_ENDPOINT_URL_S3", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+
+	hosts, err := ParseHosts([]string{"s3://archive/ci"}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hosts[0]
+	s := NewSyncer(hosts, nil)
+	then := time.Unix(1773144000, 0).UTC()
+	session := "projects/-home-dev-app/s1.jsonl"
+	first := strings.Repeat(`{"type":"user"}`+"\n", 40)
+	appended := first + `{"type":"assistant"}` + "\n"
+	compacted := strings.Repeat(`{"type":"summary"}`+"\n", 50)
+
+	steps := []struct {
+		name   string
+		files  map[string]string
+		ranged []string // Range headers sent
+	}{
+		{"initial", map[string]string{"stats-cache.json": `{"version":2}`, session: first, "other.txt": "skipped"}, []string{"", ""}},
+		{"unchanged", nil, nil},
+		{"appended", map[string]string{session: appended}, []string{"bytes=" + strconv.Itoa(len(first)-overlapBytes) + "-" + strconv.Itoa(len(appended)-1)}},
+		{"rewritten", map[string]string{session: compacted}, []string{"bytes=" + strconv.Itoa(len(appended)-overlapBytes) + "-" + strconv.Itoa(len(compacted)-1), ""}},
+	}
+	for i, step := range steps {
+		mtime := then.Add(time.Duration(i) * time.Minute)
+		for path, content := range step.files {
+			s3.put("ci/"+path, content, mtime)
+		}
+		s3.ranged = nil
+		if _, err := s.Sync(context.Background(), h); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if strings.Join(s3.ranged, ",") != strings.Join(step.ranged, ",") {
+			t.Errorf("%s: GETs with ranges %q, want %q", step.name, s3.ranged, step.ranged)
+		}
+		for _, path := range []string{"stats-cache.json", session} {
+			got, _ := os.ReadFile(filepath.Join(h.Dir, path))
+			if want := s3.objects["ci/"+path].content; !bytes.Equal(got, []byte(want)) {
+				t.Errorf("%s: mirror of %s is %q, want %q", step.name, path, got, want)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(h.Dir, "other.txt")); !os.IsNotExist(err) {
+		t.Errorf("object outside the Claude layout mirrored: %v", err)
+	}
+}
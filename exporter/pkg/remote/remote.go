@@ -0,0 +1,459 @@
+// Package remote mirrors the Claude Code data of remote hosts over SSH, or
+// of archives in object storage, so one exporter can report for developers
+// who won't run anything locally.
+//
+// Each sync lists the stats cache and session logs of a host with find and
+// stat, then fetches only the bytes appended since the last sync with tail
+// and head, in one SSH session per step. A file whose bytes before the
+// append no longer match the mirror was rewritten and is fetched whole. The remote needs nothing but a
+// POSIX shell (GNU or BSD stat). The mirror keeps the remote modification
+// times, which the session source uses to tell live files apart. Buckets
+// are mirrored the same way over HTTPS, see Bucket.
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Host is a remote machine whose Claude data is mirrored into Dir.
+type Host struct {
+	Name      string // value of the host label
+	Target    string // SSH destination, [user@]host, or bucket URL
+	ClaudeDir string // Claude data directory on the host, relative to its home
+	Dir       string // local mirror
+	// Bucket is set, instead of ClaudeDir, for object storage
+	Bucket *Bucket
+}
+
+// ParseHosts parses "[user@]host[:claude_dir]" specs, mirroring each host
+// under cacheDir. The Claude directory defaults to ~/.claude. Specs can
+// also be s3:// or gs:// URLs of a bucket prefix, see Bucket.
+func ParseHosts(specs []string, cacheDir string) ([]Host, error) {
+	var hosts []Host
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		if isBucketURL(spec) {
+			b, err := parseBucket(spec)
+			if err != nil {
+				return nil, err
+			}
+			name := b.hostName()
+			if seen[name] {
+				return nil, fmt.Errorf("duplicate host %q", name)
+			}
+			seen[name] = true
+			hosts = append(hosts, Host{Name: name, Target: spec, Bucket: b, Dir: filepath.Join(cacheDir, name)})
+			continue
+		}
+		target, dir, _ := strings.Cut(spec, ":")
+		if dir == "" {
+			dir = ".claude"
+		}
+		dir = strings.TrimPrefix(dir, "~/")
+		name := target
+		if _, host, ok := strings.Cut(target, "@"); ok {
+			name = host
+		}
+		if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return nil, fmt.Errorf("invalid host %q", spec)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate host %q", name)
+		}
+		seen[name] = true
+		hosts = append(hosts, Host{Name: name, Target: target, ClaudeDir: dir, Dir: filepath.Join(cacheDir, name)})
+	}
+	return hosts, nil
+}
+
+// --- listing ---
+
+// remoteFile is one file to mirror, with its path relative to ClaudeDir.
+type remoteFile struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+// listScript prints "size mtime path" for the stats cache and every session
+// log under the working directory.
+const listScript = `
+if stat -c %s . >/dev/null 2>&1; then set -- -c '%s %Y %n'; else set -- -f '%z %m %N'; fi
+[ -f stats-cache.json ] && stat "$@" ./stats-cache.json
+[ -d projects ] && find ./projects -type f \( -name '*.jsonl' -o -name '*.jsonl.gz' -o -name '*.jsonl.zst' \) -exec stat "$@" {} +
+exit 0
+`
+
+func parseListing(out []byte) ([]remoteFile, error) {
+	var files []remoteFile
+	for _, line := range strings.Split(string(out), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected listing line %q", line)
+		}
+		size, err1 := strconv.ParseInt(fields[0], 10, 64)
+		mtime, err2 := strconv.ParseInt(fields[1], 10, 64)
+		path := filepath.Clean(fields[2])
+		if err1 != nil || err2 != nil || !filepath.IsLocal(path) {
+			return nil, fmt.Errorf("unexpected listing line %q", line)
+		}
+		files = append(files, remoteFile{path: path, size: size, mtime: time.Unix(mtime, 0)})
+	}
+	return files, nil
+}
+
+// --- fetching ---
+
+// fetch is the part of a file to copy: the bytes from offset to size,
+// appended in place, or the whole file replaced when offset is 0.
+type fetch struct {
+	remoteFile
+	offset int64
+}
+
+// overlapBytes is how many bytes before the offset of an append are
+// fetched again, to check the mirror still matches the remote file.
+const overlapBytes = 256
+
+// start is where the fetched bytes begin: the overlap before the offset.
+func (f fetch) start() int64 {
+	return f.offset - min(f.offset, overlapBytes)
+}
+
+// errRewritten is returned by receive for a file that no longer matches
+// the mirror before the offset, e.g. one compacted and then grown past its
+// old size. It has to be fetched whole.
+var errRewritten = errors.New("rewritten since the last sync")
+
+// endMarker follows every file in the fetch stream, to catch a file that
+// shrank between listing and fetching.
+const endMarker = "\n--cc-exporter-end--\n"
+
+// plan compares the listing with the mirror. Session logs that grew are
+// appended to, unless receive finds them rewritten; anything else that
+// changed is fetched whole.
+func plan(h Host, files []remoteFile) []fetch {
+	var fetches []fetch
+	for _, f := range files {
+		info, err := os.Stat(filepath.Join(h.Dir, f.path))
+		if err == nil && info.Size() == f.size && info.ModTime().Equal(f.mtime) {
+			continue
+		}
+		offset := int64(0)
+		if err == nil && strings.HasSuffix(f.path, ".jsonl") && info.Size() < f.size {
+			offset = info.Size()
+		}
+		fetches = append(fetches, fetch{remoteFile: f, offset: offset})
+	}
+	return fetches
+}
+
+func fetchScript(fetches []fetch) string {
+	var b strings.Builder
+	for _, f := range fetches {
+		fmt.Fprintf(&b, "tail -c +%d %s | head -c %d; printf '%%s' %s\n",
+			f.start()+1, shellQuote(f.path), f.size-f.start(), shellQuote(endMarker))
+	}
+	return b.String()
+}
+
+// receive writes one file from the fetch stream into the mirror. An append
+// whose overlap doesn't match the mirror is skipped in the stream and
+// reported as errRewritten.
+func receive(h Host, f fetch, r io.Reader) error {
+	local := filepath.Join(h.Dir, f.path)
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return err
+	}
+	n := f.size - f.offset
+	if f.offset > 0 {
+		// appended in place, keeping the inode the session source tails
+		out, err := os.OpenFile(local, os.O_RDWR, 0o644)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		overlap := make([]byte, f.offset-f.start())
+		if _, err := io.ReadFull(r, overlap); err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		mirrored := make([]byte, len(overlap))
+		if _, err := out.ReadAt(mirrored, f.start()); err != nil || !bytes.Equal(mirrored, overlap) {
+			if err := copyChecked(io.Discard, r, n); err != nil {
+				return fmt.Errorf("%s: %w", f.path, err)
+			}
+			return fmt.Errorf("%s: %w", f.path, errRewritten)
+		}
+		if _, err := out.Seek(f.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if err := copyChecked(out, r, n); err != nil {
+			out.Truncate(f.offset)
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		return os.Chtimes(local, f.mtime, f.mtime)
+	}
+
+	// replaced whole, so readers never see a half-written file
+	out, err := os.CreateTemp(filepath.Dir(local), ".sync-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if err := copyChecked(out, r, n); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %w", f.path, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(out.Name(), f.mtime, f.mtime); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), local)
+}
+
+// copyChecked copies n bytes followed by endMarker.
+func copyChecked(w io.Writer, r io.Reader, n int64) error {
+	if _, err := io.CopyN(w, r, n); err != nil {
+		return err
+	}
+	marker := make([]byte, len(endMarker))
+	if _, err := io.ReadFull(r, marker); err != nil || string(marker) != endMarker {
+		return errors.New("file changed during sync")
+	}
+	return nil
+}
+
+// prune removes mirrored files the host no longer has.
+func prune(h Host, files []remoteFile) {
+	keep := make(map[string]bool, len(files))
+	for _, f := range files {
+		keep[filepath.Join(h.Dir, f.path)] = true
+	}
+	filepath.WalkDir(filepath.Join(h.Dir, "projects"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && !keep[path] {
+			os.Remove(path)
+		}
+		return nil
+	})
+	if stats := filepath.Join(h.Dir, "stats-cache.json"); !keep[stats] {
+		os.Remove(stats)
+	}
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// --- syncer ---
+
+type hostStatus struct {
+	ok       bool
+	since    time.Time // first sync, when bytes started counting
+	lastSync time.Time // of the last successful sync
+	bytes    int64     // fetched since start
+}
+
+// Syncer keeps the mirrors of its hosts up to date and reports their sync
+// status as a prometheus.Collector.
+type Syncer struct {
+	Hosts []Host
+	// SSH is the ssh command and its options, e.g. ["ssh", "-o", "BatchMode=yes"]
+	SSH []string
+
+	mu     sync.Mutex
+	status map[string]*hostStatus
+
+	up       *prometheus.Desc
+	lastSync *prometheus.Desc
+	fetched  *prometheus.Desc
+}
+
+func NewSyncer(hosts []Host, ssh []string) *Syncer {
+	return &Syncer{
+		Hosts:  hosts,
+		SSH:    ssh,
+		status: make(map[string]*hostStatus),
+		up: prometheus.NewDesc("claude_exporter_remote_up",
+			"Whether the last sync of the remote host succeeded", []string{"host"}, nil),
+		lastSync: prometheus.NewDesc("claude_exporter_remote_last_sync_timestamp_seconds",
+			"Unix time of the remote host's last successful sync", []string{"host"}, nil),
+		fetched: prometheus.NewDesc("claude_exporter_remote_fetched_bytes_total",
+			"Bytes fetched from the remote host since the exporter started", []string{"host"}, nil),
+	}
+}
+
+// ssh runs script with sh on the host and returns its output stream.
+func (s *Syncer) ssh(ctx context.Context, h Host, script string) *exec.Cmd {
+	args := append(append([]string(nil), s.SSH[1:]...), h.Target, "sh -s")
+	cmd := exec.CommandContext(ctx, s.SSH[0], args...)
+	cmd.Stdin = strings.NewReader("cd -- " + shellQuote(h.ClaudeDir) + " || exit 1\n" + script)
+	cmd.Stderr = &limitedBuffer{max: 4096}
+	return cmd
+}
+
+// Sync brings the mirror of h up to date.
+func (s *Syncer) Sync(ctx context.Context, h Host) (int64, error) {
+	if h.Bucket != nil {
+		return s.syncBucket(ctx, h)
+	}
+	cmd := s.ssh(ctx, h, listScript)
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("list: %w: %s", err, cmd.Stderr)
+	}
+	files, err := parseListing(out)
+	if err != nil {
+		return 0, err
+	}
+	prune(h, files)
+	fetched, rewritten, err := s.fetch(ctx, h, plan(h, files))
+	if err != nil || len(rewritten) == 0 {
+		return fetched, err
+	}
+	n, _, err := s.fetch(ctx, h, rewritten)
+	return fetched + n, err
+}
+
+// fetch copies fetches from h into the mirror in one SSH session. It
+// returns the files found rewritten, to be fetched again whole.
+func (s *Syncer) fetch(ctx context.Context, h Host, fetches []fetch) (int64, []fetch, error) {
+	if len(fetches) == 0 {
+		return 0, nil, nil
+	}
+	cmd := s.ssh(ctx, h, fetchScript(fetches))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, nil, err
+	}
+	r := bufio.NewReaderSize(stdout, 64*1024)
+	var fetched int64
+	var rewritten []fetch
+	for _, f := range fetches {
+		err = receive(h, f, r)
+		if err != nil && !errors.Is(err, errRewritten) {
+			break
+		}
+		fetched += f.size - f.start()
+		if err != nil {
+			log.Printf("remote %s: %v, fetching it whole", h.Name, err)
+			f.offset = 0
+			rewritten = append(rewritten, f)
+			err = nil
+		}
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fetched, nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fetched, nil, fmt.Errorf("fetch: %w: %s", err, cmd.Stderr)
+	}
+	return fetched, rewritten, nil
+}
+
+// Run syncs every host every interval until ctx is done.
+func (s *Syncer) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+	for _, h := range s.Hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				n, err := s.Sync(ctx, h)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("remote %s: %v", h.Name, err)
+				}
+				s.record(h.Name, n, err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Syncer) record(host string, n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.status[host]
+	if !ok {
+		st = &hostStatus{since: time.Now()}
+		s.status[host] = st
+	}
+	st.ok = err == nil
+	st.bytes += n
+	if err == nil {
+		st.lastSync = time.Now()
+	}
+}
+
+func (s *Syncer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.up
+	ch <- s.lastSync
+	ch <- s.fetched
+}
+
+func (s *Syncer) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for host, st := range s.status {
+		up := 0.0
+		if st.ok {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(s.up, prometheus.GaugeValue, up, host)
+		if !st.lastSync.IsZero() {
+			ch <- prometheus.MustNewConstMetric(s.lastSync, prometheus.GaugeValue,
+				float64(st.lastSync.UnixNano())/1e9, host)
+		}
+		ch <- prometheus.MustNewConstMetricWithCreatedTimestamp(s.fetched, prometheus.CounterValue,
+			float64(st.bytes), st.since, host)
+	}
+}
+
+// limitedBuffer keeps the start of ssh's stderr for error messages.
+type limitedBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	return strings.TrimSpace(b.buf.String())
+}
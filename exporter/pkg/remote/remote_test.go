@@ -0,0 +1,284 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHosts(t *testing.T) {
+	tests := []struct {
+		spec    string
+		name    string
+		target  string
+		dir     string
+		wantErr bool
+	}{
+		{spec: "dev@laptop", name: "laptop", target: "dev@laptop", dir: ".claude"},
+		{spec: "laptop:~/work/.claude", name: "laptop", target: "laptop", dir: "work/.claude"},
+		{spec: "s3://archive/ci/claude", name: "archive_ci_claude", target: "s3://archive/ci/claude"},
+		{spec: "dev@", wantErr: true},
+		{spec: "../up", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			hosts, err := ParseHosts([]string{tt.spec}, "/cache")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsed as %+v, want an error", hosts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := hosts[0]
+			if h.Name != tt.name || h.Target != tt.target || h.ClaudeDir != tt.dir || h.Dir != filepath.Join("/cache", tt.name) {
+				t.Errorf("got %+v", h)
+			}
+		})
+	}
+	if _, err := ParseHosts([]string{"a@laptop", "b@laptop"}, "/cache"); err == nil {
+		t.Error("two specs of one host parsed, want an error")
+	}
+}
+
+func TestParseListing(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    []remoteFile
+		wantErr bool
+	}{
+		{
+			name: "gnu and bsd stat",
+			out:  "120 1773144000 ./stats-cache.json\n3456 1773147600 ./projects/-home-dev-my app/s1.jsonl\n\n",
+			want: []remoteFile{
+				{path: "stats-cache.json", size: 120, mtime: time.Unix(1773144000, 0)},
+				{path: "projects/-home-dev-my app/s1.jsonl", size: 3456, mtime: time.Unix(1773147600, 0)},
+			},
+		},
+		{name: "empty", out: ""},
+		{name: "missing field", out: "120 ./stats-cache.json\n", wantErr: true},
+		{name: "not a size", out: "big 1773144000 ./stats-cache.json\n", wantErr: true},
+		{name: "outside the directory", out: "120 1773144000 ../../etc/passwd\n", wantErr: true},
+		{name: "absolute", out: "120 1773144000 /etc/passwd\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListing([]byte(tt.out))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parsed as %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// mirror writes files into a fresh mirror directory, modified at mtime.
+func mirror(t *testing.T, files map[string]string, mtime time.Time) Host {
+	t.Helper()
+	h := Host{Name: "laptop", Dir: t.TempDir()}
+	for path, content := range files {
+		local := filepath.Join(h.Dir, path)
+		if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(local, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(local, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+func TestPlan(t *testing.T) {
+	then := time.Unix(1773144000, 0)
+	later := then.Add(time.Minute)
+	h := mirror(t, map[string]string{
+		"stats-cache.json":      `{"version":2}`,
+		"projects/p/s1.jsonl":   "0123456789",
+		"projects/p/s2.jsonl":   "0123456789",
+		"projects/p/s3.jsonl":   "0123456789",
+		"projects/p/old.jsonl":  "0123456789",
+		"projects/p/a.jsonl.gz": "0123456789",
+	}, then)
+
+	tests := []struct {
+		name string
+		file remoteFile
+		want []fetch // nil when up to date
+	}{
+		{"unchanged", remoteFile{"projects/p/old.jsonl", 10, then}, nil},
+		{"grown session log", remoteFile{"projects/p/s1.jsonl", 25, later}, []fetch{{remoteFile{"projects/p/s1.jsonl", 25, later}, 10}}},
+		{"shrunk", remoteFile{"projects/p/s2.jsonl", 4, later}, []fetch{{remoteFile{"projects/p/s2.jsonl", 4, later}, 0}}},
+		{"same size, touched", remoteFile{"projects/p/s3.jsonl", 10, later}, []fetch{{remoteFile{"projects/p/s3.jsonl", 10, later}, 0}}},
+		{"new", remoteFile{"projects/p/new.jsonl", 7, later}, []fetch{{remoteFile{"projects/p/new.jsonl", 7, later}, 0}}},
+		{"grown archive", remoteFile{"projects/p/a.jsonl.gz", 30, later}, []fetch{{remoteFile{"projects/p/a.jsonl.gz", 30, later}, 0}}},
+		{"grown stats cache", remoteFile{"stats-cache.json", 40, later}, []fetch{{remoteFile{"stats-cache.json", 40, later}, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := plan(h, []remoteFile{tt.file}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReceive(t *testing.T) {
+	then := time.Unix(1773144000, 0)
+	later := then.Add(time.Minute)
+	old := strings.Repeat("a", 300) + "\n"
+	tests := []struct {
+		name    string
+		mirror  string // "" when the file isn't mirrored yet
+		remote  string
+		offset  int64
+		cut     int // bytes of the stream lost, e.g. the file shrank
+		want    string
+		wantErr error
+	}{
+		{name: "new", remote: "line 1\n", want: "line 1\n"},
+		{name: "replaced", mirror: "line 1\n", remote: "other\n", want: "other\n"},
+		{name: "appended", mirror: old, remote: old + "line 2\n", offset: int64(len(old)), want: old + "line 2\n"},
+		{name: "appended to a short file", mirror: "x\n", remote: "x\nline 2\n", offset: 2, want: "x\nline 2\n"},
+		{
+			name:   "rewritten and grown past the mirror",
+			mirror: old, remote: strings.Repeat("b", 300) + "\ncompacted\n", offset: int64(len(old)),
+			want: old, wantErr: errRewritten,
+		},
+		{name: "cut short", mirror: old, remote: old + "line 2\n", offset: int64(len(old)), cut: 3, want: old, wantErr: errors.New("")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{}
+			if tt.mirror != "" {
+				files["projects/p/s.jsonl"] = tt.mirror
+			}
+			h := mirror(t, files, then)
+			f := fetch{remoteFile{"projects/p/s.jsonl", int64(len(tt.remote)), later}, tt.offset}
+			// the stream as fetchScript has the remote send it, followed by
+			// the next file's data
+			stream := tt.remote[f.start() : len(tt.remote)-tt.cut]
+			r := strings.NewReader(stream + endMarker + "next")
+
+			err := receive(h, f, r)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("receive: %v", err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("receive succeeded, want an error")
+			case errors.Is(tt.wantErr, errRewritten) && !errors.Is(err, errRewritten):
+				t.Fatalf("receive: %v, want errRewritten", err)
+			}
+			got, err := os.ReadFile(filepath.Join(h.Dir, f.path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("mirror holds %q, want %q", got, tt.want)
+			}
+			if errors.Is(tt.wantErr, errRewritten) {
+				// skipped to the next file, so the stream stays in step
+				if rest, _ := io.ReadAll(r); string(rest) != "next" {
+					t.Errorf("stream left at %q, want the next file", rest)
+				}
+			}
+			if tt.wantErr == nil {
+				if info, _ := os.Stat(filepath.Join(h.Dir, f.path)); !info.ModTime().Equal(later) {
+					t.Errorf("mirror modified at %v, want the remote's %v", info.ModTime(), later)
+				}
+			}
+		})
+	}
+}
+
+// localSyncer runs the sync scripts with sh on this machine, standing in
+// for ssh: each Host's ClaudeDir is then a local directory.
+func localSyncer(hosts []Host) *Syncer {
+	return NewSyncer(hosts, []string{"sh", "-c", "exec sh -s"})
+}
+
+func writeRemote(t *testing.T, dir, path, content string, mtime time.Time) {
+	t.Helper()
+	file := filepath.Join(dir, path)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncSSH(t *testing.T) {
+	remote := t.TempDir()
+	h := Host{Name: "laptop", Target: "laptop", ClaudeDir: remote, Dir: t.TempDir()}
+	s := localSyncer([]Host{h})
+	then := time.Unix(1773144000, 0)
+	session := "projects/-home-dev-app/s1.jsonl"
+	first := strings.Repeat(`{"type":"user"}`+"\n", 40)
+	appended := first + `{"type":"assistant"}` + "\n"
+	compacted := strings.Repeat(`{"type":"summary"}`+"\n", 50)
+
+	steps := []struct {
+		name    string
+		files   map[string]string
+		fetched int64
+	}{
+		{"initial", map[string]string{"stats-cache.json": `{"version":2}`, session: first}, int64(len(`{"version":2}`) + len(first))},
+		{"unchanged", nil, 0},
+		{"appended", map[string]string{session: appended}, int64(len(appended)-len(first)) + overlapBytes},
+		// compacted, then grown past its old size: what looked appended,
+		// then the whole file
+		{"rewritten", map[string]string{session: compacted}, int64(len(compacted)-len(appended)) + overlapBytes + int64(len(compacted))},
+	}
+	for i, step := range steps {
+		mtime := then.Add(time.Duration(i) * time.Minute)
+		for path, content := range step.files {
+			writeRemote(t, remote, path, content, mtime)
+		}
+		n, err := s.Sync(context.Background(), h)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if n != step.fetched {
+			t.Errorf("%s: fetched %d bytes, want %d", step.name, n, step.fetched)
+		}
+		for _, path := range []string{"stats-cache.json", session} {
+			want, _ := os.ReadFile(filepath.Join(remote, path))
+			got, _ := os.ReadFile(filepath.Join(h.Dir, path))
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: mirror of %s is %q, want %q", step.name, path, got, want)
+			}
+		}
+	}
+
+	os.Remove(filepath.Join(remote, session))
+	if _, err := s.Sync(context.Background(), h); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(h.Dir, session)); !os.IsNotExist(err) {
+		t.Errorf("mirror of a removed session log kept: %v", err)
+	}
+}
@@ -0,0 +1,187 @@
+// Package remotewrite sends samples with their own timestamps to a
+// Prometheus remote_write endpoint (Prometheus with
+// --web.enable-remote-write-receiver, VictoriaMetrics, Mimir, ...), for
+// history that a scrape could only ever record at "now".
+//
+// It speaks remote write 1.0: a snappy-compressed protobuf WriteRequest.
+// The message is small enough to encode by hand instead of pulling in the
+// Prometheus server module for prompb.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxSamples bounds the samples of one request, as Prometheus' own queue
+// does (max_samples_per_send defaults to 2000).
+const maxSamples = 2000
+
+// Sample is one value at a Unix time in milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Series is a label set, including __name__, and its samples in time order.
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// FromFamilies groups the gauge, counter and untyped samples of families
+// into series. Samples without a timestamp are skipped, as are other metric
+// types; remote write of history has no use for either.
+func FromFamilies(families []*dto.MetricFamily) []Series {
+	var out []Series
+	for _, mf := range families {
+		byLabels := make(map[string]int) // label key -> index in out
+		for _, m := range mf.GetMetric() {
+			if m.TimestampMs == nil {
+				continue
+			}
+			var v float64
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				v = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				v = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				v = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			labels := map[string]string{"__name__": mf.GetName()}
+			var key bytes.Buffer
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+				fmt.Fprintf(&key, "%s=%q,", lp.GetName(), lp.GetValue())
+			}
+			i, ok := byLabels[key.String()]
+			if !ok {
+				i = len(out)
+				byLabels[key.String()] = i
+				out = append(out, Series{Labels: labels})
+			}
+			out[i].Samples = append(out[i].Samples, Sample{Value: v, Timestamp: m.GetTimestampMs()})
+		}
+	}
+	for _, s := range out {
+		sort.Slice(s.Samples, func(i, j int) bool { return s.Samples[i].Timestamp < s.Samples[j].Timestamp })
+	}
+	return out
+}
+
+// Client writes series to one endpoint.
+type Client struct {
+	URL   string // e.g. http://prometheus:9090/api/v1/write
+	Token string // bearer token, if any; basic auth can go in URL
+	HTTP  *http.Client
+}
+
+// Write sends series in requests of up to maxSamples samples. A series is
+// never split across requests unless it alone exceeds the limit, in which
+// case its samples are sent in order.
+func (c *Client) Write(ctx context.Context, series []Series) error {
+	var batch []Series
+	n := 0
+	for _, s := range series {
+		for len(s.Samples) > 0 {
+			take := min(len(s.Samples), maxSamples)
+			if n > 0 && n+take > maxSamples {
+				if err := c.send(ctx, batch); err != nil {
+					return err
+				}
+				batch, n = nil, 0
+			}
+			batch = append(batch, Series{Labels: s.Labels, Samples: s.Samples[:take]})
+			n += take
+			s.Samples = s.Samples[take:]
+		}
+	}
+	if n == 0 {
+		return nil
+	}
+	return c.send(ctx, batch)
+}
+
+func (c *Client) send(ctx context.Context, series []Series) error {
+	body := snappy.Encode(nil, encode(series))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// --- encoding ---
+
+// encode marshals a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Labels are sorted by name, as receivers require.
+func encode(series []Series) []byte {
+	var out, ts, msg []byte
+	for _, s := range series {
+		names := make([]string, 0, len(s.Labels))
+		for name := range s.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		ts = ts[:0]
+		for _, name := range names {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, s.Labels[name])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		for _, sample := range s.Samples {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+			msg = protowire.AppendFixed64(msg, math.Float64bits(sample.Value))
+			msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+			msg = protowire.AppendVarint(msg, uint64(sample.Timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, ts)
+	}
+	return out
+}
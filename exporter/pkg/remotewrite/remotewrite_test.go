@@ -0,0 +1,214 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func metric(value float64, ts int64, labels ...string) *dto.Metric {
+	m := &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(value)}}
+	if ts != 0 {
+		m.TimestampMs = proto.Int64(ts)
+	}
+	for i := 0; i < len(labels); i += 2 {
+		m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return m
+}
+
+func TestFromFamilies(t *testing.T) {
+	counter := &dto.Metric{Counter: &dto.Counter{Value: proto.Float64(7)}, TimestampMs: proto.Int64(1000)}
+	tests := []struct {
+		name   string
+		family *dto.MetricFamily
+		want   []Series
+	}{
+		{
+			name: "grouped by labels, in time order",
+			family: &dto.MetricFamily{Name: proto.String("claude_cost_usd"), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{
+				metric(2, 2000, "model", "opus"),
+				metric(1, 1000, "model", "opus"),
+				metric(5, 1000, "model", "sonnet"),
+			}},
+			want: []Series{
+				{Labels: map[string]string{"__name__": "claude_cost_usd", "model": "opus"}, Samples: []Sample{{1, 1000}, {2, 2000}}},
+				{Labels: map[string]string{"__name__": "claude_cost_usd", "model": "sonnet"}, Samples: []Sample{{5, 1000}}},
+			},
+		},
+		{
+			name:   "counter",
+			family: &dto.MetricFamily{Name: proto.String("claude_messages_total"), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{counter}},
+			want:   []Series{{Labels: map[string]string{"__name__": "claude_messages_total"}, Samples: []Sample{{7, 1000}}}},
+		},
+		{
+			name:   "no timestamp",
+			family: &dto.MetricFamily{Name: proto.String("claude_sessions"), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{metric(3, 0)}},
+		},
+		{
+			name: "histogram",
+			family: &dto.MetricFamily{Name: proto.String("claude_turn_duration_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{
+				{Histogram: &dto.Histogram{SampleCount: proto.Uint64(1)}, TimestampMs: proto.Int64(1000)},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromFamilies([]*dto.MetricFamily{tt.family}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// decode parses a WriteRequest as encode writes it.
+func decode(t *testing.T, b []byte) []Series {
+	t.Helper()
+	var out []Series
+	fields(t, b, func(_ protowire.Number, ts []byte) {
+		s := Series{Labels: map[string]string{}}
+		var names []string
+		fields(t, ts, func(num protowire.Number, msg []byte) {
+			switch num {
+			case 1:
+				var name, value string
+				fields(t, msg, func(num protowire.Number, v []byte) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				names = append(names, name)
+				s.Labels[name] = value
+			case 2:
+				var sample Sample
+				for len(msg) > 0 {
+					num, typ, n := protowire.ConsumeTag(msg)
+					msg = msg[n:]
+					if num == 1 && typ == protowire.Fixed64Type {
+						v, n := protowire.ConsumeFixed64(msg)
+						sample.Value, msg = math.Float64frombits(v), msg[n:]
+					} else {
+						v, n := protowire.ConsumeVarint(msg)
+						sample.Timestamp, msg = int64(v), msg[n:]
+					}
+				}
+				s.Samples = append(s.Samples, sample)
+			}
+		})
+		if !sortedStrings(names) {
+			t.Errorf("labels %q are not sorted by name", names)
+		}
+		out = append(out, s)
+	})
+	return out
+}
+
+// fields calls fn with each length-delimited field of b.
+func fields(t *testing.T, b []byte, fn func(protowire.Number, []byte)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 || typ != protowire.BytesType {
+			t.Fatalf("field %d of type %d, want length-delimited", num, typ)
+		}
+		v, m := protowire.ConsumeBytes(b[n:])
+		if m < 0 {
+			t.Fatal(protowire.ParseError(m))
+		}
+		fn(num, v)
+		b = b[n+m:]
+	}
+}
+
+func sortedStrings(s []string) bool {
+	for i := 1; i < len(s); i++ {
+		if s[i-1] > s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestEncode(t *testing.T) {
+	series := []Series{
+		{Labels: map[string]string{"__name__": "claude_cost_usd", "model": "opus", "host": "laptop"}, Samples: []Sample{{1.5, 1773144000000}, {-2, 1773144060000}}},
+		{Labels: map[string]string{"__name__": "claude_sessions"}, Samples: []Sample{{3, 1773144000000}}},
+	}
+	if got := decode(t, encode(series)); !reflect.DeepEqual(got, series) {
+		t.Errorf("decoded %+v, want %+v", got, series)
+	}
+}
+
+func TestWriteBatches(t *testing.T) {
+	samples := func(n int) []Sample {
+		out := make([]Sample, n)
+		for i := range out {
+			out[i] = Sample{Value: float64(i), Timestamp: int64(i)}
+		}
+		return out
+	}
+	labels := func(name string) map[string]string { return map[string]string{"__name__": name} }
+	tests := []struct {
+		name   string
+		series []Series
+		want   [][]int // samples per series of each request
+	}{
+		{"nothing", nil, nil},
+		{"one request", []Series{{labels("a"), samples(10)}, {labels("b"), samples(5)}}, [][]int{{10, 5}}},
+		{"series kept whole", []Series{{labels("a"), samples(1500)}, {labels("b"), samples(1000)}}, [][]int{{1500}, {1000}}},
+		{"series over the limit", []Series{{labels("a"), samples(4500)}}, [][]int{{2000}, {2000}, {500}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Authorization") != "Bearer secret" {
+					t.Errorf("headers %v", r.Header)
+				}
+				body, _ := io.ReadAll(r.Body)
+				b, err := snappy.Decode(nil, body)
+				if err != nil {
+					t.Errorf("snappy: %v", err)
+				}
+				var sizes []int
+				for _, s := range decode(t, b) {
+					sizes = append(sizes, len(s.Samples))
+				}
+				got = append(got, sizes)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+			c := &Client{URL: srv.URL, Token: "secret"}
+			if err := c.Write(context.Background(), tt.series); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requests of %v samples, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	c := &Client{URL: srv.URL}
+	err := c.Write(context.Background(), []Series{{Labels: map[string]string{"__name__": "a"}, Samples: []Sample{{1, 1}}}})
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("error %v, want the status and message", err)
+	}
+}
@@ -0,0 +1,95 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Influx writes the metrics to InfluxDB 2 (or 3, or 1.8 with its v2
+// compatibility API) in the line protocol. Each metric is a measurement
+// named after it with its labels as tags; gauges and counters have a
+// "value" field, histograms and summaries "count" and "sum".
+type Influx struct {
+	URL    string
+	Token  string
+	Org    string
+	Bucket string
+	HTTP   *http.Client
+}
+
+// Send writes one point per series, all stamped with the current second.
+func (i *Influx) Send(ctx context.Context, families []*dto.MetricFamily) error {
+	body := lineProtocol(families, time.Now())
+	q := url.Values{"bucket": {i.Bucket}, "precision": {"s"}}
+	if i.Org != "" {
+		q.Set("org", i.Org)
+	}
+	target := strings.TrimSuffix(i.URL, "/") + "/api/v2/write?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.Token != "" {
+		req.Header.Set("Authorization", "Token "+i.Token)
+	}
+	return do(i.HTTP, req)
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+func lineProtocol(families []*dto.MetricFamily, now time.Time) string {
+	ts := " " + strconv.FormatInt(now.Unix(), 10) + "\n"
+	var b strings.Builder
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var fields string
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				fields = "value=" + formatFloat(m.GetGauge().GetValue())
+			case dto.MetricType_COUNTER:
+				fields = "value=" + formatFloat(m.GetCounter().GetValue())
+			case dto.MetricType_UNTYPED:
+				fields = "value=" + formatFloat(m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				h := m.GetHistogram()
+				fields = "count=" + strconv.FormatUint(h.GetSampleCount(), 10) + "i,sum=" + formatFloat(h.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				fields = "count=" + strconv.FormatUint(s.GetSampleCount(), 10) + "i,sum=" + formatFloat(s.GetSampleSum())
+			default:
+				continue
+			}
+			if strings.Contains(fields, "NaN") || strings.Contains(fields, "Inf") {
+				// the line protocol has no non-finite floats
+				continue
+			}
+			b.WriteString(measurementEscaper.Replace(mf.GetName()))
+			// InfluxDB wants tags sorted by key and drops empty values
+			labels := append([]*dto.LabelPair(nil), m.GetLabel()...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			for _, lp := range labels {
+				if lp.GetValue() == "" {
+					continue
+				}
+				b.WriteString("," + tagEscaper.Replace(lp.GetName()) + "=" + tagEscaper.Replace(lp.GetValue()))
+			}
+			b.WriteString(" " + fields + ts)
+		}
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
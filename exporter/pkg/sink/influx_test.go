@@ -0,0 +1,91 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func labelPairs(labels ...string) []*dto.LabelPair {
+	var out []*dto.LabelPair
+	for i := 0; i < len(labels); i += 2 {
+		out = append(out, &dto.LabelPair{Name: proto.String(labels[i]), Value: proto.String(labels[i+1])})
+	}
+	return out
+}
+
+func gauge(name string, v float64, labels ...string) *dto.MetricFamily {
+	return &dto.MetricFamily{Name: proto.String(name), Help: proto.String(name), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{
+		{Label: labelPairs(labels...), Gauge: &dto.Gauge{Value: proto.Float64(v)}},
+	}}
+}
+
+func TestLineProtocol(t *testing.T) {
+	now := time.Unix(1773144000, 0)
+	tests := []struct {
+		name   string
+		family *dto.MetricFamily
+		want   string
+	}{
+		{"gauge", gauge("claude_cost_usd", 1.5, "model", "opus"), "claude_cost_usd,model=opus value=1.5 1773144000\n"},
+		{"tags sorted", gauge("claude_cost_usd", 2, "model", "opus", "host", "laptop"), "claude_cost_usd,host=laptop,model=opus value=2 1773144000\n"},
+		{"empty tag dropped", gauge("claude_cost_usd", 2, "model", "", "host", "laptop"), "claude_cost_usd,host=laptop value=2 1773144000\n"},
+		{"escaped", gauge("claude_sessions", 3, "project", "my app,v=2"), `claude_sessions,project=my\ app\,v\=2 value=3 1773144000` + "\n"},
+		{"NaN skipped", gauge("claude_cache_hit_ratio", math.NaN()), ""},
+		{"infinity skipped", gauge("claude_cache_hit_ratio", math.Inf(1)), ""},
+		{
+			"counter",
+			&dto.MetricFamily{Name: proto.String("claude_messages_total"), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{
+				{Counter: &dto.Counter{Value: proto.Float64(7)}},
+			}},
+			"claude_messages_total value=7 1773144000\n",
+		},
+		{
+			"histogram",
+			&dto.MetricFamily{Name: proto.String("claude_turn_duration_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{
+				{Histogram: &dto.Histogram{SampleCount: proto.Uint64(4), SampleSum: proto.Float64(12.5)}},
+			}},
+			"claude_turn_duration_seconds count=4i,sum=12.5 1773144000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lineProtocol([]*dto.MetricFamily{tt.family}, now); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxSend(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got, body = r, string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	i := &Influx{URL: srv.URL + "/", Token: "secret", Org: "dev", Bucket: "claude"}
+	if err := i.Send(context.Background(), []*dto.MetricFamily{gauge("claude_sessions", 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if got.URL.Path != "/api/v2/write" || got.URL.Query().Get("bucket") != "claude" || got.URL.Query().Get("org") != "dev" || got.URL.Query().Get("precision") != "s" {
+		t.Errorf("wrote to %s", got.URL)
+	}
+	if auth := got.Header.Get("Authorization"); auth != "Token secret" {
+		t.Errorf("Authorization %q, want the token", auth)
+	}
+	if !strings.HasPrefix(body, "claude_sessions value=3 ") {
+		t.Errorf("body %q", body)
+	}
+}
@@ -0,0 +1,204 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OTLP sends the metrics to an OpenTelemetry collector over OTLP/HTTP with
+// the JSON encoding. Gauges become gauges, counters cumulative monotonic
+// sums, and histograms and summaries keep their buckets and quantiles.
+type OTLP struct {
+	// URL is the base endpoint, e.g. http://otel-collector:4318;
+	// /v1/metrics is appended.
+	URL     string
+	Headers map[string]string
+	// Resource holds resource attributes, e.g. service.name.
+	Resource map[string]string
+	HTTP     *http.Client
+}
+
+// Send posts one ExportMetricsServiceRequest.
+func (o *OTLP) Send(ctx context.Context, families []*dto.MetricFamily) error {
+	body, err := json.Marshal(otlpRequest(families, o.Resource, time.Now()))
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(o.URL, "/") + "/v1/metrics"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+	return do(o.HTTP, req)
+}
+
+// The types below are the subset of the OTLP JSON schema the exporter
+// needs. 64-bit integers are strings, as in the proto3 JSON mapping.
+
+type otlpAttr struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpPoint struct {
+	Attributes     []otlpAttr     `json:"attributes,omitempty"`
+	StartTime      string         `json:"startTimeUnixNano,omitempty"`
+	Time           string         `json:"timeUnixNano"`
+	AsDouble       *float64       `json:"asDouble,omitempty"`
+	Count          string         `json:"count,omitempty"`
+	Sum            *float64       `json:"sum,omitempty"`
+	BucketCounts   []string       `json:"bucketCounts,omitempty"`
+	ExplicitBounds []float64      `json:"explicitBounds,omitempty"`
+	Quantiles      []otlpQuantile `json:"quantileValues,omitempty"`
+}
+
+type otlpQuantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+type otlpData struct {
+	DataPoints  []otlpPoint `json:"dataPoints"`
+	Temporality int         `json:"aggregationTemporality,omitempty"`
+	Monotonic   bool        `json:"isMonotonic,omitempty"`
+}
+
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	Gauge       *otlpData `json:"gauge,omitempty"`
+	Sum         *otlpData `json:"sum,omitempty"`
+	Histogram   *otlpData `json:"histogram,omitempty"`
+	Summary     *otlpData `json:"summary,omitempty"`
+}
+
+// cumulative is AGGREGATION_TEMPORALITY_CUMULATIVE.
+const cumulative = 2
+
+func attrs(m map[string]string) []otlpAttr {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]otlpAttr, len(keys))
+	for i, k := range keys {
+		out[i].Key = k
+		out[i].Value.StringValue = m[k]
+	}
+	return out
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// finite returns v, or nil for NaN and infinities, which JSON can't carry.
+func finite(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+func otlpRequest(families []*dto.MetricFamily, resource map[string]string, now time.Time) any {
+	var metrics []otlpMetric
+	for _, mf := range families {
+		out := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp(), Unit: mf.GetUnit()}
+		data := &otlpData{}
+		switch mf.GetType() {
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			out.Gauge = data
+		case dto.MetricType_COUNTER:
+			out.Sum = data
+			data.Temporality, data.Monotonic = cumulative, true
+		case dto.MetricType_HISTOGRAM:
+			out.Histogram = data
+			data.Temporality = cumulative
+		case dto.MetricType_SUMMARY:
+			out.Summary = data
+		default:
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			p := otlpPoint{Attributes: attrs(labels), Time: nanos(now)}
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				p.AsDouble = finite(m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				p.AsDouble = finite(m.GetUntyped().GetValue())
+			case dto.MetricType_COUNTER:
+				c := m.GetCounter()
+				p.AsDouble = finite(c.GetValue())
+				if ts := c.GetCreatedTimestamp(); ts != nil {
+					p.StartTime = nanos(ts.AsTime())
+				}
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				p.Count = strconv.FormatUint(h.GetSampleCount(), 10)
+				p.Sum = finite(h.GetSampleSum())
+				// OTLP buckets aren't cumulative and end with an overflow bucket
+				var prev uint64
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+					p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+					prev = b.GetCumulativeCount()
+				}
+				if len(p.ExplicitBounds) > 0 {
+					p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+				}
+				if ts := h.GetCreatedTimestamp(); ts != nil {
+					p.StartTime = nanos(ts.AsTime())
+				}
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				p.Count = strconv.FormatUint(s.GetSampleCount(), 10)
+				p.Sum = finite(s.GetSampleSum())
+				for _, q := range s.GetQuantile() {
+					if v := finite(q.GetValue()); v != nil {
+						p.Quantiles = append(p.Quantiles, otlpQuantile{Quantile: q.GetQuantile(), Value: *v})
+					}
+				}
+			}
+			if p.AsDouble == nil && p.Count == "" {
+				continue
+			}
+			data.DataPoints = append(data.DataPoints, p)
+		}
+		if len(data.DataPoints) > 0 {
+			metrics = append(metrics, out)
+		}
+	}
+	return map[string]any{
+		"resourceMetrics": []any{map[string]any{
+			"resource": map[string]any{"attributes": attrs(resource)},
+			"scopeMetrics": []any{map[string]any{
+				"scope":   map[string]string{"name": "cc-exporter"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
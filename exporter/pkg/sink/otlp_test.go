@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// otlpMetrics encodes the request for families and returns its metrics.
+func otlpMetrics(t *testing.T, families ...*dto.MetricFamily) []otlpMetric {
+	t.Helper()
+	b, err := json.Marshal(otlpRequest(families, map[string]string{"service.name": "cc-exporter"}, time.Unix(1773144000, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req struct {
+		ResourceMetrics []struct {
+			Resource struct {
+				Attributes []otlpAttr `json:"attributes"`
+			} `json:"resource"`
+			ScopeMetrics []struct {
+				Metrics []otlpMetric `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+	rm := req.ResourceMetrics[0]
+	if len(rm.Resource.Attributes) != 1 || rm.Resource.Attributes[0].Value.StringValue != "cc-exporter" {
+		t.Errorf("resource %+v", rm.Resource)
+	}
+	return rm.ScopeMetrics[0].Metrics
+}
+
+func TestOTLPRequest(t *testing.T) {
+	created := time.Unix(1773100000, 0)
+	tests := []struct {
+		name   string
+		family *dto.MetricFamily
+		check  func(t *testing.T, m otlpMetric)
+	}{
+		{
+			"gauge",
+			gauge("claude_cost_usd", 1.5, "model", "opus"),
+			func(t *testing.T, m otlpMetric) {
+				p := m.Gauge.DataPoints[0]
+				if *p.AsDouble != 1.5 || p.Attributes[0].Key != "model" || p.Time != "1773144000000000000" {
+					t.Errorf("point %+v", p)
+				}
+			},
+		},
+		{
+			"counter",
+			&dto.MetricFamily{Name: proto.String("claude_messages_total"), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{
+				{Counter: &dto.Counter{Value: proto.Float64(7), CreatedTimestamp: timestamppb.New(created)}},
+			}},
+			func(t *testing.T, m otlpMetric) {
+				if m.Sum == nil || !m.Sum.Monotonic || m.Sum.Temporality != cumulative {
+					t.Fatalf("sum %+v, want cumulative and monotonic", m.Sum)
+				}
+				if p := m.Sum.DataPoints[0]; *p.AsDouble != 7 || p.StartTime != "1773100000000000000" {
+					t.Errorf("point %+v, want started at the created time", p)
+				}
+			},
+		},
+		{
+			"histogram",
+			&dto.MetricFamily{Name: proto.String("claude_turn_duration_seconds"), Unit: proto.String("seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{
+				{Histogram: &dto.Histogram{SampleCount: proto.Uint64(6), SampleSum: proto.Float64(40), Bucket: []*dto.Bucket{
+					{UpperBound: proto.Float64(1), CumulativeCount: proto.Uint64(2)},
+					{UpperBound: proto.Float64(10), CumulativeCount: proto.Uint64(5)},
+					{UpperBound: proto.Float64(math.Inf(1)), CumulativeCount: proto.Uint64(6)},
+				}}},
+			}},
+			func(t *testing.T, m otlpMetric) {
+				p := m.Histogram.DataPoints[0]
+				if m.Unit != "seconds" || p.Count != "6" || *p.Sum != 40 {
+					t.Errorf("unit %q, point %+v", m.Unit, p)
+				}
+				// per bucket, with the overflow bucket last
+				if strings.Join(p.BucketCounts, ",") != "2,3,1" || len(p.ExplicitBounds) != 2 || p.ExplicitBounds[1] != 10 {
+					t.Errorf("buckets %v bounds %v, want 2,3,1 and [1 10]", p.BucketCounts, p.ExplicitBounds)
+				}
+			},
+		},
+		{
+			"summary",
+			&dto.MetricFamily{Name: proto.String("claude_turn_tokens"), Type: dto.MetricType_SUMMARY.Enum(), Metric: []*dto.Metric{
+				{Summary: &dto.Summary{SampleCount: proto.Uint64(3), SampleSum: proto.Float64(9), Quantile: []*dto.Quantile{
+					{Quantile: proto.Float64(0.5), Value: proto.Float64(2)},
+					{Quantile: proto.Float64(0.99), Value: proto.Float64(math.NaN())},
+				}}},
+			}},
+			func(t *testing.T, m otlpMetric) {
+				p := m.Summary.DataPoints[0]
+				if p.Count != "3" || len(p.Quantiles) != 1 || p.Quantiles[0].Value != 2 {
+					t.Errorf("point %+v, want the NaN quantile dropped", p)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := otlpMetrics(t, tt.family)
+			if len(metrics) != 1 || metrics[0].Name != tt.family.GetName() {
+				t.Fatalf("metrics %+v", metrics)
+			}
+			tt.check(t, metrics[0])
+		})
+	}
+
+	// a metric whose only point can't be carried is left out
+	if metrics := otlpMetrics(t, gauge("claude_cache_hit_ratio", math.NaN())); len(metrics) != 0 {
+		t.Errorf("NaN gauge sent as %+v", metrics)
+	}
+}
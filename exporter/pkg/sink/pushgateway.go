@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Pushgateway replaces the metrics of one group on a Prometheus
+// Pushgateway: job Job, plus instance Instance when set.
+type Pushgateway struct {
+	URL      string
+	Job      string
+	Instance string
+	HTTP     *http.Client
+}
+
+// Send PUTs the whole group, so series that disappeared are dropped too.
+func (p *Pushgateway) Send(ctx context.Context, families []*dto.MetricFamily) error {
+	body, err := text(families)
+	if err != nil {
+		return err
+	}
+	target := strings.TrimSuffix(p.URL, "/") + "/metrics/job/" + url.PathEscape(p.Job)
+	if p.Instance != "" {
+		target += "/instance/" + url.PathEscape(p.Instance)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	return do(p.HTTP, req)
+}
+
+// do sends req and fails on any status other than 2xx.
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
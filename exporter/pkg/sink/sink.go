@@ -0,0 +1,81 @@
+// Package sink sends the exporter's metrics to systems that don't scrape:
+// a node_exporter textfile, an OTLP collector, InfluxDB, a Pushgateway.
+//
+// A Runner gathers the metrics once per interval and hands the same
+// snapshot to every sink, so any number of them run off one scan.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Sink sends one snapshot of the gathered metrics.
+type Sink interface {
+	Send(ctx context.Context, families []*dto.MetricFamily) error
+}
+
+// Runner feeds the metrics of a Gatherer to its sinks, by name.
+type Runner struct {
+	Gatherer prometheus.Gatherer
+	Sinks    map[string]Sink
+}
+
+// Flush gathers once and sends the result to every sink in parallel, each
+// within timeout. Errors are logged per sink, so one failing sink doesn't
+// hold up the others.
+func (r *Runner) Flush(ctx context.Context, timeout time.Duration) {
+	families, err := r.Gatherer.Gather()
+	if err != nil {
+		log.Printf("sink: gather: %v", err)
+		if len(families) == 0 {
+			return
+		}
+	}
+	var wg sync.WaitGroup
+	for name, s := range r.Sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sendCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if err := s.Send(sendCtx, families); err != nil {
+				log.Printf("sink %s: %v", name, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Run flushes every interval until ctx is done.
+func (r *Runner) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.Flush(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// text encodes families in the Prometheus text format.
+func text(families []*dto.MetricFamily) (string, error) {
+	var b strings.Builder
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(&b, mf); err != nil {
+			return "", fmt.Errorf("encode %s: %w", mf.GetName(), err)
+		}
+	}
+	return b.String(), nil
+}
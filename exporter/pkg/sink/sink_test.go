@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type recordSink struct {
+	mu   sync.Mutex
+	sent [][]*dto.MetricFamily
+	err  error
+}
+
+func (s *recordSink) Send(ctx context.Context, families []*dto.MetricFamily) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, families)
+	return s.err
+}
+
+func TestRunnerFlush(t *testing.T) {
+	gathers := 0
+	g := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		gathers++
+		return []*dto.MetricFamily{gauge("claude_sessions", 3)}, nil
+	})
+	ok, failing := &recordSink{}, &recordSink{err: errors.New("unreachable")}
+	r := &Runner{Gatherer: g, Sinks: map[string]Sink{"ok": ok, "failing": failing}}
+	r.Flush(context.Background(), time.Second)
+
+	if gathers != 1 {
+		t.Errorf("gathered %d times, want once for all sinks", gathers)
+	}
+	for name, s := range map[string]*recordSink{"ok": ok, "failing": failing} {
+		if len(s.sent) != 1 || s.sent[0][0].GetName() != "claude_sessions" {
+			t.Errorf("sink %s was sent %v", name, s.sent)
+		}
+	}
+
+	// nothing gathered, nothing sent
+	r.Gatherer = prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return nil, errors.New("scan failed") })
+	r.Flush(context.Background(), time.Second)
+	if len(ok.sent) != 1 {
+		t.Errorf("sent %d snapshots after a failed gather, want still 1", len(ok.sent))
+	}
+}
+
+func TestTextfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "claude.prom")
+	tf := &Textfile{Path: path}
+	for _, v := range []float64{3, 4} {
+		if err := tf.Send(context.Background(), []*dto.MetricFamily{gauge("claude_sessions", v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# HELP claude_sessions claude_sessions\n# TYPE claude_sessions gauge\nclaude_sessions 4\n"; string(got) != want {
+		t.Errorf("file holds %q, want %q", got, want)
+	}
+	// no temporary files left for the textfile collector to trip over
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d files, want 1", len(entries))
+	}
+}
+
+func TestPushgateway(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance string
+		status   int
+		path     string
+		wantErr  bool
+	}{
+		{"job", "", http.StatusOK, "/metrics/job/claude", false},
+		{"instance", "dev laptop", http.StatusAccepted, "/metrics/job/claude/instance/dev%20laptop", false},
+		{"rejected", "", http.StatusBadRequest, "/metrics/job/claude", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path, body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				method, path, body = r.Method, r.URL.EscapedPath(), string(b)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+			p := &Pushgateway{URL: srv.URL, Job: "claude", Instance: tt.instance}
+			err := p.Send(context.Background(), []*dto.MetricFamily{gauge("claude_sessions", 3)})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error %v, want one: %v", err, tt.wantErr)
+			}
+			if method != http.MethodPut || path != tt.path || body == "" {
+				t.Errorf("%s %s with %q, want PUT %s", method, path, body, tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package sink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Textfile writes the metrics for node_exporter's textfile collector.
+// Path must end in .prom and sit in the collector's directory.
+type Textfile struct {
+	Path string
+}
+
+// Send replaces the file through a rename, so node_exporter never reads
+// a partial one.
+func (t *Textfile) Send(ctx context.Context, families []*dto.MetricFamily) error {
+	body, err := text(families)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(t.Path), "."+filepath.Base(t.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), t.Path)
+}
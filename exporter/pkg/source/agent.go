@@ -0,0 +1,168 @@
+package source
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// --- other coding agents (Codex, Gemini) ---
+
+// AgentUsage is the usage of a non-Claude agent, either for one session
+// file or summed over all of them.
+type AgentUsage struct {
+	// date -> model -> usage
+	Daily     map[string]map[string]*LiveModelUsage
+	Reasoning map[string]float64
+	Sessions  int
+	Messages  int
+	Tools     map[string]int
+}
+
+func NewAgentUsage() *AgentUsage {
+	return &AgentUsage{
+		Daily:     make(map[string]map[string]*LiveModelUsage),
+		Reasoning: make(map[string]float64),
+		Tools:     make(map[string]int),
+	}
+}
+
+// Add records usage at an RFC 3339 timestamp, defaulting to today when the
+// timestamp is missing or malformed.
+func (u *AgentUsage) Add(timestamp, model string, usage *LiveModelUsage, reasoning float64) {
+	date := time.Now().UTC().Format("2006-01-02")
+	if ts, err := time.Parse(time.RFC3339Nano, timestamp); err == nil {
+		date = ts.UTC().Format("2006-01-02")
+	}
+	u.addDay(date, model, usage)
+	u.Reasoning[model] += reasoning
+}
+
+func (u *AgentUsage) addDay(date, model string, usage *LiveModelUsage) {
+	byModel, ok := u.Daily[date]
+	if !ok {
+		byModel = make(map[string]*LiveModelUsage)
+		u.Daily[date] = byModel
+	}
+	mu, ok := byModel[model]
+	if !ok {
+		mu = &LiveModelUsage{}
+		byModel[model] = mu
+	}
+	mu.Add(usage)
+}
+
+// Merge adds o into u.
+func (u *AgentUsage) Merge(o *AgentUsage) {
+	for date, byModel := range o.Daily {
+		for model, mu := range byModel {
+			u.addDay(date, model, mu)
+		}
+	}
+	for model, n := range o.Reasoning {
+		u.Reasoning[model] += n
+	}
+	for tool, n := range o.Tools {
+		u.Tools[tool] += n
+	}
+	u.Sessions += o.Sessions
+	u.Messages += o.Messages
+}
+
+// Totals sums usage over all days by model.
+func (u *AgentUsage) Totals() map[string]*LiveModelUsage {
+	totals := make(map[string]*LiveModelUsage)
+	for _, byModel := range u.Daily {
+		for model, mu := range byModel {
+			t, ok := totals[model]
+			if !ok {
+				t = &LiveModelUsage{}
+				totals[model] = t
+			}
+			t.Add(mu)
+		}
+	}
+	return totals
+}
+
+// --- source ---
+
+type agentFileState struct {
+	size   int64
+	mtime  time.Time
+	result *AgentUsage
+}
+
+// AgentSource walks an agent's session directory and parses each matching
+// file, re-parsing only files whose size or mtime changed.
+type AgentSource struct {
+	provider string
+	root     string
+	match    func(path string) bool
+	parse    func(path string, pricing *pricing.Table) (*AgentUsage, error)
+	pricing  *pricing.Table
+
+	mu    sync.Mutex
+	files map[string]*agentFileState
+}
+
+func (s *AgentSource) Name() string { return s.provider }
+
+// Provider is the value of the provider label for this agent.
+func (s *AgentSource) Provider() string { return s.provider }
+
+func (s *AgentSource) Scan(ctx context.Context) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.files == nil {
+		s.files = make(map[string]*agentFileState)
+	}
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil || d.IsDir() || !s.match(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		seen[path] = true
+		if st, ok := s.files[path]; ok && st.size == info.Size() && st.mtime.Equal(info.ModTime()) {
+			return nil
+		}
+		res, err := s.parse(path, s.pricing)
+		if err != nil {
+			log.Printf("%s: failed to parse %s: %v", s.provider, path, err)
+			return nil
+		}
+		if len(res.Daily) > 0 {
+			res.Sessions = 1
+		}
+		s.files[path] = &agentFileState{size: info.Size(), mtime: info.ModTime(), result: res}
+		return nil
+	})
+	incomplete := err != nil && ctx.Err() != nil
+	if err != nil && !incomplete {
+		return nil, err
+	}
+
+	// a walk cut short keeps the files it did not reach
+	total := NewAgentUsage()
+	for path, st := range s.files {
+		if !seen[path] && !incomplete {
+			delete(s.files, path)
+			continue
+		}
+		total.Merge(st.result)
+	}
+	return &Snapshot{Agents: map[string]*AgentUsage{s.provider: total}, Incomplete: incomplete}, nil
+}
@@ -0,0 +1,1690 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// --- JSONL record structs ---
+
+type JSONLRecord struct {
+	Type      string `json:"type"`
+	Subtype   string `json:"subtype,omitempty"`
+	UUID      string `json:"uuid,omitempty"`
+	Parent    string `json:"parentUuid,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Version   string `json:"version,omitempty"` // Claude Code CLI version
+	Sidechain bool   `json:"isSidechain,omitempty"`
+	Meta      bool   `json:"isMeta,omitempty"` // injected context, not typed by the user
+	Cwd       string `json:"cwd,omitempty"`    // project working directory
+	// set on prompts: default, acceptEdits, plan or bypassPermissions
+	PermissionMode string `json:"permissionMode,omitempty"`
+	// For type=attachment, context Claude Code adds to a prompt
+	Attachment *JSONLAttachment `json:"attachment,omitempty"`
+
+	// For type=assistant or type=progress (nested)
+	Message *JSONLMessage `json:"message,omitempty"`
+	Data    *JSONLData    `json:"data,omitempty"`
+
+	// For subtype=turn_duration
+	DurationMs *float64 `json:"durationMs,omitempty"`
+
+	// For subtype=api_error
+	RetryAttempt *int     `json:"retryAttempt,omitempty"`
+	MaxRetries   *int     `json:"maxRetries,omitempty"`
+	RetryInMs    *float64 `json:"retryInMs,omitempty"`
+
+	// For subtype=compact_boundary
+	CompactMetadata *CompactMetadata `json:"compactMetadata,omitempty"`
+}
+
+type JSONLAttachment struct {
+	Type string `json:"type"`
+}
+
+type JSONLData struct {
+	Message *JSONLDataMessage `json:"message,omitempty"`
+}
+
+type JSONLDataMessage struct {
+	Message *JSONLMessage `json:"message,omitempty"`
+}
+
+type JSONLMessage struct {
+	ID         string         `json:"id"`
+	Model      string         `json:"model"`
+	Role       string         `json:"role"`
+	StopReason *string        `json:"stop_reason"`
+	Content    MessageContent `json:"content"`
+	Usage      JSONLUsage     `json:"usage"`
+}
+
+// interruptMarker starts the text Claude Code records when the user stops
+// a turn with Esc, e.g. "[Request interrupted by user for tool use]".
+const interruptMarker = "[Request interrupted by user"
+
+// interrupted reports whether the message marks a user interruption.
+func (m *JSONLMessage) interrupted() bool {
+	if m.Role != "user" {
+		return false
+	}
+	for _, block := range m.Content {
+		if block.Type == "text" && block.text.interrupt {
+			return true
+		}
+	}
+	return false
+}
+
+// usageLimitMarker appears in the notice Claude Code records as a synthetic
+// assistant message when the subscription usage limit is hit, e.g. "Claude
+// AI usage limit reached|1760540400" or "5-hour limit reached ∙ resets 3pm".
+const usageLimitMarker = "limit reached"
+
+// usageLimit returns the text of a usage limit notice in the message.
+func (m *JSONLMessage) usageLimit() (string, bool) {
+	if m.Model != syntheticModel {
+		return "", false
+	}
+	for _, block := range m.Content {
+		if block.Type == "text" && block.text.notice != "" {
+			return block.text.notice, true
+		}
+	}
+	return "", false
+}
+
+// permissionDenials start the tool results Claude Code records when a tool
+// call is rejected at the permission prompt or by a deny rule.
+var permissionDenials = [][]byte{
+	[]byte("The user doesn't want to proceed with this tool use"),
+	[]byte("The user doesn't want to take this action"),
+	[]byte("Permission to use "),
+	[]byte("Claude requested permissions to use "),
+}
+
+// permissionDenied reports whether a JSON tool result is a permission
+// denial. Results that are block arrays never are.
+func permissionDenied(value []byte) bool {
+	if len(value) < 2 || value[0] != '"' {
+		return false
+	}
+	for _, p := range permissionDenials {
+		if bytes.HasPrefix(value[1:], p) {
+			return true
+		}
+	}
+	return false
+}
+
+// planApproval starts the tool result of an ExitPlanMode call the user
+// accepted, which ends plan mode.
+var planApproval = []byte("User has approved your plan")
+
+// promptFreeTools never ask for permission: they only read, plan or
+// delegate, so the time to their result is all execution.
+var promptFreeTools = map[string]bool{
+	"Read": true, "Glob": true, "Grep": true, "LS": true, "NotebookRead": true,
+	"TodoWrite": true, "Task": true, "BashOutput": true,
+}
+
+// editTools skip the permission prompt in acceptEdits mode.
+var editTools = map[string]bool{"Edit": true, "MultiEdit": true, "Write": true, "NotebookEdit": true}
+
+// permissionDecision classifies a tool call by the session's permission
+// mode: "auto" when the mode skips the prompt for the tool, "allowed"
+// otherwise, since transcripts don't tell calls approved at the prompt
+// from ones an allow rule let through. Denials are found in the result.
+func permissionDecision(mode, tool string) string {
+	if mode == "bypassPermissions" || (mode == "acceptEdits" && editTools[tool]) {
+		return "auto"
+	}
+	return "allowed"
+}
+
+var (
+	// "...|1760540400"
+	limitResetEpoch = regexp.MustCompile(`\|(\d{9,})\s*$`)
+	// "resets 3pm", "reset at 3:30 PM (Europe/Berlin)"
+	limitResetClock = regexp.MustCompile(`(?i)resets?(?: at)? (\d{1,2})(?::(\d{2}))? ?([ap]m)?(?: \(([^)]+)\))?`)
+)
+
+// parseLimitReset returns when the limit in a usage limit notice written
+// at ts resets, or the zero time if the notice doesn't say. Clock times
+// without a zone are in loc.
+func parseLimitReset(notice string, ts time.Time, loc *time.Location) time.Time {
+	if m := limitResetEpoch.FindStringSubmatch(notice); m != nil {
+		if sec, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+	m := limitResetClock.FindStringSubmatch(notice)
+	if m == nil {
+		return time.Time{}
+	}
+	hour, _ := strconv.Atoi(m[1])
+	min, _ := strconv.Atoi(m[2])
+	switch strings.ToLower(m[3]) {
+	case "am":
+		if hour == 12 {
+			hour = 0
+		}
+	case "pm":
+		if hour < 12 {
+			hour += 12
+		}
+	}
+	if hour > 23 || min > 59 {
+		return time.Time{}
+	}
+	if m[4] != "" {
+		if l, err := time.LoadLocation(m[4]); err == nil {
+			loc = l
+		}
+	}
+	// the next such clock time after the notice
+	t := ts.In(loc)
+	reset := time.Date(t.Year(), t.Month(), t.Day(), hour, min, 0, 0, loc)
+	if !reset.After(t) {
+		reset = reset.AddDate(0, 0, 1)
+	}
+	return reset
+}
+
+type JSONLUsage struct {
+	InputTokens              *float64       `json:"input_tokens"`
+	OutputTokens             *float64       `json:"output_tokens"`
+	CacheReadInputTokens     *float64       `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens *float64       `json:"cache_creation_input_tokens"`
+	Cost                     *float64       `json:"cost"`
+	CostDetails              *CostDetails   `json:"cost_details"`
+	ServerToolUse            *ServerToolUse `json:"server_tool_use"`
+	OutputTokensDetails      *OutputDetails `json:"output_tokens_details"`
+	ServiceTier              *string        `json:"service_tier"`
+	IsByok                   *bool          `json:"is_byok"`
+}
+
+type CostDetails struct {
+	UpstreamInferenceCost            *float64 `json:"upstream_inference_cost"`
+	UpstreamInferencePromptCost      *float64 `json:"upstream_inference_prompt_cost"`
+	UpstreamInferenceCompletionsCost *float64 `json:"upstream_inference_completions_cost"`
+}
+
+// OutputDetails breaks down output tokens when the API (or a proxy) reports it.
+type OutputDetails struct {
+	ThinkingTokens  *float64 `json:"thinking_tokens"`
+	ReasoningTokens *float64 `json:"reasoning_tokens"`
+}
+
+type ServerToolUse struct {
+	WebSearchRequests int `json:"web_search_requests"`
+	WebFetchRequests  int `json:"web_fetch_requests"`
+}
+
+type CompactMetadata struct {
+	Trigger   string `json:"trigger"`
+	PreTokens int    `json:"preTokens"`
+}
+
+// --- live session aggregation ---
+
+type LiveModelUsage struct {
+	Input       float64
+	Output      float64
+	CacheRead   float64
+	CacheCreate float64
+	Cost        float64
+	// Thinking is the part of Output spent on extended thinking
+	Thinking float64
+	// WebSearchCost is the part of Cost estimated for web searches
+	WebSearchCost float64
+}
+
+// Scale multiplies every field of u by f.
+func (u *LiveModelUsage) Scale(f float64) {
+	u.Input *= f
+	u.Output *= f
+	u.CacheRead *= f
+	u.CacheCreate *= f
+	u.Cost *= f
+	u.WebSearchCost *= f
+	u.Thinking *= f
+}
+
+// Add adds o into u.
+func (u *LiveModelUsage) Add(o *LiveModelUsage) {
+	u.Input += o.Input
+	u.Output += o.Output
+	u.CacheRead += o.CacheRead
+	u.CacheCreate += o.CacheCreate
+	u.Cost += o.Cost
+	u.WebSearchCost += o.WebSearchCost
+	u.Thinking += o.Thinking
+}
+
+type LiveResult struct {
+	ModelUsage   map[string]*LiveModelUsage
+	SessionCount int
+	MessageCount int
+
+	// New per-request metrics from JSONL
+	TurnDurations    []Observation
+	ToolUseCounts    map[string]int
+	ToolErrors       map[string]int // failed tool results by tool name, without denials
+	Permissions      map[Permission]int
+	ToolUses         []ToolUse
+	DailyUsage       []DatedUsage
+	StopReasons      map[string]map[string]int // model -> reason -> count
+	APIErrors        int
+	APIRetries       int
+	RetryDelays      []Observation // announced backoff before a retry, in ms
+	RetriesExhausted int           // errors on the last allowed attempt
+	CompactEvents    int
+	CompactTriggers  map[string]int           // trigger -> count
+	CompactPreTokens map[string][]Observation // by trigger
+	// usage limit notices, and when the limit of the latest one resets
+	UsageLimitEvents int
+	UsageLimitReset  time.Time
+	WebSearches      int
+	WebFetches       int
+
+	// Records skipped because a resumed session already contributed them
+	DuplicateRecords int
+
+	// Messages of live files dated before the stats cache boundary, left
+	// out because the cache already counts them, and sessions that have
+	// such messages as well as live ones
+	OverlapMessages int
+	OverlapSessions int
+
+	// Turns the user interrupted (Esc)
+	Interruptions int
+
+	// Seconds from a tool call that may ask for permission to its result,
+	// see toolCall.mayPrompt
+	ApprovalWaits []Observation
+	// Milliseconds of each completed turn without its approval waits
+	TurnActiveDurations []Observation
+
+	// Cost in USD of each completed turn, subagents included
+	TurnCosts []Observation
+
+	// Durations in seconds of sessions idle for SessionEndIdle
+	SessionDurations []Observation
+	// Turns (user prompts) of sessions idle for SessionEndIdle
+	SessionTurns []Observation
+
+	// Messages by role: "user" (prompts and tool results) and "assistant"
+	// (once per API request)
+	RoleMessages map[string]int
+
+	// Main-thread output tokens per second of each completed turn, by the
+	// model that answered last
+	OutputSpeeds map[string][]Observation
+
+	// Seconds from a prompt or tool result (or its last attachment) to the
+	// first content block of the response, by model
+	FirstTokenLatency map[string][]Observation
+	// Context tokens (input, cache read and cache creation) of each API
+	// request, by model
+	RequestContexts map[string][]Observation
+
+	// Usage by local hour of day ("00"-"23"), then model
+	HourUsage map[string]map[string]*LiveModelUsage
+	// Usage by session mode and request purpose, then model
+	ClassUsage map[UsageClass]map[string]*LiveModelUsage
+
+	// Live sessions by the Claude Code version they last ran
+	Versions map[string]int
+
+	// Recent holds every request of the last RecentLookback, whether or not
+	// the stats cache already covers it, in no particular order.
+	Recent []UsageEvent
+	// Usage by model, tool uses, stop reasons by model and sessions of the
+	// last RecentWindow, likewise including what the stats cache covers
+	RecentWindow      time.Duration
+	RecentUsage       map[string]*LiveModelUsage
+	RecentTools       map[string]int
+	RecentStopReasons map[string]map[string]int
+	RecentSessions    int
+
+	// Model changes between consecutive main-thread messages of a session
+	ModelSwitches map[ModelSwitch]int
+	// Sessions with counted messages, in scan order
+	Sessions []*Session
+	// Live session files by why they count as live (LiveNewerThanCache,
+	// LiveInWindow or LiveNoStatsCache)
+	LiveFiles map[string]int
+	// Lines of the scanned files longer than the line limit, parsed
+	// with long strings cut or dropped
+	OversizedLines int
+	// Bytes of the lines behind the records kept for the next scan, and
+	// the files read whole on every scan instead since keeping theirs
+	// would go over the memory budget
+	RecordBytes     int
+	OverBudgetFiles int
+	// Lines that aren't valid records, by session file relative to the
+	// projects directory
+	MalformedLines map[string]int
+
+	// Cost of the full session history by team, when a team mapping is set
+	TeamCost map[string]float64
+
+	// Language of each project read, when a language map is set
+	ProjectLanguages map[string]string
+
+	// API provider of each model (model.Provider)
+	Providers map[string]string
+}
+
+type ModelSwitch struct {
+	From string
+	To   string
+}
+
+// Session modes, see Session.planning.
+const (
+	ModePlan   = "plan"
+	ModeNormal = "normal"
+)
+
+// Request purposes, see ClaudeSessions.background.
+const (
+	PurposeInteractive = "interactive"
+	PurposeBackground  = "background"
+)
+
+// UsageClass splits live usage for the mode and purpose labels.
+type UsageClass struct {
+	Mode    string // ModePlan or ModeNormal
+	Purpose string // PurposeInteractive or PurposeBackground
+}
+
+// Permission is a tool call's permission decision, see permissionDecision.
+type Permission struct {
+	Tool     string
+	Decision string // allowed, auto or denied
+}
+
+// Session summarizes one live session file.
+type Session struct {
+	ID          string
+	Project     string
+	Messages    int
+	Turns       int // prompts the user typed on the main thread
+	Compactions int
+	// the main thread finished its turn and waits for the user's next
+	// prompt, since LastActivity
+	AwaitingInput bool
+	Models        []string // in order of first use
+	ModelSwitches int
+	// usage by model of all threads, of the messages not in the stats cache
+	Usage         map[string]*LiveModelUsage
+	FirstActivity time.Time // first and last record timestamps
+	LastActivity  time.Time
+
+	lastModel string
+	// timestamp of the record being added, zero when it has none
+	recordTime time.Time
+	// non-assistant record UUID -> timestamp, to time the response that
+	// follows
+	requestStarts map[string]time.Time
+	// tool_use ID -> tool call, to match tool results
+	toolCalls map[string]toolCall
+	// the permission mode of the last prompt
+	permissionMode string
+	// in plan mode: set by a plan-mode prompt or reminder, cleared by
+	// another mode or an approved plan
+	planning bool
+	// whether some messages were already counted by the stats cache
+	cached bool
+	// main-thread output and cost of all threads since the last
+	// turn_duration record
+	turnOutput float64
+	turnCost   float64
+	// seconds the main thread waited on approvals in this turn, and when
+	// the last wait ended
+	turnWait    float64
+	turnWaitEnd time.Time
+}
+
+// addTurnWait adds a main-thread approval wait to the turn, counting time
+// the waits of parallel tool calls overlap only once.
+func (sess *Session) addTurnWait(from, to time.Time) {
+	if from.Before(sess.turnWaitEnd) {
+		from = sess.turnWaitEnd
+	}
+	if to.After(from) {
+		sess.turnWait += to.Sub(from).Seconds()
+		sess.turnWaitEnd = to
+	}
+}
+
+// toolCall is a pending tool call of a session.
+type toolCall struct {
+	Permission
+	at time.Time
+	// whether the call's request is in the sample
+	sampled bool
+}
+
+// mayPrompt reports whether the call could have waited at the permission
+// prompt. Transcripts don't record when the prompt was answered, so the
+// wait of such a call is the time to its result, its run time included.
+func (c toolCall) mayPrompt() bool {
+	return c.Decision != "auto" && !promptFreeTools[c.Tool] && !c.at.IsZero()
+}
+
+// syntheticModel is the model Claude Code records on locally generated
+// messages, e.g. API error notices.
+const syntheticModel = "<synthetic>"
+
+// observeModel records the model of a main-thread message and reports a
+// switch from the previous one.
+func (sess *Session) observeModel(name string) (ModelSwitch, bool) {
+	if name == syntheticModel || name == sess.lastModel {
+		return ModelSwitch{}, false
+	}
+	from := sess.lastModel
+	sess.lastModel = name
+	if !slices.Contains(sess.Models, name) {
+		sess.Models = append(sess.Models, name)
+	}
+	if from == "" {
+		return ModelSwitch{}, false
+	}
+	sess.ModelSwitches++
+	return ModelSwitch{From: from, To: name}, true
+}
+
+// mode returns ModePlan or ModeNormal.
+func (sess *Session) mode() string {
+	if sess.planning {
+		return ModePlan
+	}
+	return ModeNormal
+}
+
+// usage returns the session's usage of a model, adding it if needed.
+func (sess *Session) usage(name string) *LiveModelUsage {
+	if sess.Usage == nil {
+		sess.Usage = make(map[string]*LiveModelUsage)
+	}
+	mu, ok := sess.Usage[name]
+	if !ok {
+		mu = &LiveModelUsage{}
+		sess.Usage[name] = mu
+	}
+	return mu
+}
+
+// observeTime extends the session's activity span to ts.
+func (sess *Session) observeTime(ts time.Time) {
+	if sess.FirstActivity.IsZero() || ts.Before(sess.FirstActivity) {
+		sess.FirstActivity = ts
+	}
+	if ts.After(sess.LastActivity) {
+		sess.LastActivity = ts
+	}
+}
+
+// SessionEndIdle is how long a session must be idle to count as ended,
+// at which point its duration is observed.
+const SessionEndIdle = time.Hour
+
+// Observation is one histogram sample, identified by the record it came
+// from so it is observed only once across scans. Session and Project
+// locate the session file, for exemplars.
+type Observation struct {
+	ID      string
+	Value   float64
+	Session string
+	Project string
+	// timestamp of the record, zero when unknown
+	Time time.Time
+}
+
+// observation returns a sample from the record of the session being added.
+func (sess *Session) observation(id string, value float64) Observation {
+	return Observation{ID: id, Value: value, Session: sess.ID, Project: sess.Project, Time: sess.recordTime}
+}
+
+// Reasons a session file is live.
+const (
+	LiveNewerThanCache = "stats_cache"    // modified after the stats cache
+	LiveInWindow       = "window"         // modified within the live window
+	LiveNoStatsCache   = "no_stats_cache" // no stats cache to compare with
+)
+
+// RecentLookback is how far back LiveResult.Recent reaches at least, and
+// the default ClaudeSessionsOptions.RecentWindow.
+const RecentLookback = 24 * time.Hour
+
+// ToolUse is one tool_use block, for aggregations that outlive the scan.
+type ToolUse struct {
+	ID    string // stable across scans
+	Date  string // local date, YYYY-MM-DD
+	Tool  string
+	Count int // calls it stands for, SAMPLE_EVERY when sampled
+}
+
+// DatedUsage is the token usage of one API request under its date, for
+// aggregations that outlive the scan.
+type DatedUsage struct {
+	ID      string // stable across scans
+	Date    string // local date, YYYY-MM-DD
+	Hour    int    // local hour of day
+	Session string
+	Project string
+	Model   string
+	Usage   LiveModelUsage
+}
+
+// UsageEvent is the usage of one API request.
+type UsageEvent struct {
+	Time   time.Time
+	Model  string
+	Input  float64
+	Output float64
+	Cost   float64
+}
+
+// thinkingCharsPerToken estimates thinking tokens from the thinking text
+// when usage carries no breakdown.
+const thinkingCharsPerToken = 4
+
+func (r *LiveResult) model(name string) *LiveModelUsage {
+	mu, ok := r.ModelUsage[name]
+	if !ok {
+		mu = &LiveModelUsage{}
+		r.ModelUsage[name] = mu
+	}
+	return mu
+}
+
+func (r *LiveResult) classUsage(class UsageClass, name string) *LiveModelUsage {
+	byModel, ok := r.ClassUsage[class]
+	if !ok {
+		byModel = make(map[string]*LiveModelUsage)
+		r.ClassUsage[class] = byModel
+	}
+	if byModel[name] == nil {
+		byModel[name] = &LiveModelUsage{}
+	}
+	return byModel[name]
+}
+
+// observeProvider records the provider of a model from its raw ID.
+func (r *LiveResult) observeProvider(name, raw string) {
+	r.Providers[name] = model.MergeProvider(r.Providers[name], model.Provider(raw))
+}
+
+// thinkingTokens returns the reported thinking tokens, if any.
+func (u *JSONLUsage) thinkingTokens() (float64, bool) {
+	if d := u.OutputTokensDetails; d != nil {
+		if d.ThinkingTokens != nil {
+			return *d.ThinkingTokens, true
+		}
+		if d.ReasoningTokens != nil {
+			return *d.ReasoningTokens, true
+		}
+	}
+	return 0, false
+}
+
+func ptrVal(p *float64) float64 {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// --- source ---
+
+// ProjectFilter selects project directories under ~/.claude/projects by
+// path.Match globs on the directory name (the project path with "/"
+// replaced by "-"). Exclude wins over Include; an empty Include allows all.
+type ProjectFilter struct {
+	Include []string
+	Exclude []string
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, name); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the project directory name passes the filter.
+func (f ProjectFilter) Allows(name string) bool {
+	if matchAny(f.Exclude, name) {
+		return false
+	}
+	return len(f.Include) == 0 || matchAny(f.Include, name)
+}
+
+// sessionLogs lists the session files of all projects, including
+// compressed ones (.jsonl.gz, .jsonl.zst).
+func sessionLogs(projectsDir string) ([]string, error) {
+	var files []string
+	for _, ext := range append([]string{""}, compressedExts...) {
+		matches, err := filepath.Glob(filepath.Join(projectsDir, "*", "*.jsonl"+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// ClaudeSessions scans Claude Code session JSONL files modified after the
+// stats cache was last computed, i.e. activity the cache does not cover yet.
+type ClaudeSessions struct {
+	claudeDir        string
+	statsFile        string
+	pricing          *pricing.Table
+	projects         ProjectFilter
+	teams            *TeamMap
+	languages        *LanguageMap
+	liveWindow       time.Duration
+	budget           int
+	memoryBudget     int
+	backgroundModels []string
+	recentWindow     time.Duration
+	sampleEvery      int
+	now              func() time.Time
+
+	mu    sync.Mutex
+	files map[string]*sessionFile
+	// whether the last scan went over memoryBudget, to log changes
+	overBudget bool
+	// team of each project working directory, resolved once
+	dirTeams map[string]string
+	// language of each project working directory, resolved once
+	dirLanguages map[string]string
+	// last warning about malformed lines
+	malformedLogged time.Time
+}
+
+// ClaudeSessionsOptions configures the Claude session source.
+type ClaudeSessionsOptions struct {
+	ClaudeDir string
+	StatsFile string
+	Pricing   *pricing.Table
+	Projects  ProjectFilter
+	// Teams attributes all-time cost to teams, which makes the source read
+	// the files the stats cache covers too (nil disables it).
+	Teams *TeamMap
+	// Languages resolves the language of each project for
+	// LiveResult.ProjectLanguages (nil disables it).
+	Languages *LanguageMap
+	// LiveWindow also counts files modified this recently as live, for
+	// when the stats cache stops being recomputed (0 disables it).
+	LiveWindow time.Duration
+	// LineLimit bounds the bytes of one JSONL line held while parsing
+	// (0 uses DefaultLineLimit); see lineReader.
+	LineLimit int
+	// MemoryBudget bounds the bytes of parsed records kept between scans,
+	// counted by the lines they came from (0 keeps them all). The files
+	// over it are read whole on every scan instead of tailed.
+	MemoryBudget int
+	// BackgroundModels are model name substrings of the small model Claude
+	// Code uses for titles and summaries (nil uses DefaultBackgroundModels).
+	BackgroundModels []string
+	// RecentWindow is the span of the LiveResult.Recent* aggregates, which
+	// keep finished sessions observable after the stats cache absorbs them
+	// (0 uses RecentLookback).
+	RecentWindow time.Duration
+	// SampleEvery counts the usage of only one in this many API requests,
+	// chosen by request ID, and multiplies their tokens, cost, message,
+	// tool call and stop reason counts by it (0 or 1 counts them all).
+	// Timings and models are still read from every request.
+	SampleEvery int
+	// Now is the clock for the recent lookback, the live window and
+	// session ends (nil uses time.Now).
+	Now func() time.Time
+}
+
+// DefaultBackgroundModels is the default ClaudeSessionsOptions.BackgroundModels.
+var DefaultBackgroundModels = []string{"haiku"}
+
+func NewClaudeSessions(opts ClaudeSessionsOptions) *ClaudeSessions {
+	background := opts.BackgroundModels
+	if background == nil {
+		background = DefaultBackgroundModels
+	}
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	recent := opts.RecentWindow
+	if recent <= 0 {
+		recent = RecentLookback
+	}
+	return &ClaudeSessions{
+		sampleEvery:      max(opts.SampleEvery, 1),
+		claudeDir:        opts.ClaudeDir,
+		statsFile:        opts.StatsFile,
+		pricing:          opts.Pricing,
+		projects:         opts.Projects,
+		teams:            opts.Teams,
+		languages:        opts.Languages,
+		liveWindow:       opts.LiveWindow,
+		budget:           opts.LineLimit,
+		memoryBudget:     opts.MemoryBudget,
+		backgroundModels: background,
+		recentWindow:     recent,
+		now:              now,
+	}
+}
+
+// background reports whether a main-thread request was made by Claude Code
+// on its own, like a title or a summary, rather than for the user: a
+// background model answering without tools in a session whose main thread
+// runs on another model.
+func (s *ClaudeSessions) background(sess *Session, rec *JSONLRecord, msg *JSONLMessage, model string) bool {
+	if rec.Sidechain || sess.lastModel == "" || sess.lastModel == model {
+		return false
+	}
+	if !slices.ContainsFunc(s.backgroundModels, func(m string) bool { return strings.Contains(model, m) }) {
+		return false
+	}
+	return !slices.ContainsFunc(msg.Content, func(b ContentBlock) bool { return b.Type == "tool_use" })
+}
+
+func (s *ClaudeSessions) Name() string { return "claude-sessions" }
+
+// malformedLogInterval spaces the warnings about malformed lines, which
+// would otherwise repeat for every line of a new record format.
+const malformedLogInterval = time.Minute
+
+// reportMalformed logs new malformed lines of a file, at most once per
+// malformedLogInterval across files; the rest show up in the counts.
+func (s *ClaudeSessions) reportMalformed(name string, sf *sessionFile) {
+	if sf.malformed == sf.reported || time.Since(s.malformedLogged) < malformedLogInterval {
+		return
+	}
+	log.Printf("claude-sessions: %s: %d malformed lines, last at %s", name, sf.malformed, sf.malformedErr)
+	sf.reported = sf.malformed
+	s.malformedLogged = time.Now()
+}
+
+// requestKey identifies the API request a message belongs to. Claude writes
+// one record per content block, all carrying the same usage.
+func (rec *JSONLRecord) requestKey(msg *JSONLMessage) string {
+	if rec.RequestID != "" {
+		return rec.RequestID
+	}
+	return msg.ID
+}
+
+// extractMessage resolves the message from either direct field or nested data.message.message
+func (rec *JSONLRecord) extractMessage() *JSONLMessage {
+	if rec.Message != nil {
+		return rec.Message
+	}
+	if rec.Data != nil && rec.Data.Message != nil && rec.Data.Message.Message != nil {
+		return rec.Data.Message.Message
+	}
+	return nil
+}
+
+// cost prefers the cost recorded by the API over the pricing table.
+func (s *ClaudeSessions) cost(model string, u *JSONLUsage) float64 {
+	if u.Cost != nil {
+		return *u.Cost
+	}
+	return s.pricing.Cost(model, ptrVal(u.InputTokens), ptrVal(u.OutputTokens),
+		ptrVal(u.CacheReadInputTokens), ptrVal(u.CacheCreationInputTokens)) + s.webSearchCost(model, u)
+}
+
+// webSearchCost prices the request's web searches from the pricing table.
+func (s *ClaudeSessions) webSearchCost(model string, u *JSONLUsage) float64 {
+	if u.ServerToolUse == nil {
+		return 0
+	}
+	return s.pricing.WebSearchCost(model, float64(u.ServerToolUse.WebSearchRequests))
+}
+
+// addRecent records a message in result.Recent, and in the Recent*
+// aggregates when it falls in the recent window, once per request. Only
+// the usage of sampled requests is added.
+func (l *liveScan) addRecent(sess *Session, rec *JSONLRecord, sampled bool) {
+	msg := rec.extractMessage()
+	if msg == nil || rec.Type == "system" {
+		return
+	}
+	ts, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+	if err != nil || ts.Before(l.cutoff) {
+		return
+	}
+	inWindow := !ts.Before(l.recentFrom)
+	if rec.UUID != "" {
+		if _, dup := l.recentRecords[rec.UUID]; dup {
+			return
+		}
+		l.recentRecords[rec.UUID] = struct{}{}
+	}
+	result := l.result
+	name := model.Short(msg.Model)
+	if name == "" {
+		name = "unknown"
+	}
+	if inWindow && sampled {
+		for _, block := range msg.Content {
+			if block.Type == "tool_use" && block.Name != "" {
+				result.RecentTools[block.Name] += l.s.sampleEvery
+			}
+		}
+	}
+
+	if ptrVal(msg.Usage.InputTokens) == 0 && ptrVal(msg.Usage.OutputTokens) == 0 {
+		return
+	}
+	key := rec.requestKey(msg)
+	if key == "" {
+		key = rec.UUID
+	}
+	if key != "" {
+		if _, dup := l.recentRequests[key]; dup {
+			return
+		}
+		l.recentRequests[key] = struct{}{}
+	}
+	if inWindow {
+		if _, ok := l.recentSessions[sess.ID]; !ok {
+			l.recentSessions[sess.ID] = struct{}{}
+			result.RecentSessions++
+		}
+	}
+	if !sampled {
+		return
+	}
+	usage := &LiveModelUsage{
+		Input:       ptrVal(msg.Usage.InputTokens),
+		Output:      ptrVal(msg.Usage.OutputTokens),
+		CacheRead:   ptrVal(msg.Usage.CacheReadInputTokens),
+		CacheCreate: ptrVal(msg.Usage.CacheCreationInputTokens),
+		Cost:        l.s.cost(name, &msg.Usage),
+	}
+	usage.Scale(float64(l.s.sampleEvery))
+	result.Recent = append(result.Recent, UsageEvent{
+		Time: ts, Model: name, Input: usage.Input, Output: usage.Output, Cost: usage.Cost,
+	})
+	if !inWindow {
+		return
+	}
+	mu, ok := result.RecentUsage[name]
+	if !ok {
+		mu = &LiveModelUsage{}
+		result.RecentUsage[name] = mu
+	}
+	mu.Add(usage)
+	if msg.StopReason != nil && *msg.StopReason != "" {
+		byReason, ok := result.RecentStopReasons[name]
+		if !ok {
+			byReason = make(map[string]int)
+			result.RecentStopReasons[name] = byReason
+		}
+		byReason[*msg.StopReason] += l.s.sampleEvery
+	}
+}
+
+// observeEnd observes the duration and turns of a session idle for
+// SessionEndIdle. Every scan until the file is dropped observes it again,
+// under the same IDs, so it counts once.
+func (l *liveScan) observeEnd(sess *Session) {
+	if sess.LastActivity.IsZero() || l.s.now().Sub(sess.LastActivity) < SessionEndIdle {
+		return
+	}
+	// the session's samples date from its end
+	sess.recordTime = sess.LastActivity
+	l.result.SessionDurations = append(l.result.SessionDurations,
+		sess.observation("session:"+sess.ID, sess.LastActivity.Sub(sess.FirstActivity).Seconds()))
+	if sess.Turns > 0 {
+		l.result.SessionTurns = append(l.result.SessionTurns, sess.observation("turns:"+sess.ID, float64(sess.Turns)))
+	}
+}
+
+// endedSession fills the span and turns of sess from the records of a file
+// that is no longer live, and reports whether the session made requests.
+func endedSession(sess *Session, records []sessionRecord) bool {
+	requests := false
+	for i := range records {
+		r := &records[i]
+		if ts, err := time.Parse(time.RFC3339Nano, r.rec.Timestamp); err == nil {
+			sess.observeTime(ts)
+		}
+		if r.startsTurn() {
+			sess.Turns++
+		}
+		if msg := r.rec.extractMessage(); msg != nil && r.rec.Type == "assistant" &&
+			(ptrVal(msg.Usage.InputTokens) > 0 || ptrVal(msg.Usage.OutputTokens) > 0) {
+			requests = true
+		}
+	}
+	return requests
+}
+
+// team resolves the team of a session file from the working directory its
+// records carry, falling back to the project directory name.
+func (s *ClaudeSessions) team(sf *sessionFile, project string) string {
+	dir := ""
+	for i := range sf.records {
+		if dir = sf.records[i].rec.Cwd; dir != "" {
+			break
+		}
+	}
+	if dir == "" {
+		return s.teams.Team(project, "")
+	}
+	team, ok := s.dirTeams[dir]
+	if !ok {
+		team = s.teams.Team(dir, gitRemote(dir))
+		if s.dirTeams == nil {
+			s.dirTeams = make(map[string]string)
+		}
+		s.dirTeams[dir] = team
+	}
+	return team
+}
+
+// language resolves the language of a session file's project like team
+// does, from the working directory its records carry or the project
+// directory name.
+func (s *ClaudeSessions) language(sf *sessionFile, project string) string {
+	dir := ""
+	for i := range sf.records {
+		if dir = sf.records[i].rec.Cwd; dir != "" {
+			break
+		}
+	}
+	if dir == "" {
+		return s.languages.Language(project, "")
+	}
+	lang, ok := s.dirLanguages[dir]
+	if !ok {
+		lang = s.languages.Language(dir, gitRemote(dir))
+		if s.dirLanguages == nil {
+			s.dirLanguages = make(map[string]string)
+		}
+		s.dirLanguages[dir] = lang
+	}
+	return lang
+}
+
+// addTeam adds a message's cost to its team, once per request.
+func (l *liveScan) addTeam(team string, rec *JSONLRecord) {
+	msg := rec.extractMessage()
+	if msg == nil || rec.Type == "system" {
+		return
+	}
+	if ptrVal(msg.Usage.InputTokens) == 0 && ptrVal(msg.Usage.OutputTokens) == 0 {
+		return
+	}
+	key := rec.requestKey(msg)
+	if key == "" {
+		key = rec.UUID
+	}
+	if key != "" {
+		if _, dup := l.teamRequests[key]; dup {
+			return
+		}
+		l.teamRequests[key] = struct{}{}
+	}
+	name := model.Short(msg.Model)
+	if name == "" {
+		name = "unknown"
+	}
+	l.result.TeamCost[team] += l.s.cost(name, &msg.Usage) * float64(l.s.sampleEvery)
+}
+
+// sessionRecord is a parsed JSONL line kept between scans.
+type sessionRecord struct {
+	id          string
+	rec         JSONLRecord
+	interrupted bool
+	// whether the record's tokens, cost and counts are read; see sampled
+	sampled bool
+}
+
+// startsTurn reports whether the record is a prompt of the user on the
+// main thread.
+func (r *sessionRecord) startsTurn() bool {
+	rec := &r.rec
+	msg := rec.extractMessage()
+	if msg == nil || rec.Type == "system" || r.interrupted || rec.Sidechain || rec.Meta {
+		return false
+	}
+	role := msg.Role
+	if role == "" {
+		role = rec.Type
+	}
+	return role == "user" && !msg.Content.toolResult()
+}
+
+// sessionFile is the parsed content of one session file so far.
+type sessionFile struct {
+	tail    fileTail
+	records []sessionRecord
+	// bytes of the lines behind records
+	size int
+	// lines that failed to decode, the last error, and how many of them
+	// were already logged
+	malformed    int
+	malformedErr string
+	reported     int
+}
+
+// describeJSONError names what failed to decode, with the field path when
+// a value has an unexpected type, e.g. after Claude changes its format.
+func describeJSONError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return fmt.Sprintf("field %s: %s instead of %s", typeErr.Field, typeErr.Value, typeErr.Type)
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("invalid JSON at byte %d: %v", syntaxErr.Offset, syntaxErr)
+	}
+	return err.Error()
+}
+
+// sampled reports whether the request with key is among the one in every
+// requests that are read. Hashing the key, rather than counting records,
+// keeps the choice stable across scans and across the copies resumed
+// sessions make of a request.
+func sampled(key string, every int) bool {
+	if every <= 1 || key == "" {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()%uint32(every) == 0
+}
+
+// read parses the lines appended since the last scan, starting over when
+// the file was truncated or replaced. With sampleEvery above 1, assistant
+// records of requests left out of the sample are marked as such.
+func (f *sessionFile) read(path string, info os.FileInfo, budget, sampleEvery int) error {
+	reset, err := f.tail.read(path, info, budget, func(line []byte, lineNo int) {
+		var rec JSONLRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			f.malformed++
+			f.malformedErr = fmt.Sprintf("line %d: %s", lineNo, describeJSONError(err))
+			return
+		}
+		interrupted, inSample := false, true
+		if msg := rec.extractMessage(); msg != nil {
+			interrupted = msg.interrupted()
+			if rec.Type == "assistant" {
+				inSample = sampled(rec.requestKey(msg), sampleEvery)
+			}
+		}
+		id := rec.UUID
+		if id == "" {
+			id = fmt.Sprintf("%s:%d", path, lineNo)
+		}
+		f.records = append(f.records, sessionRecord{id: id, rec: rec, interrupted: interrupted, sampled: inSample})
+		f.size += len(line)
+	}, func() {
+		f.records = nil
+		f.size = 0
+		f.malformed, f.reported = 0, 0
+	})
+	if reset {
+		log.Printf("claude-sessions: %s was rewritten, rescanning", path)
+	}
+	return err
+}
+
+// drop forgets the records, so the next read parses the file from the
+// start. The malformed lines already logged aren't logged again.
+func (f *sessionFile) drop() {
+	f.tail = fileTail{inode: f.tail.inode}
+	f.records = nil
+	f.size = 0
+	f.malformed = 0
+}
+
+// liveScan aggregates records of all session files in one Scan.
+type liveScan struct {
+	s      *ClaudeSessions
+	result *LiveResult
+
+	// Resumed or --continue'd sessions rewrite earlier records into a new
+	// file, so dedupe by record UUID and count usage once per request.
+	seenRecords  map[string]struct{}
+	seenRequests map[string]struct{}
+	// Recent usage spans files the stats cache covers, so it is deduped
+	// separately. cutoff bounds Recent, recentFrom the Recent* aggregates.
+	recentRecords  map[string]struct{}
+	recentRequests map[string]struct{}
+	recentSessions map[string]struct{}
+	cutoff         time.Time
+	recentFrom     time.Time
+	// zone of the dates and hours, the clock's
+	loc *time.Location
+	// end of the stats cache coverage, see cacheBoundary
+	boundary time.Time
+	// timestamp of the latest usage limit notice
+	limitAt time.Time
+	// likewise team cost, which spans the full history
+	teamRequests map[string]struct{}
+}
+
+// add aggregates one record and reports whether it was a counted message.
+func (l *liveScan) add(sess *Session, r *sessionRecord, live, recent bool) bool {
+	rec := &r.rec
+	result := l.result
+
+	if recent {
+		l.addRecent(sess, rec, r.sampled)
+	}
+	if !live {
+		return false
+	}
+
+	if rec.UUID != "" {
+		if _, dup := l.seenRecords[rec.UUID]; dup {
+			result.DuplicateRecords++
+			return false
+		}
+		l.seenRecords[rec.UUID] = struct{}{}
+	}
+	// records before the boundary are already in the stats cache totals
+	cached := false
+	sess.recordTime = time.Time{}
+	if ts, err := time.Parse(time.RFC3339Nano, rec.Timestamp); err == nil {
+		sess.recordTime = ts
+		sess.observeTime(ts)
+		cached = ts.Before(l.boundary)
+		// prompts, tool results and the attachments Claude Code adds to them
+		if rec.Type != "assistant" && rec.UUID != "" {
+			if sess.requestStarts == nil {
+				sess.requestStarts = make(map[string]time.Time)
+			}
+			sess.requestStarts[rec.UUID] = ts
+		}
+	}
+	if rec.PermissionMode != "" {
+		sess.permissionMode = rec.PermissionMode
+		sess.planning = rec.PermissionMode == "plan"
+	}
+	if rec.Attachment != nil {
+		switch rec.Attachment.Type {
+		case "plan_mode":
+			sess.planning = true
+		case "plan_mode_exit":
+			sess.planning = false
+		}
+	}
+
+	// Handle system subtypes
+	if rec.Type == "system" {
+		switch rec.Subtype {
+		case "turn_duration":
+			if rec.DurationMs != nil {
+				result.TurnDurations = append(result.TurnDurations, sess.observation(r.id, *rec.DurationMs))
+				if *rec.DurationMs > 0 && sess.turnOutput > 0 && sess.lastModel != "" {
+					result.OutputSpeeds[sess.lastModel] = append(result.OutputSpeeds[sess.lastModel],
+						sess.observation(r.id+":speed", sess.turnOutput/(*rec.DurationMs/1000)))
+				}
+				if sess.turnCost > 0 {
+					result.TurnCosts = append(result.TurnCosts, sess.observation(r.id+":cost", sess.turnCost))
+				}
+				active := max(*rec.DurationMs-sess.turnWait*1000, 0)
+				result.TurnActiveDurations = append(result.TurnActiveDurations, sess.observation(r.id+":active", active))
+			}
+			sess.turnOutput, sess.turnCost, sess.turnWait = 0, 0, 0
+			sess.AwaitingInput = true
+		case "api_error":
+			result.APIErrors++
+			if rec.RetryAttempt != nil && *rec.RetryAttempt > 0 {
+				result.APIRetries++
+				if rec.MaxRetries != nil && *rec.RetryAttempt >= *rec.MaxRetries {
+					result.RetriesExhausted++
+				}
+			}
+			if rec.RetryInMs != nil && *rec.RetryInMs > 0 {
+				result.RetryDelays = append(result.RetryDelays, sess.observation(r.id, *rec.RetryInMs))
+			}
+		case "compact_boundary":
+			if rec.CompactMetadata != nil {
+				trigger := rec.CompactMetadata.Trigger
+				if trigger == "" {
+					trigger = "unknown"
+				}
+				result.CompactEvents++
+				result.CompactTriggers[trigger]++
+				sess.Compactions++
+				if rec.CompactMetadata.PreTokens > 0 {
+					result.CompactPreTokens[trigger] = append(result.CompactPreTokens[trigger], sess.observation(r.id, float64(rec.CompactMetadata.PreTokens)))
+				}
+			}
+		}
+		return false
+	}
+
+	if r.interrupted {
+		result.Interruptions++
+		sess.turnOutput, sess.turnCost, sess.turnWait = 0, 0, 0
+		sess.AwaitingInput = true
+		return false
+	}
+
+	// Handle message records (type=assistant or type=progress)
+	msg := rec.extractMessage()
+	if msg == nil {
+		return false
+	}
+
+	firstOfRequest := true
+	if key := rec.requestKey(msg); key != "" {
+		if _, seen := l.seenRequests[key]; seen {
+			firstOfRequest = false
+		} else {
+			l.seenRequests[key] = struct{}{}
+		}
+	}
+
+	role := msg.Role
+	if role == "" {
+		role = rec.Type
+	}
+	if !rec.Sidechain {
+		switch {
+		case r.startsTurn():
+			sess.Turns++
+			sess.AwaitingInput = false
+		case role == "assistant" && msg.StopReason != nil && *msg.StopReason != "":
+			sess.AwaitingInput = *msg.StopReason == "end_turn"
+		}
+	}
+	if !cached {
+		switch {
+		case role == "user":
+			result.RoleMessages["user"]++
+		case role == "assistant" && firstOfRequest && r.sampled:
+			result.RoleMessages["assistant"] += l.s.sampleEvery
+		}
+	}
+
+	inp := ptrVal(msg.Usage.InputTokens)
+	out := ptrVal(msg.Usage.OutputTokens)
+
+	if cached {
+		if firstOfRequest && (inp > 0 || out > 0) {
+			result.OverlapMessages++
+			sess.cached = true
+		}
+		return false
+	}
+
+	model := model.Short(msg.Model)
+	if model == "" {
+		model = "unknown"
+	}
+	result.observeProvider(model, msg.Model)
+
+	if notice, ok := msg.usageLimit(); ok {
+		result.UsageLimitEvents++
+		if ts, err := time.Parse(time.RFC3339Nano, rec.Timestamp); err == nil && ts.After(l.limitAt) {
+			l.limitAt = ts
+			result.UsageLimitReset = parseLimitReset(notice, ts, l.loc)
+		}
+	}
+
+	date, hour := "", 0
+	ts, tsErr := time.Parse(time.RFC3339Nano, rec.Timestamp)
+	if tsErr == nil {
+		date, hour = ts.In(l.loc).Format("2006-01-02"), ts.In(l.loc).Hour()
+	}
+
+	// Token usage, of the sampled requests only; the timing and model
+	// bookkeeping below reads every request
+	counted := false
+	_, reported := msg.Usage.thinkingTokens()
+	if firstOfRequest && (inp > 0 || out > 0) {
+		counted = true
+		background := l.s.background(sess, rec, msg, model)
+		if r.sampled {
+			l.addUsage(sess, r, msg, model, background, date, hour)
+		}
+
+		// Claude Code writes a content block once it is complete, so this is
+		// the latency to the first block rather than the first token
+		if start, ok := sess.requestStarts[rec.Parent]; ok && rec.Type == "assistant" {
+			if tsErr == nil && ts.After(start) {
+				result.FirstTokenLatency[model] = append(result.FirstTokenLatency[model],
+					sess.observation(r.id+":ttft", ts.Sub(start).Seconds()))
+			}
+		}
+		// subagents run on their own models, so only the main thread counts,
+		// and background requests don't switch it
+		if !rec.Sidechain && !background {
+			if sw, ok := sess.observeModel(model); ok {
+				result.ModelSwitches[sw]++
+			}
+			sess.turnOutput += out
+		}
+	}
+
+	// Tool usage and thinking from content blocks
+	for i, block := range msg.Content {
+		switch {
+		case block.Type == "tool_use" && block.Name != "":
+			call := Permission{Tool: block.Name, Decision: permissionDecision(sess.permissionMode, block.Name)}
+			if block.ID != "" {
+				if sess.toolCalls == nil {
+					sess.toolCalls = make(map[string]toolCall)
+				}
+				sess.toolCalls[block.ID] = toolCall{Permission: call, at: ts, sampled: r.sampled}
+			}
+			if !r.sampled {
+				continue
+			}
+			result.ToolUseCounts[block.Name] += l.s.sampleEvery
+			result.Permissions[call] += l.s.sampleEvery
+			if date != "" {
+				result.ToolUses = append(result.ToolUses, ToolUse{
+					ID: fmt.Sprintf("%s:tool%d", r.id, i), Date: date, Tool: block.Name, Count: l.s.sampleEvery,
+				})
+			}
+		case block.Type == "tool_result":
+			call, ok := sess.toolCalls[block.ToolUseID]
+			if ok && call.mayPrompt() && tsErr == nil && ts.After(call.at) {
+				wait := ts.Sub(call.at).Seconds()
+				result.ApprovalWaits = append(result.ApprovalWaits, sess.observation(fmt.Sprintf("%s:wait%d", r.id, i), wait))
+				if !rec.Sidechain {
+					sess.addTurnWait(call.at, ts)
+				}
+			}
+			switch {
+			case !ok || !call.sampled || !block.IsError:
+			case block.denied:
+				// counted as allowed or auto when the call was made
+				result.Permissions[call.Permission] -= l.s.sampleEvery
+				result.Permissions[Permission{Tool: call.Tool, Decision: "denied"}] += l.s.sampleEvery
+			default:
+				result.ToolErrors[call.Tool] += l.s.sampleEvery
+			}
+			if block.planApproved && !block.IsError {
+				sess.planning = false
+				sess.permissionMode = "default"
+			}
+		case block.Type == "thinking" && !reported && r.sampled:
+			result.model(model).Thinking += float64(block.thinking.chars) / thinkingCharsPerToken * float64(l.s.sampleEvery)
+		}
+	}
+
+	if !firstOfRequest || !r.sampled {
+		return counted
+	}
+
+	// Stop reason
+	if msg.StopReason != nil && *msg.StopReason != "" {
+		byReason, ok := result.StopReasons[model]
+		if !ok {
+			byReason = make(map[string]int)
+			result.StopReasons[model] = byReason
+		}
+		byReason[*msg.StopReason] += l.s.sampleEvery
+	}
+
+	// Server tool use (web search/fetch)
+	if msg.Usage.ServerToolUse != nil {
+		result.WebSearches += msg.Usage.ServerToolUse.WebSearchRequests * l.s.sampleEvery
+		result.WebFetches += msg.Usage.ServerToolUse.WebFetchRequests * l.s.sampleEvery
+	}
+	return counted
+}
+
+// addUsage adds the tokens and cost of a sampled request, scaled by the
+// sample rate. date is empty when the record has no valid timestamp.
+func (l *liveScan) addUsage(sess *Session, r *sessionRecord, msg *JSONLMessage, model string, background bool, date string, hour int) {
+	result := l.result
+	inp := ptrVal(msg.Usage.InputTokens)
+	thinking, _ := msg.Usage.thinkingTokens()
+	usage := &LiveModelUsage{
+		Input:       inp,
+		Output:      ptrVal(msg.Usage.OutputTokens),
+		CacheRead:   ptrVal(msg.Usage.CacheReadInputTokens),
+		CacheCreate: ptrVal(msg.Usage.CacheCreationInputTokens),
+		Cost:        l.s.cost(model, &msg.Usage),
+		Thinking:    thinking,
+
+		WebSearchCost: l.s.webSearchCost(model, &msg.Usage),
+	}
+	usage.Scale(float64(l.s.sampleEvery))
+	result.model(model).Add(usage)
+	result.MessageCount += l.s.sampleEvery
+	sess.turnCost += usage.Cost
+	sess.usage(model).Add(usage)
+	sess.Messages += l.s.sampleEvery
+	// unscaled: a sampled request stands for others of its size
+	contextTokens := inp + ptrVal(msg.Usage.CacheReadInputTokens) + ptrVal(msg.Usage.CacheCreationInputTokens)
+	result.RequestContexts[model] = append(result.RequestContexts[model], sess.observation(r.id+":context", contextTokens))
+
+	if date != "" {
+		result.DailyUsage = append(result.DailyUsage, DatedUsage{
+			ID: r.id, Date: date, Hour: hour, Session: sess.ID, Project: sess.Project, Model: model, Usage: *usage,
+		})
+		hourKey := fmt.Sprintf("%02d", hour)
+		byModel, ok := result.HourUsage[hourKey]
+		if !ok {
+			byModel = make(map[string]*LiveModelUsage)
+			result.HourUsage[hourKey] = byModel
+		}
+		if byModel[model] == nil {
+			byModel[model] = &LiveModelUsage{}
+		}
+		byModel[model].Add(usage)
+	}
+	class := UsageClass{Mode: sess.mode(), Purpose: PurposeInteractive}
+	if background {
+		class.Purpose = PurposeBackground
+	}
+	result.classUsage(class, model).Add(usage)
+}
+
+func (s *ClaudeSessions) Scan(ctx context.Context) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &LiveResult{
+		ModelUsage:    make(map[string]*LiveModelUsage),
+		ToolUseCounts: make(map[string]int),
+		ToolErrors:    make(map[string]int),
+		Permissions:   make(map[Permission]int),
+		StopReasons:   make(map[string]map[string]int),
+		OutputSpeeds:  make(map[string][]Observation),
+
+		FirstTokenLatency: make(map[string][]Observation),
+		RequestContexts:   make(map[string][]Observation),
+		Versions:          make(map[string]int),
+		ModelSwitches:     make(map[ModelSwitch]int),
+		HourUsage:         make(map[string]map[string]*LiveModelUsage),
+		ClassUsage:        make(map[UsageClass]map[string]*LiveModelUsage),
+		TeamCost:          make(map[string]float64),
+		ProjectLanguages:  make(map[string]string),
+		LiveFiles:         make(map[string]int),
+		Providers:         make(map[string]string),
+		RoleMessages:      make(map[string]int),
+
+		CompactTriggers:  make(map[string]int),
+		CompactPreTokens: make(map[string][]Observation),
+
+		RecentWindow:      s.recentWindow,
+		RecentUsage:       make(map[string]*LiveModelUsage),
+		RecentTools:       make(map[string]int),
+		RecentStopReasons: make(map[string]map[string]int),
+		MalformedLines:    make(map[string]int),
+	}
+
+	projectsDir := filepath.Join(s.claudeDir, "projects")
+	if _, err := os.Stat(projectsDir); err != nil {
+		return &Snapshot{Live: result}, nil
+	}
+
+	cacheMtime := cacheMtime(s.statsFile)
+
+	files, err := sessionLogs(projectsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &liveScan{
+		s:              s,
+		result:         result,
+		seenRecords:    make(map[string]struct{}),
+		seenRequests:   make(map[string]struct{}),
+		recentRecords:  make(map[string]struct{}),
+		recentRequests: make(map[string]struct{}),
+		recentSessions: make(map[string]struct{}),
+		teamRequests:   make(map[string]struct{}),
+		cutoff:         s.now().Add(-max(RecentLookback, s.recentWindow)),
+		recentFrom:     s.now().Add(-s.recentWindow),
+		loc:            s.now().Location(),
+		boundary:       cacheBoundary(s.statsFile, s.now().Location()),
+	}
+	if s.files == nil {
+		s.files = make(map[string]*sessionFile)
+	}
+	scanned := make(map[string]bool)
+	incomplete := false
+
+	for _, fpath := range files {
+		if ctx.Err() != nil {
+			// keep what was read, the remaining files wait for the next scan
+			incomplete = true
+			break
+		}
+		if !s.projects.Allows(filepath.Base(filepath.Dir(fpath))) {
+			continue
+		}
+		info, err := os.Stat(fpath)
+		if err != nil {
+			continue
+		}
+		live := info.ModTime().After(cacheMtime)
+		switch {
+		case live && cacheMtime.IsZero():
+			result.LiveFiles[LiveNoStatsCache]++
+		case live:
+			result.LiveFiles[LiveNewerThanCache]++
+		case s.liveWindow > 0 && s.now().Sub(info.ModTime()) < s.liveWindow:
+			live = true
+			result.LiveFiles[LiveInWindow]++
+		}
+		recent := info.ModTime().After(l.cutoff)
+		if !live && !recent && s.teams == nil {
+			continue
+		}
+
+		sf, ok := s.files[fpath]
+		if !ok {
+			sf = &sessionFile{}
+			s.files[fpath] = sf
+		}
+		if err := sf.read(fpath, info, s.budget, s.sampleEvery); err != nil {
+			log.Printf("claude-sessions: failed to read %s: %v", fpath, err)
+		}
+		scanned[fpath] = true
+		result.OversizedLines += sf.tail.oversized
+		if sf.malformed > 0 {
+			name, _ := filepath.Rel(projectsDir, fpath)
+			result.MalformedLines[name] = sf.malformed
+			s.reportMalformed(name, sf)
+		}
+
+		sess := &Session{
+			ID:      trimLogExt(filepath.Base(fpath)),
+			Project: filepath.Base(filepath.Dir(fpath)),
+		}
+		team := ""
+		if s.teams != nil {
+			team = s.team(sf, sess.Project)
+		}
+		if s.languages != nil {
+			// a file without a working directory doesn't override one with
+			if lang := s.language(sf, sess.Project); lang != "unknown" || result.ProjectLanguages[sess.Project] == "" {
+				result.ProjectLanguages[sess.Project] = lang
+			}
+		}
+		sessionHasMessages := false
+		version := ""
+		for i := range sf.records {
+			if team != "" && sf.records[i].sampled {
+				l.addTeam(team, &sf.records[i].rec)
+			}
+			if l.add(sess, &sf.records[i], live, recent) {
+				sessionHasMessages = true
+			}
+			if v := sf.records[i].rec.Version; v != "" {
+				version = v
+			}
+		}
+		if sessionHasMessages {
+			result.SessionCount++
+			result.Sessions = append(result.Sessions, sess)
+			if sess.cached {
+				result.OverlapSessions++
+			}
+			if version != "" {
+				result.Versions[version]++
+			}
+			l.observeEnd(sess)
+		} else if !live && recent && endedSession(sess, sf.records) {
+			// the stats cache took the file over, possibly before the
+			// session was idle long enough to count as ended
+			l.observeEnd(sess)
+		}
+
+		// the records are counted; keep them only while the scan is
+		// within its memory budget
+		if s.memoryBudget > 0 && result.RecordBytes+sf.size > s.memoryBudget {
+			sf.drop()
+			result.OverBudgetFiles++
+		}
+		result.RecordBytes += sf.size
+	}
+	if over := result.OverBudgetFiles > 0; over != s.overBudget {
+		s.overBudget = over
+		if over {
+			log.Printf("claude-sessions: session records are over the memory budget, reading %d file(s) whole on every scan", result.OverBudgetFiles)
+		} else {
+			log.Printf("claude-sessions: session records are within the memory budget again")
+		}
+	}
+
+	// forget files that are gone or no longer live
+	for path := range s.files {
+		if !scanned[path] && !incomplete {
+			delete(s.files, path)
+		}
+	}
+
+	return &Snapshot{Live: result, Incomplete: incomplete}, nil
+}
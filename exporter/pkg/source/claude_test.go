@@ -0,0 +1,331 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// writeSampledSession writes a session of turns of every requests each, of
+// which exactly one is in the sample of SAMPLE_EVERY=every. The requests of
+// a turn are alike, so a sampled scan scaled by every matches an unsampled
+// one. Turns alternate between two models.
+func writeSampledSession(t *testing.T, claudeDir string, turns, every int, start time.Time) {
+	t.Helper()
+	dir := filepath.Join(claudeDir, "projects", "-home-dev-app")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	ts := start
+	stamp := func() string {
+		ts = ts.Add(time.Second)
+		return ts.Format("2006-01-02T15:04:05.000Z")
+	}
+	n := 0
+	for turn := range turns {
+		model := []string{"claude-sonnet-4-5-20250929", "claude-opus-4-1-20250805"}[turn%2]
+		parent := fmt.Sprintf("u%d", turn)
+		fmt.Fprintf(&b, `{"type":"user","uuid":"%s","sessionId":"s1","timestamp":"%s","cwd":"/home/dev/app","message":{"role":"user","content":"step %d"}}`+"\n",
+			parent, stamp(), turn)
+		picked := false
+		for range every {
+			// the next request ID that fills the turn's sampled or unsampled slot
+			var key string
+			for ; ; n++ {
+				key = fmt.Sprintf("req_%d", n)
+				if sampled(key, every) != picked {
+					break
+				}
+			}
+			n++
+			picked = true
+			fmt.Fprintf(&b, `{"type":"assistant","uuid":"a%[1]s","parentUuid":"%[3]s","sessionId":"s1","timestamp":"%[2]s","cwd":"/home/dev/app","requestId":"%[1]s","message":{"id":"msg_%[1]s","role":"assistant","model":"%[4]s","stop_reason":"tool_use","content":[{"type":"tool_use","id":"toolu_%[1]s","name":"Bash","input":{}}],"usage":{"input_tokens":100,"output_tokens":50,"server_tool_use":{"web_search_requests":1}}}}`+"\n",
+				key, stamp(), parent, model)
+			parent = "r" + key
+			fmt.Fprintf(&b, `{"type":"user","uuid":"%[3]s","parentUuid":"a%[1]s","sessionId":"s1","timestamp":"%[2]s","cwd":"/home/dev/app","message":{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_%[1]s","is_error":true,"content":"exit status 1"}]}}`+"\n",
+				key, stamp(), parent)
+		}
+		fmt.Fprintf(&b, `{"type":"system","subtype":"turn_duration","uuid":"d%d","sessionId":"s1","timestamp":"%s","durationMs":10000}`+"\n",
+			turn, stamp())
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s1.jsonl"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func scanLive(t *testing.T, claudeDir string, every int, now time.Time) *LiveResult {
+	t.Helper()
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewClaudeSessions(ClaudeSessionsOptions{
+		ClaudeDir:   claudeDir,
+		StatsFile:   filepath.Join(claudeDir, "stats-cache.json"),
+		Pricing:     prices,
+		SampleEvery: every,
+		Now:         func() time.Time { return now },
+	})
+	snap, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snap.Live
+}
+
+func TestSampledScanMatchesFullScan(t *testing.T) {
+	const every = 4
+	dir := t.TempDir()
+	start := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	writeSampledSession(t, dir, 5, every, start)
+	now := start.Add(10 * time.Minute)
+
+	full := scanLive(t, dir, 1, now)
+	sampled := scanLive(t, dir, every, now)
+	if full.MessageCount != 5*every {
+		t.Fatalf("full scan counted %d messages, want %d", full.MessageCount, 5*every)
+	}
+
+	counts := func(r *LiveResult) map[string]any {
+		toolUses := 0
+		for _, u := range r.ToolUses {
+			toolUses += u.Count
+		}
+		var speeds []float64
+		for _, obs := range r.OutputSpeeds {
+			for _, o := range obs {
+				speeds = append(speeds, o.Value)
+			}
+		}
+		slices.Sort(speeds)
+		output := 0.0
+		for _, u := range r.ModelUsage {
+			output += u.Output
+		}
+		return map[string]any{
+			"messages":      r.MessageCount,
+			"output tokens": output,
+			"tool uses":     r.ToolUseCounts,
+			"tool errors":   r.ToolErrors,
+			"permissions":   r.Permissions,
+			"stop reasons":  r.StopReasons,
+			"web searches":  r.WebSearches,
+			"recent tools":  r.RecentTools,
+			"recent stops":  r.RecentStopReasons,
+			"daily tools":   toolUses,
+			"output speeds": speeds,
+		}
+	}
+	want, got := counts(full), counts(sampled)
+	for key, w := range want {
+		if !reflect.DeepEqual(got[key], w) {
+			t.Errorf("%s: sampled scan has %v, full scan %v", key, got[key], w)
+		}
+	}
+
+	// timing and model bookkeeping reads every request, unscaled
+	bookkeeping := func(r *LiveResult) map[string]any {
+		ttft := 0
+		for _, obs := range r.FirstTokenLatency {
+			ttft += len(obs)
+		}
+		var models []string
+		for _, sess := range r.Sessions {
+			models = append(models, sess.Models...)
+		}
+		return map[string]any{
+			"approval waits": len(r.ApprovalWaits),
+			"ttft":           ttft,
+			"model switches": r.ModelSwitches,
+			"models":         models,
+			"sessions":       r.SessionCount,
+		}
+	}
+	want, got = bookkeeping(full), bookkeeping(sampled)
+	if want["approval waits"] != 5*every || want["ttft"] != 5*every {
+		t.Fatalf("full scan has %v approval waits and %v first token latencies, want %d", want["approval waits"], want["ttft"], 5*every)
+	}
+	for key, w := range want {
+		if !reflect.DeepEqual(got[key], w) {
+			t.Errorf("%s: sampled scan has %v, full scan %v", key, got[key], w)
+		}
+	}
+}
+
+func TestSampledTotalsApproximate(t *testing.T) {
+	const (
+		every    = 4
+		requests = 4000
+	)
+	dir := filepath.Join(t.TempDir(), "projects", "-home-dev-app")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// request IDs as they come, so the sample is whatever the hash picks
+	var b strings.Builder
+	start := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	for i := range requests {
+		fmt.Fprintf(&b, `{"type":"assistant","uuid":"a%[1]d","sessionId":"s1","timestamp":"%[2]s","requestId":"req_011CT%[1]dx","message":{"id":"msg_%[1]d","role":"assistant","model":"claude-sonnet-4-5-20250929","stop_reason":"end_turn","content":[{"type":"text","text":"ok"}],"usage":{"input_tokens":%[3]d,"output_tokens":%[4]d}}}`+"\n",
+			i, start.Add(time.Duration(i)*time.Second).Format("2006-01-02T15:04:05.000Z"), 100+i%50, 20+i%30)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s1.jsonl"), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	now := start.Add(requests*time.Second + time.Minute)
+	claudeDir := filepath.Dir(filepath.Dir(dir))
+
+	full := scanLive(t, claudeDir, 1, now)
+	sampled := scanLive(t, claudeDir, every, now)
+	if full.MessageCount != requests {
+		t.Fatalf("full scan counted %d messages, want %d", full.MessageCount, requests)
+	}
+	if sampled.MessageCount == requests {
+		t.Fatal("the sample picked exactly one in every requests, so the test shows nothing")
+	}
+	u, su := full.ModelUsage["claude-sonnet-4-5-20250929"], sampled.ModelUsage["claude-sonnet-4-5-20250929"]
+	if u == nil || su == nil {
+		t.Fatalf("no sonnet usage in %v and %v", full.ModelUsage, sampled.ModelUsage)
+	}
+	for _, tt := range []struct {
+		name        string
+		full, scale float64
+	}{
+		{"messages", float64(full.MessageCount), float64(sampled.MessageCount)},
+		{"input tokens", u.Input, su.Input},
+		{"output tokens", u.Output, su.Output},
+		{"cost", u.Cost, su.Cost},
+	} {
+		if diff := math.Abs(tt.scale-tt.full) / tt.full; diff > 0.1 {
+			t.Errorf("%s: sampled scan estimates %.0f, %.1f%% off the full scan's %.0f", tt.name, tt.scale, diff*100, tt.full)
+		}
+	}
+}
+
+func TestMemoryBudgetKeepsTotals(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.CopyFS(dir, os.DirFS(filepath.Join("..", "..", "testdata", "golden", "basic", "claude"))); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "stats-cache.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	// the files are scanned in path order, -home-dev-api first
+	first, err := os.Stat(filepath.Join(dir, "projects", "-home-dev-api", "9d3c71e4-0b6a-4f28-a5e2-7c14b8f09d35.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices, err := pricing.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := func(budget int) *ClaudeSessions {
+		return NewClaudeSessions(ClaudeSessionsOptions{
+			ClaudeDir:    dir,
+			StatsFile:    filepath.Join(dir, "stats-cache.json"),
+			Pricing:      prices,
+			MemoryBudget: budget,
+		})
+	}
+	scan := func(s *ClaudeSessions) *LiveResult {
+		t.Helper()
+		snap, err := s.Scan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snap.Live
+	}
+	totals := func(r *LiveResult) map[string]any {
+		usage := make(map[string]LiveModelUsage)
+		for model, u := range r.ModelUsage {
+			usage[model] = *u
+		}
+		return map[string]any{
+			"sessions":    r.SessionCount,
+			"messages":    r.MessageCount,
+			"usage":       usage,
+			"tool uses":   r.ToolUseCounts,
+			"tool errors": r.ToolErrors,
+			"durations":   len(r.SessionDurations),
+		}
+	}
+	want := totals(scan(scanner(0)))
+
+	tests := []struct {
+		name       string
+		budget     int
+		overBudget int
+	}{
+		{name: "none kept", budget: 1, overBudget: 2},
+		{name: "one kept", budget: int(first.Size()), overBudget: 1},
+		{name: "all kept", budget: 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := scanner(tt.budget)
+			// the second scan rereads the files that weren't kept
+			for range 2 {
+				r := scan(s)
+				if r.OverBudgetFiles != tt.overBudget {
+					t.Errorf("%d files over budget, want %d", r.OverBudgetFiles, tt.overBudget)
+				}
+				if r.RecordBytes > tt.budget {
+					t.Errorf("kept %d bytes of records, over the budget of %d", r.RecordBytes, tt.budget)
+				}
+				got := totals(r)
+				for key, w := range want {
+					if !reflect.DeepEqual(got[key], w) {
+						t.Errorf("%s: %v within the budget, %v without", key, got[key], w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSessionEndAfterStatsCache(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+	writeSampledSession(t, dir, 3, 1, start)
+	// the last record is the third turn's duration, 4 records a turn
+	end := start.Add(12 * time.Second)
+
+	// still live and active: not ended yet
+	if live := scanLive(t, dir, 1, end.Add(10*time.Minute)); len(live.SessionDurations) != 0 {
+		t.Fatalf("observed %v before the session ended", live.SessionDurations)
+	}
+
+	// the stats cache is recomputed before the session is idle for
+	// SessionEndIdle, so the file is no longer live once it is
+	cache := filepath.Join(dir, "stats-cache.json")
+	if err := os.WriteFile(cache, []byte(`{"version":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(cache, later, later); err != nil {
+		t.Fatal(err)
+	}
+	live := scanLive(t, dir, 1, end.Add(2*time.Hour))
+	if live.SessionCount != 0 {
+		t.Fatalf("the file still counts as live, in %d sessions", live.SessionCount)
+	}
+	if len(live.SessionDurations) != 1 || live.SessionDurations[0].Value != end.Sub(start.Add(time.Second)).Seconds() {
+		t.Errorf("session durations %v, want one of %v", live.SessionDurations, end.Sub(start.Add(time.Second)).Seconds())
+	}
+	if len(live.SessionTurns) != 1 || live.SessionTurns[0].Value != 3 {
+		t.Errorf("session turns %v, want one of 3", live.SessionTurns)
+	}
+	if len(live.SessionDurations) > 0 && live.SessionDurations[0].ID != "session:s1" {
+		t.Errorf("observed the session as %q, want the ID live scans use", live.SessionDurations[0].ID)
+	}
+}
@@ -0,0 +1,142 @@
+package source
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// --- Codex CLI rollout files ---
+//
+// Codex writes one rollout JSONL per session under
+// ~/.codex/sessions/YYYY/MM/DD/rollout-*.jsonl. Every line is wrapped as
+// {"timestamp", "type", "payload"}; token usage arrives as cumulative
+// event_msg/token_count events.
+
+type CodexLine struct {
+	Timestamp string          `json:"timestamp"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+type CodexPayload struct {
+	Type  string          `json:"type"`
+	Model string          `json:"model,omitempty"`
+	Role  string          `json:"role,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Info  *CodexTokenInfo `json:"info,omitempty"`
+}
+
+type CodexTokenInfo struct {
+	TotalTokenUsage *CodexTokenUsage `json:"total_token_usage"`
+	LastTokenUsage  *CodexTokenUsage `json:"last_token_usage"`
+}
+
+type CodexTokenUsage struct {
+	InputTokens           float64 `json:"input_tokens"`
+	CachedInputTokens     float64 `json:"cached_input_tokens"`
+	OutputTokens          float64 `json:"output_tokens"`
+	ReasoningOutputTokens float64 `json:"reasoning_output_tokens"`
+	TotalTokens           float64 `json:"total_tokens"`
+}
+
+func (u CodexTokenUsage) sub(prev CodexTokenUsage) CodexTokenUsage {
+	return CodexTokenUsage{
+		InputTokens:           u.InputTokens - prev.InputTokens,
+		CachedInputTokens:     u.CachedInputTokens - prev.CachedInputTokens,
+		OutputTokens:          u.OutputTokens - prev.OutputTokens,
+		ReasoningOutputTokens: u.ReasoningOutputTokens - prev.ReasoningOutputTokens,
+		TotalTokens:           u.TotalTokens - prev.TotalTokens,
+	}
+}
+
+// NewCodex scans Codex rollout files under codexDir/sessions.
+func NewCodex(codexDir string, pricing *pricing.Table) *AgentSource {
+	return &AgentSource{
+		provider: "codex",
+		root:     filepath.Join(codexDir, "sessions"),
+		match:    isCodexRollout,
+		parse:    parseCodexRollout,
+		pricing:  pricing,
+	}
+}
+
+func isCodexRollout(path string) bool {
+	return strings.HasSuffix(path, ".jsonl")
+}
+
+func parseCodexRollout(path string, pricing *pricing.Table) (*AgentUsage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	res := NewAgentUsage()
+	name := "unknown"
+	var prev CodexTokenUsage
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var line CodexLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || len(line.Payload) == 0 {
+			continue
+		}
+		var p CodexPayload
+		if err := json.Unmarshal(line.Payload, &p); err != nil {
+			continue
+		}
+
+		switch line.Type {
+		case "turn_context":
+			if p.Model != "" {
+				name = model.Short(p.Model)
+			}
+		case "response_item":
+			switch p.Type {
+			case "message":
+				if p.Role == "assistant" {
+					res.Messages++
+				}
+			case "function_call", "custom_tool_call":
+				if p.Name != "" {
+					res.Tools[p.Name]++
+				}
+			case "local_shell_call":
+				res.Tools["local_shell"]++
+			case "web_search_call":
+				res.Tools["web_search"]++
+			}
+		case "event_msg":
+			if p.Type != "token_count" || p.Info == nil || p.Info.TotalTokenUsage == nil {
+				continue
+			}
+			total := *p.Info.TotalTokenUsage
+			delta := total.sub(prev)
+			if delta.TotalTokens < 0 {
+				// counter restarted (e.g. resumed session); take it as new
+				delta = total
+			}
+			prev = total
+			if delta.TotalTokens == 0 {
+				continue
+			}
+
+			// OpenAI input tokens include cached ones; split them like Claude does
+			input := delta.InputTokens - delta.CachedInputTokens
+			res.Add(line.Timestamp, name, &LiveModelUsage{
+				Input:     input,
+				Output:    delta.OutputTokens,
+				CacheRead: delta.CachedInputTokens,
+				Cost:      pricing.Cost(name, input, delta.OutputTokens, delta.CachedInputTokens, 0),
+			}, delta.ReasoningOutputTokens)
+		}
+	}
+	return res, scanner.Err()
+}
@@ -0,0 +1,229 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// --- message content ---
+
+// MessageContent is a message's content blocks. User messages may carry a
+// plain string instead, which decodes as a single text block.
+//
+// Content holds whole files, tool results and replies, while the metrics
+// only need tool calls and their results, plan approvals, thinking
+// lengths, interruptions and usage limit notices, so decoding is
+// selective: content without any block the metrics read is skipped, and
+// otherwise only the keys of each block listed in ContentBlock are looked
+// at.
+type MessageContent []ContentBlock
+
+// contentMarkers appear in any content the metrics read. Tool results are
+// always read for the call they answer; whether one failed is parsed, not
+// matched, since encoders differ in spacing.
+var contentMarkers = [][]byte{
+	[]byte(`"tool_use"`),
+	[]byte(`"tool_result"`),
+	[]byte(`"thinking"`),
+	planApproval,
+	[]byte(interruptMarker),
+	[]byte(usageLimitMarker),
+}
+
+// UnmarshalJSON is only called by encoding/json, which has validated data.
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	*c = nil
+	switch {
+	case len(data) == 0:
+	case data[0] == '"':
+		block := ContentBlock{Type: "text"}
+		block.text.set(data)
+		*c = MessageContent{block}
+	case data[0] == '[' && hasContentMarker(data):
+		*c = decodeContent(data)
+	}
+	return nil
+}
+
+// toolResult reports whether the content carries a tool result, i.e. the
+// user message answers a tool call rather than being a prompt.
+func (c MessageContent) toolResult() bool {
+	for _, block := range c {
+		if block.Type == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasContentMarker(data []byte) bool {
+	for _, m := range contentMarkers {
+		if bytes.Contains(data, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContentBlock is one content block of a message: text and thinking
+// bodies are measured, never copied, and tool inputs and results skipped.
+type ContentBlock struct {
+	Type string
+	Name string // tool name for tool_use blocks
+	ID   string // tool_use block ID, referenced by its tool_result
+	// tool_result blocks: the tool_use they answer, and whether the tool
+	// failed
+	ToolUseID string
+	IsError   bool
+
+	denied       bool // tool_result rejected at the permission prompt or by a rule
+	planApproved bool // tool_result accepting an ExitPlanMode plan
+
+	thinking textStats
+	text     textStats
+}
+
+// textStats summarizes a JSON string without unescaping or copying it.
+type textStats struct {
+	chars     int  // encoded length, close enough to estimate tokens
+	interrupt bool // starts with interruptMarker
+	// the text of a short block mentioning usageLimitMarker, the only
+	// text that is kept
+	notice string
+}
+
+// maxNoticeLen bounds the text kept as textStats.notice.
+const maxNoticeLen = 512
+
+func (t *textStats) set(value []byte) {
+	*t = textStats{}
+	if len(value) < 2 || value[0] != '"' {
+		return
+	}
+	body := value[1 : len(value)-1]
+	*t = textStats{chars: len(body), interrupt: bytes.HasPrefix(body, []byte(interruptMarker))}
+	if len(body) <= maxNoticeLen && bytes.Contains(body, []byte(usageLimitMarker)) {
+		t.notice = unquote(value)
+	}
+}
+
+// decodeContent walks a valid JSON array of content blocks, reading the
+// keys read into ContentBlock and skipping every other value.
+func decodeContent(data []byte) MessageContent {
+	var blocks MessageContent
+	i := skipSpace(data, 1)
+	for i < len(data) && data[i] == '{' {
+		var b ContentBlock
+		i = skipSpace(data, i+1)
+		for i < len(data) && data[i] == '"' {
+			keyEnd := stringEnd(data, i)
+			key := data[i+1 : keyEnd-1]
+			i = skipSpace(data, skipSpace(data, keyEnd)+1) // past ':'
+			end := valueEnd(data, i)
+			switch value := data[i:end]; string(key) {
+			case "type":
+				b.Type = unquote(value)
+			case "name":
+				b.Name = unquote(value)
+			case "id":
+				b.ID = unquote(value)
+			case "tool_use_id":
+				b.ToolUseID = unquote(value)
+			case "is_error":
+				b.IsError = string(value) == "true"
+			case "content":
+				b.denied = permissionDenied(value)
+				b.planApproved = len(value) > 1 && bytes.HasPrefix(value[1:], planApproval)
+			case "thinking":
+				b.thinking.set(value)
+			case "text":
+				b.text.set(value)
+			}
+			i = skipComma(data, skipSpace(data, end))
+		}
+		blocks = append(blocks, b)
+		i = skipComma(data, skipSpace(data, i+1)) // past '}'
+	}
+	return blocks
+}
+
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func skipComma(data []byte, i int) int {
+	if i < len(data) && data[i] == ',' {
+		return skipSpace(data, i+1)
+	}
+	return i
+}
+
+// stringEnd returns the index after the closing quote of the string
+// starting at data[i].
+func stringEnd(data []byte, i int) int {
+	for j := i + 1; j < len(data); j++ {
+		k := bytes.IndexByte(data[j:], '"')
+		if k < 0 {
+			break
+		}
+		j += k
+		// an odd run of backslashes escapes the quote
+		n := 0
+		for p := j - 1; p > i && data[p] == '\\'; p-- {
+			n++
+		}
+		if n%2 == 0 {
+			return j + 1
+		}
+	}
+	return len(data)
+}
+
+// valueEnd returns the index after the value starting at data[i].
+func valueEnd(data []byte, i int) int {
+	if i >= len(data) {
+		return i
+	}
+	switch data[i] {
+	case '"':
+		return stringEnd(data, i)
+	case '{', '[':
+		depth := 0
+		for i < len(data) {
+			switch data[i] {
+			case '"':
+				i = stringEnd(data, i)
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+			i++
+		}
+		return i
+	}
+	for i < len(data) && data[i] != ',' && data[i] != '}' && data[i] != ']' &&
+		data[i] != ' ' && data[i] != '\n' && data[i] != '\r' && data[i] != '\t' {
+		i++
+	}
+	return i
+}
+
+// unquote decodes a JSON string value, "" for anything else.
+func unquote(value []byte) string {
+	if len(value) < 2 || value[0] != '"' {
+		return ""
+	}
+	if bytes.IndexByte(value, '\\') < 0 {
+		return string(value[1 : len(value)-1])
+	}
+	var s string
+	json.Unmarshal(value, &s)
+	return s
+}
@@ -0,0 +1,158 @@
+package source
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestToolResultContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		isError bool
+		denied  bool
+	}{
+		{
+			name:    "success",
+			content: `[{"tool_use_id":"toolu_1","type":"tool_result","content":"ok  \tapp\t0.01s"}]`,
+		},
+		{
+			name:    "error",
+			content: `[{"tool_use_id":"toolu_1","type":"tool_result","content":"Exit code 1","is_error":true}]`,
+			isError: true,
+		},
+		{
+			name:    "spaced error",
+			content: `[ { "tool_use_id" : "toolu_1", "type" : "tool_result", "content" : "Exit code 1", "is_error" : true } ]`,
+			isError: true,
+		},
+		{
+			name:    "spaced success",
+			content: "[\n  {\n    \"type\": \"tool_result\",\n    \"tool_use_id\": \"toolu_1\",\n    \"is_error\": false\n  }\n]",
+		},
+		{
+			name:    "denied",
+			content: `[{"tool_use_id":"toolu_1","type":"tool_result","content":"The user doesn't want to proceed with this tool use.","is_error":true}]`,
+			isError: true,
+			denied:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c MessageContent
+			if err := json.Unmarshal([]byte(tt.content), &c); err != nil {
+				t.Fatal(err)
+			}
+			if len(c) != 1 {
+				t.Fatalf("decoded %d blocks, want 1", len(c))
+			}
+			b := c[0]
+			if b.Type != "tool_result" || b.ToolUseID != "toolu_1" {
+				t.Errorf("decoded type %q for %q, want tool_result for toolu_1", b.Type, b.ToolUseID)
+			}
+			if b.IsError != tt.isError {
+				t.Errorf("IsError = %v, want %v", b.IsError, tt.isError)
+			}
+			if b.denied != tt.denied {
+				t.Errorf("denied = %v, want %v", b.denied, tt.denied)
+			}
+			if !c.toolResult() {
+				t.Error("content is not recognized as a tool result")
+			}
+		})
+	}
+}
+
+// fullBlock is a content block decoded in full by encoding/json, the
+// reference for what the selective decoder reads.
+type fullBlock struct {
+	Type      string          `json:"type"`
+	Name      string          `json:"name"`
+	ID        string          `json:"id"`
+	ToolUseID string          `json:"tool_use_id"`
+	IsError   bool            `json:"is_error"`
+	Text      json.RawMessage `json:"text"`
+	Thinking  json.RawMessage `json:"thinking"`
+	Content   json.RawMessage `json:"content"`
+}
+
+func TestContentMatchesFullDecode(t *testing.T) {
+	tests := map[string]string{
+		"plain string": `"[Request interrupted by user]"`,
+		"text only":    `[{"type":"text","text":"Done."}]`,
+		"tool use": `[{"type":"text","text":"Running the tests."},` +
+			`{"type":"tool_use","id":"toolu_1","name":"Bash","input":{"type":"x","name":"not the tool","nested":[{"id":"y"}],"command":"echo \"}]\""}}]`,
+		"tool results": `[{"tool_use_id":"toolu_1","type":"tool_result","content":[{"type":"text","text":"nested"}]},` +
+			`{"type":"tool_result","tool_use_id":"toolu_2","is_error":true,"content":"Exit code 1"}]`,
+		"plan approval": `[{"tool_use_id":"toolu_3","type":"tool_result","content":"User has approved your plan. You can now start coding."}]`,
+		"denied":        `[{"tool_use_id":"toolu_4","type":"tool_result","content":"The user doesn't want to proceed with this tool use.","is_error":true}]`,
+		"thinking": `[{"type":"thinking","thinking":"First \"quote\" and a \\ backslash\nthen more","signature":"abc"},` +
+			`{"type":"tool_use","id":"toolu_5","name":"Read","input":{}}]`,
+		"interrupt":   `[{"type":"text","text":"[Request interrupted by user for tool use]"}]`,
+		"usage limit": `[{"type":"text","text":"Claude AI usage limit reached|1760000000"}]`,
+		"spaced": "[ {\n \"type\" : \"tool_use\" ,\n \"id\" : \"toolu_6\" , \"name\" : \"Edit\" , \"input\" : { \"a\" : [ 1 , 2 ] } } ,\n" +
+			" { \"type\" : \"tool_result\" , \"tool_use_id\" : \"toolu_6\" , \"is_error\" : false , \"content\" : \"ok\" } ]",
+		"escaped name": `[{"type":"tool_use","id":"toolu_7","name":"mcp__github__search","input":{}}]`,
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got MessageContent
+			if err := json.Unmarshal([]byte(content), &got); err != nil {
+				t.Fatal(err)
+			}
+			var want []fullBlock
+			if content[0] == '"' {
+				want = []fullBlock{{Type: "text", Text: json.RawMessage(content)}}
+			} else if err := json.Unmarshal([]byte(content), &want); err != nil {
+				t.Fatal(err)
+			}
+			if got == nil {
+				// skipped content may hold nothing the metrics read
+				for _, w := range want {
+					if w.Type != "text" || expectText(w.Text) != (textStats{chars: len(w.Text) - 2}) {
+						t.Fatalf("content was skipped, but block %+v is read by the metrics", w)
+					}
+				}
+				return
+			}
+			if len(got) != len(want) {
+				t.Fatalf("decoded %d blocks, want %d", len(got), len(want))
+			}
+			for i, w := range want {
+				g := got[i]
+				if g.Type != w.Type || g.Name != w.Name || g.ID != w.ID || g.ToolUseID != w.ToolUseID || g.IsError != w.IsError {
+					t.Errorf("block %d: decoded {%q %q %q %q %v}, want {%q %q %q %q %v}", i,
+						g.Type, g.Name, g.ID, g.ToolUseID, g.IsError, w.Type, w.Name, w.ID, w.ToolUseID, w.IsError)
+				}
+				if e := expectText(w.Text); g.text != e {
+					t.Errorf("block %d: text %+v, want %+v", i, g.text, e)
+				}
+				if e := expectText(w.Thinking); g.thinking.chars != e.chars {
+					t.Errorf("block %d: thinking of %d chars, want %d", i, g.thinking.chars, e.chars)
+				}
+				var body string
+				json.Unmarshal(w.Content, &body)
+				if approved := strings.HasPrefix(body, string(planApproval)); g.planApproved != approved {
+					t.Errorf("block %d: planApproved = %v, want %v", i, g.planApproved, approved)
+				}
+				if denied := permissionDenied(w.Content); g.denied != denied {
+					t.Errorf("block %d: denied = %v, want %v", i, g.denied, denied)
+				}
+			}
+		})
+	}
+}
+
+// expectText computes textStats from the fully decoded string.
+func expectText(raw json.RawMessage) textStats {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return textStats{}
+	}
+	stats := textStats{chars: len(raw) - 2, interrupt: strings.HasPrefix(s, interruptMarker)}
+	if len(raw)-2 <= maxNoticeLen && strings.Contains(s, usageLimitMarker) {
+		stats.notice = s
+	}
+	return stats
+}
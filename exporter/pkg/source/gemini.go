@@ -0,0 +1,103 @@
+package source
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+	"github.com/aireet/cc-exporter/exporter/pkg/pricing"
+)
+
+// --- Gemini CLI chat sessions ---
+//
+// Gemini CLI records each session as a single JSON document under
+// ~/.gemini/tmp/<project-hash>/chats/session-*.json. Model replies
+// ("type": "gemini") carry per-request token counts and tool calls.
+
+type GeminiSession struct {
+	SessionID string          `json:"sessionId"`
+	Messages  []GeminiMessage `json:"messages"`
+}
+
+type GeminiMessage struct {
+	Timestamp string           `json:"timestamp"`
+	Type      string           `json:"type"`
+	Model     string           `json:"model,omitempty"`
+	Tokens    *GeminiTokens    `json:"tokens,omitempty"`
+	ToolCalls []GeminiToolCall `json:"toolCalls,omitempty"`
+}
+
+type GeminiTokens struct {
+	Input    float64 `json:"input"`
+	Output   float64 `json:"output"`
+	Cached   float64 `json:"cached"`
+	Thoughts float64 `json:"thoughts"`
+	Tool     float64 `json:"tool"`
+	Total    float64 `json:"total"`
+}
+
+type GeminiToolCall struct {
+	Name string `json:"name"`
+}
+
+// NewGemini scans Gemini CLI chat sessions under geminiDir/tmp.
+func NewGemini(geminiDir string, pricing *pricing.Table) *AgentSource {
+	return &AgentSource{
+		provider: "gemini",
+		root:     filepath.Join(geminiDir, "tmp"),
+		match:    isGeminiChat,
+		parse:    parseGeminiChat,
+		pricing:  pricing,
+	}
+}
+
+func isGeminiChat(path string) bool {
+	return filepath.Base(filepath.Dir(path)) == "chats" &&
+		strings.HasPrefix(filepath.Base(path), "session-") &&
+		strings.HasSuffix(path, ".json")
+}
+
+func parseGeminiChat(path string, pricing *pricing.Table) (*AgentUsage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session GeminiSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+
+	res := NewAgentUsage()
+	for _, msg := range session.Messages {
+		if msg.Type != "gemini" {
+			continue
+		}
+		res.Messages++
+		for _, call := range msg.ToolCalls {
+			if call.Name != "" {
+				res.Tools[call.Name]++
+			}
+		}
+		if msg.Tokens == nil {
+			continue
+		}
+
+		name := model.Short(msg.Model)
+		if name == "" {
+			name = "unknown"
+		}
+		// Prompt counts include cached content; thoughts bill as output
+		t := msg.Tokens
+		input := t.Input - t.Cached + t.Tool
+		output := t.Output + t.Thoughts
+		res.Add(msg.Timestamp, name, &LiveModelUsage{
+			Input:     input,
+			Output:    output,
+			CacheRead: t.Cached,
+			Cost:      pricing.Cost(name, input, output, t.Cached, 0),
+		}, t.Thoughts)
+	}
+	return res, nil
+}
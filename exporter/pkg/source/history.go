@@ -0,0 +1,128 @@
+package source
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+)
+
+// --- message history ---
+
+// MessageRow is one API request from the session logs.
+type MessageRow struct {
+	Timestamp   time.Time
+	Session     string
+	Project     string
+	Model       string
+	Input       float64
+	Output      float64
+	CacheRead   float64
+	CacheCreate float64
+	Cost        float64
+	Tools       []string // tool_use blocks of the response, in order
+}
+
+// History reads every API request in the session logs with a timestamp in
+// [from, to), sorted by time. A zero from or to leaves that end open. Unlike
+// Scan it covers the full history, not just what the stats cache misses.
+func (s *ClaudeSessions) History(ctx context.Context, from, to time.Time) ([]MessageRow, error) {
+	files, err := sessionLogs(filepath.Join(s.claudeDir, "projects"))
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []MessageRow
+	byRequest := make(map[string]int) // request key -> index in rows
+	seenRecords := make(map[string]struct{})
+
+	for _, fpath := range files {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		project := filepath.Base(filepath.Dir(fpath))
+		if !s.projects.Allows(project) {
+			continue
+		}
+		info, err := os.Stat(fpath)
+		if err != nil {
+			continue
+		}
+		// a file cannot hold messages newer than its mtime
+		if !from.IsZero() && info.ModTime().Before(from) {
+			continue
+		}
+		// exports are per request, so never sampled
+		var sf sessionFile
+		if err := sf.read(fpath, info, s.budget, 1); err != nil {
+			log.Printf("claude-sessions: failed to read %s: %v", fpath, err)
+		}
+		session := trimLogExt(filepath.Base(fpath))
+
+		for i := range sf.records {
+			rec := &sf.records[i].rec
+			if rec.UUID != "" {
+				if _, dup := seenRecords[rec.UUID]; dup {
+					continue
+				}
+				seenRecords[rec.UUID] = struct{}{}
+			}
+			msg := rec.extractMessage()
+			if msg == nil || rec.Type == "system" {
+				continue
+			}
+			key := rec.requestKey(msg)
+
+			// later records of a request carry its remaining content blocks
+			if idx, ok := byRequest[key]; ok && key != "" {
+				rows[idx].Tools = appendTools(rows[idx].Tools, msg)
+				continue
+			}
+
+			inp := ptrVal(msg.Usage.InputTokens)
+			out := ptrVal(msg.Usage.OutputTokens)
+			if inp == 0 && out == 0 {
+				continue
+			}
+			ts, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+			if err != nil || (!from.IsZero() && ts.Before(from)) || (!to.IsZero() && !ts.Before(to)) {
+				continue
+			}
+			name := model.Short(msg.Model)
+			if name == "" {
+				name = "unknown"
+			}
+			if key != "" {
+				byRequest[key] = len(rows)
+			}
+			rows = append(rows, MessageRow{
+				Timestamp:   ts,
+				Session:     session,
+				Project:     project,
+				Model:       name,
+				Input:       inp,
+				Output:      out,
+				CacheRead:   ptrVal(msg.Usage.CacheReadInputTokens),
+				CacheCreate: ptrVal(msg.Usage.CacheCreationInputTokens),
+				Cost:        s.cost(name, &msg.Usage),
+				Tools:       appendTools(nil, msg),
+			})
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Timestamp.Before(rows[j].Timestamp) })
+	return rows, nil
+}
+
+func appendTools(tools []string, msg *JSONLMessage) []string {
+	for _, block := range msg.Content {
+		if block.Type == "tool_use" && block.Name != "" {
+			tools = append(tools, block.Name)
+		}
+	}
+	return tools
+}
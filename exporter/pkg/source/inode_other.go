@@ -0,0 +1,8 @@
+//go:build !unix
+
+package source
+
+import "os"
+
+// fileInode is unavailable; truncation is still detected by size.
+func fileInode(info os.FileInfo) uint64 { return 0 }
@@ -0,0 +1,139 @@
+package source
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- project languages ---
+
+// LanguageRule assigns projects a language when the project path or its
+// git remote URL matches one of the globs, as TeamRule does.
+type LanguageRule struct {
+	Language string   `json:"language"`
+	Paths    []string `json:"paths,omitempty"`
+	Remotes  []string `json:"remotes,omitempty"`
+}
+
+// LanguageMap labels projects with their primary language. Rules are
+// tried in order; projects no rule matches get the language most of their
+// source files are in, or "unknown".
+type LanguageMap struct {
+	Rules []LanguageRule `json:"rules"`
+}
+
+// LoadLanguageMap reads a JSON language mapping file. An empty path
+// returns a map without rules, which only detects languages.
+func LoadLanguageMap(path string) (*LanguageMap, error) {
+	m := &LanguageMap{}
+	if path == "" {
+		return m, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Language returns the language of a project working directory with the
+// given git remote URL (either may be empty). Only absolute directories
+// are looked into.
+func (m *LanguageMap) Language(dir, remote string) string {
+	for _, r := range m.Rules {
+		for _, p := range r.Paths {
+			if dir != "" && globMatch(p, dir) {
+				return r.Language
+			}
+		}
+		for _, p := range r.Remotes {
+			if remote != "" && globMatch(p, remote) {
+				return r.Language
+			}
+		}
+	}
+	if filepath.IsAbs(dir) {
+		return detectLanguage(dir)
+	}
+	return "unknown"
+}
+
+// languageExts maps source file extensions to the language label.
+var languageExts = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".js":    "javascript",
+	".jsx":   "javascript",
+	".mjs":   "javascript",
+	".vue":   "javascript",
+	".rs":    "rust",
+	".java":  "java",
+	".kt":    "kotlin",
+	".scala": "scala",
+	".rb":    "ruby",
+	".php":   "php",
+	".cs":    "csharp",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".swift": "swift",
+	".m":     "objective-c",
+	".dart":  "dart",
+	".ex":    "elixir",
+	".exs":   "elixir",
+	".sh":    "shell",
+	".tf":    "terraform",
+}
+
+// skipDirs are dependency and build directories, whose files say nothing
+// about the project's own language.
+var skipDirs = map[string]bool{
+	"node_modules": true, "vendor": true, "target": true, "build": true,
+	"dist": true, "venv": true, "__pycache__": true, "third_party": true,
+}
+
+// languageScanFiles bounds the files detectLanguage looks at, so a huge
+// checkout costs a bounded walk.
+const languageScanFiles = 5000
+
+// detectLanguage returns the language most source files under dir are
+// in, ties going to the first alphabetically, or "unknown".
+func detectLanguage(dir string) string {
+	counts := make(map[string]int)
+	seen := 0
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && (strings.HasPrefix(d.Name(), ".") || skipDirs[d.Name()]) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if seen++; seen > languageScanFiles {
+			return filepath.SkipAll
+		}
+		if lang, ok := languageExts[strings.ToLower(filepath.Ext(d.Name()))]; ok {
+			counts[lang]++
+		}
+		return nil
+	})
+	best := "unknown"
+	for lang, n := range counts {
+		if n > counts[best] || n == counts[best] && lang < best {
+			best = lang
+		}
+	}
+	return best
+}
@@ -0,0 +1,50 @@
+// Package source reads coding-agent usage data from disk.
+//
+// Each data source implements Source and returns a Snapshot holding the
+// part of the picture it knows about; the collector merges the snapshots
+// of all registered sources and maps them to metrics.
+package source
+
+import "context"
+
+// Source is one independently registered data source.
+type Source interface {
+	// Name identifies the source in logs.
+	Name() string
+	// Scan reads the current state of the source. When ctx expires it
+	// may return partial results marked Incomplete instead of an error.
+	Scan(ctx context.Context) (*Snapshot, error)
+}
+
+// Snapshot is the data produced by one or more sources.
+type Snapshot struct {
+	// Stats is Claude's stats-cache.json (historical totals).
+	Stats *StatsCache
+	// Live is Claude session activity not yet in the stats cache.
+	Live *LiveResult
+	// Agents holds usage of other coding agents, keyed by provider.
+	Agents map[string]*AgentUsage
+	// Incomplete is set when a scan ran out of time and returned what it
+	// had read so far.
+	Incomplete bool
+}
+
+// Merge copies the parts set in o into s.
+func (s *Snapshot) Merge(o *Snapshot) {
+	if o == nil {
+		return
+	}
+	s.Incomplete = s.Incomplete || o.Incomplete
+	if o.Stats != nil {
+		s.Stats = o.Stats
+	}
+	if o.Live != nil {
+		s.Live = o.Live
+	}
+	for provider, u := range o.Agents {
+		if s.Agents == nil {
+			s.Agents = make(map[string]*AgentUsage)
+		}
+		s.Agents[provider] = u
+	}
+}
@@ -0,0 +1,285 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aireet/cc-exporter/exporter/pkg/model"
+)
+
+// --- stats-cache.json structs ---
+
+// StatsCache mirrors ~/.claude/stats-cache.json. Model names are normalized
+// with model.Short when loaded.
+type StatsCache struct {
+	ModelUsage       map[string]ModelUsage `json:"modelUsage"`
+	TotalSessions    int                   `json:"totalSessions"`
+	TotalMessages    int                   `json:"totalMessages"`
+	DailyActivity    []DailyActivity       `json:"dailyActivity"`
+	DailyModelTokens []DailyModelTokens    `json:"dailyModelTokens"`
+	HourCounts       map[string]float64    `json:"hourCounts"`
+	LastComputedDate string                `json:"lastComputedDate"`
+	FirstSessionDate string                `json:"firstSessionDate"`
+
+	// API provider of each model (model.Provider)
+	Providers map[string]string `json:"-"`
+	// ModTime is when Claude last rewrote the file
+	ModTime time.Time `json:"-"`
+	// SchemaVersion is the file's version field, 0 when it has none
+	SchemaVersion int `json:"-"`
+	// Skipped lists the fields that failed to decode, see decodeStatsCache
+	Skipped []string `json:"-"`
+}
+
+// LastComputed returns the start of LastComputedDate in local time, or the
+// zero time if it is missing.
+func (s *StatsCache) LastComputed() time.Time { return s.LastComputedIn(time.Local) }
+
+// LastComputedIn is LastComputed with the date in loc.
+func (s *StatsCache) LastComputedIn(loc *time.Location) time.Time {
+	return parseStatsDate(s.LastComputedDate, loc)
+}
+
+// FirstSession returns FirstSessionDate as a time, or the zero time if it
+// is missing.
+func (s *StatsCache) FirstSession() time.Time { return s.FirstSessionIn(time.Local) }
+
+// FirstSessionIn is FirstSession with a plain date in loc.
+func (s *StatsCache) FirstSessionIn(loc *time.Location) time.Time {
+	return parseStatsDate(s.FirstSessionDate, loc)
+}
+
+// parseStatsDate accepts both the timestamps and the plain dates found in
+// the stats cache, plain dates in loc.
+func parseStatsDate(v string, loc *time.Location) time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+		return t
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, loc); err == nil {
+		return t
+	}
+	return time.Time{}
+}
+
+type ModelUsage struct {
+	InputTokens              float64 `json:"inputTokens"`
+	OutputTokens             float64 `json:"outputTokens"`
+	CacheReadInputTokens     float64 `json:"cacheReadInputTokens"`
+	CacheCreationInputTokens float64 `json:"cacheCreationInputTokens"`
+	WebSearchRequests        float64 `json:"webSearchRequests"`
+	CostUSD                  float64 `json:"costUSD"`
+	// WebSearchCostUSD prices WebSearchRequests; the stats file has no
+	// such field, it is estimated by the collector
+	WebSearchCostUSD float64 `json:"-"`
+}
+
+type DailyActivity struct {
+	Date          string `json:"date"`
+	MessageCount  int    `json:"messageCount"`
+	SessionCount  int    `json:"sessionCount"`
+	ToolCallCount int    `json:"toolCallCount"`
+}
+
+type DailyModelTokens struct {
+	Date          string             `json:"date"`
+	TokensByModel map[string]float64 `json:"tokensByModel"`
+}
+
+// --- source ---
+
+// StatsCacheSource reads Claude's precomputed stats-cache.json.
+type StatsCacheSource struct {
+	path   string
+	logged string // schema notice last logged
+}
+
+func NewStatsCache(path string) *StatsCacheSource {
+	return &StatsCacheSource{path: path}
+}
+
+func (s *StatsCacheSource) Name() string { return "stats-cache" }
+
+func (s *StatsCacheSource) Scan(ctx context.Context) (*Snapshot, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := decodeStatsCache(data)
+	if err != nil {
+		return nil, err
+	}
+	s.logSchema(stats)
+	stats.normalizeModels()
+	stats.ModTime = cacheMtime(s.path)
+	return &Snapshot{Stats: stats}, nil
+}
+
+// logSchema reports a new schema version or skipped fields once, not on
+// every scrape.
+func (s *StatsCacheSource) logSchema(stats *StatsCache) {
+	key := fmt.Sprint(stats.SchemaVersion, stats.Skipped)
+	if key == s.logged {
+		return
+	}
+	s.logged = key
+	if stats.SchemaVersion > StatsSchemaVersion {
+		log.Printf("stats-cache: schema version %d is newer than %d, reading the fields it still has", stats.SchemaVersion, StatsSchemaVersion)
+	}
+	if len(stats.Skipped) > 0 {
+		log.Printf("stats-cache: skipped fields that no longer decode: %s", strings.Join(stats.Skipped, ", "))
+	}
+}
+
+// --- schema tolerance ---
+
+// StatsSchemaVersion is the newest stats-cache.json version field seen.
+// Releases have renamed fields and nested the document, so rather than
+// unmarshal it in one go, decodeStatsCache:
+//   - unwraps a document nested under a "stats" or "data" key,
+//   - accepts snake_case spellings of every key,
+//   - decodes each top-level field on its own, so a field whose type
+//     changed is skipped (and listed in Skipped) instead of failing the
+//     whole file.
+const StatsSchemaVersion = 2
+
+// snakeKey matches keys like "model_usage" or "input_tokens", but not
+// model names or dates, which also appear as keys.
+var snakeKey = regexp.MustCompile(`^[a-z]+(_[a-z]+)+$`)
+
+// decodeStatsCache reads a stats-cache.json of any known layout. Only a
+// file that isn't a JSON object is an error.
+func decodeStatsCache(data []byte) (*StatsCache, error) {
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc = camelKeys(doc).(map[string]any)
+	stats := &StatsCache{}
+	if v, ok := doc["version"].(float64); ok {
+		stats.SchemaVersion = int(v)
+	}
+	if _, flat := doc["modelUsage"]; !flat {
+		for _, key := range []string{"stats", "data"} {
+			if inner, ok := doc[key].(map[string]any); ok {
+				doc = inner
+				break
+			}
+		}
+		if v, ok := doc["version"].(float64); ok && stats.SchemaVersion == 0 {
+			stats.SchemaVersion = int(v)
+		}
+	}
+
+	fields := map[string]any{
+		"modelUsage":       &stats.ModelUsage,
+		"totalSessions":    &stats.TotalSessions,
+		"totalMessages":    &stats.TotalMessages,
+		"dailyActivity":    &stats.DailyActivity,
+		"dailyModelTokens": &stats.DailyModelTokens,
+		"hourCounts":       &stats.HourCounts,
+		"lastComputedDate": &stats.LastComputedDate,
+		"firstSessionDate": &stats.FirstSessionDate,
+	}
+	for key, dst := range fields {
+		v, ok := doc[key]
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err == nil {
+			err = json.Unmarshal(raw, dst)
+		}
+		if err != nil {
+			stats.Skipped = append(stats.Skipped, key)
+		}
+	}
+	slices.Sort(stats.Skipped)
+	return stats, nil
+}
+
+// camelKeys rewrites snake_case object keys to camelCase throughout v.
+func camelKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			if snakeKey.MatchString(k) {
+				parts := strings.Split(k, "_")
+				for i := 1; i < len(parts); i++ {
+					parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+				}
+				k = strings.Join(parts, "")
+			}
+			out[k] = camelKeys(e)
+		}
+		return out
+	case []any:
+		for i, e := range v {
+			v[i] = camelKeys(e)
+		}
+	}
+	return v
+}
+
+// normalizeModels rekeys model maps by model.Short, summing entries that
+// only differed in spelling.
+func (s *StatsCache) normalizeModels() {
+	usage := make(map[string]ModelUsage, len(s.ModelUsage))
+	s.Providers = make(map[string]string, len(s.ModelUsage))
+	for raw, u := range s.ModelUsage {
+		name := model.Short(raw)
+		s.Providers[name] = model.MergeProvider(s.Providers[name], model.Provider(raw))
+		merged := usage[name]
+		merged.InputTokens += u.InputTokens
+		merged.OutputTokens += u.OutputTokens
+		merged.CacheReadInputTokens += u.CacheReadInputTokens
+		merged.CacheCreationInputTokens += u.CacheCreationInputTokens
+		merged.WebSearchRequests += u.WebSearchRequests
+		merged.CostUSD += u.CostUSD
+		usage[name] = merged
+	}
+	s.ModelUsage = usage
+
+	for i, entry := range s.DailyModelTokens {
+		tokens := make(map[string]float64, len(entry.TokensByModel))
+		for raw, n := range entry.TokensByModel {
+			name := model.Short(raw)
+			tokens[name] += n
+			s.Providers[name] = model.MergeProvider(s.Providers[name], model.Provider(raw))
+		}
+		s.DailyModelTokens[i].TokensByModel = tokens
+	}
+}
+
+// cacheBoundary returns when the stats cache coverage ends: midnight in loc
+// after its lastComputedDate, or its mtime when the date is missing. It is
+// the zero time when there is no cache.
+func cacheBoundary(path string, loc *time.Location) time.Time {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}
+	}
+	if stats, err := decodeStatsCache(data); err == nil {
+		if d, err := time.ParseInLocation("2006-01-02", stats.LastComputedDate, loc); err == nil {
+			return d.AddDate(0, 0, 1)
+		}
+	}
+	return cacheMtime(path)
+}
+
+// cacheMtime returns when Claude last rewrote the stats cache, or the zero
+// time if it does not exist.
+func cacheMtime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,263 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// --- incremental file reading ---
+
+// fileTail remembers how far an append-only file has been read. A file that
+// grew is read from the saved offset; one that shrank or was replaced by a
+// new inode (a rewrite, e.g. after compaction) is read again from the start.
+type fileTail struct {
+	inode  uint64
+	size   int64
+	mtime  time.Time
+	offset int64
+	lines  int
+	// lines longer than the line limit, see lineReader
+	oversized int
+}
+
+// compressedExts are the suffixes of compressed (rotated or backed up)
+// logs, which are read whole rather than tailed.
+var compressedExts = []string{".gz", ".zst"}
+
+func isCompressed(path string) bool {
+	for _, ext := range compressedExts {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// trimLogExt strips the log and compression suffixes from a file name.
+func trimLogExt(name string) string {
+	for _, ext := range compressedExts {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return strings.TrimSuffix(name, ".jsonl")
+}
+
+// read calls fn with every complete line added since the last call, and
+// reset before starting over. At most budget bytes of a line are held in
+// memory. A trailing line without a newline is still
+// being written and is left for the next call.
+func (t *fileTail) read(path string, info os.FileInfo, budget int, fn func(line []byte, lineNo int), reset func()) (bool, error) {
+	rewritten := false
+	if ino := fileInode(info); ino != t.inode || info.Size() < t.offset {
+		rewritten = t.offset > 0
+		*t = fileTail{inode: ino}
+		reset()
+	}
+	if info.Size() == t.size && info.ModTime().Equal(t.mtime) {
+		return rewritten, nil
+	}
+	if isCompressed(path) {
+		// a compressed file cannot be appended to, so any change is a rewrite
+		if t.offset > 0 {
+			rewritten = true
+			*t = fileTail{inode: t.inode}
+			reset()
+		}
+		return rewritten, t.readCompressed(path, info, budget, fn)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return rewritten, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return rewritten, err
+	}
+
+	lr := newLineReader(f, budget)
+	for {
+		line, n, err := lr.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return rewritten, err
+		}
+		t.offset += int64(n)
+		t.emit(line, lr, fn)
+	}
+	t.size, t.mtime = info.Size(), info.ModTime()
+	return rewritten, nil
+}
+
+// emit passes on a complete line, counting those over the budget.
+func (t *fileTail) emit(line []byte, lr *lineReader, fn func(line []byte, lineNo int)) {
+	t.lines++
+	if lr.oversized {
+		t.oversized++
+	}
+	if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+		fn(line, t.lines)
+	}
+}
+
+// readCompressed reads a whole .gz or .zst file. Its last line is complete
+// even without a trailing newline.
+func (t *fileTail) readCompressed(path string, info os.FileInfo, budget int, fn func(line []byte, lineNo int)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader
+	if strings.HasSuffix(path, ".zst") {
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	lr := newLineReader(r, budget)
+	for {
+		line, n, err := lr.next()
+		if n > 0 {
+			t.emit(line, lr, fn)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	t.offset, t.size, t.mtime = info.Size(), info.Size(), info.ModTime()
+	return nil
+}
+
+// --- bounded line reading ---
+
+// DefaultLineLimit bounds the bytes of one line held in memory while
+// parsing session logs. The records kept across lines are bounded by
+// ClaudeSessionsOptions.MemoryBudget instead.
+const DefaultLineLimit = 8 << 20
+
+// elideKeep is how much of a long string value an oversized line keeps,
+// enough for the prefixes the metrics look at.
+const elideKeep = 256
+
+// lineReader reads lines holding at most budget bytes of each. The rest of
+// a longer line (typically a huge tool result or file content) is streamed
+// through an elider, which keeps the JSON structure and cuts long string
+// values; a line still over budget after that is dropped.
+type lineReader struct {
+	r      *bufio.Reader
+	budget int
+	buf    []byte
+	// whether the last line exceeded the budget
+	oversized bool
+}
+
+func newLineReader(r io.Reader, budget int) *lineReader {
+	if budget <= 0 {
+		budget = DefaultLineLimit
+	}
+	return &lineReader{r: bufio.NewReaderSize(r, 64*1024), budget: budget}
+}
+
+// next returns the next line and the bytes it took in the input, including
+// the newline. At EOF it returns the unterminated rest with io.EOF. The
+// line is only valid until the next call.
+func (lr *lineReader) next() ([]byte, int, error) {
+	lr.buf = lr.buf[:0]
+	lr.oversized = false
+	var el *elider
+	n := 0
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		n += len(chunk)
+		switch {
+		case el != nil:
+			lr.buf = el.write(lr.buf, chunk, lr.budget)
+		case len(lr.buf)+len(chunk) > lr.budget:
+			lr.oversized = true
+			el = &elider{}
+			held := lr.buf
+			lr.buf = el.write(el.write(make([]byte, 0, len(held)), held, lr.budget), chunk, lr.budget)
+		default:
+			lr.buf = append(lr.buf, chunk...)
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			if el != nil && el.full {
+				return nil, n, err
+			}
+			return lr.buf, n, err
+		}
+	}
+}
+
+// elider copies JSON text, cutting string values after elideKeep bytes.
+// It keeps its state between chunks and never splits an escape sequence.
+type elider struct {
+	inString bool
+	cut      bool
+	kept     int
+	esc      int // bytes left of the current escape; -1 right after '\\'
+	// output reached the budget; the line is dropped
+	full bool
+}
+
+func (e *elider) write(dst, src []byte, budget int) []byte {
+	for _, c := range src {
+		emit := true
+		if e.inString {
+			switch {
+			case e.esc == -1:
+				e.esc = 0
+				if c == 'u' {
+					e.esc = 4
+				}
+			case e.esc > 0:
+				e.esc--
+			case c == '\\':
+				e.esc = -1
+			case c == '"':
+				e.inString = false
+			}
+			if e.inString {
+				emit = !e.cut
+				e.kept++
+				if e.kept >= elideKeep && e.esc == 0 {
+					e.cut = true
+				}
+			}
+		} else if c == '"' {
+			e.inString, e.cut, e.kept = true, false, 0
+		}
+		if emit && !e.full {
+			if len(dst) >= budget {
+				e.full = true
+				continue
+			}
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,110 @@
+package source
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// --- team cost attribution ---
+
+// TeamRule assigns projects to a team (or cost center) when the project
+// path or its git remote URL matches one of the globs. "*" matches any
+// run of characters, including "/".
+type TeamRule struct {
+	Team    string   `json:"team"`
+	Paths   []string `json:"paths,omitempty"`
+	Remotes []string `json:"remotes,omitempty"`
+}
+
+// TeamMap is a team mapping file. Rules are tried in order; projects no
+// rule matches go to Default ("unassigned" when empty).
+type TeamMap struct {
+	Rules   []TeamRule `json:"rules"`
+	Default string     `json:"default,omitempty"`
+}
+
+// LoadTeamMap reads a JSON team mapping file. An empty path returns nil,
+// which disables team attribution.
+func LoadTeamMap(path string) (*TeamMap, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &TeamMap{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if m.Default == "" {
+		m.Default = "unassigned"
+	}
+	return m, nil
+}
+
+func globMatch(pattern, s string) bool {
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	ok, _ := regexp.MatchString(re, s)
+	return ok
+}
+
+// Team returns the team of a project working directory with the given
+// git remote URL (either may be empty).
+func (m *TeamMap) Team(dir, remote string) string {
+	for _, r := range m.Rules {
+		for _, p := range r.Paths {
+			if dir != "" && globMatch(p, dir) {
+				return r.Team
+			}
+		}
+		for _, p := range r.Remotes {
+			if remote != "" && globMatch(p, remote) {
+				return r.Team
+			}
+		}
+	}
+	return m.Default
+}
+
+// gitRemote returns the URL of the "origin" remote (or the first remote)
+// of the repository containing dir, or "" when there is none or it is not
+// readable from here.
+func gitRemote(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if f, err := os.Open(filepath.Join(d, ".git", "config")); err == nil {
+			defer f.Close()
+			return remoteURL(bufio.NewScanner(f))
+		}
+		if parent := filepath.Dir(d); parent == d {
+			return ""
+		}
+	}
+}
+
+func remoteURL(sc *bufio.Scanner) string {
+	var first, section string
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			section = line
+			continue
+		}
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) != "url" || !strings.HasPrefix(section, "[remote ") {
+			continue
+		}
+		url := strings.TrimSpace(val)
+		if section == `[remote "origin"]` {
+			return url
+		}
+		if first == "" {
+			first = url
+		}
+	}
+	return first
+}
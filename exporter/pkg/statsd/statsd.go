@@ -0,0 +1,190 @@
+// Package statsd sends the exporter's metrics to a StatsD or DogStatsD
+// agent, for setups (e.g. Datadog) without a Prometheus server.
+//
+// Gauges and counters are flushed periodically from a prometheus.Gatherer;
+// histogram samples are sent as they are observed, as distributions.
+package statsd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxPacket keeps UDP datagrams under a typical MTU.
+const maxPacket = 1432
+
+// Client writes metrics in the StatsD line protocol. With DogStatsD set,
+// labels become tags and histogram samples distributions ("d"); otherwise
+// label values are appended to the metric name and samples sent as "h".
+type Client struct {
+	Prefix    string
+	Tags      []string // extra tags, "key:value"; DogStatsD only
+	DogStatsD bool
+	Gatherer  prometheus.Gatherer
+
+	mu   sync.Mutex
+	conn net.Conn
+	buf  []byte
+}
+
+// Dial connects to addr, "host:port" or "udp://host:port" for UDP or
+// "unix:///path" for a Unix datagram socket.
+func Dial(addr string) (*Client, error) {
+	network := "udp"
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		network, addr = "unixgram", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "udp://"):
+		addr = strings.TrimPrefix(addr, "udp://")
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Close flushes pending lines and closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+	return c.conn.Close()
+}
+
+// sanitize maps a name or tag value to the characters StatsD servers
+// accept unescaped.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '_', r == '-', r == '.', r == '/':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// line formats one metric with its labels in sorted order.
+func (c *Client) line(name string, labels map[string]string, value float64, typ string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(sanitize(c.Prefix + name))
+	if !c.DogStatsD {
+		for _, k := range keys {
+			b.WriteString("." + sanitize(labels[k]))
+		}
+	}
+	b.WriteString(":" + strconv.FormatFloat(value, 'f', -1, 64) + "|" + typ)
+	if c.DogStatsD {
+		tags := append([]string(nil), c.Tags...)
+		for _, k := range keys {
+			tags = append(tags, sanitize(k)+":"+sanitize(labels[k]))
+		}
+		if len(tags) > 0 {
+			b.WriteString("|#" + strings.Join(tags, ","))
+		}
+	}
+	return b.String()
+}
+
+func (c *Client) write(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.buf) > 0 && len(c.buf)+1+len(line) > maxPacket {
+		c.flushLocked()
+	}
+	if len(c.buf) > 0 {
+		c.buf = append(c.buf, '\n')
+	}
+	c.buf = append(c.buf, line...)
+}
+
+func (c *Client) flushLocked() {
+	if len(c.buf) == 0 {
+		return
+	}
+	if _, err := c.conn.Write(c.buf); err != nil {
+		log.Printf("statsd: %v", err)
+	}
+	c.buf = c.buf[:0]
+}
+
+// Observe sends one histogram sample. It matches collector.Options.OnObserve.
+func (c *Client) Observe(name string, labels prometheus.Labels, value float64) {
+	typ := "h"
+	if c.DogStatsD {
+		typ = "d"
+	}
+	c.write(c.line(name, labels, value, typ))
+}
+
+// Flush gathers the metrics of Gatherer and sends them.
+func (c *Client) Flush() error {
+	families, err := c.Gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("gather: %w", err)
+	}
+	return c.Send(context.Background(), families)
+}
+
+// Send sends the current value of every gauge and counter as a gauge;
+// the exporter's counters are absolute totals, not increments. It makes
+// the client a sink.Sink.
+func (c *Client) Send(ctx context.Context, families []*dto.MetricFamily) error {
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			labels := make(map[string]string, len(m.GetLabel()))
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			c.write(c.line(mf.GetName(), labels, value, "g"))
+		}
+	}
+	c.mu.Lock()
+	c.flushLocked()
+	c.mu.Unlock()
+	return nil
+}
+
+// Run flushes every interval until ctx is done.
+func (c *Client) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Flush(); err != nil {
+			log.Printf("statsd: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
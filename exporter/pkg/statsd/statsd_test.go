@@ -0,0 +1,129 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLine(t *testing.T) {
+	labels := map[string]string{"model": "claude-opus-4", "project": "my app"}
+	tests := []struct {
+		name   string
+		client *Client
+		labels map[string]string
+		typ    string
+		want   string
+	}{
+		{"plain", &Client{}, labels, "g", "claude_cost_usd.claude-opus-4.my_app:1.5|g"},
+		{"plain with prefix", &Client{Prefix: "ci."}, nil, "h", "ci.claude_cost_usd:1.5|h"},
+		{"dogstatsd", &Client{DogStatsD: true}, labels, "d", "claude_cost_usd:1.5|d|#model:claude-opus-4,project:my_app"},
+		{"dogstatsd extra tags", &Client{DogStatsD: true, Tags: []string{"env:dev"}}, map[string]string{"model": "opus"}, "g", "claude_cost_usd:1.5|g|#env:dev,model:opus"},
+		{"dogstatsd no tags", &Client{DogStatsD: true}, nil, "g", "claude_cost_usd:1.5|g"},
+		{"sanitized", &Client{}, map[string]string{"project": "a:b|c@d"}, "g", "claude_cost_usd.a_b_c_d:1.5|g"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.line("claude_cost_usd", tt.labels, 1.5, tt.typ); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// listen returns a client dialled to a local UDP socket and a function
+// reading the datagrams it sent.
+func listen(t *testing.T) (*Client, func() []string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	c, err := Dial("udp://" + pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, func() []string {
+		var out []string
+		buf := make([]byte, 64<<10)
+		for {
+			pc.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				return out
+			}
+			out = append(out, string(buf[:n]))
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	c, read := listen(t)
+	c.DogStatsD = true
+	families := []*dto.MetricFamily{
+		{Name: proto.String("claude_sessions"), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{
+			{Gauge: &dto.Gauge{Value: proto.Float64(3)}},
+		}},
+		{Name: proto.String("claude_messages_total"), Type: dto.MetricType_COUNTER.Enum(), Metric: []*dto.Metric{
+			{Label: []*dto.LabelPair{{Name: proto.String("model"), Value: proto.String("opus")}}, Counter: &dto.Counter{Value: proto.Float64(42)}},
+		}},
+		{Name: proto.String("claude_turn_duration_seconds"), Type: dto.MetricType_HISTOGRAM.Enum(), Metric: []*dto.Metric{
+			{Histogram: &dto.Histogram{SampleCount: proto.Uint64(1)}},
+		}},
+	}
+	if err := c.Send(context.Background(), families); err != nil {
+		t.Fatal(err)
+	}
+	// counters are absolute totals, so they go out as gauges; histograms
+	// only as they are observed
+	want := "claude_sessions:3|g\nclaude_messages_total:42|g|#model:opus"
+	if got := read(); len(got) != 1 || got[0] != want {
+		t.Errorf("sent %q, want one datagram %q", got, want)
+	}
+
+	c.Observe("claude_turn_duration_seconds", map[string]string{"model": "opus"}, 2.5)
+	if got := read(); len(got) != 0 {
+		t.Errorf("observation sent as %q before a flush", got)
+	}
+	c.mu.Lock()
+	c.flushLocked()
+	c.mu.Unlock()
+	if got := read(); len(got) != 1 || got[0] != "claude_turn_duration_seconds:2.5|d|#model:opus" {
+		t.Errorf("observation sent as %q", got)
+	}
+}
+
+func TestSendSplitsPackets(t *testing.T) {
+	c, read := listen(t)
+	mf := &dto.MetricFamily{Name: proto.String("claude_sessions"), Type: dto.MetricType_GAUGE.Enum()}
+	for i := range 200 {
+		mf.Metric = append(mf.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("session"), Value: proto.String(strings.Repeat("s", 20) + string(rune('a'+i%26)))}},
+			Gauge: &dto.Gauge{Value: proto.Float64(float64(i))},
+		})
+	}
+	if err := c.Send(context.Background(), []*dto.MetricFamily{mf}); err != nil {
+		t.Fatal(err)
+	}
+	got := read()
+	if len(got) < 2 {
+		t.Fatalf("sent %d datagrams, want the lines split", len(got))
+	}
+	lines := 0
+	for _, p := range got {
+		if len(p) > maxPacket {
+			t.Errorf("datagram of %d bytes, over %d", len(p), maxPacket)
+		}
+		lines += strings.Count(p, "\n") + 1
+	}
+	if lines != 200 {
+		t.Errorf("sent %d lines, want 200", lines)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- generated Prometheus alerting rules ---
+
+// ruleThresholds parameterizes the alerting rules. Durations use
+// Prometheus syntax (e.g. "15m").
+type ruleThresholds struct {
+	WindowBudgetUSD       float64 `json:"window_budget_usd"`
+	ErrorRate             float64 `json:"error_rate"` // API errors per message
+	ErrorRateWindow       string  `json:"error_rate_window"`
+	NoActivityFor         string  `json:"no_activity_for"`
+	CompactionsPerHour    float64 `json:"compactions_per_hour"`
+	StatsCacheMaxAgeHours float64 `json:"stats_cache_max_age_hours"`
+	CostAnomalyScore      float64 `json:"cost_anomaly_score"`
+}
+
+var defaultThresholds = ruleThresholds{
+	WindowBudgetUSD:       20,
+	ErrorRate:             0.1,
+	ErrorRateWindow:       "15m",
+	NoActivityFor:         "24h",
+	CompactionsPerHour:    6,
+	StatsCacheMaxAgeHours: 48,
+	CostAnomalyScore:      3,
+}
+
+// loadThresholds overlays the optional JSON file on the defaults.
+func loadThresholds(path string) (ruleThresholds, error) {
+	t := defaultThresholds
+	if path == "" {
+		return t, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return t, err
+	}
+	err = json.Unmarshal(data, &t)
+	return t, err
+}
+
+type alertRule struct {
+	Alert       string
+	Expr        string
+	For         string
+	Severity    string
+	Summary     string
+	Description string
+}
+
+func alertRules(t ruleThresholds) []alertRule {
+	num := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	return []alertRule{
+		{
+			Alert:       "ClaudeWindowBudgetExceeded",
+			Expr:        "claude_window_cost_usd > " + num(t.WindowBudgetUSD),
+			Severity:    "warning",
+			Summary:     "Claude Code spend in the current 5-hour window is over budget",
+			Description: "{{ $value | printf \"%.2f\" }} USD spent in the current window (budget " + num(t.WindowBudgetUSD) + " USD).",
+		},
+		{
+			Alert: "ClaudeAPIErrorRateHigh",
+			Expr: fmt.Sprintf("delta(claude_live_api_errors[%s]) / clamp_min(increase(claude_live_messages[%s]), 1) > %s",
+				t.ErrorRateWindow, t.ErrorRateWindow, num(t.ErrorRate)),
+			For:         "5m",
+			Severity:    "warning",
+			Summary:     "Claude API error rate is high",
+			Description: "{{ $value | humanizePercentage }} of messages hit an API error over the last " + t.ErrorRateWindow + ".",
+		},
+		{
+			Alert:       "ClaudeNoActivity",
+			Expr:        fmt.Sprintf("changes(claude_live_messages[%s]) == 0", t.NoActivityFor),
+			Severity:    "info",
+			Summary:     "No Claude Code activity",
+			Description: "No new messages in the last " + t.NoActivityFor + ".",
+		},
+		{
+			Alert:       "ClaudeCompactionStorm",
+			Expr:        "sum(delta(claude_live_compact_events[1h])) > " + num(t.CompactionsPerHour),
+			Severity:    "warning",
+			Summary:     "Frequent context compaction",
+			Description: "{{ $value | printf \"%.0f\" }} compactions in the last hour; sessions are running out of context.",
+		},
+		{
+			Alert:       "ClaudeStatsCacheStale",
+			Expr:        "claude_stats_cache_age_seconds > " + num(t.StatsCacheMaxAgeHours*3600),
+			Severity:    "info",
+			Summary:     "Claude Code stopped updating its stats cache",
+			Description: "stats-cache.json was last rewritten {{ $value | humanizeDuration }} ago.",
+		}, {
+			Alert:       "ClaudeCostAnomaly",
+			Expr:        "claude_cost_anomaly_score > " + num(t.CostAnomalyScore),
+			For:         "10m",
+			Severity:    "warning",
+			Summary:     "Claude Code spend is unusually high for this hour",
+			Description: "This hour's cost is {{ $value | printf \"%.1f\" }} standard deviations above the same hour of the last 7 days.",
+		},
+	}
+}
+
+// rulesYAML renders a Prometheus rule file. Strings are written as
+// double-quoted scalars, which YAML reads with JSON escaping.
+func rulesYAML(rules []alertRule) string {
+	var b strings.Builder
+	b.WriteString("groups:\n  - name: claude-exporter\n    rules:\n")
+	for _, r := range rules {
+		fmt.Fprintf(&b, "      - alert: %s\n", r.Alert)
+		fmt.Fprintf(&b, "        expr: %s\n", strconv.Quote(r.Expr))
+		if r.For != "" {
+			fmt.Fprintf(&b, "        for: %s\n", r.For)
+		}
+		fmt.Fprintf(&b, "        labels:\n          severity: %s\n", r.Severity)
+		fmt.Fprintf(&b, "        annotations:\n          summary: %s\n          description: %s\n",
+			strconv.Quote(r.Summary), strconv.Quote(r.Description))
+	}
+	return b.String()
+}
+
+// runRules implements the `rules` subcommand.
+func runRules(args []string) error {
+	fs := flag.NewFlagSet("rules", flag.ExitOnError)
+	config := fs.String("config", "", "JSON file with alert thresholds (defaults are used for missing keys)")
+	output := fs.String("output", "-", "file to write the rules YAML to (- for stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	t, err := loadThresholds(*config)
+	if err != nil {
+		return err
+	}
+	data := []byte(rulesYAML(alertRules(t)))
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0o644)
+}